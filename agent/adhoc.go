@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+
+	"validate/netplan"
+)
+
+// AdHocTestRequest asks for a single test, run while the caller waits
+type AdHocTestRequest struct {
+	TargetIP        string      `json:"target_ip"`
+	TestType        string      `json:"test_type"`
+	SourceInterface string      `json:"source_interface,omitempty"` // default: the interface sharing the most specific subnet with the target
+	AgentPort       int         `json:"agent_port,omitempty"`       // API port of the target agent, for http and bandwidth
+	Options         TestOptions `json:"options,omitempty"`
+}
+
+// Validate checks that an ad-hoc test request names a known test type that
+// applies to the target address
+func (r AdHocTestRequest) Validate() error {
+	ip := net.ParseIP(r.TargetIP)
+	if ip == nil {
+		return fmt.Errorf("invalid target IP %q", r.TargetIP)
+	}
+	if !slices.Contains(AllTestTypes, r.TestType) {
+		return fmt.Errorf("unknown test type %q (must be one of: %v)", r.TestType, AllTestTypes)
+	}
+	if r.Options.Count < 0 || r.Options.TimeoutMS < 0 || r.Options.DurationSeconds < 0 || r.Options.Streams < 0 || r.Options.MaxOffsetMS < 0 {
+		return fmt.Errorf("options must not be negative")
+	}
+	if err := validateDSCP(r.Options.DSCP); err != nil {
+		return err
+	}
+	if r.AgentPort < 0 || r.AgentPort > 65535 {
+		return fmt.Errorf("invalid agent port %d", r.AgentPort)
+	}
+	if !(testTarget{ip: r.TargetIP}).supports(r.TestType) {
+		return fmt.Errorf("%s tests do not apply to %s", r.TestType, r.TargetIP)
+	}
+	return nil
+}
+
+// RunAdHocTest runs a single test and returns its result without submitting
+// it to the aggregator. It does not affect test runs in progress.
+func (a *Agent) RunAdHocTest(ctx context.Context, req AdHocTestRequest) (TestResult, error) {
+	myIPs, err := a.getLinkIPAddressesWithMask()
+	if err != nil {
+		return TestResult{}, fmt.Errorf("failed to get local IP configuration: %w", err)
+	}
+
+	local, err := adHocSourceAddress(myIPs, req.TargetIP, req.SourceInterface)
+	if err != nil {
+		return TestResult{}, err
+	}
+
+	target := testTarget{
+		ip:              req.TargetIP,
+		sourceIP:        local.IP,
+		sourceInterface: local.BondName,
+		expectedMTU:     local.MTU,
+		agentPort:       req.AgentPort,
+	}
+	result := a.runTest(ctx, req.TestType, target, req.Options)
+	if err := ctx.Err(); err != nil {
+		return TestResult{}, err
+	}
+	return result, nil
+}
+
+// adHocSourceAddress picks the local address for an ad-hoc test. Without a
+// source interface it matches the target like a test run does. With one, it
+// prefers an address of that interface in the target's subnet, and otherwise
+// uses any address of the target's family so routed targets can be tested.
+func adHocSourceAddress(myIPs []netplan.IPWithMask, targetIP, sourceInterface string) (netplan.IPWithMask, error) {
+	if sourceInterface == "" {
+		local, ok := selectSourceAddress(myIPs, targetIP)
+		if !ok {
+			return netplan.IPWithMask{}, fmt.Errorf("no local interface in the same subnet as %s, set source_interface", targetIP)
+		}
+		return local, nil
+	}
+
+	var ifaceIPs []netplan.IPWithMask
+	for _, myIP := range myIPs {
+		if myIP.BondName == sourceInterface {
+			ifaceIPs = append(ifaceIPs, myIP)
+		}
+	}
+	if len(ifaceIPs) == 0 {
+		return netplan.IPWithMask{}, fmt.Errorf("interface %s has no IP addresses", sourceInterface)
+	}
+
+	if local, ok := selectSourceAddress(ifaceIPs, targetIP); ok {
+		return local, nil
+	}
+	targetIsV4 := net.ParseIP(targetIP).To4() != nil
+	for _, myIP := range ifaceIPs {
+		ip := net.ParseIP(myIP.IP)
+		if ip != nil && (ip.To4() != nil) == targetIsV4 {
+			return myIP, nil
+		}
+	}
+	return netplan.IPWithMask{}, fmt.Errorf("interface %s has no address of the same family as %s", sourceInterface, targetIP)
+}
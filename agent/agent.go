@@ -2,21 +2,71 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
-	"os/exec"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	"validate/auth"
 	"validate/netplan"
 	"validate/sysinfo"
 )
 
+// DefaultAgentPort is the port agents listen on unless configured otherwise
+const DefaultAgentPort = 8080
+
+// Default limits on how many targets are tested at the same time
+const (
+	DefaultMaxParallelTests             = 8
+	DefaultMaxParallelTestsPerInterface = 2
+)
+
 // Agent represents an agent that registers with an aggregator
 type Agent struct {
-	aggregatorURL string
-	httpClient    *http.Client
-	hostname      string
+	aggregatorURL           string
+	httpClient              *http.Client
+	auth                    *auth.Auth
+	hostname                string
+	listenAddr              string
+	advertiseURL            string
+	maxParallel             int
+	maxParallelPerInterface int
+	resultBatchSize         int
+	resultBatchInterval     time.Duration
+	spool                   *resultSpool // nil unless SetSpoolDir was called
+	submitAttempts          int
+	submitBackoff           time.Duration
+	breaker                 circuitBreaker
+	pacer                   *probePacer       // nil unless SetProbeRate was called
+	httpTest                TestOptions       // defaults for the http test's request
+	namespaces              map[string]string // interface -> network namespace it lives in
+	profiles                map[string]TestProfile
+
+	runMu       sync.Mutex
+	runID       int
+	cancelRun   context.CancelFunc
+	runs        sync.WaitGroup // test runs in progress, waited for by WaitForTests
+	draining    bool           // set by WaitForTests, no new runs start
+	lastRequest *TestRequest   // repeated by scheduled self-tests
+	progress    *runProgress   // current or last run
+
+	// Done once WaitForTests was called, cutting submission retries short
+	drainCtx context.Context
+	drain    context.CancelFunc
+
+	registeredHash   string        // hash of the last registration the aggregator accepted
+	registerInterval time.Duration // between registrations or heartbeats, set by StartPeriodicRegistration
+
+	lldp lldpCache // neighbors heard by StartLLDPCapture
 }
 
 // RegistrationPayload is the data sent when registering with the aggregator
@@ -24,36 +74,90 @@ type RegistrationPayload struct {
 	Hostname   string              `json:"hostname"`
 	IPAddress  string              `json:"ip_address"`
 	SystemInfo interface{}         `json:"system_info"`
-	Bonds      map[string][]string `json:"bonds"`
+	Bonds      map[string][]string `json:"bonds"`               // bond -> IPs, kept for older aggregators
+	Links      map[string][]string `json:"links,omitempty"`     // link -> IPs of every L3 interface, bonds included
+	AgentURL   string              `json:"agent_url,omitempty"` // base URL of the agent's API
+
+	// Seconds between registrations or heartbeats, 0 for agents that predate
+	// reporting it
+	RegisterInterval int `json:"register_interval,omitempty"`
+
+	Version       string                    `json:"version,omitempty"`
+	Capabilities  *Capabilities             `json:"capabilities,omitempty"`   // nil for agents that predate capability reporting
+	LLDPNeighbors map[string][]LLDPNeighbor `json:"lldp_neighbors,omitempty"` // link -> switch ports of its NICs
 }
 
 // TestRequest represents a test request from the aggregator
 type TestRequest struct {
-	Targets map[string]TargetInfo `json:"targets"`
+	Targets   map[string]TargetInfo  `json:"targets"`
+	RunID     string                 `json:"run_id,omitempty"`     // identifies the run in submitted results
+	TestTypes []string               `json:"test_types,omitempty"` // empty runs every test type
+	Options   map[string]TestOptions `json:"options,omitempty"`    // test type -> options
+	Profile   string                 `json:"profile,omitempty"`    // test profile of the agent providing test types and options
+
+	// IP addresses and http(s) URLs outside the cluster checked by external tests
+	ExternalEndpoints []string `json:"external_endpoints,omitempty"`
+	// NTP server time-sync measures the clock offset against from each uplink
+	NTPServer string `json:"ntp_server,omitempty"`
+	// Bidirectional asks each target agent to repeat every test back to this
+	// agent right after it ran, except ports and tls
+	Bidirectional bool `json:"bidirectional,omitempty"`
+	// Deduplicate tests each pair of agents in one direction only, from the
+	// agent chosen by assigned, halving the run time of a full mesh
+	Deduplicate bool `json:"deduplicate,omitempty"`
 }
 
 // TargetInfo contains information about target servers and their links
 type TargetInfo struct {
-	Links map[string][]string `json:"links"` // bond -> IPs mapping
+	Links         map[string][]string `json:"links"`                     // link -> IPs mapping
+	AgentPort     int                 `json:"agent_port,omitempty"`      // port of the target agent's API (default 8080)
+	SkipTestTypes []string            `json:"skip_test_types,omitempty"` // tests the target cannot answer, e.g. bandwidth without a sink
+	ExpectedPorts []int               `json:"expected_ports,omitempty"`  // TCP ports the ports test expects to be open
+	TLSPorts      []int               `json:"tls_ports,omitempty"`       // TCP ports the tls test performs a handshake with
 }
 
 // TestResultPayload is the result of connectivity tests
 type TestResultPayload struct {
 	SourceHostname string       `json:"source_hostname"`
+	RunID          string       `json:"run_id,omitempty"`
 	Results        []TestResult `json:"results"`
-	TestedAt       time.Time    `json:"tested_at"`
+	TestedAt       time.Time    `json:"tested_at"` // when the results were submitted
 }
 
 // TestResult represents a single connectivity test result
 type TestResult struct {
-	TargetHostname string `json:"target_hostname"`
-	TargetIP       string `json:"target_ip"`
-	SourceIP       string `json:"source_ip"`
-	BondName       string `json:"bond_name"`
-	TestType       string `json:"test_type"` // "arp" or "http"
-	Success        bool   `json:"success"`
-	ResponseTimeMS int64  `json:"response_time_ms"`
-	ErrorMessage   string `json:"error_message,omitempty"`
+	RunID           string          `json:"run_id,omitempty"`
+	SourceHostname  string          `json:"source_hostname,omitempty"` // set when another agent ran the test, as for the reverse direction of bidirectional tests
+	CorrelationID   string          `json:"correlation_id,omitempty"`  // shared by both directions of a bidirectional test
+	TargetHostname  string          `json:"target_hostname"`
+	TargetIP        string          `json:"target_ip"`
+	SourceIP        string          `json:"source_ip"`
+	BondName        string          `json:"bond_name"`
+	SourceInterface string          `json:"source_interface,omitempty"` // local interface the tests were bound to, e.g. a VLAN
+	TestType        string          `json:"test_type"`                  // one of AllTestTypes
+	Port            int             `json:"port,omitempty"`             // ports and tls only
+	Success         bool            `json:"success"`
+	ResponseTimeMS  int64           `json:"response_time_ms"`
+	RTTMinMS        float64         `json:"rtt_min_ms,omitempty"`          // arp, ndp, icmp, udp and gateway only
+	RTTAvgMS        float64         `json:"rtt_avg_ms,omitempty"`          // arp, ndp, icmp, udp and gateway only
+	RTTMaxMS        float64         `json:"rtt_max_ms,omitempty"`          // arp, ndp, icmp, udp and gateway only
+	PacketLoss      float64         `json:"packet_loss_percent,omitempty"` // arp, ndp, icmp, udp and gateway only
+	P50MS           float64         `json:"p50_ms,omitempty"`              // arp, ndp, icmp, udp and gateway only
+	P95MS           float64         `json:"p95_ms,omitempty"`              // arp, ndp, icmp, udp and gateway only
+	P99MS           float64         `json:"p99_ms,omitempty"`              // arp, ndp, icmp, udp and gateway only
+	ThroughputMbps  float64         `json:"throughput_mbps,omitempty"`     // bandwidth only
+	PathMTU         int             `json:"path_mtu,omitempty"`            // pmtu only
+	Hops            []TracerouteHop `json:"hops,omitempty"`                // traceroute only
+	SlavesUp        int             `json:"slaves_up,omitempty"`           // bond-health only
+	SlavesTotal     int             `json:"slaves_total,omitempty"`        // bond-health only
+	ClockOffsetMS   float64         `json:"clock_offset_ms,omitempty"`     // time-sync and clock-skew only
+	TLSVersion      string          `json:"tls_version,omitempty"`         // tls only
+	CertNotAfter    *time.Time      `json:"cert_not_after,omitempty"`      // tls only, expiry of the target's certificate
+	TCPRetransmits  int             `json:"tcp_retransmits,omitempty"`     // ports, tls, http and bandwidth only, from TCP_INFO
+	TCPRTTMS        float64         `json:"tcp_rtt_ms,omitempty"`          // ports, tls, http and bandwidth only, smoothed RTT from TCP_INFO
+	TCPCwnd         int             `json:"tcp_cwnd,omitempty"`            // ports, tls, http and bandwidth only, congestion window in segments
+	ErrorMessage    string          `json:"error_message,omitempty"`
+	TestedAt        time.Time       `json:"tested_at,omitzero"` // when the test finished, by the clock of the agent that ran it
 }
 
 // NewAgent creates a new agent
@@ -63,42 +167,153 @@ func NewAgent(aggregatorURL string) (*Agent, error) {
 		return nil, fmt.Errorf("failed to get hostname: %w", err)
 	}
 
+	drainCtx, drain := context.WithCancel(context.Background())
 	return &Agent{
 		aggregatorURL: aggregatorURL,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		hostname: hostname,
+		auth:                    &auth.Auth{},
+		hostname:                hostname,
+		listenAddr:              fmt.Sprintf(":%d", DefaultAgentPort),
+		maxParallel:             DefaultMaxParallelTests,
+		maxParallelPerInterface: DefaultMaxParallelTestsPerInterface,
+		resultBatchSize:         DefaultResultBatchSize,
+		resultBatchInterval:     DefaultResultBatchInterval,
+		submitAttempts:          DefaultSubmitAttempts,
+		submitBackoff:           DefaultSubmitBackoff,
+		breaker: circuitBreaker{
+			threshold: DefaultCircuitBreakerThreshold,
+			cooldown:  DefaultCircuitBreakerCooldown,
+		},
+		drainCtx: drainCtx,
+		drain:    drain,
 	}, nil
 }
 
+// SetAuth sets the credentials presented to the aggregator and other agents
+func (a *Agent) SetAuth(au *auth.Auth) {
+	a.auth = au
+	a.httpClient = au.Client(a.httpClient.Timeout)
+}
+
+// SetListenAddr sets the address the agent's API listens on, which is
+// advertised to the aggregator when registering. A non-empty advertiseURL is
+// advertised instead, for agents reached through a different address.
+func (a *Agent) SetListenAddr(listenAddr, advertiseURL string) {
+	a.listenAddr = listenAddr
+	a.advertiseURL = advertiseURL
+}
+
+// agentURL returns the base URL the aggregator should use to reach this
+// agent, whose main IP address is ipAddr
+func (a *Agent) agentURL(ipAddr string) string {
+	if a.advertiseURL != "" {
+		return a.advertiseURL
+	}
+
+	host, port, err := net.SplitHostPort(a.listenAddr)
+	if err != nil {
+		host, port = "", strconv.Itoa(DefaultAgentPort)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = ipAddr
+	}
+	return fmt.Sprintf("%s://%s", a.auth.Scheme(), net.JoinHostPort(host, port))
+}
+
+// SetParallelism limits how many targets are tested at the same time, in
+// total and through any single local interface. Values below 1 keep the
+// current limit.
+func (a *Agent) SetParallelism(total, perInterface int) {
+	if total > 0 {
+		a.maxParallel = total
+	}
+	if perInterface > 0 {
+		a.maxParallelPerInterface = perInterface
+	}
+}
+
+// SetHTTPTest sets the http test's request options used when a test request
+// leaves them unset, e.g. for agents whose API listens on another port or
+// behind HTTPS
+func (a *Agent) SetHTTPTest(opts TestOptions) error {
+	if err := (TestRequest{Options: map[string]TestOptions{TestTypeHTTP: opts}}).Validate(); err != nil {
+		return err
+	}
+	a.httpTest = opts
+	return nil
+}
+
+// SetResultBatching sets how many results are submitted together, and how
+// long a result may wait for its batch to fill up. A size of 1 submits every
+// result as soon as it is ready. Values below 1 keep the current setting.
+func (a *Agent) SetResultBatching(size int, interval time.Duration) {
+	if size > 0 {
+		a.resultBatchSize = size
+	}
+	if interval > 0 {
+		a.resultBatchInterval = interval
+	}
+}
+
 // Register registers this agent with the aggregator
 func (a *Agent) Register() error {
+	payload, err := a.registrationPayload()
+	if err != nil {
+		return err
+	}
+	if err := a.register(payload); err != nil {
+		return err
+	}
+
+	a.registeredHash = payload.hash()
+	return nil
+}
+
+// registrationPayload collects the data sent when registering
+func (a *Agent) registrationPayload() (RegistrationPayload, error) {
 	// Get system info
 	systemInfo, err := sysinfo.GetSystemInfo()
 	if err != nil {
-		return fmt.Errorf("failed to get system info: %w", err)
+		return RegistrationPayload{}, fmt.Errorf("failed to get system info: %w", err)
 	}
 
 	// Get main IP address
 	ipAddr, err := sysinfo.GetMainIPAddress()
 	if err != nil {
-		return fmt.Errorf("failed to get main IP address: %w", err)
+		return RegistrationPayload{}, fmt.Errorf("failed to get main IP address: %w", err)
 	}
 
 	// Get bond IP addresses
 	bonds, err := a.getBondIPAddresses()
 	if err != nil {
-		return fmt.Errorf("failed to get bond IP addresses: %w", err)
+		return RegistrationPayload{}, fmt.Errorf("failed to get bond IP addresses: %w", err)
 	}
 
-	payload := RegistrationPayload{
-		Hostname:   a.hostname,
-		IPAddress:  ipAddr,
-		SystemInfo: systemInfo,
-		Bonds:      bonds,
+	links, err := a.getLinkIPAddresses()
+	if err != nil {
+		return RegistrationPayload{}, fmt.Errorf("failed to get link IP addresses: %w", err)
 	}
 
+	caps := detectCapabilities(links)
+	return RegistrationPayload{
+		Hostname:      a.hostname,
+		IPAddress:     ipAddr,
+		SystemInfo:    systemInfo,
+		Bonds:         bonds,
+		Links:         links,
+		AgentURL:      a.agentURL(ipAddr),
+		Version:       Version(),
+		Capabilities:  &caps,
+		LLDPNeighbors: a.lldpNeighbors(links),
+
+		RegisterInterval: int(a.registerInterval / time.Second),
+	}, nil
+}
+
+// register sends a registration payload to the aggregator
+func (a *Agent) register(payload RegistrationPayload) error {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
@@ -118,13 +333,37 @@ func (a *Agent) Register() error {
 	return nil
 }
 
+// Deregister removes this agent from the aggregator, so it stops being shown
+// and tested as soon as the agent shuts down
+func (a *Agent) Deregister() error {
+	endpoint := fmt.Sprintf("%s/api/server/%s", a.aggregatorURL, url.PathEscape(a.hostname))
+	req, err := http.NewRequest(http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create deregistration request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deregister: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Not being registered is what deregistering is after
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("deregistration failed with status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
 // getBondIPAddresses gets all bond IP addresses from the system
 func (a *Agent) getBondIPAddresses() (map[string][]string, error) {
 	// Try to load netplan configurations
 	configs, err := netplan.LoadNetplanConfigsFromDir("/etc/netplan")
-	if err != nil {
-		// If netplan fails, return empty map (not all systems use netplan)
-		return make(map[string][]string), nil
+	if err != nil || !hasBonds(configs) {
+		// Not all systems use netplan; ask the kernel instead
+		return systemBondIPAddresses()
 	}
 
 	allBonds := make(map[string][]string)
@@ -153,177 +392,571 @@ func (a *Agent) getBondIPAddresses() (map[string][]string, error) {
 	return allBonds, nil
 }
 
-// getBondIPAddressesWithMask returns IP addresses with CIDR notation for subnet matching
-func (a *Agent) getBondIPAddressesWithMask() ([]netplan.IPWithMask, error) {
-	// Try to load netplan configurations
-	configs, err := netplan.LoadNetplanConfigsFromDir("/etc/netplan")
+// getLinkIPAddresses returns the IP addresses of every link on this host,
+// keyed by link. Bonds are links too, with the same addresses as reported by
+// getBondIPAddresses.
+func (a *Agent) getLinkIPAddresses() (map[string][]string, error) {
+	links, err := a.localLinks()
 	if err != nil {
-		// If netplan fails, return empty slice
-		return []netplan.IPWithMask{}, nil
+		return nil, err
 	}
 
-	var allIPs []netplan.IPWithMask
+	allLinks := make(map[string][]string)
+	for link, addrs := range links {
+		for _, addr := range addrs {
+			allLinks[link] = append(allLinks[link], addr.IP)
+		}
+	}
+	return allLinks, nil
+}
 
-	// Iterate through all configs and all bonds
-	for _, config := range configs {
-		if config.Network.Bonds == nil {
-			continue
+// getLinkIPAddressesWithMask returns IP addresses with CIDR notation for subnet matching
+func (a *Agent) getLinkIPAddressesWithMask() ([]netplan.IPWithMask, error) {
+	links, err := a.localLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	allIPs := []netplan.IPWithMask{}
+	for _, addrs := range links {
+		allIPs = append(allIPs, addrs...)
+	}
+	return allIPs, nil
+}
+
+// localLinks returns the addresses of every link on this host, including
+// the interfaces in the configured network namespaces
+func (a *Agent) localLinks() (map[string][]netplan.IPWithMask, error) {
+	links, err := hostLinks()
+	if err != nil {
+		return nil, err
+	}
+	if links == nil {
+		links = make(map[string][]netplan.IPWithMask)
+	}
+	for iface, addrs := range a.namespaceLinks() {
+		links[iface] = addrs
+	}
+	return links, nil
+}
+
+// hostLinks returns the addresses of every link in the agent's own network
+// namespace, from netplan or, when netplan configures none, from the running
+// system
+func hostLinks() (map[string][]netplan.IPWithMask, error) {
+	configs, err := netplan.LoadNetplanConfigsFromDir("/etc/netplan")
+	if err == nil {
+		links := make(map[string][]netplan.IPWithMask)
+		for _, config := range configs {
+			for link, addrs := range config.GetLinkIPAddressesWithMask() {
+				links[link] = append(links[link], addrs...)
+			}
+		}
+		if len(links) > 0 {
+			return links, nil
 		}
+	}
 
-		for bondName := range config.Network.Bonds {
-			bondIPs := config.GetBondIPAddressesWithMask(bondName)
-			allIPs = append(allIPs, bondIPs...)
+	// Not all systems use netplan; ask the kernel instead
+	return discoverSystemLinks()
+}
+
+// hasBonds reports whether any of the netplan configurations defines a bond
+func hasBonds(configs []*netplan.Config) bool {
+	for _, config := range configs {
+		if len(config.Network.Bonds) > 0 {
+			return true
 		}
 	}
+	return false
+}
 
-	return allIPs, nil
+// systemBondIPAddresses returns the addresses of the bonds found on the
+// running system, keyed by bond
+func systemBondIPAddresses() (map[string][]string, error) {
+	bonds, err := discoverSystemBonds()
+	if err != nil {
+		return nil, err
+	}
+
+	allBonds := make(map[string][]string)
+	for bondName, addrs := range bonds {
+		var ips []string
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		if len(ips) > 0 {
+			allBonds[bondName] = ips
+		}
+	}
+	return allBonds, nil
 }
 
-// RunConnectivityTests performs connectivity tests to the specified targets
+// RunConnectivityTests performs the requested connectivity tests to the request's targets
 // Only tests connectivity to targets where this agent has an interface in the same subnet
-// Posts results immediately after each test instead of batching
-func (a *Agent) RunConnectivityTests(targets map[string]TargetInfo) {
+// Targets are tested in parallel, bounded by the agent's parallelism limits and probe rate
+// Results are submitted in batches as tests finish, each stamped with the time it finished
+// Starting a run cancels any run still in progress
+func (a *Agent) RunConnectivityTests(ctx context.Context, req TestRequest) {
+	logger := slog.With("run_id", req.RunID)
+	ctx, done, ok := a.startTestRun(ctx)
+	if !ok {
+		logger.Info("Agent is shutting down, connectivity tests not run")
+		return
+	}
+	defer done()
+	a.rememberRequest(req)
+
+	targets := req.Targets
+
 	// Get this agent's IP addresses with CIDR notation for subnet matching
-	myIPs, err := a.getBondIPAddressesWithMask()
+	myIPs, err := a.getLinkIPAddressesWithMask()
 	if err != nil {
-		fmt.Printf("Warning: Failed to get local IP configuration: %v\n", err)
+		logger.Warn("Failed to get local IP configuration", "error", err)
 		myIPs = []netplan.IPWithMask{}
 	}
 
-	fmt.Printf("Starting connectivity tests to %d targets\n", len(targets))
-	fmt.Printf("My IPs with subnets: ")
+	subnets := make([]string, 0, len(myIPs))
 	for _, ip := range myIPs {
-		fmt.Printf("%s ", ip.CIDR)
+		subnets = append(subnets, ip.CIDR)
 	}
-	fmt.Printf("\n")
+	logger.Info("Starting connectivity tests", "targets", len(targets), "local_subnets", subnets)
 
-	testCount := 0
+	// Match every target IP to a local interface first, then test them in parallel
+	var jobs []testTarget
 	for targetHostname, targetInfo := range targets {
+		if !req.assigned(a.hostname, targetHostname) {
+			logger.Debug("Skipping target tested by its own agent", "target", targetHostname)
+			continue
+		}
 		for bondName, ips := range targetInfo.Links {
-			fmt.Printf("Checking %s via bond %s (%d IPs)\n", targetHostname, bondName, len(ips))
+			logger.Debug("Checking target link", "target", targetHostname, "bond", bondName, "ips", len(ips))
 
 			for _, targetIP := range ips {
 				// Check if this agent has an IP in the same subnet as the target
-				inSameSubnet := false
-				var matchingLocalIP string
-				var matchingInterface string
-
-				for _, myIP := range myIPs {
-					if netplan.InSameSubnet(myIP.CIDR, targetIP) {
-						inSameSubnet = true
-						matchingLocalIP = myIP.IP
-						matchingInterface = myIP.BondName
-						break
-					}
-				}
-
-				if !inSameSubnet {
-					fmt.Printf("  Skipping %s - no local interface in same subnet\n", targetIP)
+				local, ok := selectSourceAddress(myIPs, targetIP)
+				if !ok {
+					logger.Debug("Skipping target IP without a local interface in its subnet",
+						"target", targetHostname, "bond", bondName, "target_ip", targetIP)
 					continue
 				}
 
-				fmt.Printf("  Testing %s (local IP %s on %s is in same subnet)\n", targetIP, matchingLocalIP, matchingInterface)
-				results := a.testConnectivity(targetHostname, targetIP, bondName, matchingLocalIP, matchingInterface)
-
-				// Submit each result immediately (ARP and HTTP)
-				for _, result := range results {
-					fmt.Printf("  -> %s [%s]: %vms (success=%v)\n", targetIP, result.TestType, result.ResponseTimeMS, result.Success)
-					if err := a.SubmitSingleTestResult(result); err != nil {
-						fmt.Printf("  Failed to submit %s result: %v\n", result.TestType, err)
-					} else {
-						testCount++
-					}
-				}
+				jobs = append(jobs, testTarget{
+					hostname:        targetHostname,
+					ip:              targetIP,
+					bondName:        bondName,
+					sourceIP:        local.IP,
+					sourceInterface: local.BondName,
+					expectedMTU:     local.MTU,
+					agentPort:       targetInfo.AgentPort,
+					skipTestTypes:   targetInfo.SkipTestTypes,
+					expectedPorts:   targetInfo.ExpectedPorts,
+					tlsPorts:        targetInfo.TLSPorts,
+				})
 			}
 		}
 	}
 
-	fmt.Printf("Completed and submitted %d connectivity tests\n", testCount)
-}
+	// Bond health is checked once per local bond rather than per target
+	var bonds []string
+	if req.enabled(TestTypeBondHealth) {
+		if bonds, err = localBonds(); err != nil {
+			logger.Warn("Failed to list local bonds", "error", err)
+		}
+	}
 
-// SubmitSingleTestResult submits a single test result immediately to the aggregator
-func (a *Agent) SubmitSingleTestResult(result TestResult) error {
-	// Wrap the single result in an array and reuse existing SubmitTestResults
-	return a.SubmitTestResults([]TestResult{result})
+	// Gateways are probed once each from the interface they are configured
+	// on, and external endpoints and the NTP server from each interface with
+	// a gateway
+	var (
+		gateways   []gatewayTarget
+		externals  []externalTarget
+		ntpServers []externalTarget
+	)
+	wantExternal := req.enabled(TestTypeExternal) && len(req.ExternalEndpoints) > 0
+	wantNTP := req.enabled(TestTypeTimeSync) && req.NTPServer != ""
+	if req.enabled(TestTypeGateway) || wantExternal || wantNTP {
+		if gateways, err = localGateways(); err != nil {
+			logger.Warn("Failed to list configured gateways", "error", err)
+		}
+	}
+	if wantExternal {
+		externals = externalTargets(req.ExternalEndpoints, gateways)
+	}
+	if wantNTP {
+		ntpServers = externalTargets([]string{req.NTPServer}, gateways)
+	}
+	if !req.enabled(TestTypeGateway) {
+		gateways = nil
+	}
+
+	total := len(bonds) + len(gateways) + len(externals) + len(ntpServers)
+	if req.enabled(TestTypeTimeSync) {
+		// The local clock's synchronization status
+		total++
+	}
+	for _, job := range jobs {
+		total += plannedTests(job, req)
+	}
+	progress := a.startProgress(req.RunID, total)
+
+	// Each job holds a slot on its interface before taking a global slot,
+	// so jobs queued on a busy interface do not block other interfaces
+	global := make(chan struct{}, a.maxParallel)
+	perInterface := make(map[string]chan struct{})
+	for _, job := range jobs {
+		if _, ok := perInterface[job.sourceInterface]; !ok {
+			perInterface[job.sourceInterface] = make(chan struct{}, a.maxParallelPerInterface)
+		}
+	}
+
+	batch := a.newResultBatcher()
+	for _, bond := range bonds {
+		if ctx.Err() != nil {
+			break
+		}
+		result := a.testBondHealth(bond)
+		result.RunID = req.RunID
+		logger.Info("Test finished", "bond", bond, "test_type", result.TestType, "success", result.Success)
+		batch.add(result)
+		progress.complete()
+	}
+	if req.enabled(TestTypeTimeSync) {
+		result := a.testClockSync(ctx, req.Options[TestTypeTimeSync])
+		result.RunID = req.RunID
+		logger.Info("Test finished", "test_type", result.TestType, "clock_offset_ms", result.ClockOffsetMS, "success", result.Success)
+		batch.add(result)
+		progress.complete()
+	}
+	if len(gateways) > 0 || len(externals) > 0 || len(ntpServers) > 0 {
+		links, err := hostLinks()
+		if err != nil {
+			logger.Warn("Failed to get local links", "error", err)
+		}
+		for _, gw := range gateways {
+			if a.pacer.wait(ctx, gw.iface, probeCost(TestTypeGateway, req.Options[TestTypeGateway])) != nil {
+				break
+			}
+			result := a.testGateway(ctx, gw, links, req.Options[TestTypeGateway])
+			result.RunID = req.RunID
+			logger.Info("Test finished", "interface", gw.iface, "gateway", gw.gateway, "test_type", result.TestType, "success", result.Success)
+			batch.add(result)
+			progress.complete()
+		}
+		for _, ext := range externals {
+			probes := 1
+			if net.ParseIP(ext.endpoint) != nil {
+				probes = probeCost(TestTypeICMP, req.Options[TestTypeExternal])
+			}
+			if a.pacer.wait(ctx, ext.iface, probes) != nil {
+				break
+			}
+			result := a.testExternal(ctx, ext, links, req.Options[TestTypeExternal])
+			result.RunID = req.RunID
+			logger.Info("Test finished", "interface", ext.iface, "endpoint", ext.endpoint, "test_type", result.TestType, "success", result.Success)
+			batch.add(result)
+			progress.complete()
+		}
+		for _, ntp := range ntpServers {
+			if a.pacer.wait(ctx, ntp.iface, 1) != nil {
+				break
+			}
+			result := a.testNTPOffset(ctx, ntp, links, req.Options[TestTypeTimeSync])
+			result.RunID = req.RunID
+			logger.Info("Test finished", "interface", ntp.iface, "ntp_server", ntp.endpoint, "test_type", result.TestType,
+				"clock_offset_ms", result.ClockOffsetMS, "success", result.Success)
+			batch.add(result)
+			progress.complete()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(target testTarget) {
+			defer wg.Done()
+
+			ifaceSlots := perInterface[target.sourceInterface]
+			select {
+			case ifaceSlots <- struct{}{}:
+				defer func() { <-ifaceSlots }()
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case global <- struct{}{}:
+				defer func() { <-global }()
+			case <-ctx.Done():
+				return
+			}
+
+			targetLogger := logger.With("target", target.hostname, "bond", target.bondName, "target_ip", target.ip)
+			targetLogger.Debug("Testing target", "source_ip", target.sourceIP, "source_interface", target.sourceInterface)
+			results := a.testConnectivity(ctx, target, req, progress)
+
+			for _, result := range results {
+				result.RunID = req.RunID
+				targetLogger.Info("Test finished", "test_type", result.TestType,
+					"response_time_ms", result.ResponseTimeMS, "success", result.Success)
+				batch.add(result)
+			}
+		}(job)
+	}
+	wg.Wait()
+	testCount := batch.close()
+	progress.finish(ctx.Err() != nil)
+
+	if ctx.Err() != nil {
+		logger.Info("Connectivity tests cancelled", "submitted", testCount)
+		return
+	}
+	logger.Info("Connectivity tests completed", "submitted", testCount)
 }
 
-// testConnectivity tests connectivity to a specific IP address using both arping and HTTP
-// Returns two results: one for ARP test and one for HTTP test
-func (a *Agent) testConnectivity(targetHostname, targetIP, bondName, sourceIP, sourceInterface string) []TestResult {
-	var results []TestResult
+// selectSourceAddress picks the local address to test targetIP from. When
+// several interfaces, such as VLANs on the same bond, share a subnet with the
+// target, the one with the most specific prefix wins, and ties go to the
+// interface whose name sorts first so the choice is stable across runs.
+func selectSourceAddress(myIPs []netplan.IPWithMask, targetIP string) (netplan.IPWithMask, bool) {
+	var (
+		best    netplan.IPWithMask
+		bestLen = -1
+	)
+	for _, myIP := range myIPs {
+		if !netplan.InSameSubnet(myIP.CIDR, targetIP) || myIP.IPNet == nil {
+			continue
+		}
+		ones, _ := myIP.IPNet.Mask.Size()
+		if ones > bestLen || (ones == bestLen && myIP.BondName < best.BondName) {
+			best, bestLen = myIP, ones
+		}
+	}
+	return best, bestLen >= 0
+}
 
-	// Test 1: ARP connectivity
-	arpResult := TestResult{
-		TargetHostname: targetHostname,
-		TargetIP:       targetIP,
-		SourceIP:       sourceIP,
-		BondName:       bondName,
-		TestType:       "arp",
+// startTestRun cancels the test run in progress, if any, and returns the
+// context for a new run along with the function to call when it finishes.
+// It reports false, starting nothing, once WaitForTests was called.
+func (a *Agent) startTestRun(parent context.Context) (context.Context, func(), bool) {
+	a.runMu.Lock()
+	if a.draining {
+		a.runMu.Unlock()
+		return nil, nil, false
 	}
+	ctx, cancel := context.WithCancel(parent)
+	if a.cancelRun != nil {
+		slog.Info("Cancelling previous connectivity test run")
+		a.cancelRun()
+	}
+	a.runID++
+	id := a.runID
+	a.cancelRun = cancel
+	a.runs.Add(1)
+	a.runMu.Unlock()
+
+	return ctx, func() {
+		cancel()
+		a.runMu.Lock()
+		if a.runID == id {
+			a.cancelRun = nil
+		}
+		a.runMu.Unlock()
+		a.runs.Done()
+	}, true
+}
 
-	arpStart := time.Now()
-	arpCmd := exec.Command("arping", "-W", "0.5", "-c", "3", "-I", sourceInterface, targetIP)
-	arpErr := arpCmd.Run()
-	arpElapsed := time.Since(arpStart)
+// WaitForTests stops new test runs from starting and waits for the runs in
+// progress to submit or spool their results, or for ctx to be done. Failed
+// submissions are spooled without waiting to retry them. It reports whether
+// all runs finished.
+func (a *Agent) WaitForTests(ctx context.Context) bool {
+	a.runMu.Lock()
+	a.draining = true
+	a.runMu.Unlock()
+	a.drain()
+
+	finished := make(chan struct{})
+	go func() {
+		a.runs.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	arpResult.ResponseTimeMS = arpElapsed.Milliseconds()
-	if arpErr != nil {
-		arpResult.Success = false
-		arpResult.ErrorMessage = fmt.Sprintf("ARP ping failed: %v", arpErr)
-	} else {
-		arpResult.Success = true
+// rememberRequest keeps the request for scheduled self-tests
+func (a *Agent) rememberRequest(req TestRequest) {
+	if len(req.Targets) == 0 {
+		return
 	}
-	results = append(results, arpResult)
+	req.RunID = ""
 
-	// Test 2: HTTP connectivity (always run, regardless of ARP result)
-	httpResult := TestResult{
-		TargetHostname: targetHostname,
-		TargetIP:       targetIP,
-		SourceIP:       sourceIP,
-		BondName:       bondName,
-		TestType:       "http",
+	a.runMu.Lock()
+	a.lastRequest = &req
+	a.runMu.Unlock()
+}
+
+// CancelTests aborts the test run in progress and reports whether there was one
+func (a *Agent) CancelTests() bool {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	if a.cancelRun == nil {
+		return false
 	}
+	a.cancelRun()
+	a.cancelRun = nil
+	return true
+}
 
-	url := fmt.Sprintf("http://%s:8080/api/sysinfo", targetIP)
+// SubmitSingleTestResult submits a single test result immediately to the aggregator
+func (a *Agent) SubmitSingleTestResult(result TestResult) error {
+	// Wrap the single result in an array and reuse existing SubmitTestResults
+	return a.SubmitTestResults([]TestResult{result})
+}
 
-	httpStart := time.Now()
-	resp, err := a.httpClient.Get(url)
-	httpElapsed := time.Since(httpStart)
+// testConnectivity runs the requested test types against a single target IP
+// and returns one result for each, or for each port of the ports and tls
+// tests, followed by the reverse direction's result for bidirectional
+// requests. The traceroute only runs if the target
+// failed one of the reachability tests, or if it is the only test requested.
+// Once ctx is cancelled no further tests are started, and the result of a
+// test interrupted by the cancellation is dropped. Finished tests are counted
+// in progress as they complete.
+func (a *Agent) testConnectivity(ctx context.Context, target testTarget, req TestRequest, progress *runProgress) []TestResult {
+	var results []TestResult
+	reachable := true
+	ranReachability := false
 
-	httpResult.ResponseTimeMS = httpElapsed.Milliseconds()
+	for _, testType := range AllTestTypes {
+		if testType == TestTypeTraceroute || !req.enabled(testType) || !target.supports(testType) {
+			continue
+		}
+		if testType == TestTypePorts || testType == TestTypeTLS {
+			for _, port := range target.ports(testType) {
+				if a.pacer.wait(ctx, target.sourceInterface, 1) != nil {
+					return results
+				}
+				result := a.runPortTest(ctx, testType, target, port, req.Options[testType])
+				if ctx.Err() != nil {
+					return results
+				}
+				results = append(results, result)
+				progress.complete()
+			}
+			continue
+		}
+		result := a.runTest(ctx, testType, target, req.Options[testType])
+		if ctx.Err() != nil {
+			return results
+		}
+		if slices.Contains(reachabilityTestTypes, testType) {
+			ranReachability = true
+			reachable = reachable && result.Success
+		}
+		progress.complete()
+		if reverse, ok := a.reverseTest(ctx, &result, target, req, progress); ok {
+			results = append(results, result, reverse)
+		} else {
+			results = append(results, result)
+		}
+	}
 
-	if err != nil {
-		httpResult.Success = false
-		httpResult.ErrorMessage = err.Error()
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode == http.StatusOK {
-			httpResult.Success = true
+	if req.enabled(TestTypeTraceroute) && (!reachable || !ranReachability) {
+		if ranReachability {
+			// Not counted by plannedTests
+			progress.extend()
+			if req.Bidirectional {
+				progress.extend()
+			}
+		}
+		result := a.runTest(ctx, TestTypeTraceroute, target, req.Options[TestTypeTraceroute])
+		if ctx.Err() != nil {
+			return results
+		}
+		progress.complete()
+		if reverse, ok := a.reverseTest(ctx, &result, target, req, progress); ok {
+			results = append(results, result, reverse)
 		} else {
-			httpResult.Success = false
-			httpResult.ErrorMessage = fmt.Sprintf("HTTP status %d", resp.StatusCode)
+			results = append(results, result)
 		}
 	}
-	results = append(results, httpResult)
 
 	return results
 }
 
-// SubmitTestResults submits test results back to the aggregator
+// reverseTest runs the reverse direction of a test for bidirectional
+// requests, and ties both results together with a correlation ID. It reports
+// false when the request is not bidirectional, the test only runs one way or
+// the run was cancelled.
+func (a *Agent) reverseTest(ctx context.Context, forward *TestResult, target testTarget, req TestRequest, progress *runProgress) (TestResult, bool) {
+	if !req.Bidirectional || !reversible(forward.TestType) {
+		return TestResult{}, false
+	}
+
+	forward.CorrelationID = newCorrelationID()
+	result := a.runReverseTest(ctx, forward.TestType, target, req.Options[forward.TestType])
+	if ctx.Err() != nil {
+		forward.CorrelationID = ""
+		return TestResult{}, false
+	}
+	result.CorrelationID = forward.CorrelationID
+	progress.complete()
+	return result, true
+}
+
+// setProbeStats records the loss, round trip times and latency percentiles
+// of a probe series
+func (r *TestResult) setProbeStats(stats *pingStats) {
+	r.ResponseTimeMS = stats.Avg.Milliseconds()
+	r.RTTMinMS = durationMS(stats.Min)
+	r.RTTAvgMS = durationMS(stats.Avg)
+	r.RTTMaxMS = durationMS(stats.Max)
+	r.PacketLoss = stats.LossPercent()
+	r.P50MS = durationMS(stats.Percentile(50))
+	r.P95MS = durationMS(stats.Percentile(95))
+	r.P99MS = durationMS(stats.Percentile(99))
+}
+
+// SubmitTestResults submits test results back to the aggregator, retrying
+// failed attempts. Results that still cannot be delivered, or that are held
+// back by the circuit breaker, are spooled if a spool is set.
 func (a *Agent) SubmitTestResults(results []TestResult) error {
 	payload := TestResultPayload{
 		SourceHostname: a.hostname,
 		Results:        results,
 		TestedAt:       time.Now(),
 	}
+	if len(results) > 0 {
+		payload.RunID = results[0].RunID
+	}
+
+	err := a.submitWithRetry(a.drainCtx, payload)
+	if err == nil || a.spool == nil || errors.Is(err, errResultsRejected) {
+		return err
+	}
+
+	// Keep the results for StartResultRetry to deliver later
+	if spoolErr := a.spool.add(payload); spoolErr != nil {
+		return fmt.Errorf("%w (and failed to spool them: %v)", err, spoolErr)
+	}
+	slog.Warn("Spooled test results for retry", "run_id", payload.RunID, "results", len(results), "error", err)
+	return nil
+}
 
+// postResults sends a result payload to the aggregator
+func (a *Agent) postResults(payload TestResultPayload) error {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal results: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/api/test-results", a.aggregatorURL)
-	fmt.Printf("Submitting %d test results to %s\n", len(results), url)
+	slog.Debug("Submitting test results", "run_id", payload.RunID, "results", len(payload.Results), "url", url)
 
 	resp, err := a.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -331,11 +964,14 @@ func (a *Agent) SubmitTestResults(results []TestResult) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: status %d", errResultsRejected, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		return fmt.Errorf("submission failed with status %d", resp.StatusCode)
 	}
 
-	fmt.Printf("Successfully submitted test results, got status %d\n", resp.StatusCode)
+	slog.Debug("Submitted test results", "run_id", payload.RunID, "status", resp.StatusCode)
 
 	return nil
 }
@@ -344,24 +980,23 @@ func (a *Agent) SubmitTestResults(results []TestResult) error {
 func (a *Agent) StartPeriodicRegistration(interval time.Duration, stopChan <-chan struct{}) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	a.registerInterval = interval
 
 	// Register immediately
 	if err := a.Register(); err != nil {
-		fmt.Printf("Initial registration failed: %v\n", err)
+		slog.Error("Initial registration failed", "error", err)
 	} else {
-		fmt.Printf("Successfully registered with aggregator at %s\n", a.aggregatorURL)
+		slog.Info("Registered with aggregator", "url", a.aggregatorURL)
 	}
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := a.Register(); err != nil {
-				fmt.Printf("Registration failed: %v\n", err)
-			} else {
-				fmt.Printf("Registration renewed at %s\n", time.Now().Format(time.RFC3339))
+			if err := a.renewRegistration(); err != nil {
+				slog.Error("Registration failed", "error", err)
 			}
 		case <-stopChan:
-			fmt.Println("Stopping periodic registration")
+			slog.Info("Stopping periodic registration")
 			return
 		}
 	}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+const (
+	arpProbeCount   = 3
+	arpProbeTimeout = 500 * time.Millisecond
+)
+
+// errPacketSocketUnavailable is returned when ARP requests cannot be sent
+// natively, either because the platform has no packet sockets or because the
+// agent lacks CAP_NET_RAW
+var errPacketSocketUnavailable = errors.New("packet socket unavailable")
+
+// arpPing probes targetIP with count ARP requests sent from sourceInterface and
+// succeeds if any of them is answered. Requests are sent natively; the arping
+// binary is only used when packet sockets are unavailable, in which case no
+// probe statistics are returned.
+func arpPing(ctx context.Context, sourceInterface, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	stats, err := sendARPProbes(ctx, sourceInterface, sourceIP, targetIP, count, timeout)
+	if errors.Is(err, errPacketSocketUnavailable) {
+		return nil, arpingBinary(ctx, sourceInterface, targetIP, count, timeout, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stats.Received == 0 {
+		return nil, fmt.Errorf("no replies to %d ARP requests", stats.Sent)
+	}
+	return stats, nil
+}
+
+// arpingBinary probes targetIP by running the arping binary
+func arpingBinary(ctx context.Context, sourceInterface, targetIP string, count int, timeout time.Duration, nativeErr error) error {
+	path, err := exec.LookPath("arping")
+	if err != nil {
+		return fmt.Errorf("native ARP unavailable (%v) and arping is not installed", nativeErr)
+	}
+
+	return exec.CommandContext(ctx, path,
+		"-W", fmt.Sprintf("%g", timeout.Seconds()),
+		"-c", fmt.Sprintf("%d", count),
+		"-I", sourceInterface, targetIP).Run()
+}
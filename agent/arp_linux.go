@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	arpOpRequest = 1
+	arpOpReply   = 2
+	arpPacketLen = 28
+)
+
+// sendARPProbes sends count ARP requests for targetIP out of ifaceName, one at
+// a time, and returns the loss and round trip times of the replies received
+func sendARPProbes(ctx context.Context, ifaceName, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	source := net.ParseIP(sourceIP).To4()
+	target := net.ParseIP(targetIP).To4()
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("ARP requires IPv4 addresses, got %q -> %q", sourceIP, targetIP)
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", ifaceName, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("interface %s has no Ethernet address", ifaceName)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPacketSocketUnavailable, err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to bind to %s: %w", ifaceName, err)
+	}
+
+	// Poll in short intervals so each probe can stop at its own deadline
+	poll := syscall.NsecToTimeval((50 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &poll); err != nil {
+		return nil, fmt.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	broadcast := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	request := arpRequest(iface.HardwareAddr, source, target)
+
+	stats := &pingStats{}
+	buf := make([]byte, 128)
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stats.Sent++
+		start := time.Now()
+		if err := syscall.Sendto(fd, request, 0, broadcast); err != nil {
+			return nil, fmt.Errorf("failed to send ARP request: %w", err)
+		}
+
+		deadline := probeDeadline(ctx, start, timeout)
+		for time.Now().Before(deadline) {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+			if isARPReply(buf[:n], source, target) {
+				stats.record(time.Since(start))
+				break
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// arpRequest builds an Ethernet/IPv4 ARP request asking who has target
+func arpRequest(mac net.HardwareAddr, source, target net.IP) []byte {
+	pkt := make([]byte, arpPacketLen)
+	binary.BigEndian.PutUint16(pkt[0:], 1)      // hardware type: Ethernet
+	binary.BigEndian.PutUint16(pkt[2:], 0x0800) // protocol type: IPv4
+	pkt[4] = 6
+	pkt[5] = 4
+	binary.BigEndian.PutUint16(pkt[6:], arpOpRequest)
+	copy(pkt[8:14], mac)
+	copy(pkt[14:18], source)
+	copy(pkt[24:28], target)
+	return pkt
+}
+
+// isARPReply reports whether pkt is target answering an ARP request from source
+func isARPReply(pkt []byte, source, target net.IP) bool {
+	if len(pkt) < arpPacketLen || binary.BigEndian.Uint16(pkt[6:]) != arpOpReply {
+		return false
+	}
+	return bytes.Equal(pkt[14:18], target) && bytes.Equal(pkt[24:28], source)
+}
+
+// htons converts a 16-bit value to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
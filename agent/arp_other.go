@@ -0,0 +1,15 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// sendARPProbes is only implemented on Linux, other platforms use arping
+func sendARPProbes(ctx context.Context, ifaceName, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	return nil, fmt.Errorf("%w on %s", errPacketSocketUnavailable, runtime.GOOS)
+}
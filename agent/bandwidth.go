@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	bandwidthTestDuration = 5 * time.Second
+
+	// Several parallel streams give LACP's layer3+4 hashing a chance to
+	// spread the load over more than one bond member
+	bandwidthStreams = 4
+)
+
+// ThroughputSinkResponse reports how much data the throughput sink received
+type ThroughputSinkResponse struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// HandleThroughputSink discards the request body and reports its size. It is
+// the receiving end of the bandwidth test.
+func HandleThroughputSink(w http.ResponseWriter, r *http.Request) {
+	// Streams can outlast the server's read timeout, which would cut them
+	// off mid-test
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read stream: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ThroughputSinkResponse{Bytes: n})
+}
+
+// timedReader produces zeros until its deadline passes
+type timedReader struct {
+	deadline time.Time
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	if time.Now().After(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+// newBoundHTTPClient returns an HTTP client whose connections originate from
+// sourceIP on sourceInterface, so requests leave through that interface
+// rather than whichever one the routing table picks
+func (a *Agent) newBoundHTTPClient(sourceInterface, sourceIP string, timeout time.Duration) (*http.Client, error) {
+	source := net.ParseIP(sourceIP)
+	if source == nil {
+		return nil, fmt.Errorf("invalid source IP %q", sourceIP)
+	}
+
+	// These clients only carry test traffic, so the peer's certificate is
+	// not verified; that would require a SAN for every bond address
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:       a.namespaceDialer(sourceInterface, boundDialer(sourceInterface, source)),
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}, nil
+}
+
+// boundDialer returns a dialer whose connections originate from source on
+// sourceInterface
+func boundDialer(sourceInterface string, source net.IP) *net.Dialer {
+	return &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: source},
+		Timeout:   5 * time.Second,
+		Control:   deviceControl(sourceInterface),
+	}
+}
+
+// measureBandwidth streams data from sourceIP on sourceInterface to the
+// throughput sink at url for the given duration and returns the throughput
+// in Mbps, along with the streams' TCP statistics if they are available
+func (a *Agent) measureBandwidth(ctx context.Context, sourceInterface, sourceIP, url string, duration time.Duration, streams int) (float64, *tcpStats, error) {
+	client, err := a.newBoundHTTPClient(sourceInterface, sourceIP, duration+10*time.Second)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer client.CloseIdleConnections()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		total    int64
+		firstErr error
+		stats    tcpStats
+		measured int // streams with TCP statistics
+	)
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, streamStats, ok, err := sendThroughputStream(ctx, client, url, deadline)
+
+			mu.Lock()
+			defer mu.Unlock()
+			total += n
+			if ok {
+				stats.retransmits += streamStats.retransmits
+				stats.rtt += streamStats.rtt
+				stats.cwnd += streamStats.cwnd
+				measured++
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if firstErr != nil {
+		return 0, nil, firstErr
+	}
+
+	// Retransmits add up over the streams, while the round trip time and
+	// congestion window are averaged
+	mbps := float64(total) * 8 / elapsed.Seconds() / 1e6
+	if measured == 0 {
+		return mbps, nil, nil
+	}
+	stats.rtt /= time.Duration(measured)
+	stats.cwnd /= measured
+	return mbps, &stats, nil
+}
+
+// sendThroughputStream posts a stream of data until deadline and returns the
+// number of bytes the sink acknowledged, and the TCP statistics of the
+// stream's connection if they are available
+func sendThroughputStream(ctx context.Context, client *http.Client, url string, deadline time.Time) (int64, tcpStats, bool, error) {
+	var tracer connTracer
+	req, err := http.NewRequestWithContext(tracer.context(ctx), http.MethodPost, url, &timedReader{deadline: deadline})
+	if err != nil {
+		return 0, tcpStats{}, false, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, tcpStats{}, false, err
+	}
+	defer resp.Body.Close()
+	stats, ok := tracer.stats()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, stats, ok, fmt.Errorf("throughput sink returned HTTP status %d", resp.StatusCode)
+	}
+
+	var sink ThroughputSinkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sink); err != nil {
+		return 0, stats, ok, fmt.Errorf("invalid throughput sink response: %w", err)
+	}
+	return sink.Bytes, stats, ok, nil
+}
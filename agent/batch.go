@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default batching of submitted results
+const (
+	DefaultResultBatchSize     = 50
+	DefaultResultBatchInterval = 2 * time.Second
+)
+
+// resultBatcher collects the results of a test run and submits them in
+// batches, once size results are pending or interval has passed since the
+// oldest pending result, whichever comes first
+type resultBatcher struct {
+	agent    *Agent
+	size     int
+	interval time.Duration
+
+	mu        sync.Mutex
+	pending   []TestResult
+	timer     *time.Timer
+	inFlight  sync.WaitGroup
+	submitted atomic.Int64
+}
+
+// newResultBatcher returns a batcher using the agent's batching settings
+func (a *Agent) newResultBatcher() *resultBatcher {
+	return &resultBatcher{
+		agent:    a,
+		size:     a.resultBatchSize,
+		interval: a.resultBatchInterval,
+	}
+}
+
+// add queues a result, submitting the batch in the background if it is
+// full, so the test that finished does not wait for the aggregator. Results
+// not yet stamped with the time their test finished are stamped now.
+func (b *resultBatcher) add(result TestResult) {
+	if result.TestedAt.IsZero() {
+		result.TestedAt = time.Now()
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, result)
+	if len(b.pending) < b.size {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, b.flush)
+		}
+		b.mu.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mu.Unlock()
+
+	go b.submit(batch)
+}
+
+// flush submits the pending results, if any
+func (b *resultBatcher) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.submit(batch)
+	}
+}
+
+// close submits the remaining results and waits for all submissions to end.
+// It returns the number of results submitted successfully.
+func (b *resultBatcher) close() int64 {
+	b.flush()
+	b.inFlight.Wait()
+	return b.submitted.Load()
+}
+
+// take removes and returns the pending results. It must be called with
+// b.mu held, and registers the submission that follows with inFlight.
+func (b *resultBatcher) take() []TestResult {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	if len(batch) > 0 {
+		b.inFlight.Add(1)
+	}
+	return batch
+}
+
+// submit sends a batch taken with take
+func (b *resultBatcher) submit(batch []TestResult) {
+	defer b.inFlight.Done()
+
+	if err := b.agent.SubmitTestResults(batch); err != nil {
+		slog.Error("Failed to submit test results", "run_id", batch[0].RunID, "results", len(batch), "error", err)
+		return
+	}
+	b.submitted.Add(int64(len(batch)))
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAggregator records the result payloads an agent submits, answering
+// them with status
+type testAggregator struct {
+	status int
+
+	mu       sync.Mutex
+	payloads []TestResultPayload
+}
+
+func (g *testAggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload TestResultPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.mu.Lock()
+	g.payloads = append(g.payloads, payload)
+	g.mu.Unlock()
+	w.WriteHeader(g.status)
+}
+
+// received returns the payloads received so far
+func (g *testAggregator) received() []TestResultPayload {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.payloads)
+}
+
+// batchSizes returns the number of results in each payload received,
+// smallest first, as batches are submitted concurrently
+func (g *testAggregator) batchSizes() []int {
+	var sizes []int
+	for _, payload := range g.received() {
+		sizes = append(sizes, len(payload.Results))
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// newTestAgent returns an agent submitting results to an aggregator
+// answering with status, once and without retrying
+func newTestAgent(t *testing.T, status int) (*Agent, *testAggregator) {
+	t.Helper()
+	aggregator := &testAggregator{status: status}
+	server := httptest.NewServer(aggregator)
+	t.Cleanup(server.Close)
+
+	a, err := NewAgent(server.URL)
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	a.SetSubmitRetry(1, time.Millisecond)
+	return a, aggregator
+}
+
+func TestResultBatcherClose(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		size          int
+		results       int
+		wantBatches   []int
+		wantSubmitted int64
+	}{
+		{"nothing to submit", http.StatusOK, 3, 0, nil, 0},
+		{"partial batch", http.StatusOK, 10, 4, []int{4}, 4},
+		{"full batches", http.StatusOK, 3, 6, []int{3, 3}, 6},
+		{"full and partial batches", http.StatusOK, 3, 7, []int{1, 3, 3}, 7},
+		{"rejected", http.StatusBadRequest, 3, 4, []int{1, 3}, 0},
+		{"aggregator failing", http.StatusInternalServerError, 3, 2, []int{2}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, aggregator := newTestAgent(t, tt.status)
+			a.SetResultBatching(tt.size, time.Hour)
+
+			batch := a.newResultBatcher()
+			for i := 0; i < tt.results; i++ {
+				batch.add(TestResult{RunID: "run-1", TestType: TestTypeICMP})
+			}
+			submitted := batch.close()
+
+			if submitted != tt.wantSubmitted {
+				t.Errorf("close() = %d, want %d", submitted, tt.wantSubmitted)
+			}
+			if got := aggregator.batchSizes(); !slices.Equal(got, tt.wantBatches) {
+				t.Errorf("batches = %v, want %v", got, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func TestResultBatcherFlushesAfterInterval(t *testing.T) {
+	a, aggregator := newTestAgent(t, http.StatusOK)
+	a.SetResultBatching(10, 10*time.Millisecond)
+
+	batch := a.newResultBatcher()
+	batch.add(TestResult{RunID: "run-1", TestType: TestTypeICMP})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(aggregator.batchSizes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending result was not submitted after the batch interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if submitted := batch.close(); submitted != 1 {
+		t.Errorf("close() = %d, want 1", submitted)
+	}
+	if got := aggregator.batchSizes(); !slices.Equal(got, []int{1}) {
+		t.Errorf("batches = %v, want [1]", got)
+	}
+}
+
+func TestResultBatcherStampsResults(t *testing.T) {
+	a, aggregator := newTestAgent(t, http.StatusOK)
+	a.SetResultBatching(10, time.Hour)
+
+	finished := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	before := time.Now()
+	batch := a.newResultBatcher()
+	batch.add(TestResult{TestType: TestTypeICMP, TestedAt: finished})
+	batch.add(TestResult{TestType: TestTypeUDP})
+	batch.close()
+
+	payloads := aggregator.received()
+	if len(payloads) != 1 || len(payloads[0].Results) != 2 {
+		t.Fatalf("payloads = %v, want one with 2 results", payloads)
+	}
+	results := payloads[0].Results
+	if !results[0].TestedAt.Equal(finished) {
+		t.Errorf("tested_at = %v, want the time the test finished, %v", results[0].TestedAt, finished)
+	}
+	if results[1].TestedAt.Before(before) {
+		t.Errorf("tested_at = %v, want the time it was added", results[1].TestedAt)
+	}
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindConn binds an open socket to the named interface, so its packets leave
+// through that interface even when another one has a route to the target
+func bindConn(conn interface{}, iface string) error {
+	if iface == "" {
+		return nil
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("cannot bind %T to an interface", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return bindRawConn(raw, iface)
+}
+
+// deviceControl returns a net.Dialer Control function binding the socket to
+// the named interface before it connects
+func deviceControl(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return bindRawConn(c, iface)
+	}
+}
+
+// bindRawConn binds a socket to the named interface
+func bindRawConn(raw syscall.RawConn, iface string) error {
+	var sockErr error
+	err := raw.Control(func(fd uintptr) {
+		sockErr = bindToDevice(fd, iface)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to bind to interface %s: %w", iface, sockErr)
+	}
+	return nil
+}
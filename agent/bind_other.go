@@ -0,0 +1,9 @@
+//go:build !linux
+
+package agent
+
+// bindToDevice is only implemented on Linux; elsewhere sockets are bound to
+// the source address alone and the routing table picks the interface
+func bindToDevice(fd uintptr, iface string) error {
+	return nil
+}
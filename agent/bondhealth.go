@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procBondingDir lists the bonds of the running kernel, one status file each
+const procBondingDir = "/proc/net/bonding"
+
+// LACP port state bits (IEEE 802.1AX) a healthy partner port has set
+const (
+	lacpStateSynchronization = 0x08
+	lacpStateCollecting      = 0x10
+	lacpStateDistributing    = 0x20
+	lacpStateInService       = lacpStateSynchronization | lacpStateCollecting | lacpStateDistributing
+)
+
+// noLACPPartner is the partner MAC address reported before any LACPDU arrives
+const noLACPPartner = "00:00:00:00:00:00"
+
+// bondStatus is the kernel's view of a bond, from /proc/net/bonding/<bond>
+type bondStatus struct {
+	mode         string
+	miiStatus    string
+	activeSlave  string // active-backup modes only
+	aggregatorID int    // 802.3ad only, the active aggregator
+	partnerMAC   string // 802.3ad only, of the active aggregator
+	slaves       []slaveStatus
+}
+
+// slaveStatus is the state of one bond member
+type slaveStatus struct {
+	name             string
+	miiStatus        string
+	aggregatorID     int // 802.3ad only
+	partnerPortState int // 802.3ad only, -1 when not reported
+}
+
+// lacp reports whether the bond runs 802.3ad
+func (s bondStatus) lacp() bool {
+	return strings.Contains(s.mode, "802.3ad")
+}
+
+// parseBondStatus parses the status file the bonding driver writes for a bond
+func parseBondStatus(r io.Reader) (bondStatus, error) {
+	var (
+		status       bondStatus
+		slave        *slaveStatus
+		inActiveAgg  bool
+		inPartnerPDU bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			inActiveAgg, inPartnerPDU = false, false
+			continue
+		}
+
+		// Nested sections are introduced by headers and indented
+		indented := strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ")
+		if !indented {
+			inActiveAgg, inPartnerPDU = false, false
+		}
+		switch trimmed {
+		case "Active Aggregator Info:":
+			inActiveAgg = true
+			continue
+		case "details partner lacp pdu:":
+			inPartnerPDU = true
+			continue
+		case "details actor lacp pdu:":
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case inActiveAgg:
+			switch key {
+			case "Aggregator ID":
+				status.aggregatorID, _ = strconv.Atoi(value)
+			case "Partner Mac Address":
+				status.partnerMAC = value
+			}
+		case inPartnerPDU:
+			if key == "port state" && slave != nil {
+				slave.partnerPortState, _ = strconv.Atoi(value)
+			}
+		case indented:
+			// Actor details and other nested values are not checked
+		case key == "Slave Interface":
+			status.slaves = append(status.slaves, slaveStatus{name: value, partnerPortState: -1})
+			slave = &status.slaves[len(status.slaves)-1]
+		case slave != nil:
+			switch key {
+			case "MII Status":
+				slave.miiStatus = value
+			case "Aggregator ID":
+				slave.aggregatorID, _ = strconv.Atoi(value)
+			}
+		default:
+			switch key {
+			case "Bonding Mode":
+				status.mode = value
+			case "MII Status":
+				status.miiStatus = value
+			case "Currently Active Slave":
+				status.activeSlave = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return bondStatus{}, err
+	}
+	if status.mode == "" {
+		return bondStatus{}, fmt.Errorf("not a bonding status file")
+	}
+	return status, nil
+}
+
+// problems lists what is wrong with the bond. A bond with a slave down still
+// passes traffic, but has lost its redundancy.
+func (s bondStatus) problems() []string {
+	var problems []string
+	if s.miiStatus != "up" {
+		problems = append(problems, fmt.Sprintf("bond MII status is %s", s.miiStatus))
+	}
+	if len(s.slaves) == 0 {
+		problems = append(problems, "bond has no slaves")
+	}
+	if strings.Contains(s.mode, "active-backup") && (s.activeSlave == "" || s.activeSlave == "None") {
+		problems = append(problems, "no active slave")
+	}
+	if s.lacp() && (s.partnerMAC == "" || s.partnerMAC == noLACPPartner) {
+		problems = append(problems, "no LACP partner")
+	}
+
+	for _, slave := range s.slaves {
+		if slave.miiStatus != "up" {
+			problems = append(problems, fmt.Sprintf("slave %s MII status is %s", slave.name, slave.miiStatus))
+			continue
+		}
+		if !s.lacp() {
+			continue
+		}
+		if slave.aggregatorID != s.aggregatorID {
+			problems = append(problems, fmt.Sprintf("slave %s is in aggregator %d, not the active aggregator %d", slave.name, slave.aggregatorID, s.aggregatorID))
+		}
+		if slave.partnerPortState >= 0 && slave.partnerPortState&lacpStateInService != lacpStateInService {
+			problems = append(problems, fmt.Sprintf("slave %s partner port state %d is not synchronized, collecting and distributing", slave.name, slave.partnerPortState))
+		}
+	}
+	return problems
+}
+
+// slavesUp counts the slaves whose MII status is up
+func (s bondStatus) slavesUp() int {
+	up := 0
+	for _, slave := range s.slaves {
+		if slave.miiStatus == "up" {
+			up++
+		}
+	}
+	return up
+}
+
+// localBonds lists the bonds of the running kernel
+func localBonds() ([]string, error) {
+	entries, err := os.ReadDir(procBondingDir)
+	if os.IsNotExist(err) {
+		// The bonding driver is not loaded
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bonds: %w", err)
+	}
+
+	bonds := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		bonds = append(bonds, entry.Name())
+	}
+	return bonds, nil
+}
+
+// testBondHealth checks a local bond's slaves and LACP state as reported by
+// the kernel, which catches degraded bonds that still pass traffic
+func (a *Agent) testBondHealth(bond string) TestResult {
+	result := TestResult{
+		TargetHostname:  a.hostname,
+		BondName:        bond,
+		SourceInterface: bond,
+		TestType:        TestTypeBondHealth,
+	}
+
+	start := time.Now()
+	status, err := readBondStatus(bond)
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Failed to read bond status: %v", err)
+		return result
+	}
+
+	result.SlavesUp = status.slavesUp()
+	result.SlavesTotal = len(status.slaves)
+	if problems := status.problems(); len(problems) > 0 {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Bond %s is degraded: %s", bond, strings.Join(problems, "; "))
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+// readBondStatus reads the kernel's status of a bond
+func readBondStatus(bond string) (bondStatus, error) {
+	f, err := os.Open(filepath.Join(procBondingDir, bond))
+	if err != nil {
+		return bondStatus{}, err
+	}
+	defer f.Close()
+	return parseBondStatus(f)
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"validate/netplan"
+)
+
+const sysClassNetDir = "/sys/class/net"
+
+// discoverSystemBonds finds the bonds configured on the running system, for
+// hosts managed by NetworkManager or ifupdown rather than netplan. Bonds are
+// listed in /proc/net/bonding, and the interfaces stacked on them, such as
+// VLANs and bridges, are found through their upper_* links in sysfs. The
+// addresses of every interface are read from the kernel over netlink.
+func discoverSystemBonds() (map[string][]netplan.IPWithMask, error) {
+	bondNames, err := localBonds()
+	if err != nil || bondNames == nil {
+		return nil, err
+	}
+
+	bonds := make(map[string][]netplan.IPWithMask)
+	for _, bondName := range bondNames {
+		var addrs []netplan.IPWithMask
+		for _, ifaceName := range stackedSystemInterfaces(sysClassNetDir, bondName) {
+			ifaceAddrs, err := systemInterfaceAddresses(ifaceName)
+			if err != nil {
+				slog.Warn("Failed to read interface addresses", "interface", ifaceName, "error", err)
+				continue
+			}
+			addrs = append(addrs, ifaceAddrs...)
+		}
+		bonds[bondName] = addrs
+	}
+
+	return bonds, nil
+}
+
+// discoverSystemLinks returns the addresses of every interface on the running
+// system grouped by link: interfaces stacked on a bond belong to the bond's
+// link and any other non-loopback interface is a link of its own
+func discoverSystemLinks() (map[string][]netplan.IPWithMask, error) {
+	links, err := discoverSystemBonds()
+	if err != nil {
+		return nil, err
+	}
+	if links == nil {
+		links = make(map[string][]netplan.IPWithMask)
+	}
+
+	covered := make(map[string]bool)
+	for bondName := range links {
+		for _, name := range stackedSystemInterfaces(sysClassNetDir, bondName) {
+			covered[name] = true
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		if covered[iface.Name] || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := systemInterfaceAddresses(iface.Name)
+		if err != nil {
+			slog.Warn("Failed to read interface addresses", "interface", iface.Name, "error", err)
+			continue
+		}
+		if len(addrs) > 0 {
+			links[iface.Name] = addrs
+		}
+	}
+
+	return links, nil
+}
+
+// stackedSystemInterfaces returns the named interface followed by every
+// interface stacked on top of it, as linked from sysDir
+func stackedSystemInterfaces(sysDir, name string) []string {
+	result := []string{name}
+	seen := map[string]bool{name: true}
+
+	for i := 0; i < len(result); i++ {
+		entries, err := os.ReadDir(filepath.Join(sysDir, result[i]))
+		if err != nil {
+			continue
+		}
+
+		var uppers []string
+		for _, entry := range entries {
+			upper, ok := strings.CutPrefix(entry.Name(), "upper_")
+			if ok && !seen[upper] {
+				seen[upper] = true
+				uppers = append(uppers, upper)
+			}
+		}
+		slices.Sort(uppers)
+		result = append(result, uppers...)
+	}
+
+	return result
+}
+
+// physicalSystemInterfaces returns the interfaces at the bottom of the stack
+// under the named interface, such as the slaves of a bond or the NIC of a
+// VLAN, following their lower_* links in sysfs
+func physicalSystemInterfaces(name string) []string {
+	var result []string
+	seen := map[string]bool{name: true}
+
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		entries, err := os.ReadDir(filepath.Join(sysClassNetDir, current))
+		if err != nil {
+			result = append(result, current)
+			continue
+		}
+
+		var lowers []string
+		for _, entry := range entries {
+			lower, ok := strings.CutPrefix(entry.Name(), "lower_")
+			if ok && !seen[lower] {
+				seen[lower] = true
+				lowers = append(lowers, lower)
+			}
+		}
+		if len(lowers) == 0 {
+			result = append(result, current)
+		}
+		slices.Sort(lowers)
+		queue = append(queue, lowers...)
+	}
+
+	return result
+}
+
+// systemInterfaceAddresses returns the addresses assigned to an interface,
+// skipping IPv6 link-local addresses which cannot be matched to a subnet
+func systemInterfaceAddresses(name string) ([]netplan.IPWithMask, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []netplan.IPWithMask
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+
+		cidr := ipNet.String()
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		result = append(result, netplan.IPWithMask{
+			IP:       ipNet.IP.String(),
+			CIDR:     cidr,
+			IPNet:    network,
+			BondName: name,
+			MTU:      iface.MTU,
+		})
+	}
+
+	return result, nil
+}
+
+// discoverSystemGateways returns the gateways of the kernel's main routing
+// table, keyed by the interface they are reached through
+func discoverSystemGateways() (map[string][]string, error) {
+	gateways := make(map[string][]string)
+	add := func(iface string, gateway net.IP) {
+		if !slices.Contains(gateways[iface], gateway.String()) {
+			gateways[iface] = append(gateways[iface], gateway.String())
+		}
+	}
+
+	// Iface Destination Gateway Flags ..., with little-endian hex addresses
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IPv4 routes: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		flags, flagsErr := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flagsErr != nil || len(gateway) != net.IPv4len || flags&syscall.RTF_GATEWAY == 0 {
+			continue
+		}
+		slices.Reverse(gateway)
+		add(fields[0], net.IP(gateway))
+	}
+
+	// Destination prefix source prefix next-hop metric refs use flags iface,
+	// with big-endian hex addresses. IPv6 may be disabled.
+	data, err = os.ReadFile("/proc/net/ipv6_route")
+	if err != nil {
+		return gateways, nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[4])
+		flags, flagsErr := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flagsErr != nil || len(gateway) != net.IPv6len || flags&syscall.RTF_GATEWAY == 0 {
+			continue
+		}
+		add(fields[9], net.IP(gateway))
+	}
+
+	return gateways, nil
+}
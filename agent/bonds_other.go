@@ -0,0 +1,33 @@
+//go:build !linux
+
+package agent
+
+import "validate/netplan"
+
+// physicalSystemInterfaces cannot see through interface stacks without sysfs,
+// so the interface stands for itself
+func physicalSystemInterfaces(name string) []string {
+	return []string{name}
+}
+
+// discoverSystemBonds is only implemented on Linux, which exposes bonds
+// through /proc and sysfs
+func discoverSystemBonds() (map[string][]netplan.IPWithMask, error) {
+	return nil, nil
+}
+
+// discoverSystemLinks is only implemented on Linux
+func discoverSystemLinks() (map[string][]netplan.IPWithMask, error) {
+	return nil, nil
+}
+
+// discoverSystemGateways is only implemented on Linux, which exposes its
+// routing table through /proc
+func discoverSystemGateways() (map[string][]string, error) {
+	return nil, nil
+}
+
+// systemInterfaceAddresses is only implemented on Linux
+func systemInterfaceAddresses(name string) ([]netplan.IPWithMask, error) {
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"slices"
+)
+
+// version is the build version, set with
+// -ldflags "-X validate/agent.version=v1.2.3"
+var version string
+
+// Version returns the build version: the one set at link time, else the VCS
+// revision recorded by the Go toolchain, else "dev"
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// Capabilities describes what an agent can test, so the aggregator only
+// requests tests it can perform
+type Capabilities struct {
+	TestTypes       []string `json:"test_types"`       // test types this agent can run, in AllTestTypes order
+	IPv6            bool     `json:"ipv6"`             // has IPv6 addresses to test from
+	BandwidthServer bool     `json:"bandwidth_server"` // serves /api/throughput for bandwidth tests
+}
+
+// Supports reports whether the agent can run testType
+func (c Capabilities) Supports(testType string) bool {
+	return slices.Contains(c.TestTypes, testType)
+}
+
+// detectCapabilities works out which tests can run on this host, given the
+// IP addresses of its links. Probes that need raw sockets only work with
+// CAP_NET_RAW, or with unprivileged ping sockets for ICMP echo.
+func detectCapabilities(links map[string][]string) Capabilities {
+	rawICMP := canListen("ip4:icmp", "127.0.0.1")
+	pingSocket := canOpenPingSocket()
+	_, arpingErr := exec.LookPath("arping")
+	linux := runtime.GOOS == "linux"
+
+	supported := map[string]bool{
+		// Native ARP needs a packet socket, which takes the same privilege as raw ICMP
+		TestTypeARP:        (linux && rawICMP) || arpingErr == nil,
+		TestTypeNDP:        linux && canListen("ip6:ipv6-icmp", "::1"),
+		TestTypeICMP:       rawICMP || pingSocket,
+		TestTypeUDP:        true,
+		TestTypeDSCP:       linux, // marking probes and reporting received markings
+		TestTypeHTTP:       true,
+		TestTypeClockSkew:  true,
+		TestTypePorts:      true,
+		TestTypeTLS:        true,
+		TestTypeBandwidth:  true,
+		TestTypePMTU:       linux && (rawICMP || pingSocket),
+		TestTypeTraceroute: linux && rawICMP, // probes with increasing TTLs
+		TestTypeBondHealth: linux,
+		TestTypeExternal:   true, // URLs need no privileges, only IP endpoints need ICMP echo
+		TestTypeTimeSync:   true,
+	}
+	// Gateways must answer both a neighbor probe and ICMP echo
+	supported[TestTypeGateway] = supported[TestTypeARP] && supported[TestTypeICMP]
+
+	caps := Capabilities{BandwidthServer: true}
+	for _, testType := range AllTestTypes {
+		if supported[testType] {
+			caps.TestTypes = append(caps.TestTypes, testType)
+		}
+	}
+	for _, ips := range links {
+		for _, ip := range ips {
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+				caps.IPv6 = true
+			}
+		}
+	}
+	return caps
+}
+
+// canListen reports whether a packet socket of the given network can be opened
+func canListen(network, addr string) bool {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// canOpenPingSocket reports whether unprivileged ICMP echo sockets are allowed
+func canOpenPingSocket() bool {
+	conn, err := openPingConn(net.IPv4zero, false)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
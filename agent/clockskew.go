@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+const clockSkewExchanges = 4
+
+// TimeExchangeResponse carries the target agent's clock readings for a time
+// exchange, in nanoseconds since the Unix epoch
+type TimeExchangeResponse struct {
+	Received int64 `json:"received"` // when the request arrived
+	Sent     int64 `json:"sent"`     // when the response was sent
+}
+
+// HandleTimeExchange answers clock skew tests from other agents with the
+// times the request arrived and the response left
+func HandleTimeExchange(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TimeExchangeResponse{
+		Received: received.UnixNano(),
+		Sent:     time.Now().UnixNano(),
+	})
+}
+
+// testClockSkew estimates the offset of the target agent's clock from this
+// agent's, so latencies and test times reported by different hosts can be
+// compared. Like NTP it exchanges four timestamps several times and keeps
+// the exchange with the shortest round trip, whose offset is the most
+// accurate. The offset is positive when the target's clock is ahead.
+func (a *Agent) testClockSkew(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeClockSkew)
+
+	client, err := a.newBoundHTTPClient(target.sourceInterface, target.sourceIP, opts.timeout(a.httpClient.Timeout))
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	defer client.CloseIdleConnections()
+
+	var (
+		best     time.Duration
+		bestRTT  time.Duration
+		lastErr  error
+		answered int
+	)
+	url := target.url(a.auth.Scheme(), "/api/time")
+	for i := 0; i < opts.count(clockSkewExchanges); i++ {
+		offset, rtt, err := timeExchange(ctx, client, url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if answered == 0 || rtt < bestRTT {
+			best, bestRTT = offset, rtt
+		}
+		answered++
+	}
+	if answered == 0 {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Time exchange failed: %v", lastErr)
+		return result
+	}
+
+	result.ResponseTimeMS = bestRTT.Milliseconds()
+	result.RTTAvgMS = durationMS(bestRTT)
+	result.ClockOffsetMS = durationMS(best)
+	if maxOffset := opts.maxOffset(defaultMaxOffset); absDuration(best) > maxOffset {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Target clock is %v off, more than %v", best, maxOffset)
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+// timeExchange makes one time exchange with the target agent and returns
+// the target clock's offset and the round trip time. The local timestamps
+// are taken when the request was written and the response began to arrive,
+// so connection setup does not skew the offset.
+func timeExchange(ctx context.Context, client *http.Client, url string) (time.Duration, time.Duration, error) {
+	var sent, received time.Time
+	trace := &httptrace.ClientTrace{
+		WroteRequest:         func(httptrace.WroteRequestInfo) { sent = time.Now() },
+		GotFirstResponseByte: func() { received = time.Now() },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+
+	var times TimeExchangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&times); err != nil {
+		return 0, 0, fmt.Errorf("invalid time exchange response: %w", err)
+	}
+	targetReceived := time.Unix(0, times.Received)
+	targetSent := time.Unix(0, times.Sent)
+
+	offset := (targetReceived.Sub(sent) + targetSent.Sub(received)) / 2
+	rtt := received.Sub(sent) - targetSent.Sub(targetReceived)
+	return offset, rtt, nil
+}
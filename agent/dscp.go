@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dscpProbeCount   = 3
+	dscpProbeTimeout = 500 * time.Millisecond
+)
+
+// defaultDSCPValues are the markings checked unless a run selects its own:
+// EF for voice, AF41 for video and AF31 for storage and signalling
+var defaultDSCPValues = []int{46, 34, 26}
+
+// dscpProbeMagic prefixes DSCP probes, which the echo responder answers with
+// the TOS byte they arrived with
+var dscpProbeMagic = []byte("NVDSCPECHO")
+
+// validateDSCP checks that DSCP values fit their six bits
+func validateDSCP(values []int) error {
+	for _, value := range values {
+		if value < 0 || value > 63 {
+			return fmt.Errorf("invalid DSCP value %d (must be 0-63)", value)
+		}
+	}
+	return nil
+}
+
+// dscpOutcome is what became of the probes sent with one marking
+type dscpOutcome struct {
+	sent     int
+	received int
+	arrived  map[int]int // DSCP value seen by the target -> probes
+}
+
+// dscpProbe sends count probes marked with each DSCP value from sourceIP on
+// sourceInterface to the echo responder on targetIP, and returns the
+// markings the target saw for each value
+func dscpProbe(ctx context.Context, sourceInterface, sourceIP, targetIP string, values []int, count int, timeout time.Duration) (map[int]*dscpOutcome, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("invalid source or target IP %q -> %q", sourceIP, targetIP)
+	}
+
+	dialer := net.Dialer{
+		LocalAddr: &net.UDPAddr{IP: source},
+		Control:   deviceControl(sourceInterface),
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(target.String(), strconv.Itoa(UDPEchoPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	outcomes := make(map[int]*dscpOutcome)
+	probe := make([]byte, len(dscpProbeMagic)+8)
+	copy(probe, dscpProbeMagic)
+	buf := make([]byte, 1500)
+	seq := uint64(0)
+
+	for _, value := range values {
+		// The low two bits of the TOS byte are ECN
+		if err := setTOS(conn.(*net.UDPConn), target.To4() == nil, value<<2); err != nil {
+			return nil, fmt.Errorf("failed to mark probes with DSCP %d: %w", value, err)
+		}
+
+		outcome := &dscpOutcome{arrived: make(map[int]int)}
+		outcomes[value] = outcome
+		for i := 0; i < count; i++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			seq++
+			binary.BigEndian.PutUint64(probe[len(dscpProbeMagic):], seq)
+
+			outcome.sent++
+			start := time.Now()
+			if _, err := conn.Write(probe); err != nil {
+				continue
+			}
+
+			conn.SetReadDeadline(probeDeadline(ctx, start, timeout))
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break
+				}
+				if n != len(probe)+1 || !bytes.Equal(buf[:len(probe)], probe) {
+					continue
+				}
+				outcome.received++
+				outcome.arrived[int(buf[len(probe)])>>2]++
+				break
+			}
+		}
+	}
+
+	return outcomes, nil
+}
+
+// testDSCP checks that DSCP markings reach the target unchanged, so switches
+// that strip or remap QoS markings on storage or voice VLANs are caught
+func testDSCP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeDSCP)
+
+	values := opts.DSCP
+	if len(values) == 0 {
+		values = defaultDSCPValues
+	}
+
+	start := time.Now()
+	outcomes, err := dscpProbe(ctx, target.sourceInterface, target.sourceIP, target.ip, values, opts.count(dscpProbeCount), opts.timeout(dscpProbeTimeout))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("DSCP probe failed: %v", err)
+		return result
+	}
+
+	var problems []string
+	for _, value := range values {
+		outcome := outcomes[value]
+		if outcome.received == 0 {
+			problems = append(problems, fmt.Sprintf("DSCP %d: no replies to %d probes", value, outcome.sent))
+			continue
+		}
+		for arrived, probes := range outcome.arrived {
+			if arrived != value {
+				problems = append(problems, fmt.Sprintf("DSCP %d arrived as %d (%d/%d probes)", value, arrived, probes, outcome.received))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		result.Success = false
+		result.ErrorMessage = strings.Join(problems, "; ")
+	} else {
+		result.Success = true
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+// setTOS sets the TOS byte, or IPv6 traffic class, of packets sent through conn
+func setTOS(conn *net.UDPConn, ipv6 bool, tos int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// enableTOSReporting asks the kernel to report the TOS byte of received
+// packets. The responder socket is dual-stack, so both IPv4 and IPv6 are
+// enabled; the IPv4 option fails on IPv6-only sockets and vice versa.
+func enableTOSReporting(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var v4Err, v6Err error
+	err = raw.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return v4Err
+	}
+	return nil
+}
+
+// receivedTOS returns the TOS byte, or IPv6 traffic class, reported in the
+// control messages of a received packet
+func receivedTOS(oob []byte) (byte, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TOS && len(msg.Data) >= 1:
+			return msg.Data[0], true
+		case msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_TCLASS && len(msg.Data) >= 4:
+			// An int in host byte order
+			return byte(binary.NativeEndian.Uint32(msg.Data)), true
+		}
+	}
+	return 0, false
+}
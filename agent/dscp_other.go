@@ -0,0 +1,24 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// setTOS is only implemented on Linux
+func setTOS(conn *net.UDPConn, ipv6 bool, tos int) error {
+	return fmt.Errorf("DSCP marking is not supported on %s", runtime.GOOS)
+}
+
+// enableTOSReporting is only implemented on Linux
+func enableTOSReporting(conn *net.UDPConn) error {
+	return fmt.Errorf("reporting received DSCP markings is not supported on %s", runtime.GOOS)
+}
+
+// receivedTOS is only implemented on Linux
+func receivedTOS(oob []byte) (byte, bool) {
+	return 0, false
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"validate/netplan"
+)
+
+// externalHTTPTimeout bounds an external URL check, including DNS and TLS
+const externalHTTPTimeout = 10 * time.Second
+
+// externalTarget is an endpoint outside the cluster, checked from an uplink
+type externalTarget struct {
+	endpoint string // IP address or http(s) URL
+	iface    string // uplink interface the check is bound to
+	ipv6     bool   // family of the uplink's source address
+}
+
+// validateExternalEndpoint checks that an endpoint is an IP address or an
+// absolute http or https URL
+func validateExternalEndpoint(endpoint string) error {
+	if net.ParseIP(endpoint) != nil {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("external endpoint %q must be an IP address or an http(s) URL", endpoint)
+	}
+	return nil
+}
+
+// externalTargets pairs every endpoint with every uplink, an interface with
+// a gateway, of a family the endpoint can be reached over. IP endpoints are
+// checked over their own family, and URLs over every uplink family.
+func externalTargets(endpoints []string, gateways []gatewayTarget) []externalTarget {
+	type uplink struct {
+		iface string
+		ipv6  bool
+	}
+	var uplinks []uplink
+	seen := make(map[uplink]bool)
+	for _, gw := range gateways {
+		ip := net.ParseIP(gw.gateway)
+		if ip == nil {
+			continue
+		}
+		u := uplink{iface: gw.iface, ipv6: ip.To4() == nil}
+		if !seen[u] {
+			seen[u] = true
+			uplinks = append(uplinks, u)
+		}
+	}
+
+	var targets []externalTarget
+	for _, endpoint := range endpoints {
+		ip := net.ParseIP(endpoint)
+		for _, u := range uplinks {
+			if ip != nil && (ip.To4() == nil) != u.ipv6 {
+				continue
+			}
+			targets = append(targets, externalTarget{endpoint: endpoint, iface: u.iface, ipv6: u.ipv6})
+		}
+	}
+	return targets
+}
+
+// testExternal checks north-south reachability of an endpoint from an uplink:
+// IP addresses with ICMP echo and URLs with an HTTP GET, where any response
+// counts as reachable
+func (a *Agent) testExternal(ctx context.Context, ext externalTarget, links map[string][]netplan.IPWithMask, opts TestOptions) TestResult {
+	// The address of a URL is only known once connected
+	target := testTarget{
+		hostname:        ext.endpoint,
+		sourceInterface: ext.iface,
+	}
+	endpointIP := net.ParseIP(ext.endpoint)
+	if endpointIP != nil {
+		target.ip = ext.endpoint
+	}
+	target.bondName, target.sourceIP = interfaceSource(links, ext.iface, ext.ipv6)
+
+	result := target.newResult(TestTypeExternal)
+	if target.sourceIP == "" {
+		result.ErrorMessage = fmt.Sprintf("Interface %s has no address to check %s from", ext.iface, ext.endpoint)
+		return result
+	}
+
+	if endpointIP != nil {
+		echo := testICMP(ctx, target, opts)
+		echo.TestType = TestTypeExternal
+		return echo
+	}
+
+	client := &http.Client{
+		Timeout: opts.timeout(externalHTTPTimeout),
+		Transport: &http.Transport{
+			DialContext:       boundDialer(ext.iface, net.ParseIP(target.sourceIP)).DialContext,
+			DisableKeepAlives: true,
+		},
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				result.TargetIP = addr.IP.String()
+			}
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, ext.endpoint, nil)
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("External request failed: %v", err)
+		return result
+	}
+	resp.Body.Close()
+
+	result.Success = true
+	return result
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"validate/netplan"
+)
+
+// gatewayTarget is a configured gateway together with the local interface
+// that reaches it
+type gatewayTarget struct {
+	gateway string
+	iface   string
+}
+
+// localGateways returns the gateways of every interface, from netplan or,
+// when netplan configures none, from the kernel's routing table
+func localGateways() ([]gatewayTarget, error) {
+	byIface := make(map[string][]string)
+	configs, err := netplan.LoadNetplanConfigsFromDir("/etc/netplan")
+	if err == nil {
+		for _, config := range configs {
+			for iface, gateways := range config.GetGateways() {
+				byIface[iface] = append(byIface[iface], gateways...)
+			}
+		}
+	}
+	if len(byIface) == 0 {
+		// Not all systems use netplan; ask the kernel instead
+		if byIface, err = discoverSystemGateways(); err != nil {
+			return nil, err
+		}
+	}
+
+	ifaces := make([]string, 0, len(byIface))
+	for iface := range byIface {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+
+	var targets []gatewayTarget
+	for _, iface := range ifaces {
+		for _, gateway := range byIface[iface] {
+			targets = append(targets, gatewayTarget{gateway: gateway, iface: iface})
+		}
+	}
+	return targets, nil
+}
+
+// testGateway checks that a gateway answers ARP, or NDP for IPv6, and ICMP
+// echo from the interface it is configured on. Host to host tests do not
+// prove the egress path works.
+func (a *Agent) testGateway(ctx context.Context, gw gatewayTarget, links map[string][]netplan.IPWithMask, opts TestOptions) TestResult {
+	gatewayIP := net.ParseIP(gw.gateway)
+	target := testTarget{
+		hostname:        a.hostname,
+		ip:              gw.gateway,
+		sourceInterface: gw.iface,
+	}
+	target.bondName, target.sourceIP = interfaceSource(links, gw.iface, gatewayIP != nil && gatewayIP.To4() == nil)
+
+	result := target.newResult(TestTypeGateway)
+	if gatewayIP == nil {
+		result.ErrorMessage = fmt.Sprintf("Invalid gateway address %q", gw.gateway)
+		return result
+	}
+	if target.sourceIP == "" {
+		result.ErrorMessage = fmt.Sprintf("Interface %s has no address of the same family as gateway %s", gw.iface, gw.gateway)
+		return result
+	}
+
+	neighbor := testARP
+	if target.ipv6() {
+		neighbor = testNDP
+	}
+	if resolved := neighbor(ctx, target, opts); !resolved.Success {
+		result.ResponseTimeMS = resolved.ResponseTimeMS
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Gateway does not resolve: %s", resolved.ErrorMessage)
+		return result
+	}
+
+	echo := testICMP(ctx, target, opts)
+	if !echo.Success {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Gateway resolves but does not answer: %s", echo.ErrorMessage)
+		return result
+	}
+
+	// Report the round trip times of the echo requests
+	result = echo
+	result.TestType = TestTypeGateway
+	return result
+}
+
+// interfaceSource returns the link an interface belongs to, or the interface
+// itself if it has no addresses, and its first address of the given family,
+// if any
+func interfaceSource(links map[string][]netplan.IPWithMask, iface string, ipv6 bool) (string, string) {
+	link, sourceIP := iface, ""
+	for name, addrs := range links {
+		for _, addr := range addrs {
+			if addr.BondName != iface {
+				continue
+			}
+			link = name
+			ip := net.ParseIP(addr.IP)
+			if sourceIP == "" && ip != nil && (ip.To4() == nil) == ipv6 {
+				sourceIP = addr.IP
+			}
+		}
+	}
+	return link, sourceIP
+}
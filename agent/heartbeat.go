@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"validate/sysinfo"
+)
+
+// errNotRegistered is returned by heartbeats the aggregator has no
+// registration for, e.g. after its database was reset
+var errNotRegistered = errors.New("not registered with the aggregator")
+
+// HeartbeatPayload keeps a registration alive without resending it
+type HeartbeatPayload struct {
+	Hostname  string    `json:"hostname"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// renewRegistration sends a heartbeat while the registration data is
+// unchanged, and registers again when it changed or the aggregator lost it
+func (a *Agent) renewRegistration() error {
+	payload, err := a.registrationPayload()
+	if err != nil {
+		return err
+	}
+
+	hash := payload.hash()
+	if hash == a.registeredHash {
+		err := a.heartbeat()
+		if err == nil {
+			slog.Debug("Heartbeat sent")
+			return nil
+		}
+		if !errors.Is(err, errNotRegistered) {
+			return err
+		}
+		slog.Info("Aggregator lost the registration, registering again")
+	} else {
+		slog.Info("Registration data changed, registering again")
+	}
+
+	if err := a.register(payload); err != nil {
+		return err
+	}
+	a.registeredHash = hash
+	return nil
+}
+
+// heartbeat tells the aggregator this agent is still running
+func (a *Agent) heartbeat() error {
+	jsonData, err := json.Marshal(HeartbeatPayload{
+		Hostname:  a.hostname,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/heartbeat", a.aggregatorURL)
+	resp, err := a.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("heartbeat failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// hash fingerprints the registration, ignoring the system info that changes
+// all the time, such as free memory and uptime
+func (p RegistrationPayload) hash() string {
+	if info, ok := p.SystemInfo.(*sysinfo.SystemInfo); ok && info != nil {
+		stable := *info
+		stable.Timestamp = time.Time{}
+		stable.CPU.MHz = 0
+		stable.Memory = sysinfo.MemoryInfo{TotalBytes: info.Memory.TotalBytes}
+		stable.Uptime = sysinfo.UptimeInfo{}
+		p.SystemInfo = stable
+	}
+
+	// Marshalling sorts map keys, so equal payloads hash the same
+	data, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,259 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	icmpProbeCount   = 10
+	icmpProbeTimeout = 1 * time.Second
+	icmpEchoSize     = 16
+
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// pingStats summarizes the loss and round trip times of a series of probes
+type pingStats struct {
+	Sent     int
+	Received int
+	Min      time.Duration
+	Avg      time.Duration
+	Max      time.Duration
+	Samples  []time.Duration // round trip time of every answered probe
+	total    time.Duration
+}
+
+// record adds the round trip time of an answered probe
+func (s *pingStats) record(rtt time.Duration) {
+	if s.Received == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	s.Received++
+	s.total += rtt
+	s.Avg = s.total / time.Duration(s.Received)
+	s.Samples = append(s.Samples, rtt)
+}
+
+// Percentile returns the p-th percentile (0-100) of the recorded round trip
+// times using the nearest-rank method, or 0 if no probe was answered
+func (s *pingStats) Percentile(p float64) time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(s.Samples)
+	slices.Sort(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+	return sorted[rank-1]
+}
+
+// LossPercent returns the percentage of probes that were not answered
+func (s *pingStats) LossPercent() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) * 100 / float64(s.Sent)
+}
+
+// icmpConn is an ICMP socket bound to a source address. Datagram sockets
+// are unprivileged "ping" sockets on which the kernel owns the echo ID.
+type icmpConn struct {
+	net.PacketConn
+	datagram bool
+	ipv6     bool
+}
+
+// pingICMP sends count ICMP echo requests from sourceIP on sourceInterface to
+// targetIP, one at a time, and returns the round trip statistics of the
+// replies received
+func pingICMP(ctx context.Context, sourceInterface, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("invalid source or target IP %q -> %q", sourceIP, targetIP)
+	}
+
+	conn, err := openICMPConn(source, sourceInterface, target.To4() == nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := conn.destination(target)
+	id := os.Getpid() & 0xffff
+	stats := &pingStats{}
+
+	for seq := 1; seq <= count; seq++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stats.Sent++
+		rtt, ok, err := conn.probe(ctx, dst, target, id, seq, icmpEchoSize, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send ICMP echo request: %w", err)
+		}
+		if ok {
+			stats.record(rtt)
+		}
+	}
+
+	return stats, nil
+}
+
+// openICMPConn opens an unprivileged ICMP datagram socket bound to source and
+// iface, falling back to a raw socket (which requires CAP_NET_RAW) when ping
+// sockets are not permitted by net.ipv4.ping_group_range or not available
+func openICMPConn(source net.IP, iface string, ipv6 bool) (*icmpConn, error) {
+	if conn, err := openPingConn(source, ipv6); err == nil {
+		if err := bindConn(conn, iface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &icmpConn{PacketConn: conn, datagram: true, ipv6: ipv6}, nil
+	}
+
+	return openRawICMPConn(source, iface, ipv6)
+}
+
+// openRawICMPConn opens a raw ICMP socket bound to source and iface. Unlike
+// ping sockets, raw sockets also receive ICMP errors such as time exceeded.
+func openRawICMPConn(source net.IP, iface string, ipv6 bool) (*icmpConn, error) {
+	network := "ip4:icmp"
+	if ipv6 {
+		network = "ip6:ipv6-icmp"
+	}
+
+	conn, err := net.ListenPacket(network, source.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+	if err := bindConn(conn, iface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &icmpConn{PacketConn: conn, ipv6: ipv6}, nil
+}
+
+// destination returns the socket address of target for this kind of socket
+func (c *icmpConn) destination(target net.IP) net.Addr {
+	if c.datagram {
+		return &net.UDPAddr{IP: target}
+	}
+	return &net.IPAddr{IP: target}
+}
+
+// probe sends a single echo request of size bytes and waits up to timeout,
+// or until ctx expires, for its reply, returning the round trip time if one
+// arrived
+func (c *icmpConn) probe(ctx context.Context, dst net.Addr, target net.IP, id, seq, size int, timeout time.Duration) (time.Duration, bool, error) {
+	start := time.Now()
+	if _, err := c.WriteTo(c.echoRequest(id, seq, size), dst); err != nil {
+		return 0, false, err
+	}
+	if !c.waitForReply(target, id, seq, probeDeadline(ctx, start, timeout)) {
+		return 0, false, nil
+	}
+	return time.Since(start), true, nil
+}
+
+// echoRequest builds an ICMP echo request message of size bytes (at least
+// icmpEchoSize). The kernel fills in the checksum for ICMPv6.
+func (c *icmpConn) echoRequest(id, seq, size int) []byte {
+	msg := make([]byte, max(size, icmpEchoSize))
+	msg[0] = icmpv4EchoRequest
+	if c.ipv6 {
+		msg[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], uint16(id))
+	binary.BigEndian.PutUint16(msg[6:], uint16(seq))
+	binary.BigEndian.PutUint64(msg[8:], uint64(time.Now().UnixNano()))
+
+	if !c.ipv6 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	return msg
+}
+
+// waitForReply reads from the socket until the echo reply for seq arrives
+// from target or the deadline passes
+func (c *icmpConn) waitForReply(target net.IP, id, seq int, deadline time.Time) bool {
+	replyType := byte(icmpv4EchoReply)
+	if c.ipv6 {
+		replyType = icmpv6EchoReply
+	}
+
+	c.SetReadDeadline(deadline)
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		if n < 8 || buf[0] != replyType || !addrIP(from).Equal(target) {
+			continue
+		}
+		if int(binary.BigEndian.Uint16(buf[6:])) != seq {
+			continue
+		}
+		// Ping sockets rewrite the ID, and only deliver our own replies
+		if !c.datagram && int(binary.BigEndian.Uint16(buf[4:])) != id {
+			continue
+		}
+		return true
+	}
+}
+
+// addrIP extracts the IP address from a socket address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// icmpChecksum computes the Internet checksum (RFC 1071) of an ICMP message
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// durationMS converts a duration to fractional milliseconds
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// probeDeadline returns when a probe sent at start times out: after timeout,
+// or when ctx expires if that is sooner
+func probeDeadline(ctx context.Context, start time.Time, timeout time.Duration) time.Time {
+	deadline := start.Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
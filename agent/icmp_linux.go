@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// openPingConn opens an unprivileged ICMP datagram ("ping") socket bound to
+// source
+func openPingConn(source net.IP, ipv6 bool) (net.PacketConn, error) {
+	if ipv6 {
+		addr := &syscall.SockaddrInet6{}
+		copy(addr.Addr[:], source.To16())
+		return openPingSocket(syscall.AF_INET6, syscall.IPPROTO_ICMPV6, addr)
+	}
+	addr := &syscall.SockaddrInet4{}
+	copy(addr.Addr[:], source.To4())
+	return openPingSocket(syscall.AF_INET, syscall.IPPROTO_ICMP, addr)
+}
+
+// openPingSocket creates a SOCK_DGRAM ICMP socket bound to sa
+func openPingSocket(family, proto int, sa syscall.Sockaddr) (net.PacketConn, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "icmp")
+	defer file.Close()
+	return net.FilePacketConn(file)
+}
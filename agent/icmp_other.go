@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// openPingConn is only implemented on Linux, other platforms use raw sockets
+func openPingConn(source net.IP, ipv6 bool) (net.PacketConn, error) {
+	return nil, fmt.Errorf("ICMP ping sockets are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"cmp"
+	"slices"
+)
+
+// SourceAddress is a local address tests can be sent from. Targets in its
+// subnet are tested from it.
+type SourceAddress struct {
+	Link   string `json:"link"` // bond or interface the address belongs to
+	IP     string `json:"ip"`
+	CIDR   string `json:"cidr"`
+	Subnet string `json:"subnet"`
+	MTU    int    `json:"mtu,omitempty"`
+	Netns  string `json:"netns,omitempty"` // network namespace of the link, if not the agent's own
+}
+
+// SourceAddresses returns the local addresses test runs match targets
+// against, sorted by link and IP, so operators can see why a target was
+// skipped without reading the logs
+func (a *Agent) SourceAddresses() ([]SourceAddress, error) {
+	myIPs, err := a.getLinkIPAddressesWithMask()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]SourceAddress, 0, len(myIPs))
+	for _, ip := range myIPs {
+		addr := SourceAddress{
+			Link:  ip.BondName,
+			IP:    ip.IP,
+			CIDR:  ip.CIDR,
+			MTU:   ip.MTU,
+			Netns: a.namespaceOf(ip.BondName),
+		}
+		if ip.IPNet != nil {
+			addr.Subnet = ip.IPNet.String()
+		}
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, func(x, y SourceAddress) int {
+		return cmp.Or(cmp.Compare(x.Link, y.Link), cmp.Compare(x.IP, y.IP))
+	})
+	return addrs, nil
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	lldpctlTimeout = 5 * time.Second
+
+	// LLDP TLV types (IEEE 802.1AB)
+	lldpTLVEnd       = 0
+	lldpTLVChassisID = 1
+	lldpTLVPortID    = 2
+	lldpTLVTTL       = 3
+	lldpTLVPortDescr = 4
+	lldpTLVSysName   = 5
+
+	// Chassis and port ID subtypes holding a MAC or network address
+	lldpChassisIDMAC     = 4
+	lldpChassisIDNetAddr = 5
+	lldpPortIDMAC        = 3
+	lldpPortIDNetAddr    = 4
+)
+
+// LLDPNeighbor is the switch port a local NIC is plugged into, as announced
+// over LLDP
+type LLDPNeighbor struct {
+	Interface       string `json:"interface"` // local physical NIC
+	ChassisID       string `json:"chassis_id"`
+	SystemName      string `json:"system_name,omitempty"`
+	PortID          string `json:"port_id"`
+	PortDescription string `json:"port_description,omitempty"`
+}
+
+// lldpCache holds the neighbors heard by StartLLDPCapture until their TTL
+// runs out
+type lldpCache struct {
+	mu        sync.Mutex
+	neighbors map[string]cachedNeighbor // interface -> neighbor
+}
+
+// cachedNeighbor is a neighbor with the time its announcement expires
+type cachedNeighbor struct {
+	neighbor LLDPNeighbor
+	expires  time.Time
+}
+
+// store records the neighbor announced on its interface
+func (c *lldpCache) store(neighbor LLDPNeighbor, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.neighbors == nil {
+		c.neighbors = make(map[string]cachedNeighbor)
+	}
+	c.neighbors[neighbor.Interface] = cachedNeighbor{neighbor: neighbor, expires: time.Now().Add(ttl)}
+}
+
+// current returns the neighbors whose announcements have not expired
+func (c *lldpCache) current() map[string]LLDPNeighbor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	neighbors := make(map[string]LLDPNeighbor)
+	for iface, cached := range c.neighbors {
+		if now.Before(cached.expires) {
+			neighbors[iface] = cached.neighbor
+		}
+	}
+	return neighbors
+}
+
+// lldpNeighbors returns the LLDP neighbors of the physical NICs under each
+// link. Neighbors known to lldpd take precedence over those captured by the
+// agent itself.
+func (a *Agent) lldpNeighbors(links map[string][]string) map[string][]LLDPNeighbor {
+	neighbors := a.lldp.current()
+	fromLLDPD, err := lldpctlNeighbors()
+	if err != nil {
+		// lldpd is optional
+		fromLLDPD = nil
+	}
+	for iface, neighbor := range fromLLDPD {
+		neighbors[iface] = neighbor
+	}
+	if len(neighbors) == 0 {
+		return nil
+	}
+
+	byLink := make(map[string][]LLDPNeighbor)
+	for link := range links {
+		for _, iface := range physicalSystemInterfaces(link) {
+			if neighbor, ok := neighbors[iface]; ok {
+				byLink[link] = append(byLink[link], neighbor)
+			}
+		}
+	}
+	return byLink
+}
+
+// lldpctlNeighbors asks lldpd for the neighbors it has seen
+func lldpctlNeighbors() (map[string]LLDPNeighbor, error) {
+	path, err := exec.LookPath("lldpctl")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lldpctlTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "-f", "keyvalue").Output()
+	if err != nil {
+		return nil, fmt.Errorf("lldpctl failed: %w", err)
+	}
+	return parseLLDPCtlKeyValue(out), nil
+}
+
+// parseLLDPCtlKeyValue parses the neighbors from `lldpctl -f keyvalue`,
+// whose lines look like lldp.eth0.chassis.name=switch1
+func parseLLDPCtlKeyValue(out []byte) map[string]LLDPNeighbor {
+	neighbors := make(map[string]LLDPNeighbor)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		key, ok = strings.CutPrefix(key, "lldp.")
+		if !ok {
+			continue
+		}
+
+		// Interface names may contain dots, so split at the section instead
+		var iface, field string
+		for _, section := range []string{".chassis.", ".port."} {
+			if i := strings.Index(key, section); i > 0 {
+				iface, field = key[:i], key[i+1:]
+				break
+			}
+		}
+		if iface == "" {
+			continue
+		}
+
+		neighbor := neighbors[iface]
+		neighbor.Interface = iface
+		switch {
+		case field == "chassis.name":
+			neighbor.SystemName = value
+		case field == "port.descr":
+			neighbor.PortDescription = value
+		case slices.Contains([]string{"chassis.mac", "chassis.ip", "chassis.local"}, field):
+			neighbor.ChassisID = value
+		case slices.Contains([]string{"port.ifname", "port.mac", "port.ip", "port.local"}, field):
+			neighbor.PortID = value
+		default:
+			continue
+		}
+		neighbors[iface] = neighbor
+	}
+	return neighbors
+}
+
+// parseLLDPDU parses the TLVs of an LLDP frame without its Ethernet header.
+// It returns the neighbor, without its interface, and the TTL of the
+// announcement.
+func parseLLDPDU(pdu []byte) (LLDPNeighbor, time.Duration, error) {
+	var (
+		neighbor LLDPNeighbor
+		ttl      time.Duration
+		seenTTL  bool
+	)
+
+	for len(pdu) >= 2 {
+		header := binary.BigEndian.Uint16(pdu)
+		tlvType, length := int(header>>9), int(header&0x1ff)
+		if len(pdu) < 2+length {
+			return LLDPNeighbor{}, 0, fmt.Errorf("truncated TLV of type %d", tlvType)
+		}
+		value := pdu[2 : 2+length]
+		pdu = pdu[2+length:]
+
+		switch tlvType {
+		case lldpTLVEnd:
+			pdu = nil
+		case lldpTLVChassisID:
+			if length > 1 {
+				neighbor.ChassisID = lldpID(value[0], value[1:], lldpChassisIDMAC, lldpChassisIDNetAddr)
+			}
+		case lldpTLVPortID:
+			if length > 1 {
+				neighbor.PortID = lldpID(value[0], value[1:], lldpPortIDMAC, lldpPortIDNetAddr)
+			}
+		case lldpTLVTTL:
+			if length >= 2 {
+				ttl = time.Duration(binary.BigEndian.Uint16(value)) * time.Second
+				seenTTL = true
+			}
+		case lldpTLVPortDescr:
+			neighbor.PortDescription = string(value)
+		case lldpTLVSysName:
+			neighbor.SystemName = string(value)
+		}
+	}
+
+	// These three TLVs are mandatory
+	if neighbor.ChassisID == "" || neighbor.PortID == "" || !seenTTL {
+		return LLDPNeighbor{}, 0, fmt.Errorf("missing chassis ID, port ID or TTL")
+	}
+	return neighbor, ttl, nil
+}
+
+// lldpID formats a chassis or port ID according to its subtype
+func lldpID(subtype byte, id []byte, macSubtype, netAddrSubtype byte) string {
+	switch {
+	case subtype == macSubtype && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case subtype == netAddrSubtype && len(id) > 1:
+		// The address is preceded by its IANA address family
+		if ip := net.IP(id[1:]); len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+			return ip.String()
+		}
+	}
+	return string(id)
+}
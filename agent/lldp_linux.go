@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	ethPLLDP      = 0x88cc
+	packetOrigDev = 9 // PACKET_ORIGDEV: report the slave a bonded frame arrived on
+)
+
+// lldpMulticast is the nearest-bridge group address LLDP is sent to
+var lldpMulticast = [6]byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// StartLLDPCapture listens for the LLDP announcements of the switches the
+// host's NICs are plugged into, until stopChan is closed, so registrations
+// can report switch ports on hosts without lldpd. It needs CAP_NET_RAW.
+func (a *Agent) StartLLDPCapture(stopChan <-chan struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(ethPLLDP)))
+	if err != nil {
+		return fmt.Errorf("failed to open LLDP socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_PACKET, packetOrigDev, 1); err != nil {
+		return fmt.Errorf("failed to request original devices: %w", err)
+	}
+
+	// Poll so the loop notices stopChan
+	poll := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &poll); err != nil {
+		return fmt.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	joined := make(map[int]bool)
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-stopChan:
+			return nil
+		default:
+		}
+
+		// NICs may appear after the agent started
+		joinLLDPMulticast(fd, joined)
+
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			continue
+		}
+		sll, ok := from.(*syscall.SockaddrLinklayer)
+		if !ok {
+			continue
+		}
+		iface, err := net.InterfaceByIndex(sll.Ifindex)
+		if err != nil {
+			continue
+		}
+
+		neighbor, ttl, err := parseLLDPDU(buf[:n])
+		if err != nil {
+			slog.Debug("Ignoring invalid LLDP frame", "interface", iface.Name, "error", err)
+			continue
+		}
+		neighbor.Interface = iface.Name
+		a.lldp.store(neighbor, ttl)
+	}
+}
+
+// packetMreq is struct packet_mreq from linux/if_packet.h
+type packetMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]byte
+}
+
+// joinLLDPMulticast subscribes the socket to the LLDP group address on every
+// Ethernet interface not joined yet. NICs drop the group's frames otherwise.
+func joinLLDPMulticast(fd int, joined map[int]bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+
+	for _, iface := range ifaces {
+		if joined[iface.Index] || len(iface.HardwareAddr) != 6 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		mreq := packetMreq{ifindex: int32(iface.Index), typ: syscall.PACKET_MR_MULTICAST, alen: 6}
+		copy(mreq.address[:], lldpMulticast[:])
+		_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET,
+			syscall.PACKET_ADD_MEMBERSHIP, uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0)
+		if errno != 0 {
+			slog.Debug("Failed to join the LLDP group", "interface", iface.Name, "error", errno)
+		}
+		joined[iface.Index] = true
+	}
+}
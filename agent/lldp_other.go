@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// StartLLDPCapture is only supported on Linux; elsewhere switch ports are
+// only known from lldpd
+func (a *Agent) StartLLDPCapture(stopChan <-chan struct{}) error {
+	return fmt.Errorf("LLDP capture is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ndpProbeCount   = 3
+	ndpProbeTimeout = 500 * time.Millisecond
+
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+	ndpOptSourceLinkAddr        = 1
+	ndpHopLimit                 = 255 // RFC 4861 requires it on every NDP message
+)
+
+// ndpPing probes targetIP with count neighbor solicitations sent from
+// sourceIP on sourceInterface, the IPv6 equivalent of ARP, and succeeds if
+// any of them is answered with a neighbor advertisement
+func ndpPing(ctx context.Context, sourceInterface, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil || source.To4() != nil || target.To4() != nil {
+		return nil, fmt.Errorf("NDP requires IPv6 addresses, got %q -> %q", sourceIP, targetIP)
+	}
+
+	iface, err := net.InterfaceByName(sourceInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", sourceInterface, err)
+	}
+
+	conn, err := net.ListenIP("ip6:ipv6-icmp", &net.IPAddr{IP: source, Zone: iface.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMPv6 socket: %w", err)
+	}
+	defer conn.Close()
+
+	if err := setNDPHopLimit(conn); err != nil {
+		return nil, fmt.Errorf("failed to set hop limit: %w", err)
+	}
+
+	dst := &net.IPAddr{IP: solicitedNodeAddr(target), Zone: iface.Name}
+	solicitation := neighborSolicitation(target, iface.HardwareAddr)
+
+	stats := &pingStats{}
+	buf := make([]byte, 1500)
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stats.Sent++
+		start := time.Now()
+		if _, err := conn.WriteTo(solicitation, dst); err != nil {
+			return nil, fmt.Errorf("failed to send neighbor solicitation: %w", err)
+		}
+
+		conn.SetReadDeadline(probeDeadline(ctx, start, timeout))
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			if isNeighborAdvertisement(buf[:n], target) {
+				stats.record(time.Since(start))
+				break
+			}
+		}
+	}
+
+	if stats.Received == 0 {
+		return nil, fmt.Errorf("no replies to %d neighbor solicitations", stats.Sent)
+	}
+	return stats, nil
+}
+
+// solicitedNodeAddr returns the solicited-node multicast address of ip
+// (ff02::1:ffXX:XXXX), which the owner of ip listens on
+func solicitedNodeAddr(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// neighborSolicitation builds a neighbor solicitation for target, carrying
+// the sender's link-layer address when it has one. The kernel fills in the
+// ICMPv6 checksum.
+func neighborSolicitation(target net.IP, hwAddr net.HardwareAddr) []byte {
+	msg := make([]byte, 24, 24+2+len(hwAddr))
+	msg[0] = icmpv6NeighborSolicitation
+	copy(msg[8:24], target.To16())
+
+	// Options are padded to multiples of 8 bytes, which Ethernet addresses fill
+	if len(hwAddr) == 6 {
+		msg = append(msg, ndpOptSourceLinkAddr, 1)
+		msg = append(msg, hwAddr...)
+	}
+	return msg
+}
+
+// isNeighborAdvertisement reports whether msg is a neighbor advertisement
+// for target
+func isNeighborAdvertisement(msg []byte, target net.IP) bool {
+	if len(msg) < 24 || msg[0] != icmpv6NeighborAdvertisement || msg[1] != 0 {
+		return false
+	}
+	return net.IP(msg[8:24]).Equal(target)
+}
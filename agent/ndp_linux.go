@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"net"
+	"syscall"
+)
+
+// setNDPHopLimit sets the hop limit of unicast and multicast packets sent
+// through conn to the value receivers of NDP messages insist on
+func setNDPHopLimit(conn *net.IPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ndpHopLimit)
+		if sockErr == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ndpHopLimit)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
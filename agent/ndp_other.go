@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// setNDPHopLimit is only implemented on Linux
+func setNDPHopLimit(conn *net.IPConn) error {
+	return fmt.Errorf("NDP probing is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"validate/netplan"
+)
+
+// SetNamespaces sets the interfaces that live in named network namespaces,
+// keyed by namespace. Their links are discovered, and tests from them run,
+// inside the namespace, for hosts where tenant VLANs are kept out of the
+// host's namespace. Namespaces are named as by "ip netns", or by the path of
+// a namespace file such as /proc/<pid>/ns/net.
+func (a *Agent) SetNamespaces(namespaces map[string][]string) error {
+	ifaceNamespaces := make(map[string]string)
+	for namespace, ifaces := range namespaces {
+		if namespace == "" || (strings.Contains(namespace, "/") && !strings.HasPrefix(namespace, "/")) {
+			return fmt.Errorf("invalid network namespace %q", namespace)
+		}
+		for _, iface := range ifaces {
+			if other, ok := ifaceNamespaces[iface]; ok && other != namespace {
+				return fmt.Errorf("interface %s is in both network namespaces %s and %s", iface, other, namespace)
+			}
+			ifaceNamespaces[iface] = namespace
+		}
+	}
+	a.namespaces = ifaceNamespaces
+	return nil
+}
+
+// Namespaces returns the configured network namespaces, sorted by name
+func (a *Agent) Namespaces() []string {
+	var names []string
+	for _, namespace := range a.namespaces {
+		if !slices.Contains(names, namespace) {
+			names = append(names, namespace)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// namespaceOf returns the network namespace iface lives in, or "" for the
+// agent's own
+func (a *Agent) namespaceOf(iface string) string {
+	return a.namespaces[iface]
+}
+
+// inNamespace runs fn with the calling goroutine in the named network
+// namespace, so the sockets it opens belong to that namespace. Sockets keep
+// their namespace once opened, but goroutines started by fn do not run in
+// it. An empty name runs fn in the agent's own namespace.
+func inNamespace(namespace string, fn func() error) error {
+	if namespace == "" {
+		return fn()
+	}
+	return runInNamespace(namespace, fn)
+}
+
+// namespaceDialer returns the DialContext function of dialer that connects
+// from the network namespace iface lives in, for HTTP transports which dial
+// on goroutines of their own
+func (a *Agent) namespaceDialer(iface string, dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	namespace := a.namespaceOf(iface)
+	if namespace == "" {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		var conn net.Conn
+		err := inNamespace(namespace, func() error {
+			var err error
+			conn, err = dialer.DialContext(ctx, network, address)
+			return err
+		})
+		return conn, err
+	}
+}
+
+// namespaceLinks returns the addresses of the interfaces in the configured
+// network namespaces, keyed by interface
+func (a *Agent) namespaceLinks() map[string][]netplan.IPWithMask {
+	links := make(map[string][]netplan.IPWithMask)
+	for iface, namespace := range a.namespaces {
+		var addrs []netplan.IPWithMask
+		err := inNamespace(namespace, func() error {
+			var err error
+			addrs, err = systemInterfaceAddresses(iface)
+			return err
+		})
+		if err != nil {
+			slog.Warn("Failed to read interface addresses", "interface", iface, "netns", namespace, "error", err)
+			continue
+		}
+		if len(addrs) > 0 {
+			links[iface] = addrs
+		}
+	}
+	return links
+}
+
+// ListenInNamespace listens on addr from the named network namespace, so
+// agents testing links in that namespace can reach this agent's API
+func ListenInNamespace(namespace, network, addr string) (net.Listener, error) {
+	var ln net.Listener
+	err := inNamespace(namespace, func() error {
+		var err error
+		ln, err = net.Listen(network, addr)
+		return err
+	})
+	return ln, err
+}
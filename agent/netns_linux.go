@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// netnsDir is where "ip netns" keeps named network namespaces
+const netnsDir = "/run/netns"
+
+// setnsTraps holds the setns syscall number of each architecture, which the
+// syscall package does not define everywhere
+var setnsTraps = map[string]uintptr{
+	"386":      346,
+	"amd64":    308,
+	"arm":      375,
+	"arm64":    268,
+	"loong64":  268,
+	"ppc64le":  350,
+	"riscv64":  268,
+	"s390x":    339,
+	"mips64le": 5303,
+}
+
+// runInNamespace runs fn with the calling goroutine locked to an OS thread
+// switched into the named network namespace, and switches the thread back
+// afterwards. A thread that cannot be switched back is never reused.
+func runInNamespace(namespace string, fn func() error) error {
+	trap, ok := setnsTraps[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("network namespaces are not supported on %s", runtime.GOARCH)
+	}
+
+	path := namespace
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(netnsDir, namespace)
+	}
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace %s: %w", namespace, err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to open current network namespace: %w", err)
+	}
+	defer current.Close()
+
+	if err := setns(trap, target); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter network namespace %s: %w", namespace, err)
+	}
+	defer func() {
+		// Leave the thread locked, so it exits with the goroutine, if it
+		// cannot return to the agent's namespace
+		if setns(trap, current) == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	return fn()
+}
+
+// setns moves the calling thread into the network namespace open as ns
+func setns(trap uintptr, ns *os.File) error {
+	if _, _, errno := syscall.RawSyscall(trap, ns.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package agent
+
+import "fmt"
+
+// runInNamespace is only implemented on Linux
+func runInNamespace(namespace string, fn func() error) error {
+	return fmt.Errorf("network namespaces are only supported on Linux")
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pmtuProbeEstimate is the most probes a path MTU discovery sends: a binary
+// search between the minimum and a jumbo MTU takes up to 16 sizes
+const pmtuProbeEstimate = pmtuProbeAttempts * 16
+
+// probeLimiter spaces out probes so no more than rate are sent per second.
+// Bursts are not allowed: a reservation waits until the probes reserved
+// before it would have been sent at the limited rate.
+type probeLimiter struct {
+	rate int
+
+	mu   sync.Mutex
+	next time.Time // when the next reservation may start
+}
+
+// reserve reserves n probes and returns how long to wait before sending them
+func (l *probeLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	return start.Sub(now)
+}
+
+// wait reserves n probes and blocks until they may be sent, or ctx is done
+func (l *probeLimiter) wait(ctx context.Context, n int) error {
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probePacer limits the probe rate of the agent as a whole and of each
+// local interface, so a full-mesh run does not trip storm control on the
+// switches. A nil pacer, or a rate of 0, leaves probes unpaced.
+type probePacer struct {
+	global    *probeLimiter
+	ifaceRate int
+
+	mu     sync.Mutex
+	ifaces map[string]*probeLimiter
+}
+
+// newProbePacer returns a pacer allowing total probes per second from the
+// agent and perInterface probes per second from each interface, or nil if
+// both are 0
+func newProbePacer(total, perInterface int) *probePacer {
+	if total <= 0 && perInterface <= 0 {
+		return nil
+	}
+	p := &probePacer{ifaceRate: perInterface, ifaces: make(map[string]*probeLimiter)}
+	if total > 0 {
+		p.global = &probeLimiter{rate: total}
+	}
+	return p
+}
+
+// wait blocks until probes may be sent from iface
+func (p *probePacer) wait(ctx context.Context, iface string, probes int) error {
+	if p == nil || probes <= 0 {
+		return ctx.Err()
+	}
+
+	if p.ifaceRate > 0 {
+		p.mu.Lock()
+		limiter, ok := p.ifaces[iface]
+		if !ok {
+			limiter = &probeLimiter{rate: p.ifaceRate}
+			p.ifaces[iface] = limiter
+		}
+		p.mu.Unlock()
+
+		if err := limiter.wait(ctx, probes); err != nil {
+			return err
+		}
+	}
+	if p.global != nil {
+		return p.global.wait(ctx, probes)
+	}
+	return ctx.Err()
+}
+
+// SetProbeRate limits how many probes per second are sent in total and from
+// any single local interface. Each test waits for all the probes it may send
+// before it starts. A rate of 0 leaves probes unpaced.
+func (a *Agent) SetProbeRate(total, perInterface int) {
+	a.pacer = newProbePacer(total, perInterface)
+}
+
+// probeCost returns the most probes a test of testType sends with opts.
+// Tests that open a connection or make a request count as one probe.
+func probeCost(testType string, opts TestOptions) int {
+	switch testType {
+	case TestTypeARP:
+		return opts.count(arpProbeCount)
+	case TestTypeNDP:
+		return opts.count(ndpProbeCount)
+	case TestTypeICMP:
+		return opts.count(icmpProbeCount)
+	case TestTypeGateway:
+		// Neighbor resolution followed by echo requests
+		return opts.count(arpProbeCount) + opts.count(icmpProbeCount)
+	case TestTypeUDP:
+		return opts.count(udpProbeCount)
+	case TestTypeClockSkew:
+		return opts.count(clockSkewExchanges)
+	case TestTypeDSCP:
+		values := len(opts.DSCP)
+		if values == 0 {
+			values = len(defaultDSCPValues)
+		}
+		return opts.count(dscpProbeCount) * values
+	case TestTypePMTU:
+		return pmtuProbeEstimate
+	case TestTypeTraceroute:
+		return tracerouteMaxHops
+	}
+	return 1
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// pacingSlack allows for the time passing between the calls of a test
+const pacingSlack = 50 * time.Millisecond
+
+func TestProbeLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name         string
+		rate         int
+		reservations []int
+		want         []time.Duration
+	}{
+		{"first is immediate", 10, []int{5}, []time.Duration{0}},
+		{"waits for earlier probes", 10, []int{5, 5, 1}, []time.Duration{0, 500 * time.Millisecond, time.Second}},
+		{"single probes", 100, []int{1, 1, 1}, []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &probeLimiter{rate: tt.rate}
+			for i, n := range tt.reservations {
+				got := l.reserve(n)
+				if got > tt.want[i] || got < tt.want[i]-pacingSlack {
+					t.Errorf("reserve(%d) #%d = %v, want %v", n, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProbePacer(t *testing.T) {
+	type probes struct {
+		iface string
+		n     int
+	}
+	tests := []struct {
+		name         string
+		total        int
+		perInterface int
+		waits        []probes
+		want         time.Duration // before the last probes may be sent
+	}{
+		{"unpaced", 0, 0, []probes{{"eth0", 1000}, {"eth0", 1000}}, 0},
+		{"global limit", 100, 0, []probes{{"eth0", 10}, {"eth1", 10}}, 100 * time.Millisecond},
+		{"interfaces paced separately", 0, 100, []probes{{"eth0", 10}, {"eth1", 10}}, 0},
+		{"same interface", 0, 100, []probes{{"eth0", 10}, {"eth0", 10}}, 100 * time.Millisecond},
+		{"global limit below interface limits", 100, 1000, []probes{{"eth0", 10}, {"eth1", 10}}, 100 * time.Millisecond},
+		{"interface limit below global limit", 1000, 100, []probes{{"eth0", 10}, {"eth0", 10}}, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProbePacer(tt.total, tt.perInterface)
+			if (p == nil) != (tt.total == 0 && tt.perInterface == 0) {
+				t.Fatalf("newProbePacer(%d, %d) = %v", tt.total, tt.perInterface, p)
+			}
+
+			start := time.Now()
+			for _, w := range tt.waits {
+				if err := p.wait(context.Background(), w.iface, w.n); err != nil {
+					t.Fatalf("wait() error = %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.want-pacingSlack || elapsed > tt.want+pacingSlack {
+				t.Errorf("waited %v, want %v", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbePacerCancelled(t *testing.T) {
+	p := newProbePacer(1, 0)
+	if err := p.wait(context.Background(), "eth0", 10); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+
+	// The next probes could only be sent in 10 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.wait(ctx, "eth0", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestProbeCost(t *testing.T) {
+	tests := []struct {
+		testType string
+		opts     TestOptions
+		want     int
+	}{
+		{TestTypeICMP, TestOptions{}, icmpProbeCount},
+		{TestTypeICMP, TestOptions{Count: 7}, 7},
+		{TestTypeGateway, TestOptions{}, arpProbeCount + icmpProbeCount},
+		{TestTypeDSCP, TestOptions{}, dscpProbeCount * len(defaultDSCPValues)},
+		{TestTypeDSCP, TestOptions{Count: 2, DSCP: []int{0, 46}}, 4},
+		{TestTypePMTU, TestOptions{}, pmtuProbeEstimate},
+		{TestTypeTraceroute, TestOptions{}, tracerouteMaxHops},
+		{TestTypeHTTP, TestOptions{}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testType, func(t *testing.T) {
+			if got := probeCost(tt.testType, tt.opts); got != tt.want {
+				t.Errorf("probeCost(%s, %+v) = %d, want %d", tt.testType, tt.opts, got, tt.want)
+			}
+		})
+	}
+}
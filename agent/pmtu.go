@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"validate/netplan"
+)
+
+const (
+	pmtuProbeAttempts = 2
+	pmtuProbeTimeout  = 500 * time.Millisecond
+
+	// Every IPv4 host must accept 576 byte datagrams and every IPv6 link
+	// must carry 1280 byte packets, so the search never goes below these
+	minIPv4MTU = 576
+	minIPv6MTU = 1280
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+)
+
+// discoverPathMTU finds the largest packet, up to maxMTU bytes, that reaches
+// targetIP from sourceIP without being fragmented. It sends ICMP echo
+// requests with the don't-fragment bit set, confirming maxMTU first and
+// otherwise narrowing down the largest size that is answered within timeout.
+func discoverPathMTU(ctx context.Context, sourceInterface, sourceIP, targetIP string, maxMTU int, timeout time.Duration) (int, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil {
+		return 0, fmt.Errorf("invalid source or target IP %q -> %q", sourceIP, targetIP)
+	}
+
+	ipv6 := target.To4() == nil
+	minMTU, headerLen := minIPv4MTU, ipv4HeaderLen
+	if ipv6 {
+		minMTU, headerLen = minIPv6MTU, ipv6HeaderLen
+	}
+	if maxMTU <= 0 {
+		maxMTU = netplan.DefaultMTU
+	}
+	if maxMTU < minMTU {
+		maxMTU = minMTU
+	}
+
+	conn, err := openICMPConn(source, sourceInterface, ipv6)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := setDontFragment(conn, ipv6); err != nil {
+		return 0, fmt.Errorf("failed to set don't-fragment: %w", err)
+	}
+
+	dst := conn.destination(target)
+	id := os.Getpid() & 0xffff
+	seq := 0
+
+	// passes reports whether a packet of mtu bytes gets a reply
+	passes := func(mtu int) (bool, error) {
+		for attempt := 0; attempt < pmtuProbeAttempts; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			seq++
+			_, ok, err := conn.probe(ctx, dst, target, id, seq, mtu-headerLen, timeout)
+			if errors.Is(err, syscall.EMSGSIZE) {
+				// Larger than the local interface or a cached path MTU
+				return false, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to send ICMP echo request: %w", err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if ok, err := passes(maxMTU); err != nil || ok {
+		return maxMTU, err
+	}
+	if ok, err := passes(minMTU); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("no replies to %d byte probes", minMTU)
+	}
+
+	// minMTU passes and maxMTU does not
+	low, high := minMTU, maxMTU
+	for high-low > 1 {
+		mid := (low + high) / 2
+		ok, err := passes(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
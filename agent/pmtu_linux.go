@@ -0,0 +1,30 @@
+package agent
+
+import "syscall"
+
+// setDontFragment sets the don't-fragment bit on packets sent through conn.
+// Probe mode also ignores the kernel's cached path MTU, so every size is
+// actually put on the wire.
+func setDontFragment(conn *icmpConn, ipv6 bool) error {
+	sc, ok := conn.PacketConn.(syscall.Conn)
+	if !ok {
+		return syscall.EINVAL
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
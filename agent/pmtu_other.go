@@ -0,0 +1,13 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setDontFragment is only implemented on Linux
+func setDontFragment(conn *icmpConn, ipv6 bool) error {
+	return fmt.Errorf("path MTU probing is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const portConnectTimeout = 2 * time.Second
+
+// validatePorts checks that expected ports are valid TCP ports
+func validatePorts(ports []int) error {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d (must be 1-65535)", port)
+		}
+	}
+	return nil
+}
+
+// testPort checks that a TCP port expected to be open on the target accepts
+// connections from the matched local address
+func testPort(ctx context.Context, target testTarget, port int, opts TestOptions) TestResult {
+	result := target.newResult(TestTypePorts)
+	result.Port = port
+
+	dialer := boundDialer(target.sourceInterface, net.ParseIP(target.sourceIP))
+	dialer.Timeout = opts.timeout(portConnectTimeout)
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.ip, strconv.Itoa(port)))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		var netErr net.Error
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			result.ErrorMessage = fmt.Sprintf("Port %d is closed", port)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			result.ErrorMessage = fmt.Sprintf("Port %d is filtered: no answer within %v", port, dialer.Timeout)
+		default:
+			result.ErrorMessage = fmt.Sprintf("Port %d is unreachable: %v", port, err)
+		}
+		return result
+	}
+	if stats, ok := connTCPStats(conn); ok {
+		result.setTCPStats(stats)
+	}
+	conn.Close()
+
+	result.Success = true
+	return result
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// TestProfile is a named selection of tests and their options, which test
+// requests can ask for by name instead of listing them
+type TestProfile struct {
+	TestTypes []string               // empty runs every test type
+	Options   map[string]TestOptions // test type -> options
+	Ports     []int                  // TCP ports the ports test checks on every target, besides those the aggregator expects
+}
+
+// SetProfiles sets the test profiles requests can select by name
+func (a *Agent) SetProfiles(profiles map[string]TestProfile) error {
+	for name, profile := range profiles {
+		if name == "" {
+			return fmt.Errorf("test profile without a name")
+		}
+		req := TestRequest{TestTypes: profile.TestTypes, Options: profile.Options}
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("test profile %s: %w", name, err)
+		}
+		if err := validatePorts(profile.Ports); err != nil {
+			return fmt.Errorf("test profile %s: %w", name, err)
+		}
+	}
+	a.profiles = profiles
+	return nil
+}
+
+// ResolveProfile returns req with the tests and options of the profile it
+// selects. Test types in the request narrow the profile's down, e.g. to
+// those the agent supports, and options in the request override the
+// profile's for their test type. Requests without a profile are returned
+// unchanged.
+func (a *Agent) ResolveProfile(req TestRequest) (TestRequest, error) {
+	if req.Profile == "" {
+		return req, nil
+	}
+	profile, ok := a.profiles[req.Profile]
+	if !ok {
+		return req, fmt.Errorf("unknown test profile %q (must be one of: %v)", req.Profile, slices.Sorted(maps.Keys(a.profiles)))
+	}
+
+	testTypes := profile.TestTypes
+	if len(testTypes) == 0 {
+		testTypes = AllTestTypes
+	}
+	if len(req.TestTypes) > 0 {
+		testTypes = slices.DeleteFunc(slices.Clone(testTypes), func(testType string) bool {
+			return !slices.Contains(req.TestTypes, testType)
+		})
+		if len(testTypes) == 0 {
+			return req, fmt.Errorf("test profile %s runs none of the requested test types", req.Profile)
+		}
+	}
+
+	options := maps.Clone(profile.Options)
+	if options == nil {
+		options = make(map[string]TestOptions)
+	}
+	maps.Copy(options, req.Options)
+
+	resolved := req
+	resolved.Profile = ""
+	resolved.TestTypes = testTypes
+	resolved.Options = options
+	if len(profile.Ports) > 0 {
+		resolved.Targets = make(map[string]TargetInfo, len(req.Targets))
+		for hostname, target := range req.Targets {
+			ports := slices.Clone(target.ExpectedPorts)
+			for _, port := range profile.Ports {
+				if !slices.Contains(ports, port) {
+					ports = append(ports, port)
+				}
+			}
+			target.ExpectedPorts = ports
+			resolved.Targets[hostname] = target
+		}
+	}
+	return resolved, nil
+}
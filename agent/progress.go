@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Test run states reported by Progress
+const (
+	RunStateIdle      = "idle" // no run since the agent started
+	RunStateRunning   = "running"
+	RunStateCompleted = "completed"
+	RunStateCancelled = "cancelled"
+)
+
+// TestProgress reports how far the current or last test run has got
+type TestProgress struct {
+	RunID      string     `json:"run_id,omitempty"`
+	State      string     `json:"state"` // one of the RunState constants
+	Completed  int        `json:"completed"`
+	Total      int        `json:"total"` // grows when failed targets get a traceroute
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ETASeconds int64      `json:"eta_seconds,omitempty"` // running only, once a test has completed
+}
+
+// runProgress tracks the tests of one run
+type runProgress struct {
+	mu         sync.Mutex
+	runID      string
+	state      string
+	startedAt  time.Time
+	finishedAt time.Time
+	completed  int
+	total      int
+}
+
+// startProgress begins tracking a run with the given number of planned tests
+func (a *Agent) startProgress(runID string, total int) *runProgress {
+	p := &runProgress{
+		runID:     runID,
+		state:     RunStateRunning,
+		startedAt: time.Now(),
+		total:     total,
+	}
+
+	a.runMu.Lock()
+	a.progress = p
+	a.runMu.Unlock()
+	return p
+}
+
+// complete counts a finished test. A nil runProgress ignores it.
+func (p *runProgress) complete() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.completed++
+	p.mu.Unlock()
+}
+
+// extend adds a test that was not planned when the run started, such as a
+// traceroute after a failed reachability test. A nil runProgress ignores it.
+func (p *runProgress) extend() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total++
+	p.mu.Unlock()
+}
+
+// finish marks the run as completed, or cancelled
+func (p *runProgress) finish(cancelled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = RunStateCompleted
+	if cancelled {
+		p.state = RunStateCancelled
+	}
+	p.finishedAt = time.Now()
+}
+
+// Progress reports the progress of the running test run, or the outcome of
+// the last one. The ETA assumes the remaining tests take as long as the
+// completed ones did on average.
+func (a *Agent) Progress() TestProgress {
+	a.runMu.Lock()
+	p := a.progress
+	a.runMu.Unlock()
+
+	if p == nil {
+		return TestProgress{State: RunStateIdle}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress := TestProgress{
+		RunID:     p.runID,
+		State:     p.state,
+		Completed: p.completed,
+		Total:     p.total,
+		StartedAt: &p.startedAt,
+	}
+	if p.state != RunStateRunning {
+		progress.FinishedAt = &p.finishedAt
+	} else if p.completed > 0 && p.total > p.completed {
+		elapsed := time.Since(p.startedAt)
+		remaining := elapsed * time.Duration(p.total-p.completed) / time.Duration(p.completed)
+		progress.ETASeconds = int64(remaining.Round(time.Second) / time.Second)
+	}
+	return progress
+}
+
+// plannedTests returns the number of tests testConnectivity runs against the
+// target, counting the traceroute only when it runs unconditionally
+func plannedTests(target testTarget, req TestRequest) int {
+	planned := 0
+	ranReachability := false
+	for _, testType := range AllTestTypes {
+		if testType == TestTypeTraceroute || !req.enabled(testType) || !target.supports(testType) {
+			continue
+		}
+		if testType == TestTypePorts || testType == TestTypeTLS {
+			planned += len(target.ports(testType))
+			continue
+		}
+		planned++
+		if req.Bidirectional && reversible(testType) {
+			planned++
+		}
+		if slices.Contains(reachabilityTestTypes, testType) {
+			ranReachability = true
+		}
+	}
+	if req.enabled(TestTypeTraceroute) && !ranReachability {
+		planned++
+		if req.Bidirectional {
+			planned++
+		}
+	}
+	return planned
+}
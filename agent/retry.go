@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Default retrying of result submissions
+const (
+	DefaultSubmitAttempts          = 3
+	DefaultSubmitBackoff           = 500 * time.Millisecond
+	DefaultCircuitBreakerThreshold = 3
+	DefaultCircuitBreakerCooldown  = 30 * time.Second
+
+	submitMaxBackoff = 10 * time.Second
+)
+
+// errCircuitOpen is returned instead of contacting an aggregator that kept
+// failing until its cooldown has passed
+var errCircuitOpen = errors.New("aggregator circuit breaker is open")
+
+// circuitBreaker stops result submissions to an aggregator that failed
+// threshold submissions in a row, so tests are not held up retrying it. Once
+// the cooldown has passed a single submission is let through; if it fails
+// the breaker opens again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a submission may be attempted
+func (c *circuitBreaker) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures < c.threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(c.openUntil) {
+		return false
+	}
+	// Half open: let this submission probe the aggregator and hold back
+	// the others until it is done or the cooldown passes again
+	c.openUntil = now.Add(c.cooldown)
+	return true
+}
+
+// record updates the breaker with the outcome of a submission. Rejected
+// results still prove the aggregator is reachable.
+func (c *circuitBreaker) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil || errors.Is(err, errResultsRejected) {
+		if c.failures >= c.threshold {
+			slog.Info("Aggregator reachable again, closing circuit breaker")
+		}
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures == c.threshold {
+		c.openUntil = time.Now().Add(c.cooldown)
+		slog.Warn("Aggregator keeps failing, opening circuit breaker", "failures", c.failures, "cooldown", c.cooldown)
+	}
+}
+
+// SetSubmitRetry sets how many times a result submission is attempted, and
+// the backoff before the first retry, which doubles with every further
+// retry. Values below 1 keep the current setting.
+func (a *Agent) SetSubmitRetry(attempts int, backoff time.Duration) {
+	if attempts > 0 {
+		a.submitAttempts = attempts
+	}
+	if backoff > 0 {
+		a.submitBackoff = backoff
+	}
+}
+
+// SetCircuitBreaker sets after how many failed submissions in a row the
+// aggregator is left alone, and for how long. Values below 1 keep the
+// current setting.
+func (a *Agent) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	a.breaker.mu.Lock()
+	defer a.breaker.mu.Unlock()
+
+	if threshold > 0 {
+		a.breaker.threshold = threshold
+	}
+	if cooldown > 0 {
+		a.breaker.cooldown = cooldown
+	}
+}
+
+// submitWithRetry posts a result payload, retrying with exponential backoff
+// and jitter while the aggregator fails. Payloads the aggregator rejects are
+// not retried, nothing is sent while the circuit breaker is open, and no
+// retry follows once ctx is done.
+func (a *Agent) submitWithRetry(ctx context.Context, payload TestResultPayload) error {
+	if !a.breaker.allow() {
+		return errCircuitOpen
+	}
+
+	backoff := a.submitBackoff
+	for attempt := 1; ; attempt++ {
+		err := a.postResults(payload)
+		if err == nil || errors.Is(err, errResultsRejected) || attempt >= a.submitAttempts {
+			a.breaker.record(err)
+			return err
+		}
+
+		// Jitter between half and all of the backoff keeps agents that
+		// failed together from retrying together
+		wait := backoff/2 + rand.N(backoff/2+1)
+		slog.Debug("Retrying result submission", "run_id", payload.RunID, "attempt", attempt, "retry_in", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			a.breaker.record(err)
+			return err
+		}
+		backoff = min(backoff*2, submitMaxBackoff)
+	}
+}
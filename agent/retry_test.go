@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// Steps record the outcome of a submission (fail, ok or reject), let the
+	// cooldown pass (expire), or check whether a submission is allowed
+	// (allow or deny)
+	tests := []struct {
+		name  string
+		steps []string
+	}{
+		{"closed", []string{"allow", "fail", "allow", "fail", "allow"}},
+		{"opens at threshold", []string{"fail", "fail", "fail", "deny", "deny"}},
+		{"success resets failures", []string{"fail", "fail", "ok", "fail", "allow"}},
+		{"rejection proves aggregator reachable", []string{"fail", "fail", "reject", "fail", "allow"}},
+		{"half open lets one submission through", []string{"fail", "fail", "fail", "expire", "allow", "deny"}},
+		{"half open failure reopens", []string{"fail", "fail", "fail", "expire", "allow", "fail", "deny", "expire", "allow", "deny"}},
+		{"half open success closes", []string{"fail", "fail", "fail", "expire", "allow", "ok", "allow", "allow"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &circuitBreaker{threshold: 3, cooldown: time.Hour}
+			for i, step := range tt.steps {
+				switch step {
+				case "fail":
+					c.record(errors.New("connection refused"))
+				case "ok":
+					c.record(nil)
+				case "reject":
+					c.record(errResultsRejected)
+				case "expire":
+					c.openUntil = time.Now()
+				case "allow", "deny":
+					if got, want := c.allow(), step == "allow"; got != want {
+						t.Fatalf("step %d: allow() = %v, want %v", i, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		breakerOpen  bool
+		draining     bool
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"accepted", http.StatusOK, false, false, false, 1},
+		{"rejected", http.StatusBadRequest, false, false, true, 1},
+		{"failing", http.StatusInternalServerError, false, false, true, 3},
+		{"circuit open", http.StatusOK, true, false, true, 0},
+		{"failing while draining", http.StatusInternalServerError, false, true, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, aggregator := newTestAgent(t, tt.status)
+			a.SetSubmitRetry(3, time.Millisecond)
+			if tt.draining {
+				// Retries would wait for an hour unless cut short
+				a.SetSubmitRetry(3, time.Hour)
+				a.WaitForTests(context.Background())
+			}
+			a.SetCircuitBreaker(10, time.Hour)
+			if tt.breakerOpen {
+				a.breaker.failures = a.breaker.threshold
+				a.breaker.openUntil = time.Now().Add(time.Hour)
+			}
+
+			err := a.submitWithRetry(a.drainCtx, TestResultPayload{Results: []TestResult{{TestType: TestTypeICMP}}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("submitWithRetry() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := len(aggregator.received()); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
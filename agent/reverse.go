@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// reverseTestTimeout bounds a reverse test, which includes running the test
+// on the target agent
+const reverseTestTimeout = 2 * time.Minute
+
+// reversible reports whether the target agent can run testType back to this
+// agent. Ports and TLS check services expected on the target, so they only
+// run one way.
+func reversible(testType string) bool {
+	return testType != TestTypePorts && testType != TestTypeTLS
+}
+
+// newCorrelationID returns an identifier shared by both directions of a
+// bidirectional test
+func newCorrelationID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// apiPort returns the port other agents reach this agent's API on
+func (a *Agent) apiPort() int {
+	if a.advertiseURL != "" {
+		if u, err := url.Parse(a.advertiseURL); err == nil && u.Port() != "" {
+			port, _ := strconv.Atoi(u.Port())
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(a.listenAddr); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			return p
+		}
+	}
+	return DefaultAgentPort
+}
+
+// runReverseTest asks the target agent to run testType back to this agent,
+// from its address on the link under test to the address the forward test
+// came from, and returns the result as measured by the target
+func (a *Agent) runReverseTest(ctx context.Context, testType string, target testTarget, opts TestOptions) TestResult {
+	result := TestResult{
+		SourceHostname: target.hostname,
+		TargetHostname: a.hostname,
+		TargetIP:       target.sourceIP,
+		SourceIP:       target.ip,
+		BondName:       target.sourceInterface,
+		TestType:       testType,
+	}
+
+	reverse, err := a.requestReverseTest(ctx, target, AdHocTestRequest{
+		TargetIP:  target.sourceIP,
+		TestType:  testType,
+		AgentPort: a.apiPort(),
+		Options:   opts,
+	})
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Target agent could not run the reverse test: %v", err)
+		result.TestedAt = time.Now()
+		return result
+	}
+
+	// The target tested an address rather than a host, and does not know
+	// which of this agent's links it belongs to
+	reverse.SourceHostname = target.hostname
+	reverse.TargetHostname = a.hostname
+	reverse.BondName = target.sourceInterface
+	return reverse
+}
+
+// requestReverseTest runs an ad-hoc test on the target agent, reached on the
+// target address of the forward test from its source address
+func (a *Agent) requestReverseTest(ctx context.Context, target testTarget, req AdHocTestRequest) (TestResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return TestResult{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target.url(a.auth.Scheme(), "/api/test-now"), bytes.NewReader(body))
+	if err != nil {
+		return TestResult{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	dialer := boundDialer(target.sourceInterface, net.ParseIP(target.sourceIP))
+	client := a.auth.LinkClient(reverseTestTimeout, a.namespaceDialer(target.sourceInterface, dialer))
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return TestResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return TestResult{}, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var result TestResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return TestResult{}, fmt.Errorf("invalid test result: %w", err)
+	}
+	return result, nil
+}
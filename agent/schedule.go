@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when scheduled self-tests run
+type Schedule interface {
+	// Next returns the first run time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// IntervalSchedule runs at every multiple of the interval since the Unix
+// epoch, so agents with the same interval run at the same moments
+type IntervalSchedule time.Duration
+
+// Next returns the next multiple of the interval after t
+func (s IntervalSchedule) Next(t time.Time) time.Time {
+	d := time.Duration(s)
+	return t.Truncate(d).Add(d)
+}
+
+// cronSchedule is a parsed five-field cron expression, evaluated in UTC
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+// ParseCron parses a standard cron expression with five fields (minute,
+// hour, day of month, month and day of week), each of which may be "*",
+// a value, a range "a-b", a list "a,b" and a step "*/n" or "a-b/n".
+// Expressions are evaluated in UTC.
+func ParseCron(expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	s.dow[0] = s.dow[0] || s.dow[7]
+
+	return s, nil
+}
+
+// parseCronField returns which values between lo and hi a field selects
+func parseCronField(field string, lo, hi int) ([]bool, error) {
+	values := make([]bool, hi+1)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		start, end := lo, hi
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("invalid value %q", first)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// Next returns the first minute after t matching the expression
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	next := t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// Every valid expression matches at least once in a few years
+	for limit := next.AddDate(5, 0, 0); next.Before(limit); {
+		if !s.month[int(next.Month())] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.hour[next.Hour()] {
+			next = next.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !s.minute[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a day matches either day field when
+// both are restricted, and the restricted one otherwise
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// StartScheduledTests re-runs the last test request received from the
+// aggregator whenever the schedule fires, until ctx is done, so monitoring
+// continues while the aggregator cannot trigger runs. Runs are skipped while
+// another run is in progress or before any request arrived, and are
+// cancelled with ctx.
+func (a *Agent) StartScheduledTests(ctx context.Context, schedule Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			slog.Warn("Test schedule never fires, scheduled tests disabled")
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		req, ok := a.scheduledRequest(next)
+		if !ok {
+			continue
+		}
+		slog.Info("Starting scheduled connectivity tests", "run_id", req.RunID)
+		a.RunConnectivityTests(ctx, req)
+	}
+}
+
+// scheduledRequest returns the request for a scheduled run at the given
+// time. Its run ID only depends on that time, so the results of agents on
+// the same schedule are grouped into one run.
+func (a *Agent) scheduledRequest(at time.Time) (TestRequest, bool) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	if a.lastRequest == nil {
+		slog.Info("Skipping scheduled tests, no targets received from the aggregator yet")
+		return TestRequest{}, false
+	}
+	if a.cancelRun != nil {
+		slog.Info("Skipping scheduled tests, a test run is in progress")
+		return TestRequest{}, false
+	}
+
+	req := *a.lastRequest
+	req.RunID = at.UTC().Format("20060102T150405Z") + "-scheduled"
+	return req, true
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backoff between attempts to deliver spooled results
+const (
+	spoolRetryMinBackoff = 5 * time.Second
+	spoolRetryMaxBackoff = 5 * time.Minute
+)
+
+// errResultsRejected is returned when the aggregator refuses a payload with a
+// client error, which retrying the same payload cannot fix
+var errResultsRejected = errors.New("results rejected by aggregator")
+
+// resultSpool is a disk-backed queue of result payloads that could not be
+// delivered to the aggregator. Each payload is stored in its own file, named
+// so that lexical order is the order in which they were spooled.
+type resultSpool struct {
+	dir string
+
+	mu    sync.Mutex
+	seq   int
+	kick  chan struct{}
+	flush sync.Mutex
+}
+
+// newResultSpool creates the spool directory if needed
+func newResultSpool(dir string) (*resultSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &resultSpool{dir: dir, kick: make(chan struct{}, 1)}, nil
+}
+
+// add writes payload to the spool and wakes up the retry loop
+func (s *resultSpool) add(payload TestResultPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal results: %w", err)
+	}
+
+	s.mu.Lock()
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), s.seq%1000000)
+	s.mu.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partial payload
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending returns the spooled payload files, oldest first
+func (s *resultSpool) pending() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// deliver submits the spooled payloads in order with send, removing each one
+// once it is acknowledged. It stops at the first payload that cannot be sent
+// and returns the number delivered.
+func (s *resultSpool) deliver(send func(TestResultPayload) error) (int, error) {
+	s.flush.Lock()
+	defer s.flush.Unlock()
+
+	files, err := s.pending()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return delivered, fmt.Errorf("failed to read spool file: %w", err)
+		}
+
+		var payload TestResultPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			slog.Warn("Discarding corrupt spool file", "file", file, "error", err)
+			os.Remove(file)
+			continue
+		}
+
+		if err := send(payload); err != nil {
+			if !errors.Is(err, errResultsRejected) {
+				return delivered, err
+			}
+			slog.Warn("Discarding spooled results", "file", file, "run_id", payload.RunID, "error", err)
+		} else {
+			delivered++
+		}
+
+		if err := os.Remove(file); err != nil {
+			return delivered, fmt.Errorf("failed to remove spool file: %w", err)
+		}
+	}
+
+	return delivered, nil
+}
+
+// SetSpoolDir enables spooling of results the aggregator could not receive
+// to dir, from where StartResultRetry resubmits them
+func (a *Agent) SetSpoolDir(dir string) error {
+	spool, err := newResultSpool(dir)
+	if err != nil {
+		return err
+	}
+	a.spool = spool
+	return nil
+}
+
+// StartResultRetry resubmits spooled results until stopChan is closed,
+// backing off exponentially while the aggregator stays unreachable
+func (a *Agent) StartResultRetry(stopChan <-chan struct{}) {
+	if a.spool == nil {
+		return
+	}
+
+	backoff := spoolRetryMinBackoff
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-a.spool.kick:
+			// New results were spooled; keep waiting out the current backoff
+			continue
+		case <-stopChan:
+			return
+		}
+
+		// Spooled deliveries also tell the circuit breaker when the
+		// aggregator is back
+		delivered, err := a.spool.deliver(func(payload TestResultPayload) error {
+			err := a.postResults(payload)
+			a.breaker.record(err)
+			return err
+		})
+		if delivered > 0 {
+			slog.Info("Delivered spooled results", "batches", delivered)
+		}
+		if err != nil {
+			slog.Warn("Failed to deliver spooled results", "retry_in", backoff, "error", err)
+			timer.Reset(backoff)
+			backoff = min(backoff*2, spoolRetryMaxBackoff)
+			continue
+		}
+
+		backoff = spoolRetryMinBackoff
+		// Wait for more results to be spooled
+		select {
+		case <-a.spool.kick:
+			timer.Reset(backoff)
+		case <-stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"syscall"
+	"time"
+)
+
+// tcpStats are the kernel's statistics of a test's TCP connection, which
+// tell a lossy but working link from a clean one
+type tcpStats struct {
+	retransmits int           // segments retransmitted over the connection's lifetime
+	rtt         time.Duration // smoothed round trip time
+	cwnd        int           // congestion window in segments
+}
+
+// connTCPStats reads the TCP statistics of conn, which may be wrapped in TLS.
+// It reports false where they are not available.
+func connTCPStats(conn net.Conn) (tcpStats, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return tcpStats{}, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return tcpStats{}, false
+	}
+
+	var (
+		stats    tcpStats
+		statsErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		stats, statsErr = readTCPInfo(fd)
+	}); err != nil || statsErr != nil {
+		return tcpStats{}, false
+	}
+	return stats, true
+}
+
+// connTracer remembers the connection an HTTP request was sent over, so its
+// TCP statistics can be read before the response body is closed
+type connTracer struct {
+	conn net.Conn
+}
+
+// context returns ctx with a trace that records the request's connection
+func (t *connTracer) context(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.conn = info.Conn
+		},
+	})
+}
+
+// stats reads the TCP statistics of the recorded connection
+func (t *connTracer) stats() (tcpStats, bool) {
+	if t.conn == nil {
+		return tcpStats{}, false
+	}
+	return connTCPStats(t.conn)
+}
+
+// setTCPStats records the TCP statistics of the test's connection
+func (r *TestResult) setTCPStats(stats tcpStats) {
+	r.TCPRetransmits = stats.retransmits
+	r.TCPRTTMS = durationMS(stats.rtt)
+	r.TCPCwnd = stats.cwnd
+}
@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// readTCPInfo reads TCP_INFO from the socket
+func readTCPInfo(fd uintptr) (tcpStats, error) {
+	var info syscall.TCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return tcpStats{}, errno
+	}
+
+	return tcpStats{
+		retransmits: int(info.Total_retrans),
+		rtt:         time.Duration(info.Rtt) * time.Microsecond,
+		cwnd:        int(info.Snd_cwnd),
+	}, nil
+}
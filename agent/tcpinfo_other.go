@@ -0,0 +1,10 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+// readTCPInfo is only implemented on Linux
+func readTCPInfo(fd uintptr) (tcpStats, error) {
+	return tcpStats{}, errors.New("TCP_INFO is only available on Linux")
+}
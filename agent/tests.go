@@ -0,0 +1,573 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connectivity test types
+const (
+	TestTypeARP        = "arp"
+	TestTypeNDP        = "ndp"
+	TestTypeICMP       = "icmp"
+	TestTypeUDP        = "udp"
+	TestTypeDSCP       = "dscp"
+	TestTypeHTTP       = "http"
+	TestTypeClockSkew  = "clock-skew"
+	TestTypePorts      = "ports" // one result per expected port of the target
+	TestTypeTLS        = "tls"   // one result per TLS port of the target
+	TestTypeBandwidth  = "bandwidth"
+	TestTypePMTU       = "pmtu"
+	TestTypeTraceroute = "traceroute"
+	TestTypeBondHealth = "bond-health" // local, once per bond rather than per target
+	TestTypeGateway    = "gateway"     // local, once per configured gateway
+	TestTypeExternal   = "external"    // local, once per external endpoint and uplink
+	TestTypeTimeSync   = "time-sync"   // local, once for the clock and once per uplink to the NTP server
+)
+
+// AllTestTypes lists every test type in the order the tests are run
+var AllTestTypes = []string{
+	TestTypeARP,
+	TestTypeNDP,
+	TestTypeICMP,
+	TestTypeUDP,
+	TestTypeDSCP,
+	TestTypeHTTP,
+	TestTypeClockSkew,
+	TestTypePorts,
+	TestTypeTLS,
+	TestTypeBandwidth,
+	TestTypePMTU,
+	TestTypeTraceroute,
+	TestTypeBondHealth,
+	TestTypeGateway,
+	TestTypeExternal,
+	TestTypeTimeSync,
+}
+
+// reachabilityTestTypes are the tests whose failure triggers a traceroute
+var reachabilityTestTypes = []string{TestTypeARP, TestTypeNDP, TestTypeICMP, TestTypeUDP, TestTypeHTTP}
+
+// TestOptions tunes a single test type. Zero values keep the defaults, and
+// options that do not apply to a test type are ignored.
+type TestOptions struct {
+	Count           int   `json:"count,omitempty"`            // probes sent by arp, ndp, icmp, udp, gateway and external, per marking by dscp, and time exchanges by clock-skew
+	TimeoutMS       int   `json:"timeout_ms,omitempty"`       // per-probe timeout for arp, ndp, icmp, udp, dscp, ports, tls, gateway, external, time-sync, pmtu and traceroute
+	DurationSeconds int   `json:"duration_seconds,omitempty"` // bandwidth stream duration
+	Streams         int   `json:"streams,omitempty"`          // parallel bandwidth streams
+	MaxOffsetMS     int   `json:"max_offset_ms,omitempty"`    // clock offset time-sync and clock-skew tolerate
+	DSCP            []int `json:"dscp,omitempty"`             // markings dscp checks
+
+	// The http test's request, by default GET /api/sysinfo on the target
+	// agent's API answered with 200 OK
+	Port           int    `json:"port,omitempty"`            // port instead of the target agent's
+	Path           string `json:"path,omitempty"`            // path instead of /api/sysinfo
+	Scheme         string `json:"scheme,omitempty"`          // http or https instead of the agent API's scheme
+	ExpectedStatus int    `json:"expected_status,omitempty"` // status code instead of 200
+}
+
+func (o TestOptions) count(def int) int {
+	if o.Count > 0 {
+		return o.Count
+	}
+	return def
+}
+
+func (o TestOptions) timeout(def time.Duration) time.Duration {
+	if o.TimeoutMS > 0 {
+		return time.Duration(o.TimeoutMS) * time.Millisecond
+	}
+	return def
+}
+
+func (o TestOptions) duration(def time.Duration) time.Duration {
+	if o.DurationSeconds > 0 {
+		return time.Duration(o.DurationSeconds) * time.Second
+	}
+	return def
+}
+
+func (o TestOptions) streams(def int) int {
+	if o.Streams > 0 {
+		return o.Streams
+	}
+	return def
+}
+
+func (o TestOptions) maxOffset(def time.Duration) time.Duration {
+	if o.MaxOffsetMS > 0 {
+		return time.Duration(o.MaxOffsetMS) * time.Millisecond
+	}
+	return def
+}
+
+// Validate checks that a test request only asks for known test types and
+// sensible options
+func (r TestRequest) Validate() error {
+	for _, testType := range r.TestTypes {
+		if !slices.Contains(AllTestTypes, testType) {
+			return fmt.Errorf("unknown test type %q (must be one of: %v)", testType, AllTestTypes)
+		}
+	}
+	for testType, opts := range r.Options {
+		if !slices.Contains(AllTestTypes, testType) {
+			return fmt.Errorf("options for unknown test type %q", testType)
+		}
+		if opts.Count < 0 || opts.TimeoutMS < 0 || opts.DurationSeconds < 0 || opts.Streams < 0 || opts.MaxOffsetMS < 0 {
+			return fmt.Errorf("%s options must not be negative", testType)
+		}
+		if err := validateDSCP(opts.DSCP); err != nil {
+			return err
+		}
+		if err := validateHTTPOptions(opts); err != nil {
+			return fmt.Errorf("%s options: %w", testType, err)
+		}
+	}
+	for hostname, target := range r.Targets {
+		if err := validatePorts(target.ExpectedPorts); err != nil {
+			return fmt.Errorf("target %s: %w", hostname, err)
+		}
+		if err := validatePorts(target.TLSPorts); err != nil {
+			return fmt.Errorf("target %s: TLS %w", hostname, err)
+		}
+	}
+	for _, endpoint := range r.ExternalEndpoints {
+		if err := validateExternalEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enabled reports whether the request asks for a test type. A request
+// without test types runs every test.
+func (r TestRequest) enabled(testType string) bool {
+	return len(r.TestTypes) == 0 || slices.Contains(r.TestTypes, testType)
+}
+
+// assigned reports whether the agent on local tests target in this run.
+// Deduplicated runs test each pair of agents from one side only, picked by a
+// hash of the pair so both agents agree and the pairs are split evenly.
+func (r TestRequest) assigned(local, target string) bool {
+	if !r.Deduplicate {
+		return true
+	}
+
+	first, second := local, target
+	if second < first {
+		first, second = second, first
+	}
+	h := fnv.New32a()
+	h.Write([]byte(first))
+	h.Write([]byte{0})
+	h.Write([]byte(second))
+	return (h.Sum32()%2 == 0) == (local == first)
+}
+
+// testTarget is a target IP together with the local address used to reach it
+type testTarget struct {
+	hostname        string
+	ip              string
+	bondName        string
+	sourceIP        string
+	sourceInterface string
+	expectedMTU     int
+	agentPort       int      // 0 for the default port
+	skipTestTypes   []string // tests the target cannot answer
+	expectedPorts   []int    // TCP ports expected to be open
+	tlsPorts        []int    // TCP ports expected to complete a TLS handshake
+}
+
+// ports returns the ports a per-port test type checks on the target, or nil
+// for test types that run once per target
+func (t testTarget) ports(testType string) []int {
+	switch testType {
+	case TestTypePorts:
+		return t.expectedPorts
+	case TestTypeTLS:
+		return t.tlsPorts
+	}
+	return nil
+}
+
+// ipv6 reports whether the target is an IPv6 address
+func (t testTarget) ipv6() bool {
+	ip := net.ParseIP(t.ip)
+	return ip != nil && ip.To4() == nil
+}
+
+// supports reports whether testType applies to the target. ARP only resolves
+// IPv4 addresses and NDP only IPv6 ones, ports and TLS are only checked on
+// targets with ports to check, targets may not answer some tests,
+// and bond health, gateways, external endpoints and time sync are checked
+// locally rather than against targets.
+func (t testTarget) supports(testType string) bool {
+	if slices.Contains(t.skipTestTypes, testType) {
+		return false
+	}
+	switch testType {
+	case TestTypeBondHealth, TestTypeGateway, TestTypeExternal, TestTypeTimeSync:
+		return false
+	case TestTypeARP:
+		return !t.ipv6()
+	case TestTypeNDP:
+		return t.ipv6()
+	case TestTypePorts:
+		return len(t.expectedPorts) > 0
+	case TestTypeTLS:
+		return len(t.tlsPorts) > 0
+	}
+	return true
+}
+
+// newResult returns an empty result of the given type for the target
+func (t testTarget) newResult(testType string) TestResult {
+	return TestResult{
+		TargetHostname:  t.hostname,
+		TargetIP:        t.ip,
+		SourceIP:        t.sourceIP,
+		BondName:        t.bondName,
+		SourceInterface: t.sourceInterface,
+		TestType:        testType,
+	}
+}
+
+// url returns the URL of path on the target agent's API
+func (t testTarget) url(scheme, path string) string {
+	return t.urlOnPort(scheme, t.agentPort, path)
+}
+
+// urlOnPort returns the URL of path on a port of the target, or on the
+// target agent's API for port 0
+func (t testTarget) urlOnPort(scheme string, port int, path string) string {
+	if port == 0 {
+		port = t.agentPort
+	}
+	if port == 0 {
+		port = DefaultAgentPort
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(t.ip, strconv.Itoa(port)), path)
+}
+
+// runTest runs a single test type against the target, once the probe rate
+// limits allow it, from the network namespace of the source interface
+func (a *Agent) runTest(ctx context.Context, testType string, target testTarget, opts TestOptions) TestResult {
+	if err := a.pacer.wait(ctx, target.sourceInterface, probeCost(testType, opts)); err != nil {
+		result := target.newResult(testType)
+		result.ErrorMessage = fmt.Sprintf("Test cancelled: %v", err)
+		return result
+	}
+
+	var result TestResult
+	err := inNamespace(a.namespaceOf(target.sourceInterface), func() error {
+		result = a.runTestType(ctx, testType, target, opts)
+		return nil
+	})
+	if err != nil {
+		result = target.newResult(testType)
+		result.ErrorMessage = err.Error()
+	}
+	result.TestedAt = time.Now()
+	return result
+}
+
+// runPortTest runs the ports or tls test against a port of the target, from
+// the network namespace of the source interface
+func (a *Agent) runPortTest(ctx context.Context, testType string, target testTarget, port int, opts TestOptions) TestResult {
+	var result TestResult
+	err := inNamespace(a.namespaceOf(target.sourceInterface), func() error {
+		if testType == TestTypePorts {
+			result = testPort(ctx, target, port, opts)
+		} else {
+			result = testTLS(ctx, target, port, opts)
+		}
+		return nil
+	})
+	if err != nil {
+		result = target.newResult(testType)
+		result.Port = port
+		result.ErrorMessage = err.Error()
+	}
+	result.TestedAt = time.Now()
+	return result
+}
+
+// runTestType runs a single test type against the target
+func (a *Agent) runTestType(ctx context.Context, testType string, target testTarget, opts TestOptions) TestResult {
+	switch testType {
+	case TestTypeARP:
+		return testARP(ctx, target, opts)
+	case TestTypeNDP:
+		return testNDP(ctx, target, opts)
+	case TestTypeICMP:
+		return testICMP(ctx, target, opts)
+	case TestTypeUDP:
+		return testUDP(ctx, target, opts)
+	case TestTypeDSCP:
+		return testDSCP(ctx, target, opts)
+	case TestTypeHTTP:
+		return a.testHTTP(ctx, target, opts)
+	case TestTypeClockSkew:
+		return a.testClockSkew(ctx, target, opts)
+	case TestTypeBandwidth:
+		return a.testBandwidth(ctx, target, opts)
+	case TestTypePMTU:
+		return testPMTU(ctx, target, opts)
+	case TestTypeTraceroute:
+		return testTraceroute(ctx, target, opts)
+	}
+
+	result := target.newResult(testType)
+	result.ErrorMessage = fmt.Sprintf("unknown test type %q", testType)
+	return result
+}
+
+// testARP checks link-layer reachability with ARP requests
+func testARP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeARP)
+
+	start := time.Now()
+	stats, err := arpPing(ctx, target.sourceInterface, target.sourceIP, target.ip, opts.count(arpProbeCount), opts.timeout(arpProbeTimeout))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("ARP ping failed: %v", err)
+	} else {
+		result.Success = true
+		// The arping binary fallback does not report per-probe times
+		if stats != nil {
+			result.setProbeStats(stats)
+		}
+	}
+	return result
+}
+
+// testNDP checks link-layer reachability of IPv6 targets with neighbor
+// solicitations
+func testNDP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeNDP)
+
+	start := time.Now()
+	stats, err := ndpPing(ctx, target.sourceInterface, target.sourceIP, target.ip, opts.count(ndpProbeCount), opts.timeout(ndpProbeTimeout))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("NDP probe failed: %v", err)
+	} else {
+		result.Success = true
+		result.setProbeStats(stats)
+	}
+	return result
+}
+
+// testICMP checks IP reachability with ICMP echo, which also covers targets
+// reached through a router
+func testICMP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeICMP)
+
+	stats, err := pingICMP(ctx, target.sourceInterface, target.sourceIP, target.ip, opts.count(icmpProbeCount), opts.timeout(icmpProbeTimeout))
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("ICMP ping failed: %v", err)
+	} else if stats.Received == 0 {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("ICMP ping failed: no replies to %d echo requests", stats.Sent)
+	} else {
+		result.Success = true
+		result.setProbeStats(stats)
+	}
+	return result
+}
+
+// testUDP measures loss and latency as seen by UDP workloads using the
+// target's echo responder
+func testUDP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeUDP)
+
+	stats, err := udpProbe(ctx, target.sourceInterface, target.sourceIP, target.ip, opts.count(udpProbeCount), opts.timeout(udpProbeTimeout))
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("UDP probe failed: %v", err)
+	} else if stats.Received == 0 {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("UDP probe failed: no replies to %d probes", stats.Sent)
+	} else {
+		result.Success = true
+		result.setProbeStats(stats)
+	}
+	return result
+}
+
+// validateHTTPOptions checks the http test's request options
+func validateHTTPOptions(opts TestOptions) error {
+	if opts.Port < 0 || opts.Port > 65535 {
+		return fmt.Errorf("invalid port %d (must be 1-65535)", opts.Port)
+	}
+	if opts.Path != "" && !strings.HasPrefix(opts.Path, "/") {
+		return fmt.Errorf("path %q must start with /", opts.Path)
+	}
+	if opts.Scheme != "" && opts.Scheme != "http" && opts.Scheme != "https" {
+		return fmt.Errorf("scheme %q must be http or https", opts.Scheme)
+	}
+	if opts.ExpectedStatus != 0 && (opts.ExpectedStatus < 100 || opts.ExpectedStatus > 599) {
+		return fmt.Errorf("invalid expected status %d", opts.ExpectedStatus)
+	}
+	return nil
+}
+
+// withHTTPDefaults fills the http test's request options the request left
+// unset from the agent's configured defaults
+func (o TestOptions) withHTTPDefaults(defaults TestOptions) TestOptions {
+	if o.Port == 0 {
+		o.Port = defaults.Port
+	}
+	if o.Path == "" {
+		o.Path = defaults.Path
+	}
+	if o.Scheme == "" {
+		o.Scheme = defaults.Scheme
+	}
+	if o.ExpectedStatus == 0 {
+		o.ExpectedStatus = defaults.ExpectedStatus
+	}
+	if o.TimeoutMS == 0 {
+		o.TimeoutMS = defaults.TimeoutMS
+	}
+	return o
+}
+
+// testHTTP fetches the target agent's system info, or the configured URL on
+// the target, from the matched local address, so the request exercises the
+// link under test
+func (a *Agent) testHTTP(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeHTTP)
+
+	opts = opts.withHTTPDefaults(a.httpTest)
+	scheme := opts.Scheme
+	if scheme == "" {
+		scheme = a.auth.Scheme()
+	}
+	path := opts.Path
+	if path == "" {
+		path = "/api/sysinfo"
+	}
+	expectedStatus := opts.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+
+	client, err := a.newBoundHTTPClient(target.sourceInterface, target.sourceIP, opts.timeout(a.httpClient.Timeout))
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	defer client.CloseIdleConnections()
+
+	var tracer connTracer
+	url := target.urlOnPort(scheme, opts.Port, path)
+	req, err := http.NewRequestWithContext(tracer.context(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	if stats, ok := tracer.stats(); ok {
+		result.setTCPStats(stats)
+	}
+
+	if resp.StatusCode == expectedStatus {
+		result.Success = true
+	} else {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("HTTP status %d, expected %d", resp.StatusCode, expectedStatus)
+	}
+	return result
+}
+
+// testBandwidth streams data to the target's throughput sink
+func (a *Agent) testBandwidth(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeBandwidth)
+
+	start := time.Now()
+	mbps, stats, err := a.measureBandwidth(ctx, target.sourceInterface, target.sourceIP, target.url(a.auth.Scheme(), "/api/throughput"), opts.duration(bandwidthTestDuration), opts.streams(bandwidthStreams))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if stats != nil {
+		result.setTCPStats(*stats)
+	}
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Bandwidth test failed: %v", err)
+	} else {
+		result.Success = true
+		result.ThroughputMbps = mbps
+	}
+	return result
+}
+
+// testPMTU discovers the path MTU, which should match the MTU configured in
+// netplan for the source interface
+func testPMTU(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypePMTU)
+
+	start := time.Now()
+	pathMTU, err := discoverPathMTU(ctx, target.sourceInterface, target.sourceIP, target.ip, target.expectedMTU, opts.timeout(pmtuProbeTimeout))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Path MTU discovery failed: %v", err)
+		return result
+	}
+
+	result.PathMTU = pathMTU
+	if target.expectedMTU > 0 && pathMTU < target.expectedMTU {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Path MTU %d is below configured MTU %d on %s", pathMTU, target.expectedMTU, target.sourceInterface)
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+// testTraceroute records the hops toward the target to show where the path breaks
+func testTraceroute(ctx context.Context, target testTarget, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeTraceroute)
+
+	start := time.Now()
+	hops, reached, err := traceroute(ctx, target.sourceInterface, target.sourceIP, target.ip, opts.timeout(tracerouteTimeout))
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	result.Hops = hops
+
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Traceroute failed: %v", err)
+	} else if !reached {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Target not reached after %d hops", len(hops))
+	} else {
+		result.Success = true
+	}
+	return result
+}
@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"validate/netplan"
+)
+
+const (
+	ntpPort          = "123"
+	ntpQueryTimeout  = 2 * time.Second
+	timeSyncTimeout  = 5 * time.Second
+	defaultMaxOffset = 100 * time.Millisecond
+
+	// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to
+	// the Unix epoch (1970)
+	ntpEpochOffset = 2208988800
+)
+
+// clockStatus is the local clock's synchronization state as reported by
+// chrony or systemd-timesyncd
+type clockStatus struct {
+	source       string // tool the status came from
+	synchronized bool
+	offset       time.Duration // chrony only, positive when the clock is behind NTP time
+	detail       string
+}
+
+// localClockStatus asks chrony, then timedatectl, whether the clock is
+// synchronized. It fails when neither is installed.
+func localClockStatus(ctx context.Context) (clockStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeSyncTimeout)
+	defer cancel()
+
+	if path, err := exec.LookPath("chronyc"); err == nil {
+		out, err := exec.CommandContext(ctx, path, "-c", "tracking").Output()
+		if err == nil {
+			return parseChronyTracking(string(out))
+		}
+	}
+	if path, err := exec.LookPath("timedatectl"); err == nil {
+		out, err := exec.CommandContext(ctx, path, "show", "-p", "NTPSynchronized", "--value").Output()
+		if err != nil {
+			return clockStatus{}, fmt.Errorf("timedatectl failed: %w", err)
+		}
+		synchronized := strings.TrimSpace(string(out)) == "yes"
+		return clockStatus{source: "timedatectl", synchronized: synchronized, detail: "NTPSynchronized=" + strings.TrimSpace(string(out))}, nil
+	}
+	return clockStatus{}, fmt.Errorf("neither chronyc nor timedatectl is available")
+}
+
+// parseChronyTracking parses the CSV output of `chronyc -c tracking`, whose
+// fields are reference ID, name, stratum, reference time, system time offset,
+// last offset, RMS offset, frequency, residual frequency, skew, root delay,
+// root dispersion, update interval and leap status
+func parseChronyTracking(out string) (clockStatus, error) {
+	fields := strings.Split(strings.TrimSpace(out), ",")
+	if len(fields) < 14 {
+		return clockStatus{}, fmt.Errorf("unexpected chronyc tracking output %q", out)
+	}
+
+	stratum, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return clockStatus{}, fmt.Errorf("invalid stratum %q", fields[2])
+	}
+	offset, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return clockStatus{}, fmt.Errorf("invalid system time offset %q", fields[4])
+	}
+	leap := fields[13]
+
+	return clockStatus{
+		source:       "chrony",
+		synchronized: leap != "Not synchronised" && stratum > 0,
+		offset:       -time.Duration(offset * float64(time.Second)), // chrony reports how far the clock is ahead
+		detail:       fmt.Sprintf("reference %s, stratum %d, leap status %s", fields[1], stratum, leap),
+	}, nil
+}
+
+// testClockSync reports whether the local clock is synchronized, and fails
+// if it drifted further than the allowed offset
+func (a *Agent) testClockSync(ctx context.Context, opts TestOptions) TestResult {
+	result := TestResult{
+		TargetHostname: a.hostname,
+		TestType:       TestTypeTimeSync,
+	}
+
+	start := time.Now()
+	status, err := localClockStatus(ctx)
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Failed to read clock synchronization status: %v", err)
+		return result
+	}
+
+	result.ClockOffsetMS = durationMS(status.offset)
+	maxOffset := opts.maxOffset(defaultMaxOffset)
+	switch {
+	case !status.synchronized:
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Clock is not synchronized according to %s (%s)", status.source, status.detail)
+	case absDuration(status.offset) > maxOffset:
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Clock is %v off according to %s, more than %v (%s)", status.offset, status.source, maxOffset, status.detail)
+	default:
+		result.Success = true
+	}
+	return result
+}
+
+// testNTPOffset measures the clock offset against an NTP server from an
+// uplink, so skew shows up per path even when the local daemon syncs
+// against a different server
+func (a *Agent) testNTPOffset(ctx context.Context, ntp externalTarget, links map[string][]netplan.IPWithMask, opts TestOptions) TestResult {
+	target := testTarget{
+		hostname:        ntp.endpoint,
+		sourceInterface: ntp.iface,
+	}
+	if net.ParseIP(ntp.endpoint) != nil {
+		target.ip = ntp.endpoint
+	}
+	target.bondName, target.sourceIP = interfaceSource(links, ntp.iface, ntp.ipv6)
+
+	result := target.newResult(TestTypeTimeSync)
+	if target.sourceIP == "" {
+		result.ErrorMessage = fmt.Sprintf("Interface %s has no address to query %s from", ntp.iface, ntp.endpoint)
+		return result
+	}
+
+	offset, rtt, serverIP, err := queryNTP(ctx, ntp.iface, target.sourceIP, ntp.endpoint, opts.timeout(ntpQueryTimeout))
+	result.TargetIP = serverIP
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("NTP query failed: %v", err)
+		return result
+	}
+
+	result.ResponseTimeMS = rtt.Milliseconds()
+	result.RTTAvgMS = durationMS(rtt)
+	result.ClockOffsetMS = durationMS(offset)
+	if maxOffset := opts.maxOffset(defaultMaxOffset); absDuration(offset) > maxOffset {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Clock is %v off from %s, more than %v", offset, ntp.endpoint, maxOffset)
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+// queryNTP sends an SNTP request to server from sourceIP on sourceInterface
+// and returns the local clock's offset from the server, the round trip time
+// and the server address that answered
+func queryNTP(ctx context.Context, sourceInterface, sourceIP, server string, timeout time.Duration) (time.Duration, time.Duration, string, error) {
+	dialer := &net.Dialer{
+		LocalAddr: &net.UDPAddr{IP: net.ParseIP(sourceIP)},
+		Timeout:   timeout,
+		Control:   deviceControl(sourceInterface),
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(server, ntpPort))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer conn.Close()
+	serverIP := conn.RemoteAddr().(*net.UDPAddr).IP.String()
+
+	// LI 0, version 4, mode 3 (client), with the transmit time to match the
+	// reply against
+	request := make([]byte, 48)
+	request[0] = 0<<6 | 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], ntpTimestamp(sent))
+
+	conn.SetDeadline(sent.Add(timeout))
+	if _, err := conn.Write(request); err != nil {
+		return 0, 0, serverIP, err
+	}
+
+	reply := make([]byte, 48)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return 0, 0, serverIP, err
+		}
+		received := time.Now()
+		if n < 48 || reply[0]&0x7 != 4 || binary.BigEndian.Uint64(reply[24:]) != binary.BigEndian.Uint64(request[40:]) {
+			// Not a server reply to this request
+			continue
+		}
+		if reply[1] == 0 {
+			return 0, 0, serverIP, fmt.Errorf("server sent kiss-o'-death %q", reply[12:16])
+		}
+
+		serverReceived := ntpTime(binary.BigEndian.Uint64(reply[32:]))
+		serverSent := ntpTime(binary.BigEndian.Uint64(reply[40:]))
+		offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+		rtt := received.Sub(sent) - serverSent.Sub(serverReceived)
+		return offset, rtt, serverIP, nil
+	}
+}
+
+// ntpTimestamp converts t to the 64-bit NTP timestamp format
+func ntpTimestamp(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// ntpTime converts a 64-bit NTP timestamp to a time
+func ntpTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & math.MaxUint32) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
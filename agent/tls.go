@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const tlsHandshakeTimeout = 5 * time.Second
+
+// testTLS performs a TLS handshake with a port on the target from the
+// matched local address, and reports the handshake latency, the negotiated
+// version and when the certificate expires. The certificate chain is not
+// verified, since intra-cluster endpoints commonly use a private CA, but
+// expired and not yet valid certificates fail the test.
+func testTLS(ctx context.Context, target testTarget, port int, opts TestOptions) TestResult {
+	result := target.newResult(TestTypeTLS)
+	result.Port = port
+
+	timeout := opts.timeout(tlsHandshakeTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := boundDialer(target.sourceInterface, net.ParseIP(target.sourceIP))
+	rawConn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.ip, strconv.Itoa(port)))
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Failed to connect to port %d: %v", port, err)
+		return result
+	}
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:         target.hostname,
+		InsecureSkipVerify: true,
+	})
+	start := time.Now()
+	err = conn.HandshakeContext(ctx)
+	result.ResponseTimeMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("TLS handshake on port %d failed: %v", port, err)
+		return result
+	}
+
+	if stats, ok := connTCPStats(rawConn); ok {
+		result.setTCPStats(stats)
+	}
+
+	state := conn.ConnectionState()
+	result.TLSVersion = tls.VersionName(state.Version)
+	if len(state.PeerCertificates) == 0 {
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Port %d presented no certificate", port)
+		return result
+	}
+
+	cert := state.PeerCertificates[0]
+	notAfter := cert.NotAfter.UTC()
+	result.CertNotAfter = &notAfter
+	now := time.Now()
+	switch {
+	case now.After(cert.NotAfter):
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Certificate on port %d expired on %s", port, notAfter.Format(time.DateOnly))
+	case now.Before(cert.NotBefore):
+		result.Success = false
+		result.ErrorMessage = fmt.Sprintf("Certificate on port %d is not valid before %s", port, cert.NotBefore.UTC().Format(time.DateOnly))
+	default:
+		result.Success = true
+	}
+	return result
+}
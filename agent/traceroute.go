@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	tracerouteMaxHops  = 30
+	tracerouteTimeout  = 1 * time.Second
+	tracerouteMaxQuiet = 3 // consecutive silent hops before giving up
+
+	icmpv4DestUnreachable = 3
+	icmpv4TimeExceeded    = 11
+	icmpv6DestUnreachable = 1
+	icmpv6TimeExceeded    = 3
+)
+
+// TracerouteHop is a single hop on the path to a target
+type TracerouteHop struct {
+	TTL   int     `json:"ttl"`
+	IP    string  `json:"ip,omitempty"` // empty if the hop did not answer
+	RTTMS float64 `json:"rtt_ms,omitempty"`
+}
+
+// traceroute sends ICMP echo requests from sourceIP to targetIP with
+// increasing TTLs, waiting up to timeout for each hop, and returns the hops
+// that answered along the way. It stops when the target answers, a router
+// reports it unreachable, or several hops in a row stay silent. It reports
+// whether the target was reached.
+func traceroute(ctx context.Context, sourceInterface, sourceIP, targetIP string, timeout time.Duration) ([]TracerouteHop, bool, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil {
+		return nil, false, fmt.Errorf("invalid source or target IP %q -> %q", sourceIP, targetIP)
+	}
+
+	ipv6 := target.To4() == nil
+	conn, err := openRawICMPConn(source, sourceInterface, ipv6)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	dst := conn.destination(target)
+	id := os.Getpid() & 0xffff
+	var hops []TracerouteHop
+	quiet := 0
+
+	for ttl := 1; ttl <= tracerouteMaxHops; ttl++ {
+		if err := ctx.Err(); err != nil {
+			return hops, false, err
+		}
+		if err := setTTL(conn, ttl); err != nil {
+			return hops, false, fmt.Errorf("failed to set TTL: %w", err)
+		}
+
+		start := time.Now()
+		if _, err := conn.WriteTo(conn.echoRequest(id, ttl, icmpEchoSize), dst); err != nil {
+			return hops, false, fmt.Errorf("failed to send ICMP echo request: %w", err)
+		}
+
+		hop := TracerouteHop{TTL: ttl}
+		from, kind := conn.waitForHop(target, id, ttl, probeDeadline(ctx, start, timeout))
+		if from != nil {
+			hop.IP = from.String()
+			hop.RTTMS = durationMS(time.Since(start))
+		}
+		hops = append(hops, hop)
+
+		switch kind {
+		case hopReached:
+			return hops, true, nil
+		case hopUnreachable:
+			return hops, false, nil
+		case hopSilent:
+			quiet++
+			if quiet >= tracerouteMaxQuiet {
+				return hops, false, nil
+			}
+		default:
+			quiet = 0
+		}
+	}
+
+	return hops, false, nil
+}
+
+// hopKind classifies the answer to a traceroute probe
+type hopKind int
+
+const (
+	hopSilent hopKind = iota
+	hopTransit
+	hopReached
+	hopUnreachable
+)
+
+// waitForHop waits for the answer to the probe with the given sequence
+// number: an echo reply from the target or an ICMP error quoting the probe
+func (c *icmpConn) waitForHop(target net.IP, id, seq int, deadline time.Time) (net.IP, hopKind) {
+	echoReply, timeExceeded, unreachable := byte(icmpv4EchoReply), byte(icmpv4TimeExceeded), byte(icmpv4DestUnreachable)
+	innerHeaderLen := func(msg []byte) int { return int(msg[8]&0x0f) * 4 }
+	if c.ipv6 {
+		echoReply, timeExceeded, unreachable = icmpv6EchoReply, icmpv6TimeExceeded, icmpv6DestUnreachable
+		innerHeaderLen = func([]byte) int { return ipv6HeaderLen }
+	}
+
+	c.SetReadDeadline(deadline)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return nil, hopSilent
+		}
+		msg := buf[:n]
+		if n < 8 {
+			continue
+		}
+
+		switch msg[0] {
+		case echoReply:
+			if addrIP(from).Equal(target) && matchesProbe(msg, id, seq) {
+				return addrIP(from), hopReached
+			}
+		case timeExceeded, unreachable:
+			// The error quotes the IP header and first 8 bytes of the probe
+			if n < 9 {
+				continue
+			}
+			offset := 8 + innerHeaderLen(msg)
+			if n < offset+8 || !matchesProbe(msg[offset:], id, seq) {
+				continue
+			}
+			if msg[0] == unreachable {
+				return addrIP(from), hopUnreachable
+			}
+			return addrIP(from), hopTransit
+		}
+	}
+}
+
+// matchesProbe reports whether an ICMP echo header carries the probe's id and seq
+func matchesProbe(header []byte, id, seq int) bool {
+	return int(binary.BigEndian.Uint16(header[4:])) == id && int(binary.BigEndian.Uint16(header[6:])) == seq
+}
@@ -0,0 +1,28 @@
+package agent
+
+import "syscall"
+
+// setTTL sets the TTL (hop limit for IPv6) of packets sent through conn
+func setTTL(conn *icmpConn, ttl int) error {
+	sc, ok := conn.PacketConn.(syscall.Conn)
+	if !ok {
+		return syscall.EINVAL
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if conn.ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setTTL is only implemented on Linux
+func setTTL(conn *icmpConn, ttl int) error {
+	return fmt.Errorf("setting the TTL of ICMP probes is not supported on %s", runtime.GOOS)
+}
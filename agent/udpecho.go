@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// UDPEchoPort is the port on which every agent answers UDP echo probes
+const UDPEchoPort = 8081
+
+const (
+	udpProbeCount   = 10
+	udpProbeTimeout = 500 * time.Millisecond
+)
+
+// udpProbeMagic prefixes probe payloads so stray datagrams are not echoed
+var udpProbeMagic = []byte("NVUDPECHO")
+
+// StartUDPEcho answers UDP echo probes on addr until stopChan is closed.
+// DSCP probes are answered with the TOS byte they arrived with appended.
+func StartUDPEcho(addr string, stopChan <-chan struct{}) error {
+	return StartUDPEchoInNamespace("", addr, stopChan)
+}
+
+// StartUDPEchoInNamespace answers UDP echo probes like StartUDPEcho, on addr
+// in the named network namespace
+func StartUDPEchoInNamespace(namespace, addr string, stopChan <-chan struct{}) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid UDP echo address %s: %w", addr, err)
+	}
+	var conn *net.UDPConn
+	err = inNamespace(namespace, func() error {
+		var err error
+		conn, err = net.ListenUDP("udp", udpAddr)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to listen for UDP echo on %s: %w", addr, err)
+	}
+	if err := enableTOSReporting(conn); err != nil {
+		slog.Warn("DSCP probes will not be answered", "netns", namespace, "error", err)
+	}
+
+	go func() {
+		<-stopChan
+		conn.Close()
+	}()
+
+	slog.Info("UDP echo responder listening", "addr", conn.LocalAddr().String(), "netns", namespace)
+
+	buf := make([]byte, 1500)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, from, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			select {
+			case <-stopChan:
+				slog.Info("Stopping UDP echo responder")
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return fmt.Errorf("UDP echo read failed: %w", err)
+		}
+		switch {
+		case bytes.HasPrefix(buf[:n], udpProbeMagic):
+			conn.WriteTo(buf[:n], from)
+		case bytes.HasPrefix(buf[:n], dscpProbeMagic):
+			if tos, ok := receivedTOS(oob[:oobn]); ok {
+				conn.WriteTo(append(buf[:n], tos), from)
+			}
+		}
+	}
+}
+
+// udpProbe sends count UDP probes from sourceIP on sourceInterface to the
+// echo responder on targetIP, one at a time, and returns the loss and round
+// trip statistics
+func udpProbe(ctx context.Context, sourceInterface, sourceIP, targetIP string, count int, timeout time.Duration) (*pingStats, error) {
+	source := net.ParseIP(sourceIP)
+	target := net.ParseIP(targetIP)
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("invalid source or target IP %q -> %q", sourceIP, targetIP)
+	}
+
+	dialer := net.Dialer{
+		LocalAddr: &net.UDPAddr{IP: source},
+		Control:   deviceControl(sourceInterface),
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(target.String(), strconv.Itoa(UDPEchoPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	stats := &pingStats{}
+	probe := make([]byte, len(udpProbeMagic)+8)
+	copy(probe, udpProbeMagic)
+	buf := make([]byte, 1500)
+
+	for seq := 1; seq <= count; seq++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(probe[len(udpProbeMagic):], uint64(seq))
+
+		stats.Sent++
+		start := time.Now()
+		if _, err := conn.Write(probe); err != nil {
+			// An ICMP port unreachable from a previous probe is reported
+			// on the next write; count it as a lost probe
+			continue
+		}
+
+		conn.SetReadDeadline(probeDeadline(ctx, start, timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if n != len(probe) || !bytes.Equal(buf[:n], probe) {
+				continue
+			}
+			stats.record(time.Since(start))
+			break
+		}
+	}
+
+	return stats, nil
+}
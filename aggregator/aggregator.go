@@ -2,22 +2,55 @@ package aggregator
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"validate/agent"
+	"validate/auth"
 	"validate/database"
 	"validate/sysinfo"
+	"validate/systemd"
 )
 
+// drainTimeout is how long Stop waits for requests in flight to finish
+const drainTimeout = 30 * time.Second
+
 // Aggregator represents an aggregator server
 type Aggregator struct {
-	port   int
-	db     *database.DB
-	server *http.Server
+	port              int
+	db                *database.DB
+	auth              *auth.Auth
+	server            *http.Server
+	externalEndpoints []string
+	ntpServer         string
+	expectedPorts     map[string][]int // hostname glob -> ports
+	tlsPorts          map[string][]int // hostname glob -> ports
+	events            eventBroker
+	alerts            *alerter      // nil without alert webhooks
+	staleAfter        time.Duration // without registration or heartbeat, 0 derives it from each agent's register interval
+	retention         time.Duration // test results kept, 0 forever
+	maxResults        int           // test results kept at most, 0 unlimited
+	stop              chan struct{} // closed by Shutdown
+	stopOnce          sync.Once
+
+	resultSubmissions atomic.Int64 // since the aggregator started
+	resultsReceived   atomic.Int64
 }
 
 // NewAggregator creates a new aggregator server
@@ -27,12 +60,92 @@ func NewAggregator(port int, dbPath string) (*Aggregator, error) {
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
 
+	// Runs still in progress when the aggregator stopped are no longer followed
+	if err := db.FailRunningRuns("aggregator restarted"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &Aggregator{
 		port: port,
 		db:   db,
+		auth: &auth.Auth{},
+		stop: make(chan struct{}),
 	}, nil
 }
 
+// SetAuth sets the credentials required from agents and presented to them
+func (a *Aggregator) SetAuth(au *auth.Auth) {
+	a.auth = au
+}
+
+// SetExternalEndpoints sets the endpoints outside the cluster that agents
+// check from their uplinks, unless a run selects its own
+func (a *Aggregator) SetExternalEndpoints(endpoints []string) error {
+	if err := (agent.TestRequest{ExternalEndpoints: endpoints}).Validate(); err != nil {
+		return err
+	}
+	a.externalEndpoints = endpoints
+	return nil
+}
+
+// SetNTPServer sets the NTP server agents measure their clock offset
+// against, unless a run selects its own
+func (a *Aggregator) SetNTPServer(server string) {
+	a.ntpServer = server
+}
+
+// SetExpectedPorts sets the TCP ports agents check are open on the servers
+// whose hostname matches each glob pattern
+func (a *Aggregator) SetExpectedPorts(patterns map[string][]int) error {
+	if err := validatePortPatterns("expected_ports", patterns); err != nil {
+		return err
+	}
+	a.expectedPorts = patterns
+	return nil
+}
+
+// SetTLSPorts sets the TCP ports agents perform a TLS handshake with on the
+// servers whose hostname matches each glob pattern
+func (a *Aggregator) SetTLSPorts(patterns map[string][]int) error {
+	if err := validatePortPatterns("tls_ports", patterns); err != nil {
+		return err
+	}
+	a.tlsPorts = patterns
+	return nil
+}
+
+// validatePortPatterns checks the glob patterns and ports of the named
+// setting
+func validatePortPatterns(name string, patterns map[string][]int) error {
+	for pattern, ports := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid %s pattern %q: %w", name, pattern, err)
+		}
+		if err := (agent.TestRequest{Targets: map[string]agent.TargetInfo{pattern: {ExpectedPorts: ports}}}).Validate(); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// matchingPorts returns the ports of every pattern the hostname matches
+func matchingPorts(patterns map[string][]int, hostname string) []int {
+	var ports []int
+	for pattern, patternPorts := range patterns {
+		if matched, _ := path.Match(pattern, hostname); !matched {
+			continue
+		}
+		for _, port := range patternPorts {
+			if !slices.Contains(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	slices.Sort(ports)
+	return ports
+}
+
 // Start starts the aggregator server
 func (a *Aggregator) Start() error {
 	mux := http.NewServeMux()
@@ -45,11 +158,26 @@ func (a *Aggregator) Start() error {
 	mux.HandleFunc("GET /api/health", a.handleHealth)
 
 	// Aggregator-specific endpoints
-	mux.HandleFunc("POST /api/server", a.handleServerRegistration)
+	mux.HandleFunc("POST /api/server", a.auth.Require(a.handleServerRegistration))
+	mux.HandleFunc("DELETE /api/server/{hostname}", a.auth.RequireOperatorOrSelf(a.fromServer, a.handleServerDeregistration))
+	mux.HandleFunc("POST /api/heartbeat", a.auth.Require(a.handleHeartbeat))
 	mux.HandleFunc("GET /api/servers", a.handleGetServers)
-	mux.HandleFunc("POST /api/test-results", a.handleTestResults)
+	mux.HandleFunc("GET /api/servers/{hostname}/sysinfo", a.handleServerSysinfo)
+	mux.HandleFunc("POST /api/test-results", a.auth.Require(a.handleTestResults))
 	mux.HandleFunc("GET /api/test-results", a.handleGetTestResults)
-	mux.HandleFunc("POST /api/run-tests", a.handleRunTests)
+	mux.HandleFunc("GET /api/test-results.csv", a.handleTestResultsCSV)
+	mux.HandleFunc("GET /api/test-results.ndjson", a.handleTestResultsNDJSON)
+	mux.HandleFunc("GET /api/matrix.csv", a.handleMatrixCSV)
+	mux.HandleFunc("POST /api/run-tests", a.auth.RequireOperator(a.handleRunTests))
+	mux.HandleFunc("POST /api/runs", a.auth.RequireOperator(a.handleRunTests))
+	mux.HandleFunc("GET /api/runs", a.handleGetRuns)
+	mux.HandleFunc("GET /api/runs/{id}", a.handleGetRun)
+	mux.HandleFunc("GET /api/runs/{a}/compare/{b}", a.handleCompareRuns)
+	mux.HandleFunc("POST /api/cancel-tests", a.auth.RequireOperator(a.handleCancelTests))
+	mux.HandleFunc("GET /api/events", a.handleEvents)
+	mux.HandleFunc("GET /api/topology", a.handleTopology)
+	mux.HandleFunc("GET /api/trends", a.handleTrends)
+	mux.HandleFunc("GET /metrics", a.handleMetrics)
 
 	a.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", a.port),
@@ -57,26 +185,71 @@ func (a *Aggregator) Start() error {
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		TLSConfig:    a.auth.ServerTLSConfig(),
 	}
 
-	log.Printf("Starting aggregator server on port %d", a.port)
+	log.Printf("Starting aggregator server on port %d (%s)", a.port, strings.ToUpper(a.auth.Scheme()))
 	log.Printf("Available endpoints:")
 	log.Printf("  GET / - HTML dashboard")
 	log.Printf("  GET /api/sysinfo - System information")
 	log.Printf("  GET /api/health - Health check")
 	log.Printf("  POST /api/server - Server registration")
+	log.Printf("  DELETE /api/server/{hostname} - Server deregistration")
+	log.Printf("  POST /api/heartbeat - Keep a server registration alive")
 	log.Printf("  GET /api/servers - List registered servers")
+	log.Printf("  GET /api/servers/{hostname}/sysinfo - Live system information of a server, fetched from its agent")
 	log.Printf("  POST /api/test-results - Submit test results")
 	log.Printf("  GET /api/test-results - Get test results")
+	log.Printf("  GET /api/test-results.csv - Export test results as CSV")
+	log.Printf("  GET /api/test-results.ndjson - Stream test results as newline delimited JSON")
+	log.Printf("  GET /api/matrix.csv - Export a matrix of tests passed per pair of hosts as CSV")
 	log.Printf("  POST /api/run-tests - Trigger connectivity tests")
+	log.Printf("  POST /api/runs - Start a test run (same as POST /api/run-tests)")
+	log.Printf("  GET /api/runs - List test runs")
+	log.Printf("  GET /api/runs/{id} - Get a test run")
+	log.Printf("  GET /api/runs/{a}/compare/{b} - Tests whose outcome changed from run a to run b")
+	log.Printf("  POST /api/cancel-tests - Cancel running connectivity tests")
+	log.Printf("  GET /api/events - Stream of registrations, test results and runs (server-sent events)")
+	log.Printf("  GET /api/topology - Graph of hosts, subnets and tested links (JSON or DOT)")
+	log.Printf("  GET /api/trends - Success rates and latencies over time")
+	log.Printf("  GET /metrics - Prometheus metrics")
+
+	ln, err := net.Listen("tcp", a.server.Addr)
+	if err != nil {
+		return err
+	}
+	if a.alerts != nil {
+		go a.watchStaleAgents(a.stop)
+	}
+	if a.retention > 0 || a.maxResults > 0 {
+		go a.watchRetention(a.stop)
+	}
+	if err := systemd.Ready(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 
-	return a.server.ListenAndServe()
+	return a.auth.Serve(a.server, ln)
 }
 
-// Stop stops the aggregator server
+// Stop stops the aggregator server gracefully, giving requests in flight,
+// such as result submissions, drainTimeout to finish
 func (a *Aggregator) Stop() error {
-	if a.server != nil {
-		return a.server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	return a.Shutdown(ctx)
+}
+
+// Shutdown stops accepting connections and new runs, and waits for requests
+// in flight to finish until ctx is done, when the remaining connections are
+// closed
+func (a *Aggregator) Shutdown(ctx context.Context) error {
+	a.stopOnce.Do(func() { close(a.stop) })
+	if a.server == nil {
+		return nil
+	}
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.server.Close()
+		return fmt.Errorf("failed to drain requests: %w", err)
 	}
 	return nil
 }
@@ -103,6 +276,7 @@ func (a *Aggregator) corsMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -132,14 +306,44 @@ func (a *Aggregator) handleServerRegistration(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if payload.RegisterInterval < 0 {
+		http.Error(w, "register_interval must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if payload.AgentURL != "" {
+		u, err := parseAgentURL(payload.AgentURL)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid agent_url: %v", err), http.StatusBadRequest)
+			return
+		}
+		// Tests are triggered with the aggregator's credentials, which only
+		// work over the matching scheme
+		if u.Scheme != a.auth.Scheme() {
+			log.Printf("Warning: %s advertises %s, but the aggregator talks %s to agents", payload.Hostname, payload.AgentURL, strings.ToUpper(a.auth.Scheme()))
+		}
+	}
+
+	// Agents that predate capability reporting can run any test
+	var capabilities interface{}
+	if payload.Capabilities != nil {
+		capabilities = payload.Capabilities
+	}
+
+	var lldp interface{}
+	if payload.LLDPNeighbors != nil {
+		lldp = payload.LLDPNeighbors
+	}
+
 	// Register the server in the database
-	if err := a.db.RegisterServer(payload.Hostname, payload.IPAddress, payload.SystemInfo, payload.Bonds); err != nil {
+	if err := a.db.RegisterServer(payload.Hostname, payload.IPAddress, payload.AgentURL, payload.SystemInfo, payload.Bonds, payload.Links, payload.Version, payload.RegisterInterval, capabilities, lldp); err != nil {
 		log.Printf("Failed to register server %s: %v", payload.Hostname, err)
 		http.Error(w, fmt.Sprintf("Failed to register server: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Server registered: %s (%s) with bonds: %v", payload.Hostname, payload.IPAddress, payload.Bonds)
+	log.Printf("Server registered: %s (%s, version %q) with bonds: %v, links: %v", payload.Hostname, payload.IPAddress, payload.Version, payload.Bonds, payload.Links)
+	a.events.publish(eventServer, serverEvent{Hostname: payload.Hostname, Action: "registered"})
 
 	response := map[string]interface{}{
 		"status":  "success",
@@ -151,6 +355,115 @@ func (a *Aggregator) handleServerRegistration(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(response)
 }
 
+// Handler for heartbeats keeping registrations alive between full registrations
+func (a *Aggregator) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var payload agent.HeartbeatPayload
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if payload.Hostname == "" {
+		http.Error(w, "hostname is required", http.StatusBadRequest)
+		return
+	}
+
+	registered, err := a.db.TouchServer(payload.Hostname)
+	if err != nil {
+		log.Printf("Failed to record heartbeat of %s: %v", payload.Hostname, err)
+		http.Error(w, fmt.Sprintf("Failed to record heartbeat: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !registered {
+		// The agent registers in full when it gets this
+		http.Error(w, fmt.Sprintf("Server %s is not registered", payload.Hostname), http.StatusNotFound)
+		return
+	}
+	a.events.publish(eventServer, serverEvent{Hostname: payload.Hostname, Action: "heartbeat"})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success"})
+}
+
+// Handler for agents deregistering when they shut down, and for operators
+// removing decommissioned hosts
+func (a *Aggregator) handleServerDeregistration(w http.ResponseWriter, r *http.Request) {
+	hostname := r.PathValue("hostname")
+
+	deleted, err := a.db.DeleteServer(hostname)
+	if err != nil {
+		log.Printf("Failed to deregister server %s: %v", hostname, err)
+		http.Error(w, fmt.Sprintf("Failed to deregister server: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, fmt.Sprintf("Server %s is not registered", hostname), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Server deregistered: %s", hostname)
+	a.events.publish(eventServer, serverEvent{Hostname: hostname, Action: "deregistered"})
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Server %s deregistered successfully", hostname),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// fromServer reports whether a request about the server {hostname} comes
+// from that server: its client certificate names the host or one of its
+// addresses or, without one, it comes from one of its addresses. Requests
+// about servers that are not registered pass, as there is nothing to remove.
+func (a *Aggregator) fromServer(r *http.Request) bool {
+	hostname := r.PathValue("hostname")
+	server, err := a.db.GetServer(hostname)
+	if err != nil {
+		log.Printf("Failed to get server %s: %v", hostname, err)
+		return false
+	}
+	if server == nil {
+		return true
+	}
+
+	if names := auth.PeerNames(r); names != nil {
+		return slices.ContainsFunc(names, func(name string) bool {
+			return strings.EqualFold(name, hostname) || serverHasIP(*server, name)
+		})
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return err == nil && serverHasIP(*server, host)
+}
+
+// serverHasIP reports whether ip is the address a server registered with or
+// an address of one of its links. A multi-homed agent's requests may leave
+// through any of them.
+func serverHasIP(server database.ServerRegistration, ip string) bool {
+	if sameIP(ip, server.IPAddress) {
+		return true
+	}
+	links, err := serverLinks(server)
+	if err != nil {
+		return false
+	}
+	for _, addrs := range links {
+		if slices.ContainsFunc(addrs, func(addr string) bool { return sameIP(ip, addr) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameIP reports whether a and b are the same IP address
+func sameIP(a, b string) bool {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	return errA == nil && errB == nil && ipA.Unmap().WithZone("") == ipB.Unmap().WithZone("")
+}
+
 // Handler to get all registered servers
 func (a *Aggregator) handleGetServers(w http.ResponseWriter, r *http.Request) {
 	servers, err := a.db.GetAllServers()
@@ -158,6 +471,7 @@ func (a *Aggregator) handleGetServers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to get servers: %v", err), http.StatusInternalServerError)
 		return
 	}
+	a.setServerStatuses(servers)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(servers)
@@ -172,25 +486,103 @@ func (a *Aggregator) handleTestResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.resultSubmissions.Add(1)
+	a.resultsReceived.Add(int64(len(payload.Results)))
+
 	// Save each test result to the database
+	saved := []database.TestResult{}
+	turnedRed := []PairStatus{}
 	for _, result := range payload.Results {
+		var hops string
+		if len(result.Hops) > 0 {
+			hopsJSON, err := json.Marshal(result.Hops)
+			if err != nil {
+				log.Printf("Failed to marshal traceroute hops: %v", err)
+			} else {
+				hops = string(hopsJSON)
+			}
+		}
+
+		runID := result.RunID
+		if runID == "" {
+			runID = payload.RunID
+		}
+
+		// Results measured by another agent, such as the reverse direction
+		// of a bidirectional test, name their source
+		sourceHostname := payload.SourceHostname
+		if result.SourceHostname != "" {
+			sourceHostname = result.SourceHostname
+		}
+
 		dbResult := database.TestResult{
-			SourceHostname: payload.SourceHostname,
-			TargetHostname: result.TargetHostname,
-			TargetIP:       result.TargetIP,
-			SourceIP:       result.SourceIP,
-			BondName:       result.BondName,
-			TestType:       result.TestType,
-			Success:        result.Success,
-			ResponseTime:   result.ResponseTimeMS,
-			ErrorMessage:   result.ErrorMessage,
-			TestedAt:       payload.TestedAt,
+			RunID:           runID,
+			CorrelationID:   result.CorrelationID,
+			SourceHostname:  sourceHostname,
+			TargetHostname:  result.TargetHostname,
+			TargetIP:        result.TargetIP,
+			SourceIP:        result.SourceIP,
+			BondName:        result.BondName,
+			SourceInterface: result.SourceInterface,
+			TestType:        result.TestType,
+			Port:            result.Port,
+			Success:         result.Success,
+			ResponseTime:    result.ResponseTimeMS,
+			RTTMinMS:        result.RTTMinMS,
+			RTTAvgMS:        result.RTTAvgMS,
+			RTTMaxMS:        result.RTTMaxMS,
+			PacketLoss:      result.PacketLoss,
+			P50MS:           result.P50MS,
+			P95MS:           result.P95MS,
+			P99MS:           result.P99MS,
+			ThroughputMbps:  result.ThroughputMbps,
+			PathMTU:         result.PathMTU,
+			Hops:            hops,
+			SlavesUp:        result.SlavesUp,
+			SlavesTotal:     result.SlavesTotal,
+			ClockOffsetMS:   result.ClockOffsetMS,
+			TLSVersion:      result.TLSVersion,
+			CertNotAfter:    result.CertNotAfter,
+			TCPRetransmits:  result.TCPRetransmits,
+			TCPRTTMS:        result.TCPRTTMS,
+			TCPCwnd:         result.TCPCwnd,
+			ErrorMessage:    result.ErrorMessage,
+			TestedAt:        result.TestedAt,
+		}
+		// Agents predating per-result times only timed the submission
+		if dbResult.TestedAt.IsZero() {
+			dbResult.TestedAt = payload.TestedAt
+		}
+
+		// Alert about tests that passed last time and fail now
+		if a.alerts != nil && !dbResult.Success {
+			passed, found, err := a.db.LastOutcome(dbResult)
+			if err != nil {
+				log.Printf("Failed to check previous result: %v", err)
+			} else if found && passed {
+				turnedRed = append(turnedRed, PairStatus{
+					SourceHostname: dbResult.SourceHostname,
+					TargetHostname: dbResult.TargetHostname,
+					TargetIP:       dbResult.TargetIP,
+					BondName:       dbResult.BondName,
+					TestType:       dbResult.TestType,
+					Port:           dbResult.Port,
+					ErrorMessage:   dbResult.ErrorMessage,
+				})
+			}
 		}
 
 		if err := a.db.SaveTestResult(dbResult); err != nil {
 			log.Printf("Failed to save test result: %v", err)
 			continue
 		}
+		saved = append(saved, dbResult)
+	}
+	if len(saved) > 0 {
+		a.events.publish(eventTestResults, saved)
+	}
+	if len(turnedRed) > 0 {
+		a.alertPairsFailed(payload.RunID, turnedRed)
 	}
 
 	log.Printf("Received %d test results from %s", len(payload.Results), payload.SourceHostname)
@@ -206,75 +598,257 @@ func (a *Aggregator) handleTestResults(w http.ResponseWriter, r *http.Request) {
 
 // Handler to get test results
 func (a *Aggregator) handleGetTestResults(w http.ResponseWriter, r *http.Request) {
-	// Get limit from query parameter, default to 0 (unlimited)
-	limit := 0
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		fmt.Sscanf(limitStr, "%d", &limit)
+	filter, err := testResultFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	// Get source hostname filter if provided
-	source := r.URL.Query().Get("source")
-
-	var results []database.TestResult
-	var err error
-
-	if source != "" {
-		results, err = a.db.GetTestResultsBySource(source, limit)
-	} else {
-		results, err = a.db.GetTestResults(limit)
+	found, err := a.resolveLatestRun(&filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", "0")
+		json.NewEncoder(w).Encode([]database.TestResult{})
+		return
 	}
 
+	total, err := a.db.CountTestResults(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+		return
+	}
+	results, err := a.db.FindTestResults(filter)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if results == nil {
+		results = []database.TestResult{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	json.NewEncoder(w).Encode(results)
 }
 
+// resolveLatestRun replaces the run ID "latest" of a filter with that of
+// the most recently triggered run. It reports false when there is none.
+func (a *Aggregator) resolveLatestRun(filter *database.TestResultFilter) (bool, error) {
+	if filter.RunID != "latest" {
+		return true, nil
+	}
+	runID, err := a.db.LatestRunID()
+	if err != nil {
+		return false, err
+	}
+	filter.RunID = runID
+	return runID != "", nil
+}
+
+// testResultFilter parses the query parameters selecting test results:
+// source, target, bond, test_type, success, run_id, since, until, limit,
+// offset, sort and order (asc or desc)
+func testResultFilter(query url.Values) (database.TestResultFilter, error) {
+	filter := database.TestResultFilter{
+		SourceHostname: query.Get("source"),
+		TargetHostname: query.Get("target"),
+		BondName:       query.Get("bond"),
+		TestType:       query.Get("test_type"),
+		RunID:          query.Get("run_id"),
+		Sort:           query.Get("sort"),
+	}
+
+	var err error
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("success must be 'true' or 'false'")
+		}
+		filter.Success = &success
+	}
+	if filter.Since, err = timeParam(query, "since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = timeParam(query, "until"); err != nil {
+		return filter, err
+	}
+	if filter.Limit, err = nonNegativeParam(query, "limit"); err != nil {
+		return filter, err
+	}
+	if filter.Offset, err = nonNegativeParam(query, "offset"); err != nil {
+		return filter, err
+	}
+	if filter.Sort != "" && !slices.Contains(database.TestResultSortColumns, filter.Sort) {
+		return filter, fmt.Errorf("sort must be one of: %v", database.TestResultSortColumns)
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("order must be 'asc' or 'desc'")
+	}
+	return filter, nil
+}
+
+// timeParam parses an optional time query parameter, either an RFC 3339
+// time or a duration before now such as "1h"
+func timeParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a duration such as 1h", name)
+}
+
+// nonNegativeParam parses an optional non-negative integer query parameter
+func nonNegativeParam(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
 // Handler to trigger connectivity tests
 func (a *Aggregator) handleRunTests(w http.ResponseWriter, r *http.Request) {
-	// Trigger connectivity tests on all registered agents...
-	log.Println("Triggering connectivity tests on all agents...")
+	// Runs started while shutting down could not be followed
+	select {
+	case <-a.stop:
+		http.Error(w, "Aggregator is shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
 
-	// Clear existing test results before running new tests
-	if err := a.db.ClearTestResults(); err != nil {
-		log.Printf("Warning: Failed to clear test results: %v", err)
-		// Continue anyway - don't fail the request
-	} else {
-		log.Println("Cleared all previous test results")
+	// The body may select test types, their options, external endpoints, the
+	// NTP server and the agents, links and subnets taking part
+	var selection RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&selection); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := selection.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid test request: %v", err), http.StatusBadRequest)
+		return
 	}
 
+	externalEndpoints := selection.ExternalEndpoints
+	if len(externalEndpoints) == 0 {
+		externalEndpoints = a.externalEndpoints
+	}
+	ntpServer := selection.NTPServer
+	if ntpServer == "" {
+		ntpServer = a.ntpServer
+	}
+
+	log.Println("Triggering connectivity tests on the selected agents...")
+
+	// Results of this run are tagged with its ID so they can be told apart
+	// from earlier or overlapping runs
+	runID, err := newRunID()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create run ID: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Starting test run %s", runID)
+	if err := a.db.CreateRun(runID, time.Now().UTC()); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create run: %v", err), http.StatusInternalServerError)
+		return
+	}
+	a.publishRun(runID)
+
 	servers, err := a.db.GetAllServers()
 	if err != nil {
+		a.failRun(runID, err.Error())
 		http.Error(w, fmt.Sprintf("Failed to get servers: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	if len(servers) == 0 {
+		a.failRun(runID, "no servers registered")
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  "success",
 			"message": "No servers registered to test",
 			"count":   0,
+			"run_id":  runID,
 		})
 		return
 	}
 
 	// Build test targets from registered servers
 	allTargets := make(map[string]agent.TargetInfo)
+	capabilities := make(map[string]*agent.Capabilities)
 
 	for _, server := range servers {
-		var bonds map[string][]string
-		if err := json.Unmarshal([]byte(server.Bonds), &bonds); err != nil {
-			log.Printf("Failed to unmarshal bonds for %s: %v", server.Hostname, err)
+		if !selection.isTarget(server.Hostname) {
+			continue
+		}
+		links, err := serverLinks(server)
+		if err != nil {
+			log.Printf("Failed to unmarshal links for %s: %v", server.Hostname, err)
+			continue
+		}
+		if links = selection.selectLinks(links); len(links) == 0 {
 			continue
 		}
 
-		allTargets[server.Hostname] = agent.TargetInfo{
-			Links: bonds,
+		caps, err := serverCapabilities(server)
+		if err != nil {
+			log.Printf("Failed to unmarshal capabilities for %s: %v", server.Hostname, err)
+		}
+		capabilities[server.Hostname] = caps
+
+		target := agent.TargetInfo{
+			Links:         links,
+			AgentPort:     agentPort(server),
+			ExpectedPorts: matchingPorts(a.expectedPorts, server.Hostname),
+			TLSPorts:      matchingPorts(a.tlsPorts, server.Hostname),
+		}
+		if caps != nil && !caps.BandwidthServer {
+			target.SkipTestTypes = []string{agent.TestTypeBandwidth}
 		}
+		// Only agents that can mark probes also report the markings they receive
+		if caps == nil || !caps.Supports(agent.TestTypeDSCP) {
+			target.SkipTestTypes = append(target.SkipTestTypes, agent.TestTypeDSCP)
+		}
+		// Agents that do not report clock-skew predate the time exchange endpoint
+		if caps == nil || !caps.Supports(agent.TestTypeClockSkew) {
+			target.SkipTestTypes = append(target.SkipTestTypes, agent.TestTypeClockSkew)
+		}
+		allTargets[server.Hostname] = target
+	}
+
+	var sources []database.ServerRegistration
+	for _, server := range servers {
+		if selection.isSource(server.Hostname) {
+			sources = append(sources, server)
+		}
+	}
+	if len(sources) == 0 || (selection.selectsTargets() && len(allTargets) == 0) {
+		a.failRun(runID, "no servers match the selection")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "No servers match the selection",
+			"count":   0,
+			"run_id":  runID,
+		})
+		return
 	}
 
 	// Trigger tests on each agent asynchronously
@@ -285,9 +859,24 @@ func (a *Aggregator) handleRunTests(w http.ResponseWriter, r *http.Request) {
 		err      error
 	}
 
-	resultsChan := make(chan triggerResult, len(servers))
-
+	registered := make(map[string]database.ServerRegistration, len(servers))
 	for _, server := range servers {
+		registered[server.Hostname] = server
+	}
+
+	resultsChan := make(chan triggerResult, len(sources))
+	client := a.auth.Client(10 * time.Second)
+	skippedAgents := []string{}
+
+	for _, server := range sources {
+		// Only request the tests the agent can run
+		testTypes := supportedTestTypes(selection.TestTypes, capabilities[server.Hostname])
+		if testTypes != nil && len(testTypes) == 0 {
+			log.Printf("Skipping %s (%s): it cannot run any of the requested tests", server.Hostname, server.IPAddress)
+			skippedAgents = append(skippedAgents, server.Hostname)
+			continue
+		}
+
 		// Build targets for this agent (exclude itself)
 		targets := make(map[string]agent.TargetInfo)
 		for hostname, info := range allTargets {
@@ -297,15 +886,24 @@ func (a *Aggregator) handleRunTests(w http.ResponseWriter, r *http.Request) {
 		}
 
 		testRequest := agent.TestRequest{
-			Targets: targets,
+			Targets:   targets,
+			RunID:     runID,
+			TestTypes: testTypes,
+			Options:   selection.Options,
+			Profile:   selection.Profile,
+
+			ExternalEndpoints: externalEndpoints,
+			NTPServer:         ntpServer,
+			Bidirectional:     selection.Bidirectional,
+			Deduplicate:       selection.Deduplicate,
 		}
 
 		// Send test request to agent using its IP address
-		agentURL := fmt.Sprintf("http://%s:8080/api/run-tests", server.IPAddress)
+		agentURL := a.agentURL(server, "/api/run-tests")
 
 		go func(url, hostname, ipAddr string, req agent.TestRequest) {
 			reqBody, _ := json.Marshal(req)
-			resp, err := http.Post(url, "application/json", bytes.NewReader(reqBody))
+			resp, err := client.Post(url, "application/json", bytes.NewReader(reqBody))
 			if err != nil {
 				log.Printf("Failed to trigger tests on %s (%s): %v", hostname, ipAddr, err)
 				resultsChan <- triggerResult{hostname: hostname, ipAddr: ipAddr, success: false, err: err}
@@ -325,20 +923,23 @@ func (a *Aggregator) handleRunTests(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Wait briefly for all trigger acknowledgments (not test results)
+	triggered := len(sources) - len(skippedAgents)
 	successCount := 0
+	triggeredServers := []database.ServerRegistration{}
 	failedAgents := []string{}
 	timeout := time.After(2 * time.Second)
 
-	for i := 0; i < len(servers); i++ {
+	for i := 0; i < triggered; i++ {
 		select {
 		case result := <-resultsChan:
 			if result.success {
 				successCount++
+				triggeredServers = append(triggeredServers, registered[result.hostname])
 			} else {
 				failedAgents = append(failedAgents, fmt.Sprintf("%s (%s): %v", result.hostname, result.ipAddr, result.err))
 			}
 		case <-timeout:
-			remaining := len(servers) - i
+			remaining := triggered - i
 			if remaining > 0 {
 				log.Printf("Timeout waiting for %d agent acknowledgments", remaining)
 				failedAgents = append(failedAgents, fmt.Sprintf("%d agents timed out", remaining))
@@ -348,17 +949,214 @@ func (a *Aggregator) handleRunTests(w http.ResponseWriter, r *http.Request) {
 	}
 
 done:
+	// The run completes once every agent it was triggered on has finished it
+	if successCount == 0 {
+		a.failRun(runID, "no agent could be triggered")
+	} else {
+		if err := a.db.SetRunAgents(runID, successCount); err != nil {
+			log.Printf("Failed to update run %s: %v", runID, err)
+		}
+		go a.watchRun(runID, triggeredServers)
+	}
+
 	// Return results
 	response := map[string]interface{}{
 		"status":  "success",
-		"message": fmt.Sprintf("Test requests sent to %d/%d agent(s). Results will be posted back.", successCount, len(servers)),
+		"message": fmt.Sprintf("Test requests sent to %d/%d agent(s). Results will be posted back.", successCount, len(sources)),
 		"count":   successCount,
-		"total":   len(servers),
+		"total":   len(sources),
+		"run_id":  runID,
+	}
+
+	if len(failedAgents) > 0 {
+		response["failed_agents"] = failedAgents
+		response["message"] = fmt.Sprintf("Tests triggered on %d/%d agent(s). %d failed.", successCount, len(sources), len(failedAgents))
+	}
+	if len(skippedAgents) > 0 {
+		response["skipped_agents"] = skippedAgents
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// failRun records that a run failed before it got going
+func (a *Aggregator) failRun(runID, errorMessage string) {
+	if err := a.db.FinishRun(runID, database.RunStatusFailed, errorMessage); err != nil {
+		log.Printf("Failed to record the end of run %s: %v", runID, err)
+		return
+	}
+	a.publishRun(runID)
+	a.alertRunEnded(runID, database.RunStatusFailed, errorMessage)
+}
+
+// publishRun tells dashboards about the current state of a run
+func (a *Aggregator) publishRun(runID string) {
+	run, err := a.db.GetRun(runID)
+	if err != nil || run == nil {
+		log.Printf("Failed to get run %s: %v", runID, err)
+		return
+	}
+	a.events.publish(eventRun, run)
+}
+
+// serverCapabilities returns what a registered server can test, or nil for
+// agents that predate capability reporting
+func serverCapabilities(server database.ServerRegistration) (*agent.Capabilities, error) {
+	if server.Capabilities == "" {
+		return nil, nil
+	}
+
+	var caps agent.Capabilities
+	if err := json.Unmarshal([]byte(server.Capabilities), &caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// supportedTestTypes narrows the requested test types, where none means all
+// of them, to those an agent can run. Without capabilities it returns the
+// request unchanged.
+func supportedTestTypes(requested []string, caps *agent.Capabilities) []string {
+	if caps == nil {
+		return requested
+	}
+	if len(requested) == 0 {
+		requested = agent.AllTestTypes
+	}
+
+	supported := []string{}
+	for _, testType := range requested {
+		if caps.Supports(testType) {
+			supported = append(supported, testType)
+		}
+	}
+	return supported
+}
+
+// serverLinks returns the links a registered server can be tested on. Agents
+// that predate link reporting only registered their bonds.
+func serverLinks(server database.ServerRegistration) (map[string][]string, error) {
+	data := server.Links
+	if data == "" {
+		data = server.Bonds
 	}
 
+	var links map[string][]string
+	if err := json.Unmarshal([]byte(data), &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// agentURL returns the URL of path on a registered agent's API. Agents that
+// did not advertise their URL are assumed to listen on the default port.
+func (a *Aggregator) agentURL(server database.ServerRegistration, path string) string {
+	if server.AgentURL != "" {
+		return strings.TrimSuffix(server.AgentURL, "/") + path
+	}
+	return fmt.Sprintf("%s://%s%s", a.auth.Scheme(), net.JoinHostPort(server.IPAddress, strconv.Itoa(agent.DefaultAgentPort)), path)
+}
+
+// agentPort returns the port of a registered agent's API, which other agents
+// connect to for the http and bandwidth tests
+func agentPort(server database.ServerRegistration) int {
+	if server.AgentURL == "" {
+		return agent.DefaultAgentPort
+	}
+	u, err := parseAgentURL(server.AgentURL)
+	if err != nil {
+		return agent.DefaultAgentPort
+	}
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			return 443
+		}
+		return 80
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+// parseAgentURL parses an advertised agent URL, which must be an absolute
+// http or https URL
+func parseAgentURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	if p := u.Port(); p != "" {
+		if port, err := strconv.Atoi(p); err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+	}
+	return u, nil
+}
+
+// newRunID returns a unique, time-ordered identifier for a test run
+func newRunID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix)), nil
+}
+
+// Handler to cancel running connectivity tests on all agents
+func (a *Aggregator) handleCancelTests(w http.ResponseWriter, r *http.Request) {
+	servers, err := a.db.GetAllServers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get servers: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	client := a.auth.Client(5 * time.Second)
+
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		cancelled    int
+		failedAgents = []string{}
+	)
+	for _, server := range servers {
+		wg.Add(1)
+		go func(url, hostname, ipAddr string) {
+			defer wg.Done()
+
+			resp, err := client.Post(url, "application/json", nil)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("status %d", resp.StatusCode)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Failed to cancel tests on %s (%s): %v", hostname, ipAddr, err)
+				failedAgents = append(failedAgents, fmt.Sprintf("%s (%s): %v", hostname, ipAddr, err))
+				return
+			}
+			cancelled++
+		}(a.agentURL(server, "/api/cancel-tests"), server.Hostname, server.IPAddress)
+	}
+	wg.Wait()
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Cancel requests sent to %d/%d agent(s)", cancelled, len(servers)),
+		"count":   cancelled,
+		"total":   len(servers),
+	}
 	if len(failedAgents) > 0 {
 		response["failed_agents"] = failedAgents
-		response["message"] = fmt.Sprintf("Tests triggered on %d/%d agent(s). %d failed.", successCount, len(servers), len(failedAgents))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -383,6 +1181,7 @@ func (a *Aggregator) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"status":    "healthy",
 		"timestamp": time.Now(),
 		"mode":      "aggregator",
+		"version":   agent.Version(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -481,6 +1280,7 @@ func (a *Aggregator) getDashboardHTML() string {
 
         .success { color: #27ae60; font-weight: bold; }
         .failure { color: #e74c3c; font-weight: bold; }
+        .warning { color: #f39c12; font-weight: bold; }
 
         .refresh-btn {
             background: linear-gradient(135deg, #3498db, #2980b9);
@@ -602,12 +1402,15 @@ func (a *Aggregator) getDashboardHTML() string {
                     <tr>
                         <th>Hostname</th>
                         <th>IP Address</th>
-                        <th>Bonds</th>
+                        <th>Links</th>
+                        <th>Version</th>
+                        <th>Status</th>
                         <th>Last Seen</th>
+                        <th></th>
                     </tr>
                 </thead>
                 <tbody id="servers-body">
-                    <tr><td colspan="4">Loading...</td></tr>
+                    <tr><td colspan="7">Loading...</td></tr>
                 </tbody>
             </table>
         </div>
@@ -624,7 +1427,7 @@ func (a *Aggregator) getDashboardHTML() string {
                         <th>Source IP</th>
                         <th>Target</th>
                         <th>Target IP</th>
-                        <th>Bond</th>
+                        <th>Link</th>
                         <th>Test Type</th>
                         <th>Status</th>
                         <th>Response Time</th>
@@ -643,47 +1446,80 @@ func (a *Aggregator) getDashboardHTML() string {
             await Promise.all([loadServers(), loadTestResults()]);
         }
 
+        // Switch ports of each server's links, from LLDP: hostname -> link -> ports
+        let switchPorts = {};
+
+        // describeSwitchPorts formats the LLDP neighbors of a link's NICs
+        function describeSwitchPorts(neighbors) {
+            return neighbors.map(n => ` + "`" + `${n.interface} → ${n.system_name || n.chassis_id} ${n.port_description || n.port_id}` + "`" + `).join(', ');
+        }
+
         async function loadServers() {
             try {
-                const response = await fetch('/api/servers');
+                const [response, healthResponse] = await Promise.all([fetch('/api/servers'), fetch('/api/health')]);
                 const servers = await response.json();
+                const health = await healthResponse.json();
 
                 document.getElementById('server-count').textContent = servers.length;
 
                 const tbody = document.getElementById('servers-body');
                 if (servers.length === 0) {
-                    tbody.innerHTML = '<tr><td colspan="4">No servers registered</td></tr>';
+                    tbody.innerHTML = '<tr><td colspan="7">No servers registered</td></tr>';
                     return;
                 }
 
+                switchPorts = {};
                 tbody.innerHTML = servers.map(server => {
-                    const bonds = JSON.parse(server.bonds);
-                    const bondList = Object.keys(bonds).join(', ') || 'None';
+                    const links = JSON.parse(server.links || server.bonds) || {};
+                    const neighbors = server.lldp_neighbors ? JSON.parse(server.lldp_neighbors) : {};
+                    switchPorts[server.hostname] = {};
+                    const linkList = Object.keys(links).sort().map(link => {
+                        if (!neighbors[link]) {
+                            return link;
+                        }
+                        switchPorts[server.hostname][link] = describeSwitchPorts(neighbors[link]);
+                        return ` + "`" + `${link} (${switchPorts[server.hostname][link]})` + "`" + `;
+                    }).join(', ') || 'None';
                     const lastSeen = new Date(server.last_seen).toLocaleString();
+                    const statusClass = {online: 'success', stale: 'warning', offline: 'failure'}[server.status] || '';
+
+                    // Agents built from a different version than the aggregator are flagged
+                    let version = server.version || 'unknown';
+                    if (server.version !== health.version) {
+                        version = ` + "`" + `<span class="failure" title="Aggregator runs ${health.version}">${version}</span>` + "`" + `;
+                    }
 
                     return ` + "`" + `
                         <tr>
-                            <td>${server.hostname}</td>
+                            <td><a href="/api/servers/${encodeURIComponent(server.hostname)}/sysinfo" target="_blank" title="Live system info">${server.hostname}</a></td>
                             <td>${server.ip_address}</td>
-                            <td>${bondList}</td>
+                            <td>${linkList}</td>
+                            <td>${version}</td>
+                            <td><span class="${statusClass}">${server.status}</span></td>
                             <td>${lastSeen}</td>
+                            <td><button class="filter-btn" onclick="removeServer('${server.hostname}')">🗑 Remove</button></td>
                         </tr>
                     ` + "`" + `;
                 }).join('');
+
+                // Failed results name the switch ports of their target link
+                renderTestResults();
             } catch (error) {
                 console.error('Failed to load servers:', error);
             }
         }
 
         let allTestResults = [];
+        let currentRunID = null;  // run whose results are shown
         let showFailedOnly = true;  // Default to showing only failed tests
 
         async function loadTestResults() {
             try {
-                const response = await fetch('/api/test-results');
+                const response = await fetch('/api/test-results?run_id=latest');
                 const results = await response.json();
 
                 allTestResults = results;
+                currentRunID = results.length > 0 ? results[0].run_id : null;
                 renderTestResults();
             } catch (error) {
                 console.error('Failed to load test results:', error);
@@ -720,19 +1556,60 @@ func (a *Aggregator) getDashboardHTML() string {
                 const status = result.success
                     ? '<span class="success">✓ Success</span>'
                     : '<span class="failure">✗ Failed</span>';
-                const responseTime = result.success
+                let responseTime = result.success
                     ? ` + "`" + `${result.response_time_ms}ms` + "`" + `
                     : result.error_message;
+                if (result.success && result.rtt_avg_ms) {
+                    responseTime = ` + "`" + `${result.rtt_avg_ms.toFixed(2)}ms (min ${result.rtt_min_ms.toFixed(2)} / max ${result.rtt_max_ms.toFixed(2)}, p50 ${result.p50_ms.toFixed(2)} / p95 ${result.p95_ms.toFixed(2)} / p99 ${result.p99_ms.toFixed(2)})` + "`" + `;
+                    if (result.packet_loss_percent) {
+                        responseTime += ` + "`" + `, ${result.packet_loss_percent.toFixed(0)}% loss` + "`" + `;
+                    }
+                }
+                if (result.success && result.test_type === 'bandwidth') {
+                    responseTime = ` + "`" + `${result.throughput_mbps.toFixed(1)} Mbps` + "`" + `;
+                }
+                if (result.success && result.test_type === 'pmtu') {
+                    responseTime = ` + "`" + `MTU ${result.path_mtu}` + "`" + `;
+                }
+                if (result.test_type === 'bond-health' && result.slaves_total) {
+                    const slaves = ` + "`" + `${result.slaves_up}/${result.slaves_total} slaves up` + "`" + `;
+                    responseTime = result.success ? slaves : ` + "`" + `${result.error_message} (${slaves})` + "`" + `;
+                }
+                if (result.test_type === 'tls' && result.success) {
+                    const expires = result.cert_not_after ? ` + "`" + `, cert expires ${result.cert_not_after.slice(0, 10)}` + "`" + ` : '';
+                    responseTime = ` + "`" + `port ${result.port} ${result.tls_version}${expires} (${result.response_time_ms}ms)` + "`" + `;
+                }
+                if (result.test_type === 'ports') {
+                    responseTime = result.success ? ` + "`" + `port ${result.port} open (${result.response_time_ms}ms)` + "`" + ` : result.error_message;
+                }
+                if ((result.test_type === 'time-sync' || result.test_type === 'clock-skew') && result.success) {
+                    responseTime = ` + "`" + `offset ${result.clock_offset_ms.toFixed(2)}ms` + "`" + `;
+                }
+                if (result.success && result.tcp_rtt_ms) {
+                    responseTime += ` + "`" + `, TCP RTT ${result.tcp_rtt_ms.toFixed(2)}ms / ${result.tcp_retransmits || 0} retransmits / cwnd ${result.tcp_cwnd}` + "`" + `;
+                }
+                if (result.test_type === 'traceroute' && result.hops) {
+                    const path = JSON.parse(result.hops).map(hop => hop.ip || '*').join(' → ');
+                    responseTime = result.success ? path : ` + "`" + `${result.error_message}: ${path}` + "`" + `;
+                }
+                const targetPorts = (switchPorts[result.target_hostname] || {})[result.bond_name];
+                const link = !result.success && targetPorts
+                    ? ` + "`" + `${result.bond_name}<br><small>${targetPorts}</small>` + "`" + `
+                    : result.bond_name;
                 const testedAt = new Date(result.tested_at).toLocaleString();
-                const testType = result.test_type ? result.test_type.toUpperCase() : 'N/A';
+                let testType = result.test_type ? result.test_type.toUpperCase() : 'N/A';
+                // Both directions of a bidirectional test share a correlation ID
+                if (result.correlation_id) {
+                    testType += ` + "`" + `<br><small>⇄ ${result.correlation_id.slice(0, 8)}</small>` + "`" + `;
+                }
 
                 return ` + "`" + `
                     <tr>
                         <td>${result.source_hostname}</td>
-                        <td>${result.source_ip}</td>
+                        <td>${result.source_ip}${result.source_interface ? ' (' + result.source_interface + ')' : ''}</td>
                         <td>${result.target_hostname}</td>
                         <td>${result.target_ip}</td>
-                        <td>${result.bond_name}</td>
+                        <td>${link}</td>
                         <td>${testType}</td>
                         <td>${status}</td>
                         <td>${responseTime}</td>
@@ -767,10 +1644,7 @@ func (a *Aggregator) getDashboardHTML() string {
                 statusDiv.style.display = 'none';
 
                 // Trigger tests on the aggregator (it will coordinate with agents)
-                const response = await fetch('/api/run-tests', {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' }
-                });
+                const response = await operatorFetch('/api/run-tests', 'POST');
 
                 if (!response.ok) {
                     throw new Error('Failed to trigger tests');
@@ -801,6 +1675,46 @@ func (a *Aggregator) getDashboardHTML() string {
             }
         }
 
+        // operatorFetch sends a request presenting the stored operator
+        // token, and asks for one when the aggregator requires it
+        async function operatorFetch(url, method) {
+            const send = () => {
+                const headers = { 'Content-Type': 'application/json' };
+                const token = localStorage.getItem('operatorToken');
+                if (token) {
+                    headers['Authorization'] = 'Bearer ' + token;
+                }
+                return fetch(url, { method: method, headers: headers });
+            };
+
+            let response = await send();
+            if (response.status === 401) {
+                const token = prompt('Operator token');
+                if (token) {
+                    localStorage.setItem('operatorToken', token);
+                    response = await send();
+                }
+            }
+            return response;
+        }
+
+        // removeServer deregisters a decommissioned host. Test results are kept.
+        async function removeServer(hostname) {
+            if (!confirm(` + "`" + `Remove ${hostname}? It registers again if its agent is still running.` + "`" + `)) {
+                return;
+            }
+            try {
+                const response = await operatorFetch('/api/server/' + encodeURIComponent(hostname), 'DELETE');
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                showStatus(` + "`" + `Removed ${hostname}` + "`" + `, 'success');
+                loadServers();
+            } catch (error) {
+                showStatus(` + "`Error: ${error.message}`" + `, 'error');
+            }
+        }
+
         function showStatus(message, type) {
             const statusDiv = document.getElementById('test-status');
             statusDiv.textContent = message;
@@ -813,11 +1727,49 @@ func (a *Aggregator) getDashboardHTML() string {
             }, 5000);
         }
 
-        // Load data on page load
-        refreshData();
+        // Rendering is deferred so a burst of results redraws the table once
+        let renderPending = false;
+        function scheduleRender() {
+            if (renderPending) {
+                return;
+            }
+            renderPending = true;
+            setTimeout(() => {
+                renderPending = false;
+                renderTestResults();
+            }, 500);
+        }
 
-        // Auto-refresh every 30 seconds
-        setInterval(refreshData, 30000);
+        // Registrations, results and runs are pushed as they happen. The
+        // data is reloaded whenever the stream (re)connects, since events
+        // may have been missed in between.
+        const events = new EventSource('/api/events');
+        events.onopen = refreshData;
+        events.addEventListener('resync', refreshData);
+        events.addEventListener('server', loadServers);
+        // Agents that stop sending turn stale and offline without an event
+        setInterval(loadServers, 60000);
+        events.addEventListener('run', e => {
+            const run = JSON.parse(e.data);
+            // A new run replaces the results shown
+            if (run.status === 'running' && run.id !== currentRunID) {
+                currentRunID = run.id;
+                allTestResults = [];
+                scheduleRender();
+            }
+        });
+        events.addEventListener('test-results', e => {
+            const results = JSON.parse(e.data);
+            for (const result of results) {
+                // Results of another run, e.g. a scheduled one, are the latest now
+                if (result.run_id !== currentRunID) {
+                    currentRunID = result.run_id;
+                    allTestResults = [];
+                }
+                allTestResults.unshift(result);
+            }
+            scheduleRender();
+        });
     </script>
 </body>
 </html>`
@@ -0,0 +1,41 @@
+package aggregator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromServer(t *testing.T) {
+	a := newTestAggregator(t)
+	bonds := map[string][]string{"bond0": {"10.0.1.1"}}
+	links := map[string][]string{"bond0": {"10.0.1.1"}, "eth2": {"192.168.0.1", "fd00::1"}}
+	if err := a.db.RegisterServer("web1", "10.0.0.1", "", nil, bonds, links, "", 0, nil, nil); err != nil {
+		t.Fatalf("RegisterServer() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		hostname   string
+		remoteAddr string
+		want       bool
+	}{
+		{"registered address", "web1", "10.0.0.1:40000", true},
+		{"bond address", "web1", "10.0.1.1:40000", true},
+		{"link address", "web1", "192.168.0.1:40000", true},
+		{"IPv6 link address", "web1", "[fd00::1]:40000", true},
+		{"other host", "web1", "10.0.0.2:40000", false},
+		{"not registered", "web2", "10.0.0.2:40000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/api/server/"+tt.hostname, nil)
+			r.SetPathValue("hostname", tt.hostname)
+			r.RemoteAddr = tt.remoteAddr
+			if got := a.fromServer(r); got != tt.want {
+				t.Errorf("fromServer() from %s = %v, want %v", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
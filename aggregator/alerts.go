@@ -0,0 +1,237 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"validate/database"
+)
+
+// Alert events
+const (
+	alertRunFailures = "run_failures" // a run ended with failed tests, or failed itself
+	alertAgentStale  = "agent_stale"  // an agent stopped registering and sending heartbeats
+	alertPairFailed  = "pair_failed"  // tests that passed last time failed
+)
+
+const (
+	alertTimeout       = 10 * time.Second // for each webhook request
+	staleCheckInterval = time.Minute
+)
+
+// Alert is the JSON payload POSTed to the alert webhooks
+type Alert struct {
+	Event    string       `json:"event"` // one of the alert constants
+	Message  string       `json:"message"`
+	RunID    string       `json:"run_id,omitempty"`
+	Hostname string       `json:"hostname,omitempty"` // agent_stale only
+	LastSeen *time.Time   `json:"last_seen,omitempty"`
+	Failures int          `json:"failures,omitempty"` // run_failures only
+	Pairs    []PairStatus `json:"pairs,omitempty"`    // pair_failed only
+	Time     time.Time    `json:"time"`
+}
+
+// PairStatus names a test from one host to another's link that turned red
+type PairStatus struct {
+	SourceHostname string `json:"source_hostname"`
+	TargetHostname string `json:"target_hostname"`
+	TargetIP       string `json:"target_ip"`
+	BondName       string `json:"bond_name"`
+	TestType       string `json:"test_type"`
+	Port           int    `json:"port,omitempty"`
+	ErrorMessage   string `json:"error_message,omitempty"`
+}
+
+// alerter sends alerts to webhooks, at most once per cooldown for the same
+// subject, such as a stale agent or a failing pair
+type alerter struct {
+	webhooks []string
+	cooldown time.Duration
+	client   *http.Client
+
+	mu    sync.Mutex
+	sent  map[string]time.Time // subject -> when last alerted
+	stale map[string]bool      // agents alerted as stale and not seen since
+}
+
+// SetAlerts sets the webhooks alerts are sent to, how long the same alert is
+// held back after it was sent, and how long an agent may go without
+// registering or sending a heartbeat before it is stale; 0 derives that from
+// each agent's register interval. Without webhooks no alerts are sent.
+func (a *Aggregator) SetAlerts(webhooks []string, cooldown, staleAfter time.Duration) error {
+	for _, webhook := range webhooks {
+		if _, err := parseAgentURL(webhook); err != nil {
+			return fmt.Errorf("invalid alert webhook %q: %w", webhook, err)
+		}
+	}
+	a.staleAfter = staleAfter
+	if len(webhooks) == 0 {
+		a.alerts = nil
+		return nil
+	}
+	a.alerts = &alerter{
+		webhooks: webhooks,
+		cooldown: cooldown,
+		client:   &http.Client{Timeout: alertTimeout},
+		sent:     make(map[string]time.Time),
+		stale:    make(map[string]bool),
+	}
+	return nil
+}
+
+// allow reports whether an alert about subject may be sent now, and if so
+// records that it is
+func (al *alerter) allow(subject string) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := al.sent[subject]; ok && now.Sub(last) < al.cooldown {
+		return false
+	}
+
+	// Forget subjects whose cooldown is over, so the map does not grow
+	for s, last := range al.sent {
+		if now.Sub(last) >= al.cooldown {
+			delete(al.sent, s)
+		}
+	}
+	al.sent[subject] = now
+	return true
+}
+
+// send POSTs the alert to every webhook in the background
+func (al *alerter) send(alert Alert) {
+	alert.Time = time.Now()
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Failed to marshal %s alert: %v", alert.Event, err)
+		return
+	}
+
+	log.Printf("Alert: %s", alert.Message)
+	for _, webhook := range al.webhooks {
+		go func(url string) {
+			resp, err := al.client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Printf("Failed to send %s alert to %s: %v", alert.Event, url, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				log.Printf("Failed to send %s alert to %s: status %d", alert.Event, url, resp.StatusCode)
+			}
+		}(webhook)
+	}
+}
+
+// alertRunEnded alerts when a run failed or any of its tests did
+func (a *Aggregator) alertRunEnded(runID, status, errorMessage string) {
+	if a.alerts == nil {
+		return
+	}
+
+	failed := false
+	failures, err := a.db.CountTestResults(database.TestResultFilter{RunID: runID, Success: &failed})
+	if err != nil {
+		log.Printf("Failed to count failures of run %s: %v", runID, err)
+	}
+	if status != database.RunStatusFailed && failures == 0 {
+		return
+	}
+	if !a.alerts.allow("run:" + runID) {
+		return
+	}
+
+	message := fmt.Sprintf("Test run %s %s with %d failed tests", runID, status, failures)
+	if errorMessage != "" {
+		message += ": " + errorMessage
+	}
+	a.alerts.send(Alert{Event: alertRunFailures, Message: message, RunID: runID, Failures: failures})
+}
+
+// alertPairsFailed alerts about the failed pairs, held back for pairs
+// alerted about within the cooldown
+func (a *Aggregator) alertPairsFailed(runID string, pairs []PairStatus) {
+	if a.alerts == nil {
+		return
+	}
+
+	var alerted []PairStatus
+	for _, pair := range pairs {
+		subject := fmt.Sprintf("pair:%s>%s/%s/%s/%d", pair.SourceHostname, pair.TargetHostname, pair.TargetIP, pair.TestType, pair.Port)
+		if a.alerts.allow(subject) {
+			alerted = append(alerted, pair)
+		}
+	}
+	if len(alerted) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(alerted))
+	for _, pair := range alerted {
+		names = append(names, fmt.Sprintf("%s -> %s %s (%s)", pair.SourceHostname, pair.TargetHostname, pair.TestType, pair.TargetIP))
+	}
+	a.alerts.send(Alert{
+		Event:   alertPairFailed,
+		Message: fmt.Sprintf("%d tests that passed before failed: %s", len(alerted), strings.Join(names, ", ")),
+		RunID:   runID,
+		Pairs:   alerted,
+	})
+}
+
+// checkStaleAgents alerts once about each agent that went stale, until it
+// is seen again
+func (a *Aggregator) checkStaleAgents() {
+	servers, err := a.db.GetAllServers()
+	if err != nil {
+		log.Printf("Failed to check for stale agents: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, server := range servers {
+		stale := a.serverStatus(server, now) != serverOnline
+
+		a.alerts.mu.Lock()
+		alerted := a.alerts.stale[server.Hostname]
+		if stale {
+			a.alerts.stale[server.Hostname] = true
+		} else {
+			delete(a.alerts.stale, server.Hostname)
+		}
+		a.alerts.mu.Unlock()
+
+		if !stale || alerted || !a.alerts.allow("stale:"+server.Hostname) {
+			continue
+		}
+		lastSeen := server.LastSeen
+		a.alerts.send(Alert{
+			Event:    alertAgentStale,
+			Message:  fmt.Sprintf("Agent %s (%s) not seen since %s", server.Hostname, server.IPAddress, lastSeen.Format(time.RFC3339)),
+			Hostname: server.Hostname,
+			LastSeen: &lastSeen,
+		})
+	}
+}
+
+// watchStaleAgents checks for stale agents until stop is closed
+func (a *Aggregator) watchStaleAgents(stop <-chan struct{}) {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.checkStaleAgents()
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"validate/database"
+)
+
+const (
+	defaultLatencyThreshold = 50.0 // percent a latency may grow by
+	defaultLatencyMinMS     = 1.0  // milliseconds a latency must grow by to count
+)
+
+// RunComparison lists the tests whose outcome changed from one run to
+// another
+type RunComparison struct {
+	Base    string `json:"base"`    // run compared against
+	Compare string `json:"compare"` // run compared
+
+	NewlyFailing       []PairChange `json:"newly_failing"`
+	NewlyPassing       []PairChange `json:"newly_passing"`
+	LatencyRegressions []PairChange `json:"latency_regressions"`
+	Missing            []PairChange `json:"missing"` // tested in base only
+	Added              []PairChange `json:"added"`   // tested in compare only
+	Unchanged          int          `json:"unchanged"`
+}
+
+// PairChange is a test from a source host to a target address and port in
+// both runs
+type PairChange struct {
+	SourceHostname string      `json:"source_hostname"`
+	TargetHostname string      `json:"target_hostname"`
+	TargetIP       string      `json:"target_ip"`
+	BondName       string      `json:"bond_name"`
+	TestType       string      `json:"test_type"`
+	Port           int         `json:"port,omitempty"`
+	Before         *PairResult `json:"before,omitempty"` // nil when added
+	After          *PairResult `json:"after,omitempty"`  // nil when missing
+}
+
+// PairResult sums up the results of a test in a run
+type PairResult struct {
+	Total        int     `json:"total"`
+	Passed       int     `json:"passed"`
+	LatencyMS    float64 `json:"latency_ms"` // average round trip time, or response time of tests without one
+	ErrorMessage string  `json:"error_message,omitempty"`
+}
+
+// pairKey identifies a test across runs
+type pairKey struct {
+	source, target, ip, testType string
+	port                         int
+}
+
+// Handler comparing run b against run a. b may be "latest". A test is newly
+// failing when it passed in a and not in b, and its latency regressed when
+// it passed in both and grew by more than threshold percent (default 50)
+// and min_ms milliseconds (default 1).
+func (a *Aggregator) handleCompareRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	threshold, err := floatParam(query.Get("threshold"), defaultLatencyThreshold)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: threshold %v", err), http.StatusBadRequest)
+		return
+	}
+	minMS, err := floatParam(query.Get("min_ms"), defaultLatencyMinMS)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: min_ms %v", err), http.StatusBadRequest)
+		return
+	}
+
+	base := r.PathValue("a")
+	compare := r.PathValue("b")
+	if compare == "latest" {
+		if compare, err = a.db.LatestRunID(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get latest run: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if compare == "" {
+			http.Error(w, "No runs found", http.StatusNotFound)
+			return
+		}
+	}
+
+	before, ok := a.runOutcomes(w, base)
+	if !ok {
+		return
+	}
+	after, ok := a.runOutcomes(w, compare)
+	if !ok {
+		return
+	}
+
+	comparison := compareOutcomes(before, after, threshold, minMS)
+	comparison.Base = base
+	comparison.Compare = compare
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// compareOutcomes sorts the tests of two runs by how their outcome changed
+// from before to after
+func compareOutcomes(before, after []database.PairOutcome, threshold, minMS float64) RunComparison {
+	afterByKey := make(map[pairKey]database.PairOutcome, len(after))
+	for _, outcome := range after {
+		afterByKey[outcomeKey(outcome)] = outcome
+	}
+
+	comparison := RunComparison{
+		NewlyFailing:       []PairChange{},
+		NewlyPassing:       []PairChange{},
+		LatencyRegressions: []PairChange{},
+		Missing:            []PairChange{},
+		Added:              []PairChange{},
+	}
+	for _, outcome := range before {
+		key := outcomeKey(outcome)
+		later, found := afterByKey[key]
+		if !found {
+			comparison.Missing = append(comparison.Missing, pairChange(&outcome, nil))
+			continue
+		}
+		delete(afterByKey, key)
+
+		passedBefore := outcome.Passed == outcome.Total
+		passedAfter := later.Passed == later.Total
+		switch {
+		case passedBefore && !passedAfter:
+			comparison.NewlyFailing = append(comparison.NewlyFailing, pairChange(&outcome, &later))
+		case !passedBefore && passedAfter:
+			comparison.NewlyPassing = append(comparison.NewlyPassing, pairChange(&outcome, &later))
+		case passedBefore && latencyRegressed(outcome, later, threshold, minMS):
+			comparison.LatencyRegressions = append(comparison.LatencyRegressions, pairChange(&outcome, &later))
+		default:
+			comparison.Unchanged++
+		}
+	}
+	// What is left was not tested in base, and keeps the order of the run
+	for _, outcome := range after {
+		if _, found := afterByKey[outcomeKey(outcome)]; found {
+			comparison.Added = append(comparison.Added, pairChange(nil, &outcome))
+		}
+	}
+	return comparison
+}
+
+// runOutcomes returns the outcomes of the tests of a run, reporting runs
+// that are unknown or failed to load
+func (a *Aggregator) runOutcomes(w http.ResponseWriter, runID string) ([]database.PairOutcome, bool) {
+	outcomes, err := a.db.PairOutcomes(runID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(outcomes) > 0 {
+		return outcomes, true
+	}
+
+	// Runs without results are compared as long as they exist
+	run, err := a.db.GetRun(runID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get run: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if run == nil {
+		http.Error(w, fmt.Sprintf("Run %s not found", runID), http.StatusNotFound)
+		return nil, false
+	}
+	return nil, true
+}
+
+func outcomeKey(outcome database.PairOutcome) pairKey {
+	return pairKey{outcome.SourceHostname, outcome.TargetHostname, outcome.TargetIP, outcome.TestType, outcome.Port}
+}
+
+// latency returns the average round trip time of an outcome, or its
+// response time for tests that do not measure one
+func latency(outcome database.PairOutcome) float64 {
+	if outcome.AvgRTTMS > 0 {
+		return outcome.AvgRTTMS
+	}
+	return outcome.AvgResponseMS
+}
+
+// latencyRegressed reports whether the latency of after grew by more than
+// threshold percent and minMS milliseconds over that of before
+func latencyRegressed(before, after database.PairOutcome, threshold, minMS float64) bool {
+	// Round trip times are only compared with round trip times
+	if (before.AvgRTTMS > 0) != (after.AvgRTTMS > 0) {
+		return false
+	}
+	was, is := latency(before), latency(after)
+	return is-was > minMS && is > was*(1+threshold/100)
+}
+
+// pairChange describes a test from its outcomes in both runs, either of
+// which may be nil
+func pairChange(before, after *database.PairOutcome) PairChange {
+	outcome := before
+	if outcome == nil {
+		outcome = after
+	}
+	change := PairChange{
+		SourceHostname: outcome.SourceHostname,
+		TargetHostname: outcome.TargetHostname,
+		TargetIP:       outcome.TargetIP,
+		BondName:       outcome.BondName,
+		TestType:       outcome.TestType,
+		Port:           outcome.Port,
+	}
+	if before != nil {
+		change.Before = pairResult(*before)
+	}
+	if after != nil {
+		change.After = pairResult(*after)
+	}
+	return change
+}
+
+func pairResult(outcome database.PairOutcome) *PairResult {
+	return &PairResult{
+		Total:        outcome.Total,
+		Passed:       outcome.Passed,
+		LatencyMS:    latency(outcome),
+		ErrorMessage: outcome.ErrorMessage,
+	}
+}
+
+// floatParam parses a non-negative number, returning def for an empty value
+func floatParam(value string, def float64) (float64, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	// Written this way round to reject NaN as well
+	if err != nil || !(n >= 0) {
+		return 0, fmt.Errorf("must be a non-negative number")
+	}
+	return n, nil
+}
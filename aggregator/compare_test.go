@@ -0,0 +1,124 @@
+package aggregator
+
+import (
+	"testing"
+
+	"validate/database"
+)
+
+// outcome returns the outcome of an icmp test from web1 to web2
+func outcome(passed, total int, rttMS float64) *database.PairOutcome {
+	return &database.PairOutcome{
+		SourceHostname: "web1",
+		TargetHostname: "web2",
+		TargetIP:       "10.0.0.2",
+		TestType:       "icmp",
+		Total:          total,
+		Passed:         passed,
+		AvgRTTMS:       rttMS,
+	}
+}
+
+func TestCompareOutcomes(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after *database.PairOutcome
+		want          string
+	}{
+		{"unchanged pass", outcome(3, 3, 1), outcome(3, 3, 1.2), "unchanged"},
+		{"unchanged failure", outcome(1, 3, 1), outcome(2, 3, 1), "unchanged"},
+		{"newly failing", outcome(3, 3, 1), outcome(2, 3, 1), "newly_failing"},
+		{"newly passing", outcome(0, 3, 0), outcome(3, 3, 1), "newly_passing"},
+		{"latency regression", outcome(3, 3, 1), outcome(3, 3, 5), "latency_regressions"},
+		{"latency within threshold", outcome(3, 3, 10), outcome(3, 3, 14), "unchanged"},
+		{"latency below min_ms", outcome(3, 3, 0.2), outcome(3, 3, 0.9), "unchanged"},
+		{"failing slower is not a regression", outcome(1, 3, 1), outcome(1, 3, 5), "unchanged"},
+		{"missing", outcome(3, 3, 1), nil, "missing"},
+		{"added", nil, outcome(3, 3, 1), "added"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before, after []database.PairOutcome
+			if tt.before != nil {
+				before = append(before, *tt.before)
+			}
+			if tt.after != nil {
+				after = append(after, *tt.after)
+			}
+
+			comparison := compareOutcomes(before, after, defaultLatencyThreshold, defaultLatencyMinMS)
+			got := map[string]int{
+				"newly_failing":       len(comparison.NewlyFailing),
+				"newly_passing":       len(comparison.NewlyPassing),
+				"latency_regressions": len(comparison.LatencyRegressions),
+				"missing":             len(comparison.Missing),
+				"added":               len(comparison.Added),
+				"unchanged":           comparison.Unchanged,
+			}
+			for category, n := range got {
+				want := 0
+				if category == tt.want {
+					want = 1
+				}
+				if n != want {
+					t.Errorf("%s = %d, want %d", category, n, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareOutcomesMatchesTests(t *testing.T) {
+	// Tests are matched by source, target, address, type and port, not by
+	// their position in the run
+	udp := *outcome(3, 3, 1)
+	udp.TestType = "udp"
+	port := *outcome(1, 1, 0)
+	port.TestType, port.Port = "ports", 22
+	otherPort := port
+	otherPort.Port = 443
+
+	before := []database.PairOutcome{*outcome(3, 3, 1), udp, port}
+	after := []database.PairOutcome{otherPort, udp, *outcome(0, 3, 0)}
+	comparison := compareOutcomes(before, after, defaultLatencyThreshold, defaultLatencyMinMS)
+
+	if len(comparison.NewlyFailing) != 1 || comparison.NewlyFailing[0].TestType != "icmp" {
+		t.Errorf("newly failing = %+v, want the icmp test", comparison.NewlyFailing)
+	}
+	if len(comparison.Missing) != 1 || comparison.Missing[0].Port != 22 {
+		t.Errorf("missing = %+v, want port 22", comparison.Missing)
+	}
+	if len(comparison.Added) != 1 || comparison.Added[0].Port != 443 {
+		t.Errorf("added = %+v, want port 443", comparison.Added)
+	}
+	if comparison.Unchanged != 1 {
+		t.Errorf("unchanged = %d, want 1", comparison.Unchanged)
+	}
+}
+
+func TestLatencyRegressed(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after database.PairOutcome
+		threshold     float64
+		minMS         float64
+		want          bool
+	}{
+		{"slower round trips", database.PairOutcome{AvgRTTMS: 2}, database.PairOutcome{AvgRTTMS: 4}, 50, 1, true},
+		{"faster round trips", database.PairOutcome{AvgRTTMS: 4}, database.PairOutcome{AvgRTTMS: 2}, 50, 1, false},
+		{"at threshold", database.PairOutcome{AvgRTTMS: 10}, database.PairOutcome{AvgRTTMS: 15}, 50, 1, false},
+		{"below min_ms", database.PairOutcome{AvgRTTMS: 1}, database.PairOutcome{AvgRTTMS: 1.9}, 50, 1, false},
+		{"zero threshold", database.PairOutcome{AvgRTTMS: 10}, database.PairOutcome{AvgRTTMS: 11.5}, 0, 1, true},
+		{"slower responses", database.PairOutcome{AvgResponseMS: 10}, database.PairOutcome{AvgResponseMS: 30}, 50, 1, true},
+		{"round trip time against response time", database.PairOutcome{AvgRTTMS: 1}, database.PairOutcome{AvgResponseMS: 30}, 50, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latencyRegressed(tt.before, tt.after, tt.threshold, tt.minMS); got != tt.want {
+				t.Errorf("latencyRegressed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types pushed to dashboards
+const (
+	eventServer      = "server"       // a server registered or deregistered
+	eventTestResults = "test-results" // test results were saved
+	eventRun         = "run"          // a test run started or ended
+	eventResync      = "resync"       // the subscriber missed events and should reload
+)
+
+const (
+	eventBuffer      = 64               // events queued per subscriber before it misses some
+	eventKeepAlive   = 15 * time.Second // between comments keeping idle streams open
+	eventRetryMillis = 3000             // reconnection delay suggested to clients, in milliseconds
+)
+
+// serverEvent is the payload of server events
+type serverEvent struct {
+	Hostname string `json:"hostname"`
+	Action   string `json:"action"` // "registered", "heartbeat" or "deregistered"
+}
+
+// event is a server-sent event with a JSON payload
+type event struct {
+	name string
+	data []byte
+}
+
+// eventBroker fans out events to the subscribed event streams
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan event]bool // -> whether it missed events
+}
+
+// subscribe returns a channel receiving every event published from now on
+func (b *eventBroker) subscribe() chan event {
+	ch := make(chan event, eventBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan event]bool)
+	}
+	b.subscribers[ch] = false
+	return ch
+}
+
+// unsubscribe stops sending events to ch
+func (b *eventBroker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// publish sends an event to every subscriber. Slow subscribers do not hold
+// up the aggregator: events that do not fit in their queue are dropped, and
+// they are told to reload once there is room again.
+func (b *eventBroker) publish(name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", name, err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, missed := range b.subscribers {
+		if missed {
+			select {
+			case ch <- event{name: eventResync, data: []byte("{}")}:
+				b.subscribers[ch] = false
+			default:
+				continue
+			}
+		}
+		select {
+		case ch <- event{name: name, data: data}:
+		default:
+			b.subscribers[ch] = true
+		}
+	}
+}
+
+// Handler streaming registrations, test results and run updates as
+// server-sent events
+func (a *Aggregator) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, fmt.Sprintf("Streaming not supported: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	events := a.events.subscribe()
+	defer a.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.stop:
+			// Streams would keep a graceful shutdown waiting
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-events:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package aggregator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"validate/database"
+)
+
+// exportWriteTimeout is how long an export waits for a client to read more
+const exportWriteTimeout = 30 * time.Second
+
+// testResultCSVHeader names the columns of exported test results
+var testResultCSVHeader = []string{
+	"id", "run_id", "correlation_id", "tested_at", "source_hostname", "source_ip", "source_interface",
+	"target_hostname", "target_ip", "bond_name", "port", "test_type", "success", "response_time_ms",
+	"rtt_min_ms", "rtt_avg_ms", "rtt_max_ms", "packet_loss_percent", "p50_ms", "p95_ms", "p99_ms",
+	"throughput_mbps", "path_mtu", "slaves_up", "slaves_total", "clock_offset_ms", "tls_version",
+	"cert_not_after", "tcp_retransmits", "tcp_rtt_ms", "tcp_cwnd", "error_message",
+}
+
+// testResultCSVRecord returns the columns of an exported test result
+func testResultCSVRecord(result database.TestResult) []string {
+	certNotAfter := ""
+	if result.CertNotAfter != nil {
+		certNotAfter = result.CertNotAfter.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatInt(result.ID, 10),
+		result.RunID,
+		result.CorrelationID,
+		result.TestedAt.UTC().Format(time.RFC3339),
+		result.SourceHostname,
+		result.SourceIP,
+		result.SourceInterface,
+		result.TargetHostname,
+		result.TargetIP,
+		result.BondName,
+		strconv.Itoa(result.Port),
+		result.TestType,
+		strconv.FormatBool(result.Success),
+		strconv.FormatInt(result.ResponseTime, 10),
+		formatFloat(result.RTTMinMS),
+		formatFloat(result.RTTAvgMS),
+		formatFloat(result.RTTMaxMS),
+		formatFloat(result.PacketLoss),
+		formatFloat(result.P50MS),
+		formatFloat(result.P95MS),
+		formatFloat(result.P99MS),
+		formatFloat(result.ThroughputMbps),
+		strconv.Itoa(result.PathMTU),
+		strconv.Itoa(result.SlavesUp),
+		strconv.Itoa(result.SlavesTotal),
+		formatFloat(result.ClockOffsetMS),
+		result.TLSVersion,
+		certNotAfter,
+		strconv.Itoa(result.TCPRetransmits),
+		formatFloat(result.TCPRTTMS),
+		strconv.Itoa(result.TCPCwnd),
+		result.ErrorMessage,
+	}
+}
+
+// Handler exporting test results as CSV, taking the same query parameters
+// as GET /api/test-results
+func (a *Aggregator) handleTestResultsCSV(w http.ResponseWriter, r *http.Request) {
+	filter, found, ok := a.exportFilter(w, r)
+	if !ok {
+		return
+	}
+
+	writer := csvResponse(w, "test-results.csv")
+	writer.Write(testResultCSVHeader)
+	if found && !a.exportTestResults(w, filter, func(result database.TestResult) error {
+		return writer.Write(testResultCSVRecord(result))
+	}) {
+		return
+	}
+	writer.Flush()
+}
+
+// Handler streaming test results as newline delimited JSON, one result per
+// line, taking the same query parameters as GET /api/test-results. Results
+// are written as they are read from the database, so exports of any size
+// take little memory.
+func (a *Aggregator) handleTestResultsNDJSON(w http.ResponseWriter, r *http.Request) {
+	filter, found, ok := a.exportFilter(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if found {
+		encoder := json.NewEncoder(w)
+		a.exportTestResults(w, filter, func(result database.TestResult) error {
+			return encoder.Encode(result)
+		})
+	}
+}
+
+// exportFilter parses the filter of an export. found is false when it asks
+// for the latest run and there is none; ok is false when the request was
+// answered with an error.
+func (a *Aggregator) exportFilter(w http.ResponseWriter, r *http.Request) (filter database.TestResultFilter, found, ok bool) {
+	filter, err := testResultFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return filter, false, false
+	}
+	found, err = a.resolveLatestRun(&filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+		return filter, false, false
+	}
+	return filter, found, true
+}
+
+// exportTestResults passes the test results matching the filter to write
+// as they are read from the database, a page at a time. Clients that stop
+// reading for exportWriteTimeout are dropped, rather than the server's write
+// timeout bounding the whole export. It reports false when it failed,
+// answering with an error if nothing was written yet.
+func (a *Aggregator) exportTestResults(w http.ResponseWriter, filter database.TestResultFilter, write func(database.TestResult) error) bool {
+	rc := http.NewResponseController(w)
+	started := false
+	err := a.db.EachTestResult(filter, func(result database.TestResult) error {
+		started = true
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+			return err
+		}
+		return write(result)
+	})
+	if err == nil {
+		return true
+	}
+
+	if !started {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	// The status was sent with the first results, so the export just ends
+	log.Printf("Failed to export test results: %v", err)
+	return false
+}
+
+// Handler exporting a matrix of source hosts, one per row, and target
+// hosts, one per column, as CSV. Each cell holds the tests passed out of
+// those run, e.g. 12/14, and is empty for pairs without tests. It takes the
+// filters of GET /api/test-results.
+func (a *Aggregator) handleMatrixCSV(w http.ResponseWriter, r *http.Request) {
+	filter, found, ok := a.exportFilter(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		pairs []database.PairCount
+		err   error
+	)
+	if found {
+		if pairs, err = a.db.CountTestResultsByPair(filter); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count test results: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Pairs are sorted by source, so rows come out in order
+	var sources, targets []string
+	cells := make(map[[2]string]string, len(pairs))
+	for _, pair := range pairs {
+		if !slices.Contains(sources, pair.SourceHostname) {
+			sources = append(sources, pair.SourceHostname)
+		}
+		if !slices.Contains(targets, pair.TargetHostname) {
+			targets = append(targets, pair.TargetHostname)
+		}
+		cells[[2]string{pair.SourceHostname, pair.TargetHostname}] = fmt.Sprintf("%d/%d", pair.Passed, pair.Total)
+	}
+	slices.Sort(targets)
+
+	writer := csvResponse(w, "matrix.csv")
+	writer.Write(append([]string{"source \\ target"}, targets...))
+	for _, source := range sources {
+		record := []string{source}
+		for _, target := range targets {
+			record = append(record, cells[[2]string{source, target}])
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+}
+
+// csvResponse sets the headers of a CSV download and returns a writer for
+// its body
+func csvResponse(w http.ResponseWriter, filename string) *csv.Writer {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return csv.NewWriter(w)
+}
+
+// formatFloat formats a number without trailing zeros
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
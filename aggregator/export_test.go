@@ -0,0 +1,220 @@
+package aggregator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"validate/database"
+)
+
+// newTestAggregator returns an aggregator with an empty database holding
+// results, which is closed with the test
+func newTestAggregator(t *testing.T, results ...database.TestResult) *Aggregator {
+	t.Helper()
+	a, err := NewAggregator(0, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewAggregator() error = %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+
+	for _, result := range results {
+		if err := a.db.SaveTestResult(result); err != nil {
+			t.Fatalf("SaveTestResult() error = %v", err)
+		}
+	}
+	return a
+}
+
+// get requests target from handler served over HTTP, as exports need a
+// connection whose write deadline can be set
+func get(t *testing.T, handler http.HandlerFunc, target string) (*http.Response, string) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + target)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s error = %v", target, err)
+	}
+	return resp, string(body)
+}
+
+// exportedResults are saved with ids 1 to 3, in order
+var exportedResults = []database.TestResult{
+	{
+		RunID:          "run-1",
+		SourceHostname: "web1",
+		TargetHostname: "web2",
+		TargetIP:       "10.0.0.2",
+		BondName:       "bond0",
+		TestType:       "icmp",
+		Success:        true,
+		ResponseTime:   3,
+		RTTAvgMS:       0.25,
+		TestedAt:       time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+	},
+	{
+		RunID:          "run-1",
+		SourceHostname: "web1",
+		TargetHostname: "web2",
+		TargetIP:       "10.0.0.2",
+		BondName:       "bond0",
+		TestType:       "udp",
+		ErrorMessage:   "timeout, no reply",
+		TestedAt:       time.Date(2026, 3, 1, 7, 0, 1, 0, time.UTC),
+	},
+	{
+		RunID:          "run-2",
+		SourceHostname: "web2",
+		TargetHostname: "web1",
+		TargetIP:       "10.0.0.1",
+		BondName:       "bond0",
+		TestType:       "icmp",
+		Success:        true,
+		TestedAt:       time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestTestResultsCSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []database.TestResult
+		query      string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"all", exportedResults, "", http.StatusOK, []string{"3", "2", "1"}},
+		{"oldest first", exportedResults, "?order=asc", http.StatusOK, []string{"1", "2", "3"}},
+		{"filtered", exportedResults, "?test_type=icmp&source=web1", http.StatusOK, []string{"1"}},
+		{"latest run", exportedResults, "?run_id=latest", http.StatusOK, []string{"3"}},
+		{"no runs", nil, "?run_id=latest", http.StatusOK, nil},
+		{"limit and offset", exportedResults, "?limit=1&offset=1", http.StatusOK, []string{"2"}},
+		{"invalid query", exportedResults, "?order=sideways", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAggregator(t, tt.results...)
+			resp, body := get(t, a.handleTestResultsCSV, "/api/test-results.csv"+tt.query)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+			if err != nil {
+				t.Fatalf("parsing CSV error = %v", err)
+			}
+			if len(records) == 0 || !slices.Equal(records[0], testResultCSVHeader) {
+				t.Fatalf("header = %v, want %v", records, testResultCSVHeader)
+			}
+			var ids []string
+			for _, record := range records[1:] {
+				ids = append(ids, record[0])
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestTestResultCSVRecord(t *testing.T) {
+	a := newTestAggregator(t, exportedResults[:2]...)
+	_, body := get(t, a.handleTestResultsCSV, "/api/test-results.csv?order=asc")
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("records = %v, %v, want a header and 2 results", records, err)
+	}
+
+	columns := func(record []string) map[string]string {
+		m := make(map[string]string, len(record))
+		for i, name := range testResultCSVHeader {
+			m[name] = record[i]
+		}
+		return m
+	}
+	tests := []struct {
+		record []string
+		column string
+		want   string
+	}{
+		{records[1], "tested_at", "2026-03-01T07:00:00Z"},
+		{records[1], "success", "true"},
+		{records[1], "rtt_avg_ms", "0.25"},
+		{records[1], "cert_not_after", ""},
+		{records[2], "success", "false"},
+		{records[2], "error_message", "timeout, no reply"},
+	}
+	for _, tt := range tests {
+		if got := columns(tt.record)[tt.column]; got != tt.want {
+			t.Errorf("result %s %s = %q, want %q", tt.record[0], tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestTestResultsNDJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []database.TestResult
+		query      string
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{"all", exportedResults, "", http.StatusOK, []int64{3, 2, 1}},
+		{"filtered", exportedResults, "?success=false", http.StatusOK, []int64{2}},
+		{"latest run", exportedResults, "?run_id=latest&order=asc", http.StatusOK, []int64{3}},
+		{"no runs", nil, "?run_id=latest", http.StatusOK, nil},
+		{"invalid query", exportedResults, "?limit=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAggregator(t, tt.results...)
+			resp, body := get(t, a.handleTestResultsNDJSON, "/api/test-results.ndjson"+tt.query)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			// One result per line
+			var ids []int64
+			for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				var result database.TestResult
+				if err := json.Unmarshal([]byte(line), &result); err != nil {
+					t.Fatalf("line %q error = %v", line, err)
+				}
+				ids = append(ids, result.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package aggregator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"validate/database"
+)
+
+// metricsPrefix starts the name of every metric
+const metricsPrefix = "network_validator_"
+
+// Handler exposing metrics in the Prometheus text format
+func (a *Aggregator) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	servers, err := a.db.GetAllServers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get servers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	statuses := make(map[string]int)
+	now := time.Now()
+	for _, server := range servers {
+		statuses[a.serverStatus(server, now)]++
+	}
+
+	runID, err := a.db.LatestRunID()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get latest run: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var (
+		passed, failed int
+		pairs          []database.PairFailures
+	)
+	if runID != "" {
+		success := true
+		if passed, err = a.db.CountTestResults(database.TestResultFilter{RunID: runID, Success: &success}); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count test results: %v", err), http.StatusInternalServerError)
+			return
+		}
+		success = false
+		if failed, err = a.db.CountTestResults(database.TestResultFilter{RunID: runID, Success: &success}); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count test results: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if pairs, err = a.db.CountPairFailures(runID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count failures: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "agents_registered", "gauge", "Registered agents.")
+	fmt.Fprintf(w, "%sagents_registered %d\n", metricsPrefix, len(servers))
+
+	writeMetric(w, "agents", "gauge", "Registered agents, by status.")
+	for _, status := range []string{serverOnline, serverStale, serverOffline} {
+		fmt.Fprintf(w, "%sagents{status=%q} %d\n", metricsPrefix, status, statuses[status])
+	}
+
+	writeMetric(w, "last_run_tests", "gauge", "Tests of the latest run, by outcome.")
+	fmt.Fprintf(w, "%slast_run_tests{outcome=\"passed\"} %d\n", metricsPrefix, passed)
+	fmt.Fprintf(w, "%slast_run_tests{outcome=\"failed\"} %d\n", metricsPrefix, failed)
+
+	// Without results there is no ratio to report
+	writeMetric(w, "last_run_success_ratio", "gauge", "Share of the tests of the latest run that passed.")
+	if passed+failed > 0 {
+		fmt.Fprintf(w, "%slast_run_success_ratio %g\n", metricsPrefix, float64(passed)/float64(passed+failed))
+	}
+
+	writeMetric(w, "pair_failures", "gauge", "Failed tests of the latest run from a source host to a target host's link, by test type.")
+	for _, pair := range pairs {
+		fmt.Fprintf(w, "%spair_failures{source=%s,target=%s,link=%s,test_type=%s} %d\n", metricsPrefix,
+			labelValue(pair.SourceHostname), labelValue(pair.TargetHostname), labelValue(pair.BondName), labelValue(pair.TestType), pair.Failures)
+	}
+
+	writeMetric(w, "result_submissions_total", "counter", "Test result submissions received from agents since the aggregator started.")
+	fmt.Fprintf(w, "%sresult_submissions_total %d\n", metricsPrefix, a.resultSubmissions.Load())
+
+	writeMetric(w, "results_received_total", "counter", "Test results received from agents since the aggregator started.")
+	fmt.Fprintf(w, "%sresults_received_total %d\n", metricsPrefix, a.resultsReceived.Load())
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, metricType)
+}
+
+// labelValue returns s as a quoted label value
+func labelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,51 @@
+package aggregator
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sysinfoProxyTimeout bounds fetching system info from an agent
+const sysinfoProxyTimeout = 10 * time.Second
+
+// Handler fetching the live system info of a registered server from its
+// agent, so operators only need to reach the aggregator
+func (a *Aggregator) handleServerSysinfo(w http.ResponseWriter, r *http.Request) {
+	hostname := r.PathValue("hostname")
+
+	server, err := a.db.GetServer(hostname)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get server: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if server == nil {
+		http.Error(w, fmt.Sprintf("Server %s is not registered", hostname), http.StatusNotFound)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.agentURL(*server, "/api/sysinfo"), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp, err := a.auth.Client(sysinfoProxyTimeout).Do(req)
+	if err != nil {
+		log.Printf("Failed to get system info from %s (%s): %v", server.Hostname, server.IPAddress, err)
+		http.Error(w, fmt.Sprintf("Failed to reach agent %s: %v", server.Hostname, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, fmt.Sprintf("Agent %s returned status %d", server.Hostname, resp.StatusCode), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Failed to relay system info of %s: %v", server.Hostname, err)
+	}
+}
@@ -0,0 +1,54 @@
+package aggregator
+
+import (
+	"log"
+	"time"
+)
+
+// pruneInterval is the time between prunings of old test results
+const pruneInterval = time.Hour
+
+// SetRetention sets how long test results are kept and how many of them at
+// most. Zero keeps them forever, or without limit.
+func (a *Aggregator) SetRetention(maxAge time.Duration, maxResults int) {
+	a.retention = maxAge
+	a.maxResults = maxResults
+}
+
+// pruneResults deletes the test results that are too old or too many
+func (a *Aggregator) pruneResults() {
+	if a.retention > 0 {
+		deleted, err := a.db.DeleteTestResultsBefore(time.Now().Add(-a.retention))
+		if err != nil {
+			log.Printf("Failed to delete old test results: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Deleted %d test results older than %v", deleted, a.retention)
+		}
+	}
+	if a.maxResults > 0 {
+		deleted, err := a.db.TrimTestResults(a.maxResults)
+		if err != nil {
+			log.Printf("Failed to trim test results: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Deleted %d test results beyond the newest %d", deleted, a.maxResults)
+		}
+	}
+}
+
+// watchRetention prunes test results right away and then every
+// pruneInterval, until stop is closed
+func (a *Aggregator) watchRetention(stop <-chan struct{}) {
+	a.pruneResults()
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.pruneResults()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"validate/agent"
+	"validate/database"
+)
+
+const (
+	runPollInterval = 5 * time.Second // between checks of the agents' progress
+	runPollFailures = 3               // failed checks in a row that give up on an agent
+	runWatchTimeout = 12 * time.Hour  // longest a run is followed before it is failed
+)
+
+// watchRun follows the progress of a run on the agents it was triggered on,
+// and records whether it completed once none of them is running it anymore.
+// Agents that were cancelled, or stopped answering, fail the run.
+func (a *Aggregator) watchRun(runID string, servers []database.ServerRegistration) {
+	client := a.auth.Client(5 * time.Second)
+	failures := make(map[string]int)
+	pending := servers
+	problems := []string{}
+	deadline := time.Now().Add(runWatchTimeout)
+
+	ticker := time.NewTicker(runPollInterval)
+	defer ticker.Stop()
+
+	for len(pending) > 0 {
+		if time.Now().After(deadline) {
+			problems = append(problems, fmt.Sprintf("%d agents still running after %v", len(pending), runWatchTimeout))
+			break
+		}
+		<-ticker.C
+
+		var running []database.ServerRegistration
+		for _, server := range pending {
+			progress, err := a.agentProgress(client, server)
+			if err != nil {
+				failures[server.Hostname]++
+				if failures[server.Hostname] >= runPollFailures {
+					log.Printf("Run %s: giving up on %s: %v", runID, server.Hostname, err)
+					problems = append(problems, fmt.Sprintf("%s: %v", server.Hostname, err))
+					continue
+				}
+				running = append(running, server)
+				continue
+			}
+			failures[server.Hostname] = 0
+
+			// A newer run replaces this one on the agent
+			if progress.RunID != runID {
+				continue
+			}
+			switch progress.State {
+			case agent.RunStateRunning:
+				running = append(running, server)
+			case agent.RunStateCancelled:
+				problems = append(problems, fmt.Sprintf("%s: cancelled", server.Hostname))
+			}
+		}
+		pending = running
+	}
+
+	status := database.RunStatusComplete
+	errorMessage := ""
+	if len(problems) > 0 {
+		status = database.RunStatusFailed
+		errorMessage = strings.Join(problems, "; ")
+	}
+	if err := a.db.FinishRun(runID, status, errorMessage); err != nil {
+		log.Printf("Failed to record the end of run %s: %v", runID, err)
+		return
+	}
+	log.Printf("Test run %s %s", runID, status)
+	a.publishRun(runID)
+	a.alertRunEnded(runID, status, errorMessage)
+}
+
+// agentProgress asks a registered agent how far its current test run has got
+func (a *Aggregator) agentProgress(client *http.Client, server database.ServerRegistration) (agent.TestProgress, error) {
+	var progress agent.TestProgress
+
+	resp, err := client.Get(a.agentURL(server, "/api/test-progress"))
+	if err != nil {
+		return progress, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return progress, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&progress); err != nil {
+		return progress, fmt.Errorf("invalid progress: %w", err)
+	}
+	return progress, nil
+}
+
+// Handler to list test runs, most recent first
+func (a *Aggregator) handleGetRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
+
+	runs, err := a.db.GetRuns(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get runs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// Handler to get a single test run
+func (a *Aggregator) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	run, err := a.db.GetRun(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get run: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if run == nil {
+		http.Error(w, fmt.Sprintf("Run %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
@@ -0,0 +1,120 @@
+package aggregator
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+
+	"validate/agent"
+)
+
+// RunRequest is the body of POST /api/run-tests: what is sent to each agent,
+// minus the targets the aggregator fills in, and which agents, links and
+// subnets take part. Empty selections take part in full.
+type RunRequest struct {
+	agent.TestRequest
+
+	Sources []string `json:"sources,omitempty"` // hostname globs of the agents running tests
+	Targets []string `json:"targets,omitempty"` // hostname globs of the agents tested
+	Links   []string `json:"links,omitempty"`   // bonds or links of the targets tested
+	Subnets []string `json:"subnets,omitempty"` // CIDRs the target addresses tested lie in
+}
+
+// Validate checks the test request and the selection
+func (r RunRequest) Validate() error {
+	if err := r.TestRequest.Validate(); err != nil {
+		return err
+	}
+	for _, pattern := range slices.Concat(r.Sources, r.Targets) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid hostname pattern %q: %w", pattern, err)
+		}
+	}
+	for _, subnet := range r.Subnets {
+		if _, err := netip.ParsePrefix(subnet); err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+	}
+	// Each pair is tested by one agent the other does not know about
+	if r.Deduplicate && (len(r.Sources) > 0 || len(r.Targets) > 0) {
+		return fmt.Errorf("deduplicate cannot be combined with sources or targets")
+	}
+	return nil
+}
+
+// selectsTargets reports whether the request narrows down what is tested
+func (r RunRequest) selectsTargets() bool {
+	return len(r.Targets) > 0 || len(r.Links) > 0 || len(r.Subnets) > 0
+}
+
+// isSource reports whether the agent on hostname runs tests
+func (r RunRequest) isSource(hostname string) bool {
+	return matchesAny(r.Sources, hostname)
+}
+
+// isTarget reports whether the agent on hostname is tested
+func (r RunRequest) isTarget(hostname string) bool {
+	return matchesAny(r.Targets, hostname)
+}
+
+// selectLinks returns the links of a target and their addresses that are
+// tested, leaving out links without any
+func (r RunRequest) selectLinks(links map[string][]string) map[string][]string {
+	if len(r.Links) == 0 && len(r.Subnets) == 0 {
+		return links
+	}
+
+	// Subnets were checked by Validate
+	var subnets []netip.Prefix
+	for _, subnet := range r.Subnets {
+		prefix, _ := netip.ParsePrefix(subnet)
+		subnets = append(subnets, prefix.Masked())
+	}
+
+	selected := make(map[string][]string)
+	for link, ips := range links {
+		if len(r.Links) > 0 && !slices.Contains(r.Links, link) {
+			continue
+		}
+		for _, ip := range ips {
+			if inSubnets(subnets, ip) {
+				selected[link] = append(selected[link], ip)
+			}
+		}
+	}
+	return selected
+}
+
+// matchesAny reports whether hostname matches one of patterns, or whether
+// there are none
+func matchesAny(patterns []string, hostname string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, hostname); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// inSubnets reports whether ip lies in one of subnets, or whether there
+// are none
+func inSubnets(subnets []netip.Prefix, ip string) bool {
+	if len(subnets) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, subnet := range subnets {
+		if subnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
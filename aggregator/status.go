@@ -0,0 +1,58 @@
+package aggregator
+
+import (
+	"time"
+
+	"validate/database"
+)
+
+// Statuses of registered servers
+const (
+	serverOnline  = "online"
+	serverStale   = "stale"
+	serverOffline = "offline"
+)
+
+// defaultRegisterInterval is assumed for agents that predate reporting
+// their register interval, and matches the agent's default
+const defaultRegisterInterval = 5 * time.Minute
+
+// offlineFactor is how many times longer than the stale threshold a server
+// may go unseen before it is offline
+const offlineFactor = 3
+
+// serverStaleAfter returns how long server may go without registering or
+// sending a heartbeat before it is stale: stale_after when configured, else
+// twice its register interval, so a single late heartbeat is tolerated
+func (a *Aggregator) serverStaleAfter(server database.ServerRegistration) time.Duration {
+	if a.staleAfter > 0 {
+		return a.staleAfter
+	}
+	interval := time.Duration(server.RegisterInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultRegisterInterval
+	}
+	return 2 * interval
+}
+
+// serverStatus tells whether server is online, stale or offline at now
+func (a *Aggregator) serverStatus(server database.ServerRegistration, now time.Time) string {
+	unseen := now.Sub(server.LastSeen)
+	staleAfter := a.serverStaleAfter(server)
+	switch {
+	case unseen > offlineFactor*staleAfter:
+		return serverOffline
+	case unseen > staleAfter:
+		return serverStale
+	default:
+		return serverOnline
+	}
+}
+
+// setServerStatuses fills in the status of each server
+func (a *Aggregator) setServerStatuses(servers []database.ServerRegistration) {
+	now := time.Now()
+	for i := range servers {
+		servers[i].Status = a.serverStatus(servers[i], now)
+	}
+}
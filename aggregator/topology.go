@@ -0,0 +1,297 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"validate/database"
+)
+
+// Topology node and edge types
+const (
+	nodeHost   = "host"
+	nodeSubnet = "subnet"
+
+	edgeMember = "member" // a host has an address in a subnet
+	edgeTested = "tested" // a host tested another's link
+)
+
+// Statuses of tested edges
+const (
+	linkOK       = "ok"       // every test passed
+	linkDegraded = "degraded" // some tests failed
+	linkFailed   = "failed"   // every test failed
+)
+
+// Registrations carry addresses without their prefix length, so hosts are
+// grouped into subnets of this size unless the request sets another
+const (
+	defaultPrefix4 = 24
+	defaultPrefix6 = 64
+)
+
+// Topology is the graph of the registered hosts, the subnets their links
+// are in, and the links tested between them in a run
+type Topology struct {
+	RunID string         `json:"run_id,omitempty"`
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+// TopologyNode is a host or a subnet
+type TopologyNode struct {
+	ID    string              `json:"id"` // "host:<hostname>" or "subnet:<cidr>"
+	Type  string              `json:"type"`
+	Label string              `json:"label"`
+	Links map[string][]string `json:"links,omitempty"` // hosts only: link -> addresses
+}
+
+// TopologyEdge connects a host to a subnet it is in, or a testing host to
+// the host whose link it tested
+type TopologyEdge struct {
+	Source          string   `json:"source"`
+	Target          string   `json:"target"`
+	Type            string   `json:"type"`
+	Link            string   `json:"link"`                // member: the host's link; tested: the target's link
+	Addresses       []string `json:"addresses,omitempty"` // member only
+	Status          string   `json:"status,omitempty"`    // tested only
+	Passed          int      `json:"passed,omitempty"`
+	Failed          int      `json:"failed,omitempty"`
+	FailedTestTypes []string `json:"failed_test_types,omitempty"`
+}
+
+// Handler returning the cluster topology as JSON, or as graphviz DOT with
+// format=dot. Tested links come from the run in run_id, the latest by
+// default, and prefix4 and prefix6 set the size of the subnets.
+func (a *Aggregator) handleTopology(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	prefix4, err := prefixParam(query, "prefix4", defaultPrefix4, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
+	prefix6, err := prefixParam(query, "prefix6", defaultPrefix6, 128)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		http.Error(w, "Invalid query: format must be 'json' or 'dot'", http.StatusBadRequest)
+		return
+	}
+
+	servers, err := a.db.GetAllServers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get servers: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	runID := query.Get("run_id")
+	if runID == "" || runID == "latest" {
+		if runID, err = a.db.LatestRunID(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	var results []database.TestResult
+	if runID != "" {
+		if results, err = a.db.FindTestResults(database.TestResultFilter{RunID: runID}); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get test results: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	topo := buildTopology(servers, results, prefix4, prefix6)
+	topo.RunID = runID
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, topo.DOT())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topo)
+}
+
+// prefixParam parses an optional prefix length query parameter
+func prefixParam(query url.Values, name string, def, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%s must be between 0 and %d", name, max)
+	}
+	return n, nil
+}
+
+// buildTopology builds the graph of the servers, the subnets of their links
+// and the links tested by the results
+func buildTopology(servers []database.ServerRegistration, results []database.TestResult, prefix4, prefix6 int) Topology {
+	topo := Topology{Nodes: []TopologyNode{}, Edges: []TopologyEdge{}}
+	subnets := make(map[string]bool)
+	hosts := make(map[string]bool)
+
+	for _, server := range servers {
+		links, err := serverLinks(server)
+		if err != nil {
+			links = nil
+		}
+		hostID := "host:" + server.Hostname
+		hosts[server.Hostname] = true
+		topo.Nodes = append(topo.Nodes, TopologyNode{ID: hostID, Type: nodeHost, Label: server.Hostname, Links: links})
+
+		for _, link := range sortedKeys(links) {
+			// Addresses of a link in the same subnet share an edge
+			bySubnet := make(map[string][]string)
+			for _, addr := range links[link] {
+				subnet := subnetOf(addr, prefix4, prefix6)
+				if subnet == "" {
+					continue
+				}
+				bySubnet[subnet] = append(bySubnet[subnet], addr)
+			}
+			for _, subnet := range sortedKeys(bySubnet) {
+				subnets[subnet] = true
+				topo.Edges = append(topo.Edges, TopologyEdge{
+					Source:    hostID,
+					Target:    "subnet:" + subnet,
+					Type:      edgeMember,
+					Link:      link,
+					Addresses: bySubnet[subnet],
+				})
+			}
+		}
+	}
+	for _, subnet := range sortedKeys(subnets) {
+		topo.Nodes = append(topo.Nodes, TopologyNode{ID: "subnet:" + subnet, Type: nodeSubnet, Label: subnet})
+	}
+
+	// Tested links between registered hosts, keyed by source, target and link
+	type linkKey struct{ source, target, link string }
+	tested := make(map[linkKey]*TopologyEdge)
+	var keys []linkKey
+	for _, result := range results {
+		if !hosts[result.SourceHostname] || !hosts[result.TargetHostname] || result.SourceHostname == result.TargetHostname {
+			continue
+		}
+		key := linkKey{result.SourceHostname, result.TargetHostname, result.BondName}
+		edge, ok := tested[key]
+		if !ok {
+			edge = &TopologyEdge{
+				Source: "host:" + key.source,
+				Target: "host:" + key.target,
+				Type:   edgeTested,
+				Link:   key.link,
+			}
+			tested[key] = edge
+			keys = append(keys, key)
+		}
+		if result.Success {
+			edge.Passed++
+		} else {
+			edge.Failed++
+			if !slices.Contains(edge.FailedTestTypes, result.TestType) {
+				edge.FailedTestTypes = append(edge.FailedTestTypes, result.TestType)
+			}
+		}
+	}
+	slices.SortFunc(keys, func(x, y linkKey) int {
+		return strings.Compare(x.source+"\x00"+x.target+"\x00"+x.link, y.source+"\x00"+y.target+"\x00"+y.link)
+	})
+	for _, key := range keys {
+		edge := tested[key]
+		switch {
+		case edge.Failed == 0:
+			edge.Status = linkOK
+		case edge.Passed == 0:
+			edge.Status = linkFailed
+		default:
+			edge.Status = linkDegraded
+		}
+		slices.Sort(edge.FailedTestTypes)
+		topo.Edges = append(topo.Edges, *edge)
+	}
+
+	return topo
+}
+
+// subnetOf returns the subnet of the given size an address is in, or "" for
+// invalid and link-local addresses
+func subnetOf(addr string, prefix4, prefix6 int) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsLinkLocalUnicast() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(prefix4, 32)), Mask: net.CIDRMask(prefix4, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(prefix6, 128)), Mask: net.CIDRMask(prefix6, 128)}).String()
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// dotColors maps the status of tested links to graphviz edge colors
+var dotColors = map[string]string{
+	linkOK:       "darkgreen",
+	linkDegraded: "orange",
+	linkFailed:   "red",
+}
+
+// DOT renders the topology as a graphviz digraph. Hosts are boxes and
+// subnets ellipses; tested links are colored by their status.
+func (t Topology) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph topology {\n")
+	sb.WriteString("\tnode [fontname=\"monospace\"];\n")
+
+	for _, node := range t.Nodes {
+		shape := "box"
+		if node.Type == nodeSubnet {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&sb, "\t%s [label=%s, shape=%s];\n", dotQuote(node.ID), dotQuote(node.Label), shape)
+	}
+
+	for _, edge := range t.Edges {
+		if edge.Type == edgeMember {
+			fmt.Fprintf(&sb, "\t%s -> %s [label=%s, style=dashed, arrowhead=none];\n",
+				dotQuote(edge.Source), dotQuote(edge.Target), dotQuote(edge.Link))
+			continue
+		}
+		label := fmt.Sprintf("%s\n%d/%d passed", edge.Link, edge.Passed, edge.Passed+edge.Failed)
+		if len(edge.FailedTestTypes) > 0 {
+			label += "\nfailed: " + strings.Join(edge.FailedTestTypes, ", ")
+		}
+		fmt.Fprintf(&sb, "\t%s -> %s [label=%s, color=%s];\n",
+			dotQuote(edge.Source), dotQuote(edge.Target), dotQuote(label), dotColors[edge.Status])
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// dotQuote returns s as a double-quoted DOT identifier
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"validate/database"
+)
+
+const (
+	defaultTrendWindow = 7 * 24 * time.Hour
+	defaultTrendBucket = time.Hour
+	maxTrendBuckets    = 20000 // per series
+)
+
+// Trends are success rates and latencies of test results over time
+type Trends struct {
+	Since  time.Time     `json:"since"`
+	Bucket string        `json:"bucket"`
+	Series []TrendSeries `json:"series"`
+}
+
+// TrendSeries are the buckets of a pair, or of a link of every host
+type TrendSeries struct {
+	SourceHostname string       `json:"source_hostname,omitempty"` // pairs only
+	TargetHostname string       `json:"target_hostname,omitempty"` // pairs only
+	BondName       string       `json:"bond_name"`
+	Points         []TrendPoint `json:"points"`
+}
+
+// TrendPoint aggregates the test results of a bucket. Buckets without
+// results are left out.
+type TrendPoint struct {
+	Start             time.Time `json:"start"`
+	Total             int       `json:"total"`
+	Passed            int       `json:"passed"`
+	SuccessRate       float64   `json:"success_rate"` // percent
+	AvgResponseTimeMS float64   `json:"avg_response_time_ms"`
+	AvgRTTMS          float64   `json:"avg_rtt_ms,omitempty"`
+	MaxRTTMS          float64   `json:"max_rtt_ms,omitempty"`
+}
+
+// Handler returning success rates and latencies over time, per pair or,
+// with group=link, per link. window (default 7d) and bucket (default 1h)
+// take Go durations and days such as 7d; the filters of the test results
+// API narrow down the results, and since overrides the window.
+func (a *Aggregator) handleTrends(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := testResultFilter(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
+	window, err := spanParam(query.Get("window"), defaultTrendWindow)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: window %v", err), http.StatusBadRequest)
+		return
+	}
+	bucket, err := spanParam(query.Get("bucket"), defaultTrendBucket)
+	if err != nil || bucket < time.Second {
+		http.Error(w, "Invalid query: bucket must be a duration of at least 1s, such as 1h or 1d", http.StatusBadRequest)
+		return
+	}
+	if filter.Since.IsZero() {
+		filter.Since = time.Now().Add(-window)
+	}
+	until := filter.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if until.Sub(filter.Since)/bucket > maxTrendBuckets {
+		http.Error(w, fmt.Sprintf("Invalid query: more than %d buckets, use a larger bucket", maxTrendBuckets), http.StatusBadRequest)
+		return
+	}
+
+	var byLink bool
+	switch query.Get("group") {
+	case "", "pair":
+	case "link":
+		byLink = true
+	default:
+		http.Error(w, "Invalid query: group must be 'pair' or 'link'", http.StatusBadRequest)
+		return
+	}
+
+	if filter.RunID == "latest" {
+		if filter.RunID, err = a.db.LatestRunID(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get trends: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	points, err := a.db.TestResultTrends(filter, bucket, byLink)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get trends: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Trends{
+		Since:  filter.Since.UTC(),
+		Bucket: bucket.String(),
+		Series: trendSeries(points),
+	})
+}
+
+// trendSeries groups the points, which are ordered by series, into series
+func trendSeries(points []database.TrendPoint) []TrendSeries {
+	series := []TrendSeries{}
+	for _, p := range points {
+		n := len(series)
+		if n == 0 || series[n-1].SourceHostname != p.SourceHostname || series[n-1].TargetHostname != p.TargetHostname || series[n-1].BondName != p.BondName {
+			series = append(series, TrendSeries{SourceHostname: p.SourceHostname, TargetHostname: p.TargetHostname, BondName: p.BondName})
+			n++
+		}
+		series[n-1].Points = append(series[n-1].Points, TrendPoint{
+			Start:             p.BucketStart,
+			Total:             p.Total,
+			Passed:            p.Passed,
+			SuccessRate:       float64(p.Passed) / float64(p.Total) * 100,
+			AvgResponseTimeMS: p.AvgResponseTimeMS,
+			AvgRTTMS:          p.AvgRTTMS,
+			MaxRTTMS:          p.MaxRTTMS,
+		})
+	}
+	return series
+}
+
+// spanParam parses a positive duration, which may also be given in days
+// such as "7d", or returns def for an empty value
+func spanParam(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("must be a positive duration such as 1h or 7d")
+	}
+	return d, nil
+}
@@ -0,0 +1,182 @@
+// Package auth authenticates the traffic between agents and the aggregator
+// with a shared bearer token and/or mutual TLS, and operators triggering
+// runs with bearer tokens of their own. It also serves and reaches peers
+// over TLS when certificates are configured.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"validate/config"
+)
+
+// DialFunc opens the connections of an HTTP client, like
+// net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Auth holds the credentials shared by agents and the aggregator, and those
+// of operators. The zero value disables authentication and uses plain HTTP.
+type Auth struct {
+	token          string
+	cert           *tls.Certificate
+	ca             *x509.CertPool
+	operatorTokens []string
+}
+
+// New loads the credentials described by cfg and the certificates of tlsCfg
+func New(cfg config.AuthConfig, tlsCfg config.TLSConfig) (*Auth, error) {
+	a := &Auth{token: cfg.Token}
+
+	for _, token := range cfg.OperatorTokens {
+		if token == "" {
+			return nil, fmt.Errorf("operator_tokens must not be empty")
+		}
+		if token == cfg.Token {
+			return nil, fmt.Errorf("operator_tokens must differ from the agents' token")
+		}
+		a.operatorTokens = append(a.operatorTokens, token)
+	}
+
+	if err := a.loadTLS(tlsCfg); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Client returns an HTTP client that presents the configured credentials
+func (a *Auth) Client(timeout time.Duration) *http.Client {
+	return a.client(timeout, a.ClientTLSConfig(), nil)
+}
+
+// client returns an HTTP client that presents the bearer token, uses
+// tlsConfig and opens connections with dial, unless it is nil
+func (a *Auth) client(timeout time.Duration, tlsConfig *tls.Config, dial DialFunc) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if dial != nil {
+		transport.DialContext = dial
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &tokenTransport{token: a.token, next: transport},
+	}
+}
+
+// Require rejects requests without the bearer token or, when a CA is
+// configured, without a verified client certificate
+func (a *Auth) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.ca != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		if a.token != "" && !bearerMatches(r, []string{a.token}) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireOperator rejects requests without one of the operator tokens.
+// Operators are not asked for client certificates, so runs can be triggered
+// from a browser. Without operator tokens the agents' credentials are
+// required like Require does, so configuring only those does not leave runs
+// open to anyone.
+func (a *Auth) RequireOperator(next http.HandlerFunc) http.HandlerFunc {
+	if len(a.operatorTokens) == 0 {
+		return a.Require(next)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearerMatches(r, a.operatorTokens) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing operator token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireOperatorOrSelf lets through requests with one of the operator
+// tokens, and requests with the agents' credentials, checked like Require
+// does, when self reports that they come from the agent the request is
+// about. Agents cannot act on other agents, even without operator tokens.
+func (a *Auth) RequireOperatorOrSelf(self func(*http.Request) bool, next http.HandlerFunc) http.HandlerFunc {
+	agent := a.Require(func(w http.ResponseWriter, r *http.Request) {
+		if !self(r) {
+			http.Error(w, "agents may only act on their own registration", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Without any credentials there is no telling agents and operators apart
+		open := a.token == "" && a.ca == nil && len(a.operatorTokens) == 0
+		if open || (len(a.operatorTokens) > 0 && bearerMatches(r, a.operatorTokens)) {
+			next(w, r)
+			return
+		}
+		agent(w, r)
+	}
+}
+
+// PeerNames returns the common name, DNS names and IP addresses of the
+// request's verified client certificate, or nil without one
+func PeerNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// bearerMatches reports whether the request carries one of the tokens as
+// its bearer token
+func bearerMatches(r *http.Request, tokens []string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	matched := false
+	for _, want := range tokens {
+		// Compare against every token so timing does not tell which matched
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// tokenTransport adds the bearer token to outgoing requests
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"validate/config"
+)
+
+func TestRequireToken(t *testing.T) {
+	a, err := New(config.AuthConfig{Token: "secret"}, config.TLSConfig{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	handler := a.Require(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic secret", http.StatusUnauthorized},
+		{"valid", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/run-tests", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireDisabled(t *testing.T) {
+	a := &Auth{}
+	handler := a.Require(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/api/server", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if a.Scheme() != "http" || a.ServerTLSConfig() != nil {
+		t.Errorf("zero Auth should use plain HTTP")
+	}
+}
+
+func TestClientSendsToken(t *testing.T) {
+	a, err := New(config.AuthConfig{Token: "secret"}, config.TLSConfig{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	srv := httptest.NewServer(a.Require(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	resp, err := a.Client(5*time.Second).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.AuthConfig
+	}{
+		{"empty operator token", config.AuthConfig{OperatorTokens: []string{""}}},
+		{"operator token reused", config.AuthConfig{Token: "secret", OperatorTokens: []string{"secret"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg, config.TLSConfig{}); err == nil {
+				t.Error("New() expected error")
+			}
+		})
+	}
+}
+
+func TestRequireOperator(t *testing.T) {
+	a, err := New(config.AuthConfig{Token: "agent-secret", OperatorTokens: []string{"alice", "ci"}}, config.TLSConfig{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	handler := a.RequireOperator(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"agent token", "Bearer agent-secret", http.StatusUnauthorized},
+		{"first operator", "Bearer alice", http.StatusOK},
+		{"second operator", "Bearer ci", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/runs", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Operator tokens do not authenticate agents
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/server", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	a.Require(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("agent endpoint status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireOperatorOrSelf(t *testing.T) {
+	withOperators := config.AuthConfig{Token: "agent-secret", OperatorTokens: []string{"alice"}}
+	agentOnly := config.AuthConfig{Token: "agent-secret"}
+
+	tests := []struct {
+		name   string
+		cfg    config.AuthConfig
+		header string
+		self   bool
+		want   int
+	}{
+		{"missing", withOperators, "", true, http.StatusUnauthorized},
+		{"wrong token", withOperators, "Bearer wrong", true, http.StatusUnauthorized},
+		{"agent itself", withOperators, "Bearer agent-secret", true, http.StatusOK},
+		{"agent other host", withOperators, "Bearer agent-secret", false, http.StatusForbidden},
+		{"operator other host", withOperators, "Bearer alice", false, http.StatusOK},
+		{"agent only itself", agentOnly, "Bearer agent-secret", true, http.StatusOK},
+		{"agent only other host", agentOnly, "Bearer agent-secret", false, http.StatusForbidden},
+		{"no auth", config.AuthConfig{}, "", false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.cfg, config.TLSConfig{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			self := func(r *http.Request) bool { return tt.self }
+			handler := a.RequireOperatorOrSelf(self, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/server/web1", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireOperatorWithoutOperatorTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.AuthConfig
+		header string
+		want   int
+	}{
+		{"agent token missing", config.AuthConfig{Token: "agent-secret"}, "", http.StatusUnauthorized},
+		{"agent token wrong", config.AuthConfig{Token: "agent-secret"}, "Bearer wrong", http.StatusUnauthorized},
+		{"agent token", config.AuthConfig{Token: "agent-secret"}, "Bearer agent-secret", http.StatusOK},
+		{"no auth", config.AuthConfig{}, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.cfg, config.TLSConfig{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			handler := a.RequireOperator(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/runs", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerNames(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "web1"},
+		DNSNames:    []string{"web1.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  []string
+	}{
+		{"plain HTTP", nil, nil},
+		{"no client certificate", &tls.ConnectionState{}, nil},
+		{"verified", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}, []string{"web1", "web1.example.com", "10.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/server/web1", nil)
+			req.TLS = tt.state
+			if got := PeerNames(req); !slices.Equal(got, tt.want) {
+				t.Errorf("PeerNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
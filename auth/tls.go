@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"validate/config"
+)
+
+// loadTLS loads the certificate served and presented to peers and, for
+// mutual TLS, the CA their certificates are verified against
+func (a *Auth) loadTLS(cfg config.TLSConfig) error {
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return fmt.Errorf("tls cert and key must be set together")
+	}
+	if cfg.ClientCA != "" && cfg.Cert == "" {
+		return fmt.Errorf("tls client_ca requires cert and key")
+	}
+
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		a.cert = &cert
+	}
+
+	if cfg.ClientCA != "" {
+		data, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		a.ca = x509.NewCertPool()
+		if !a.ca.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+		}
+	}
+
+	return nil
+}
+
+// TLSEnabled reports whether servers use HTTPS
+func (a *Auth) TLSEnabled() bool {
+	return a.cert != nil
+}
+
+// Scheme returns the URL scheme peers are reached with
+func (a *Auth) Scheme() string {
+	if a.TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// ServerTLSConfig returns the TLS configuration for servers, or nil when
+// TLS is disabled. Client certificates are verified when presented, and
+// required by Require, so unauthenticated endpoints such as the dashboard
+// stay reachable from a browser.
+func (a *Auth) ServerTLSConfig() *tls.Config {
+	if !a.TLSEnabled() {
+		return nil
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{*a.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if a.ca != nil {
+		cfg.ClientCAs = a.ca
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+// ClientTLSConfig returns the TLS configuration for clients, presenting
+// this host's certificate, or nil when TLS is disabled
+func (a *Auth) ClientTLSConfig() *tls.Config {
+	if !a.TLSEnabled() {
+		return nil
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*a.cert},
+		RootCAs:      a.ca,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// LinkClient returns an HTTP client like Client for requests to other agents
+// by the addresses of their links, which their certificates do not name. The
+// peer's certificate chain is still verified, against the CA if one is set,
+// but not its host name. Connections are opened with dial, e.g. to bind them
+// to the link, or the default dialer when it is nil.
+func (a *Auth) LinkClient(timeout time.Duration, dial DialFunc) *http.Client {
+	tlsConfig := a.ClientTLSConfig()
+	if tlsConfig != nil {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = a.verifyChain
+	}
+	return a.client(timeout, tlsConfig, dial)
+}
+
+// verifyChain verifies the peer's certificate chain without checking that
+// it names the host
+func (a *Auth) verifyChain(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("peer presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.ca,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// Serve accepts connections on ln, serving HTTPS when TLS is enabled and
+// plain HTTP otherwise. The server's TLSConfig must be ServerTLSConfig.
+func (a *Auth) Serve(server *http.Server, ln net.Listener) error {
+	if !a.TLSEnabled() {
+		return server.Serve(ln)
+	}
+	// The certificate is already part of TLSConfig
+	return server.ServeTLS(ln, "", "")
+}
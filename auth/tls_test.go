@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"validate/config"
+)
+
+func TestNewTLSValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+	}{
+		{"cert without key", config.TLSConfig{Cert: "cert.pem"}},
+		{"key without cert", config.TLSConfig{Key: "key.pem"}},
+		{"client ca without cert", config.TLSConfig{ClientCA: "ca.pem"}},
+		{"missing files", config.TLSConfig{Cert: "missing.pem", Key: "missing.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(config.AuthConfig{}, tt.cfg); err == nil {
+				t.Error("New() expected error")
+			}
+		})
+	}
+}
+
+func TestLinkClientSkipsHostName(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// The test server's certificate names 127.0.0.1 but not localhost
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	url := "https://" + net.JoinHostPort("localhost", port)
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(srv.Certificate())
+	a := &Auth{cert: &srv.TLS.Certificates[0], ca: trusted}
+
+	if _, err := a.Client(5 * time.Second).Get(url); err == nil {
+		t.Error("Client() accepted a certificate that does not name the host")
+	}
+	resp, err := a.LinkClient(5*time.Second, nil).Get(url)
+	if err != nil {
+		t.Fatalf("LinkClient() error = %v", err)
+	}
+	resp.Body.Close()
+
+	untrusted := &Auth{cert: &srv.TLS.Certificates[0], ca: x509.NewCertPool()}
+	if _, err := untrusted.LinkClient(5*time.Second, nil).Get(url); err == nil {
+		t.Error("LinkClient() accepted a certificate from an unknown CA")
+	}
+}
@@ -12,19 +12,117 @@ type Config struct {
 	Mode       string           `toml:"mode"` // "aggregator" or "agent"
 	Aggregator AggregatorConfig `toml:"aggregator"`
 	Agent      AgentConfig      `toml:"agent"`
+	Auth       AuthConfig       `toml:"auth"`
+	TLS        TLSConfig        `toml:"tls"`
 }
 
 // AggregatorConfig contains settings for aggregator mode
 type AggregatorConfig struct {
 	Port     int    `toml:"port"`     // Port to listen on (default 8080)
 	Database string `toml:"database"` // SQLite database path
+
+	ExternalEndpoints []string `toml:"external_endpoints,omitempty"` // IPs and http(s) URLs checked from agent uplinks by external tests
+	NTPServer         string   `toml:"ntp_server,omitempty"`         // NTP server agents measure their clock offset against
+
+	ExpectedPorts map[string][]int `toml:"expected_ports,omitempty"` // hostname glob -> TCP ports expected open on matching servers
+	TLSPorts      map[string][]int `toml:"tls_ports,omitempty"`      // hostname glob -> TCP ports the tls test performs a handshake with
+
+	AlertWebhooks []string `toml:"alert_webhooks,omitempty"` // URLs each alert is POSTed to as JSON
+	AlertCooldown int      `toml:"alert_cooldown,omitempty"` // Seconds before the same alert is sent again (default 900)
+	StaleAfter    int      `toml:"stale_after,omitempty"`    // Seconds without registration or heartbeat after which an agent is stale (default twice its register_interval)
+
+	RetentionDays int `toml:"retention_days,omitempty"` // Days test results are kept (default 0, forever)
+	MaxResults    int `toml:"max_results,omitempty"`    // Test results kept at most, oldest deleted first (default 0, unlimited)
 }
 
 // AgentConfig contains settings for agent mode
 type AgentConfig struct {
 	ListenAddr       string `toml:"listen_addr"`       // Address to listen on (default ":8080")
+	AdvertiseURL     string `toml:"advertise_url"`     // URL the aggregator reaches this agent at (default derived from listen_addr)
 	AggregatorURL    string `toml:"aggregator_url"`    // URL of the aggregator
 	RegisterInterval int    `toml:"register_interval"` // Seconds between registrations (default 300)
+
+	MaxParallelTests             int `toml:"max_parallel_tests"`               // Targets tested at once (default 8)
+	MaxParallelTestsPerInterface int `toml:"max_parallel_tests_per_interface"` // Targets tested at once per local interface (default 2)
+
+	MaxProbesPerSecond             int `toml:"max_probes_per_second"`               // Probes sent per second in total (default 0, unpaced)
+	MaxProbesPerSecondPerInterface int `toml:"max_probes_per_second_per_interface"` // Probes sent per second per local interface (default 0, unpaced)
+
+	ResultBatchSize     int `toml:"result_batch_size"`     // Results submitted in one request (default 50, 1 disables batching)
+	ResultBatchInterval int `toml:"result_batch_interval"` // Seconds a result may wait for its batch to fill (default 2)
+
+	SpoolDir string `toml:"spool_dir"` // Directory for results awaiting delivery to the aggregator (default "result-spool")
+
+	SubmitAttempts          int `toml:"submit_attempts"`           // Attempts per result submission before spooling (default 3, 1 disables retries)
+	SubmitRetryBackoffMS    int `toml:"submit_retry_backoff_ms"`   // Milliseconds before the first retry, doubled for each further one (default 500)
+	CircuitBreakerThreshold int `toml:"circuit_breaker_threshold"` // Failed submissions in a row before the aggregator is left alone (default 3)
+	CircuitBreakerCooldown  int `toml:"circuit_breaker_cooldown"`  // Seconds the aggregator is left alone for (default 30)
+
+	SelfTestInterval int    `toml:"self_test_interval,omitempty"` // Seconds between scheduled self-tests (default 0, disabled)
+	SelfTestCron     string `toml:"self_test_cron,omitempty"`     // Cron expression in UTC for scheduled self-tests, instead of self_test_interval
+
+	LogLevel  string `toml:"log_level"`  // debug, info, warn or error (default "info")
+	LogFormat string `toml:"log_format"` // text or json (default "text")
+
+	HTTPTest HTTPTestConfig `toml:"http_test,omitempty"` // Request made by the http test unless a test request sets its own
+
+	Namespaces map[string][]string `toml:"namespaces,omitempty"` // network namespace -> interfaces tested from inside it
+
+	Profiles map[string]TestProfileConfig `toml:"profiles,omitempty"` // Named test selections test requests can ask for
+}
+
+// HTTPTestConfig describes the request made by the http test. Unset fields
+// request GET /api/sysinfo from the target agent's API, expecting 200 OK.
+type HTTPTestConfig struct {
+	Port           int    `toml:"port,omitempty"`            // Port on the target (default the target agent's API port)
+	Path           string `toml:"path,omitempty"`            // Request path (default "/api/sysinfo")
+	Scheme         string `toml:"scheme,omitempty"`          // http or https (default that of the agent API)
+	ExpectedStatus int    `toml:"expected_status,omitempty"` // Status code counted as success (default 200)
+	TimeoutMS      int    `toml:"timeout_ms,omitempty"`      // Request timeout in milliseconds (default 10000)
+}
+
+// TestProfileConfig is a named selection of tests and their options
+type TestProfileConfig struct {
+	TestTypes []string                     `toml:"test_types,omitempty"` // Test types to run (default all)
+	Ports     []int                        `toml:"ports,omitempty"`      // TCP ports the ports test checks on every target, besides those the aggregator expects
+	Options   map[string]TestOptionsConfig `toml:"options,omitempty"`    // Test type -> options
+}
+
+// TestOptionsConfig tunes a test type of a profile, like the options of a
+// test request. Unset fields keep the defaults.
+type TestOptionsConfig struct {
+	Count           int    `toml:"count,omitempty"`
+	TimeoutMS       int    `toml:"timeout_ms,omitempty"`
+	DurationSeconds int    `toml:"duration_seconds,omitempty"`
+	Streams         int    `toml:"streams,omitempty"`
+	MaxOffsetMS     int    `toml:"max_offset_ms,omitempty"`
+	DSCP            []int  `toml:"dscp,omitempty"`
+	Port            int    `toml:"port,omitempty"`
+	Path            string `toml:"path,omitempty"`
+	Scheme          string `toml:"scheme,omitempty"`
+	ExpectedStatus  int    `toml:"expected_status,omitempty"`
+}
+
+// AuthConfig contains the credentials shared by agents and the aggregator.
+// Authentication is disabled when none are set.
+type AuthConfig struct {
+	Token string `toml:"token,omitempty"` // Shared bearer token
+
+	OperatorTokens []string `toml:"operator_tokens,omitempty"` // Bearer tokens operators trigger and cancel runs on the aggregator with
+
+	// Deprecated: use the [tls] section. LoadConfig moves these there when
+	// it is empty.
+	TLSCert string `toml:"tls_cert,omitempty"`
+	TLSKey  string `toml:"tls_key,omitempty"`
+	TLSCA   string `toml:"tls_ca,omitempty"`
+}
+
+// TLSConfig contains the certificates the aggregator and agents serve HTTPS
+// and reach each other with. TLS is disabled when none are set.
+type TLSConfig struct {
+	Cert     string `toml:"cert,omitempty"`      // Certificate served and presented as client certificate
+	Key      string `toml:"key,omitempty"`       // Private key of cert
+	ClientCA string `toml:"client_ca,omitempty"` // CA that signs peer certificates; enables mutual TLS
 }
 
 // LoadConfig loads configuration from a TOML file
@@ -46,12 +144,51 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Aggregator.Database == "" {
 		config.Aggregator.Database = "sysinfo.db"
 	}
+	if config.Aggregator.AlertCooldown == 0 {
+		config.Aggregator.AlertCooldown = 900
+	}
 	if config.Agent.ListenAddr == "" {
 		config.Agent.ListenAddr = ":8080"
 	}
 	if config.Agent.RegisterInterval == 0 {
 		config.Agent.RegisterInterval = 300
 	}
+	if config.Agent.MaxParallelTests == 0 {
+		config.Agent.MaxParallelTests = 8
+	}
+	if config.Agent.MaxParallelTestsPerInterface == 0 {
+		config.Agent.MaxParallelTestsPerInterface = 2
+	}
+	if config.Agent.ResultBatchSize == 0 {
+		config.Agent.ResultBatchSize = 50
+	}
+	if config.Agent.ResultBatchInterval == 0 {
+		config.Agent.ResultBatchInterval = 2
+	}
+	if config.Agent.SpoolDir == "" {
+		config.Agent.SpoolDir = "result-spool"
+	}
+	if config.Agent.SubmitAttempts == 0 {
+		config.Agent.SubmitAttempts = 3
+	}
+	if config.Agent.SubmitRetryBackoffMS == 0 {
+		config.Agent.SubmitRetryBackoffMS = 500
+	}
+	if config.Agent.CircuitBreakerThreshold == 0 {
+		config.Agent.CircuitBreakerThreshold = 3
+	}
+	if config.Agent.CircuitBreakerCooldown == 0 {
+		config.Agent.CircuitBreakerCooldown = 30
+	}
+	if config.Agent.LogLevel == "" {
+		config.Agent.LogLevel = "info"
+	}
+	if config.Agent.LogFormat == "" {
+		config.Agent.LogFormat = "text"
+	}
+	if config.TLS == (TLSConfig{}) {
+		config.TLS = TLSConfig{Cert: config.Auth.TLSCert, Key: config.Auth.TLSKey, ClientCA: config.Auth.TLSCA}
+	}
 
 	// Validate mode
 	if config.Mode != "aggregator" && config.Mode != "agent" {
@@ -62,6 +199,25 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Mode == "agent" && config.Agent.AggregatorURL == "" {
 		return nil, fmt.Errorf("aggregator_url is required in agent mode")
 	}
+	if config.Aggregator.AlertCooldown < 0 || config.Aggregator.StaleAfter < 0 {
+		return nil, fmt.Errorf("alert_cooldown and stale_after must not be negative")
+	}
+	if config.Aggregator.RetentionDays < 0 || config.Aggregator.MaxResults < 0 {
+		return nil, fmt.Errorf("retention_days and max_results must not be negative")
+	}
+	if config.Agent.SelfTestInterval != 0 && config.Agent.SelfTestCron != "" {
+		return nil, fmt.Errorf("self_test_interval and self_test_cron are mutually exclusive")
+	}
+	if config.Agent.SelfTestInterval < 0 {
+		return nil, fmt.Errorf("self_test_interval must not be negative")
+	}
+	if config.Agent.MaxProbesPerSecond < 0 || config.Agent.MaxProbesPerSecondPerInterface < 0 {
+		return nil, fmt.Errorf("probe rate limits must not be negative")
+	}
+	if config.Agent.SubmitAttempts < 0 || config.Agent.SubmitRetryBackoffMS < 0 ||
+		config.Agent.CircuitBreakerThreshold < 0 || config.Agent.CircuitBreakerCooldown < 0 {
+		return nil, fmt.Errorf("submission retry and circuit breaker settings must not be negative")
+	}
 
 	return &config, nil
 }
@@ -85,6 +241,25 @@ func GenerateDefaultConfig(path string, mode string) error {
 				ListenAddr:       ":8080",
 				AggregatorURL:    "http://localhost:8080",
 				RegisterInterval: 300,
+
+				MaxParallelTests:             8,
+				MaxParallelTestsPerInterface: 2,
+
+				MaxProbesPerSecond:             0,
+				MaxProbesPerSecondPerInterface: 0,
+
+				ResultBatchSize:     50,
+				ResultBatchInterval: 2,
+
+				SpoolDir: "result-spool",
+
+				SubmitAttempts:          3,
+				SubmitRetryBackoffMS:    500,
+				CircuitBreakerThreshold: 3,
+				CircuitBreakerCooldown:  30,
+
+				LogLevel:  "info",
+				LogFormat: "text",
 			},
 		}
 	}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -21,23 +23,71 @@ type ServerRegistration struct {
 	IPAddress    string    `json:"ip_address"`
 	SystemInfo   string    `json:"system_info"` // JSON blob
 	Bonds        string    `json:"bonds"`       // JSON blob of bond -> IPs mapping
+	Links        string    `json:"links"`       // JSON blob of link -> IPs mapping, empty for agents that only report bonds
+	AgentURL     string    `json:"agent_url,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	Capabilities string    `json:"capabilities,omitempty"`   // JSON blob, empty for agents that predate capability reporting
+	LLDP         string    `json:"lldp_neighbors,omitempty"` // JSON blob of link -> LLDP neighbors of its NICs
 	RegisteredAt time.Time `json:"registered_at"`
 	LastSeen     time.Time `json:"last_seen"`
+
+	RegisterInterval int    `json:"register_interval,omitempty"` // seconds between registrations or heartbeats, 0 if unreported
+	Status           string `json:"status,omitempty"`            // online, stale or offline, set by the aggregator
 }
 
 // TestResult represents the result of a connectivity test
 type TestResult struct {
-	ID             int64     `json:"id"`
-	SourceHostname string    `json:"source_hostname"`
-	TargetHostname string    `json:"target_hostname"`
-	TargetIP       string    `json:"target_ip"`
-	SourceIP       string    `json:"source_ip"`
-	BondName       string    `json:"bond_name"`
-	TestType       string    `json:"test_type"` // "arp" or "http"
-	Success        bool      `json:"success"`
-	ResponseTime   int64     `json:"response_time_ms"` // milliseconds
-	ErrorMessage   string    `json:"error_message,omitempty"`
-	TestedAt       time.Time `json:"tested_at"`
+	ID              int64      `json:"id"`
+	RunID           string     `json:"run_id,omitempty"`
+	CorrelationID   string     `json:"correlation_id,omitempty"` // shared by both directions of a bidirectional test
+	SourceHostname  string     `json:"source_hostname"`
+	TargetHostname  string     `json:"target_hostname"`
+	TargetIP        string     `json:"target_ip"`
+	SourceIP        string     `json:"source_ip"`
+	BondName        string     `json:"bond_name"`
+	SourceInterface string     `json:"source_interface,omitempty"`
+	Port            int        `json:"port,omitempty"`
+	TestType        string     `json:"test_type"` // "arp", "ndp", "http", "icmp", "udp", "dscp", "ports", "tls", "bandwidth", "pmtu", "traceroute", "bond-health", "gateway", "external" or "time-sync"
+	Success         bool       `json:"success"`
+	ResponseTime    int64      `json:"response_time_ms"` // milliseconds
+	RTTMinMS        float64    `json:"rtt_min_ms,omitempty"`
+	RTTAvgMS        float64    `json:"rtt_avg_ms,omitempty"`
+	RTTMaxMS        float64    `json:"rtt_max_ms,omitempty"`
+	PacketLoss      float64    `json:"packet_loss_percent,omitempty"`
+	P50MS           float64    `json:"p50_ms,omitempty"`
+	P95MS           float64    `json:"p95_ms,omitempty"`
+	P99MS           float64    `json:"p99_ms,omitempty"`
+	ThroughputMbps  float64    `json:"throughput_mbps,omitempty"`
+	PathMTU         int        `json:"path_mtu,omitempty"`
+	Hops            string     `json:"hops,omitempty"` // JSON blob of traceroute hops
+	SlavesUp        int        `json:"slaves_up,omitempty"`
+	SlavesTotal     int        `json:"slaves_total,omitempty"`
+	ClockOffsetMS   float64    `json:"clock_offset_ms,omitempty"`
+	TLSVersion      string     `json:"tls_version,omitempty"`
+	CertNotAfter    *time.Time `json:"cert_not_after,omitempty"`
+	TCPRetransmits  int        `json:"tcp_retransmits,omitempty"`
+	TCPRTTMS        float64    `json:"tcp_rtt_ms,omitempty"`
+	TCPCwnd         int        `json:"tcp_cwnd,omitempty"`
+	ErrorMessage    string     `json:"error_message,omitempty"`
+	TestedAt        time.Time  `json:"tested_at"`
+}
+
+// Test run statuses
+const (
+	RunStatusRunning  = "running"
+	RunStatusComplete = "complete"
+	RunStatusFailed   = "failed"
+)
+
+// Run represents a test run triggered through the aggregator. Its results
+// reference it by ID.
+type Run struct {
+	ID           string     `json:"id"`
+	Status       string     `json:"status"` // one of the RunStatus constants
+	Agents       int        `json:"agents"` // agents the run was triggered on
+	ErrorMessage string     `json:"error_message,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
 }
 
 // NewDB creates a new database connection and initializes tables
@@ -71,26 +121,63 @@ func (db *DB) initTables() error {
 			ip_address TEXT NOT NULL,
 			system_info TEXT NOT NULL,
 			bonds TEXT NOT NULL,
+			links TEXT NOT NULL DEFAULT '',
+			agent_url TEXT NOT NULL DEFAULT '',
+			version TEXT NOT NULL DEFAULT '',
+			capabilities TEXT NOT NULL DEFAULT '',
+			lldp_neighbors TEXT NOT NULL DEFAULT '',
+			register_interval INTEGER NOT NULL DEFAULT 0,
 			registered_at DATETIME NOT NULL,
 			last_seen DATETIME NOT NULL
 		)`,
 		`CREATE TABLE IF NOT EXISTS test_results (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id TEXT NOT NULL DEFAULT '',
 			source_hostname TEXT NOT NULL,
 			target_hostname TEXT NOT NULL,
 			target_ip TEXT NOT NULL,
 			source_ip TEXT NOT NULL,
 			bond_name TEXT NOT NULL,
+			source_interface TEXT NOT NULL DEFAULT '',
 			test_type TEXT NOT NULL,
+			port INTEGER NOT NULL DEFAULT 0,
 			success INTEGER NOT NULL,
 			response_time_ms INTEGER,
+			rtt_min_ms REAL NOT NULL DEFAULT 0,
+			rtt_avg_ms REAL NOT NULL DEFAULT 0,
+			rtt_max_ms REAL NOT NULL DEFAULT 0,
+			packet_loss_percent REAL NOT NULL DEFAULT 0,
+			p50_ms REAL NOT NULL DEFAULT 0,
+			p95_ms REAL NOT NULL DEFAULT 0,
+			p99_ms REAL NOT NULL DEFAULT 0,
+			throughput_mbps REAL NOT NULL DEFAULT 0,
+			path_mtu INTEGER NOT NULL DEFAULT 0,
+			hops TEXT NOT NULL DEFAULT '',
+			slaves_up INTEGER NOT NULL DEFAULT 0,
+			slaves_total INTEGER NOT NULL DEFAULT 0,
+			clock_offset_ms REAL NOT NULL DEFAULT 0,
+			tls_version TEXT NOT NULL DEFAULT '',
+			cert_not_after DATETIME,
+			tcp_retransmits INTEGER NOT NULL DEFAULT 0,
+			tcp_rtt_ms REAL NOT NULL DEFAULT 0,
+			tcp_cwnd INTEGER NOT NULL DEFAULT 0,
+			correlation_id TEXT NOT NULL DEFAULT '',
 			error_message TEXT,
 			tested_at DATETIME NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS runs (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			agents INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_servers_hostname ON servers(hostname)`,
 		`CREATE INDEX IF NOT EXISTS idx_test_results_source ON test_results(source_hostname)`,
 		`CREATE INDEX IF NOT EXISTS idx_test_results_target ON test_results(target_hostname)`,
 		`CREATE INDEX IF NOT EXISTS idx_test_results_tested_at ON test_results(tested_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_runs_started_at ON runs(started_at)`,
 	}
 
 	for _, schema := range schemas {
@@ -99,11 +186,156 @@ func (db *DB) initTables() error {
 		}
 	}
 
+	// Databases created by older versions lack columns added since
+	if err := db.addMissingColumns("servers", serverColumns); err != nil {
+		return err
+	}
+	if err := db.addMissingColumns("test_results", testResultColumns); err != nil {
+		return err
+	}
+	if err := db.normalizeRunTimes(); err != nil {
+		return err
+	}
+
+	// Indexes on added columns can only be created once the columns exist
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_test_results_run ON test_results(run_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_test_results_run_type ON test_results(run_id, test_type, success)`,
+		`CREATE INDEX IF NOT EXISTS idx_test_results_test_type ON test_results(test_type, tested_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_test_results_bond ON test_results(bond_name)`,
+	}
+	for _, index := range indexes {
+		if _, err := db.conn.Exec(index); err != nil {
+			return fmt.Errorf("failed to execute schema: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// RegisterServer registers or updates a server in the database
-func (db *DB) RegisterServer(hostname, ipAddress string, systemInfo interface{}, bonds map[string][]string) error {
+// column is a column added to a table after its initial schema
+type column struct {
+	name       string
+	definition string
+}
+
+// serverColumns are the servers columns added after the initial schema
+var serverColumns = []column{
+	{"agent_url", "TEXT NOT NULL DEFAULT ''"},
+	{"links", "TEXT NOT NULL DEFAULT ''"},
+	{"version", "TEXT NOT NULL DEFAULT ''"},
+	{"capabilities", "TEXT NOT NULL DEFAULT ''"},
+	{"lldp_neighbors", "TEXT NOT NULL DEFAULT ''"},
+	{"register_interval", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// testResultColumns are the test_results columns added after the initial schema
+var testResultColumns = []column{
+	{"rtt_min_ms", "REAL NOT NULL DEFAULT 0"},
+	{"rtt_avg_ms", "REAL NOT NULL DEFAULT 0"},
+	{"rtt_max_ms", "REAL NOT NULL DEFAULT 0"},
+	{"packet_loss_percent", "REAL NOT NULL DEFAULT 0"},
+	{"throughput_mbps", "REAL NOT NULL DEFAULT 0"},
+	{"path_mtu", "INTEGER NOT NULL DEFAULT 0"},
+	{"hops", "TEXT NOT NULL DEFAULT ''"},
+	{"p50_ms", "REAL NOT NULL DEFAULT 0"},
+	{"p95_ms", "REAL NOT NULL DEFAULT 0"},
+	{"p99_ms", "REAL NOT NULL DEFAULT 0"},
+	{"run_id", "TEXT NOT NULL DEFAULT ''"},
+	{"source_interface", "TEXT NOT NULL DEFAULT ''"},
+	{"slaves_up", "INTEGER NOT NULL DEFAULT 0"},
+	{"slaves_total", "INTEGER NOT NULL DEFAULT 0"},
+	{"clock_offset_ms", "REAL NOT NULL DEFAULT 0"},
+	{"port", "INTEGER NOT NULL DEFAULT 0"},
+	{"tls_version", "TEXT NOT NULL DEFAULT ''"},
+	{"cert_not_after", "DATETIME"},
+	{"tcp_retransmits", "INTEGER NOT NULL DEFAULT 0"},
+	{"tcp_rtt_ms", "REAL NOT NULL DEFAULT 0"},
+	{"tcp_cwnd", "INTEGER NOT NULL DEFAULT 0"},
+	{"correlation_id", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// normalizeRunTimes converts the times of runs recorded by older versions
+// in the local time zone to UTC, so they compare in order with newer ones
+func (db *DB) normalizeRunTimes() error {
+	rows, err := db.conn.Query(`
+		SELECT id, started_at, finished_at FROM runs
+		WHERE started_at NOT LIKE '% UTC' OR finished_at NOT LIKE '% UTC'
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to read runs: %w", err)
+	}
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		if err := rows.Scan(&run.ID, &run.StartedAt, &run.FinishedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read runs: %w", err)
+	}
+
+	// Updated once the query is done, as it holds the only connection
+	for _, run := range runs {
+		var finishedAt *time.Time
+		if run.FinishedAt != nil {
+			utc := run.FinishedAt.UTC()
+			finishedAt = &utc
+		}
+		if _, err := db.conn.Exec("UPDATE runs SET started_at = ?, finished_at = ? WHERE id = ?", run.StartedAt.UTC(), finishedAt, run.ID); err != nil {
+			return fmt.Errorf("failed to update run: %w", err)
+		}
+	}
+	return nil
+}
+
+// addMissingColumns adds any of the given columns that a table does not have yet
+func (db *DB) addMissingColumns(table string, columns []column) error {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read %s schema: %w", table, err)
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s schema: %w", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, col := range columns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", table, col.name, err)
+		}
+	}
+
+	return nil
+}
+
+// RegisterServer registers or updates a server in the database. agentURL is
+// the base URL of the agent's API, or empty if the agent did not advertise one,
+// links is nil for agents that only report their bonds, and version,
+// registerInterval, capabilities and lldp are empty for agents that predate
+// reporting them.
+func (db *DB) RegisterServer(hostname, ipAddress, agentURL string, systemInfo interface{}, bonds, links map[string][]string, version string, registerInterval int, capabilities, lldp interface{}) error {
 	systemInfoJSON, err := json.Marshal(systemInfo)
 	if err != nil {
 		return fmt.Errorf("failed to marshal system info: %w", err)
@@ -114,17 +346,44 @@ func (db *DB) RegisterServer(hostname, ipAddress string, systemInfo interface{},
 		return fmt.Errorf("failed to marshal bonds: %w", err)
 	}
 
+	var linksJSON []byte
+	if links != nil {
+		if linksJSON, err = json.Marshal(links); err != nil {
+			return fmt.Errorf("failed to marshal links: %w", err)
+		}
+	}
+
+	var capabilitiesJSON []byte
+	if capabilities != nil {
+		if capabilitiesJSON, err = json.Marshal(capabilities); err != nil {
+			return fmt.Errorf("failed to marshal capabilities: %w", err)
+		}
+	}
+
+	var lldpJSON []byte
+	if lldp != nil {
+		if lldpJSON, err = json.Marshal(lldp); err != nil {
+			return fmt.Errorf("failed to marshal LLDP neighbors: %w", err)
+		}
+	}
+
 	now := time.Now()
 
 	_, err = db.conn.Exec(`
-		INSERT INTO servers (hostname, ip_address, system_info, bonds, registered_at, last_seen)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO servers (hostname, ip_address, system_info, bonds, links, agent_url, version, capabilities, lldp_neighbors, register_interval, registered_at, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(hostname) DO UPDATE SET
 			ip_address = excluded.ip_address,
 			system_info = excluded.system_info,
 			bonds = excluded.bonds,
+			links = excluded.links,
+			agent_url = excluded.agent_url,
+			version = excluded.version,
+			capabilities = excluded.capabilities,
+			lldp_neighbors = excluded.lldp_neighbors,
+			register_interval = excluded.register_interval,
 			last_seen = excluded.last_seen
-	`, hostname, ipAddress, string(systemInfoJSON), string(bondsJSON), now, now)
+	`, hostname, ipAddress, string(systemInfoJSON), string(bondsJSON), string(linksJSON), agentURL, version, string(capabilitiesJSON), string(lldpJSON), registerInterval, now, now)
 
 	if err != nil {
 		return fmt.Errorf("failed to register server: %w", err)
@@ -136,7 +395,7 @@ func (db *DB) RegisterServer(hostname, ipAddress string, systemInfo interface{},
 // GetAllServers returns all registered servers
 func (db *DB) GetAllServers() ([]ServerRegistration, error) {
 	rows, err := db.conn.Query(`
-		SELECT id, hostname, ip_address, system_info, bonds, registered_at, last_seen
+		SELECT id, hostname, ip_address, system_info, bonds, links, agent_url, version, capabilities, lldp_neighbors, register_interval, registered_at, last_seen
 		FROM servers
 		ORDER BY hostname
 	`)
@@ -154,6 +413,12 @@ func (db *DB) GetAllServers() ([]ServerRegistration, error) {
 			&server.IPAddress,
 			&server.SystemInfo,
 			&server.Bonds,
+			&server.Links,
+			&server.AgentURL,
+			&server.Version,
+			&server.Capabilities,
+			&server.LLDP,
+			&server.RegisterInterval,
 			&server.RegisteredAt,
 			&server.LastSeen,
 		); err != nil {
@@ -169,14 +434,21 @@ func (db *DB) GetAllServers() ([]ServerRegistration, error) {
 func (db *DB) GetServer(hostname string) (*ServerRegistration, error) {
 	var server ServerRegistration
 	err := db.conn.QueryRow(`
-		SELECT id, hostname, system_info, bonds, registered_at, last_seen
+		SELECT id, hostname, ip_address, system_info, bonds, links, agent_url, version, capabilities, lldp_neighbors, register_interval, registered_at, last_seen
 		FROM servers
 		WHERE hostname = ?
 	`, hostname).Scan(
 		&server.ID,
 		&server.Hostname,
+		&server.IPAddress,
 		&server.SystemInfo,
 		&server.Bonds,
+		&server.Links,
+		&server.AgentURL,
+		&server.Version,
+		&server.Capabilities,
+		&server.LLDP,
+		&server.RegisterInterval,
 		&server.RegisteredAt,
 		&server.LastSeen,
 	)
@@ -191,24 +463,78 @@ func (db *DB) GetServer(hostname string) (*ServerRegistration, error) {
 	return &server, nil
 }
 
+// TouchServer records that a server is still alive. It reports whether the
+// server is registered.
+func (db *DB) TouchServer(hostname string) (bool, error) {
+	res, err := db.conn.Exec("UPDATE servers SET last_seen = ? WHERE hostname = ?", time.Now(), hostname)
+	if err != nil {
+		return false, fmt.Errorf("failed to update server: %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update server: %w", err)
+	}
+	return updated > 0, nil
+}
+
+// DeleteServer removes a server, keeping its test results. It reports
+// whether the server was registered.
+func (db *DB) DeleteServer(hostname string) (bool, error) {
+	res, err := db.conn.Exec("DELETE FROM servers WHERE hostname = ?", hostname)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete server: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete server: %w", err)
+	}
+	return deleted > 0, nil
+}
+
 // SaveTestResult saves a connectivity test result
 func (db *DB) SaveTestResult(result TestResult) error {
 	_, err := db.conn.Exec(`
 		INSERT INTO test_results (
-			source_hostname, target_hostname, target_ip, source_ip, bond_name, test_type,
-			success, response_time_ms, error_message, tested_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			run_id, correlation_id, source_hostname, target_hostname, target_ip, source_ip, bond_name, source_interface, test_type, port,
+			success, response_time_ms, rtt_min_ms, rtt_avg_ms, rtt_max_ms, packet_loss_percent,
+			p50_ms, p95_ms, p99_ms, throughput_mbps, path_mtu, hops, slaves_up, slaves_total, clock_offset_ms,
+			tls_version, cert_not_after, tcp_retransmits, tcp_rtt_ms, tcp_cwnd, error_message, tested_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
+		result.RunID,
+		result.CorrelationID,
 		result.SourceHostname,
 		result.TargetHostname,
 		result.TargetIP,
 		result.SourceIP,
 		result.BondName,
+		result.SourceInterface,
 		result.TestType,
+		result.Port,
 		result.Success,
 		result.ResponseTime,
+		result.RTTMinMS,
+		result.RTTAvgMS,
+		result.RTTMaxMS,
+		result.PacketLoss,
+		result.P50MS,
+		result.P95MS,
+		result.P99MS,
+		result.ThroughputMbps,
+		result.PathMTU,
+		result.Hops,
+		result.SlavesUp,
+		result.SlavesTotal,
+		result.ClockOffsetMS,
+		result.TLSVersion,
+		result.CertNotAfter,
+		result.TCPRetransmits,
+		result.TCPRTTMS,
+		result.TCPCwnd,
 		result.ErrorMessage,
-		result.TestedAt,
+		result.TestedAt.UTC(), // agents report their local time zone
 	)
 
 	if err != nil {
@@ -218,25 +544,128 @@ func (db *DB) SaveTestResult(result TestResult) error {
 	return nil
 }
 
+// TestResultFilter narrows down the test results returned by FindTestResults.
+// Empty fields do not filter, and a zero Limit returns every match.
+type TestResultFilter struct {
+	SourceHostname string
+	TargetHostname string
+	BondName       string
+	TestType       string
+	Success        *bool // nil matches both outcomes
+	RunID          string
+	Since          time.Time // tested at or after
+	Until          time.Time // tested before
+	Limit          int
+	Offset         int    // matches skipped, for paging
+	Sort           string // one of TestResultSortColumns, default tested_at
+	Ascending      bool   // sort order, default descending
+}
+
+// TestResultSortColumns are the columns test results can be sorted by
+var TestResultSortColumns = []string{
+	"tested_at", "id", "run_id", "source_hostname", "target_hostname", "target_ip", "bond_name",
+	"test_type", "success", "response_time_ms", "rtt_avg_ms", "packet_loss_percent", "throughput_mbps",
+}
+
+// where returns the WHERE clause selecting the matching test results and
+// its arguments
+func (f TestResultFilter) where() (string, []interface{}) {
+	clause := " WHERE 1 = 1"
+	var args []interface{}
+
+	if f.SourceHostname != "" {
+		clause += " AND source_hostname = ?"
+		args = append(args, f.SourceHostname)
+	}
+	if f.TargetHostname != "" {
+		clause += " AND target_hostname = ?"
+		args = append(args, f.TargetHostname)
+	}
+	if f.BondName != "" {
+		clause += " AND bond_name = ?"
+		args = append(args, f.BondName)
+	}
+	if f.TestType != "" {
+		clause += " AND test_type = ?"
+		args = append(args, f.TestType)
+	}
+	if f.Success != nil {
+		clause += " AND success = ?"
+		args = append(args, *f.Success)
+	}
+	if f.RunID != "" {
+		clause += " AND run_id = ?"
+		args = append(args, f.RunID)
+	}
+	// Times are stored as text, in UTC, so they compare in order
+	if !f.Since.IsZero() {
+		clause += " AND tested_at >= ?"
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		clause += " AND tested_at < ?"
+		args = append(args, f.Until.UTC())
+	}
+	return clause, args
+}
+
 // GetTestResults returns recent test results
 func (db *DB) GetTestResults(limit int) ([]TestResult, error) {
-	query := `
-		SELECT id, source_hostname, target_hostname, target_ip, source_ip, bond_name, test_type,
-			   success, response_time_ms, error_message, tested_at
-		FROM test_results
-		ORDER BY tested_at DESC
-	`
+	return db.FindTestResults(TestResultFilter{Limit: limit})
+}
 
-	var rows *sql.Rows
-	var err error
+// GetTestResultsBySource returns test results for a specific source hostname
+func (db *DB) GetTestResultsBySource(hostname string, limit int) ([]TestResult, error) {
+	return db.FindTestResults(TestResultFilter{SourceHostname: hostname, Limit: limit})
+}
 
-	if limit > 0 {
-		query += " LIMIT ?"
-		rows, err = db.conn.Query(query, limit)
-	} else {
-		rows, err = db.conn.Query(query)
+// testResultFields are the columns test results are read from, in the
+// order scanTestResult expects them
+const testResultFields = `id, run_id, correlation_id, source_hostname, target_hostname, target_ip, source_ip, bond_name, source_interface, test_type, port,
+	success, response_time_ms, rtt_min_ms, rtt_avg_ms, rtt_max_ms, packet_loss_percent,
+	p50_ms, p95_ms, p99_ms, throughput_mbps, path_mtu, hops, slaves_up, slaves_total, clock_offset_ms,
+	tls_version, cert_not_after, tcp_retransmits, tcp_rtt_ms, tcp_cwnd, error_message, tested_at`
+
+// query returns the query selecting columns of the matching test results,
+// sorted and limited as the filter asks, and its arguments
+func (f TestResultFilter) query(columns string) (string, []interface{}, error) {
+	sort := f.Sort
+	if sort == "" {
+		sort = "tested_at"
+	}
+	if !slices.Contains(TestResultSortColumns, sort) {
+		return "", nil, fmt.Errorf("cannot sort test results by %q", sort)
+	}
+
+	where, args := f.where()
+	query := "SELECT " + columns + " FROM test_results" + where
+
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	// Ties are broken by ID so pages do not overlap
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", sort, order, order)
+
+	if f.Limit > 0 || f.Offset > 0 {
+		limit := f.Limit
+		if limit <= 0 {
+			limit = -1 // SQLite's "no limit"
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, f.Offset)
+	}
+	return query, args, nil
+}
+
+// FindTestResults returns the most recent test results matching the filter
+func (db *DB) FindTestResults(filter TestResultFilter) ([]TestResult, error) {
+	query, args, err := filter.query(testResultFields)
+	if err != nil {
+		return nil, err
 	}
 
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query test results: %w", err)
 	}
@@ -244,77 +673,521 @@ func (db *DB) GetTestResults(limit int) ([]TestResult, error) {
 
 	var results []TestResult
 	for rows.Next() {
-		var result TestResult
-		if err := rows.Scan(
-			&result.ID,
-			&result.SourceHostname,
-			&result.TargetHostname,
-			&result.TargetIP,
-			&result.SourceIP,
-			&result.BondName,
-			&result.TestType,
-			&result.Success,
-			&result.ResponseTime,
-			&result.ErrorMessage,
-			&result.TestedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan test result: %w", err)
+		result, err := scanTestResult(rows)
+		if err != nil {
+			return nil, err
 		}
 		results = append(results, result)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read test results: %w", err)
+	}
 	return results, nil
 }
 
-// GetTestResultsBySource returns test results for a specific source hostname
-func (db *DB) GetTestResultsBySource(hostname string, limit int) ([]TestResult, error) {
-	query := `
-		SELECT id, source_hostname, target_hostname, target_ip, source_ip, bond_name, test_type,
-			   success, response_time_ms, error_message, tested_at
-		FROM test_results
-		WHERE source_hostname = ?
-		ORDER BY tested_at DESC
-	`
+// testResultPageSize is how many test results EachTestResult reads at a time
+const testResultPageSize = 1000
 
-	var rows *sql.Rows
-	var err error
+// EachTestResult calls fn with each test result matching the filter, in
+// order, holding only their IDs in memory. The IDs are read up front, then
+// the results a page at a time, and the database is free while fn runs, so
+// fn may block, e.g. on a slow client, without holding up other users of
+// the database. Results deleted meanwhile are skipped. An error returned by
+// fn stops the iteration and is returned.
+func (db *DB) EachTestResult(filter TestResultFilter, fn func(TestResult) error) error {
+	ids, err := db.findTestResultIDs(filter)
+	if err != nil {
+		return err
+	}
 
-	if limit > 0 {
-		query += " LIMIT ?"
-		rows, err = db.conn.Query(query, hostname, limit)
-	} else {
-		rows, err = db.conn.Query(query, hostname)
+	for len(ids) > 0 {
+		page := ids[:min(len(ids), testResultPageSize)]
+		ids = ids[len(page):]
+
+		results, err := db.getTestResults(page)
+		if err != nil {
+			return err
+		}
+		for _, id := range page {
+			result, found := results[id]
+			if !found {
+				continue
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findTestResultIDs returns the IDs of the test results matching the filter,
+// in order
+func (db *DB) findTestResultIDs(filter TestResultFilter) ([]int64, error) {
+	query, args, err := filter.query("id")
+	if err != nil {
+		return nil, err
 	}
 
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query test results: %w", err)
 	}
 	defer rows.Close()
 
-	var results []TestResult
+	var ids []int64
 	for rows.Next() {
-		var result TestResult
-		if err := rows.Scan(
-			&result.ID,
-			&result.SourceHostname,
-			&result.TargetHostname,
-			&result.TargetIP,
-			&result.SourceIP,
-			&result.BondName,
-			&result.TestType,
-			&result.Success,
-			&result.ResponseTime,
-			&result.ErrorMessage,
-			&result.TestedAt,
-		); err != nil {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
 			return nil, fmt.Errorf("failed to scan test result: %w", err)
 		}
-		results = append(results, result)
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read test results: %w", err)
+	}
+	return ids, nil
+}
+
+// getTestResults returns the test results with the given IDs that exist,
+// by ID
+func (db *DB) getTestResults(ids []int64) (map[int64]TestResult, error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := db.conn.Query("SELECT "+testResultFields+" FROM test_results WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test results: %w", err)
+	}
+	defer rows.Close()
+
+	results := make(map[int64]TestResult, len(ids))
+	for rows.Next() {
+		result, err := scanTestResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results[result.ID] = result
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read test results: %w", err)
+	}
 	return results, nil
 }
 
+// scanTestResult scans a row of testResultFields
+func scanTestResult(rows *sql.Rows) (TestResult, error) {
+	var result TestResult
+	if err := rows.Scan(
+		&result.ID,
+		&result.RunID,
+		&result.CorrelationID,
+		&result.SourceHostname,
+		&result.TargetHostname,
+		&result.TargetIP,
+		&result.SourceIP,
+		&result.BondName,
+		&result.SourceInterface,
+		&result.TestType,
+		&result.Port,
+		&result.Success,
+		&result.ResponseTime,
+		&result.RTTMinMS,
+		&result.RTTAvgMS,
+		&result.RTTMaxMS,
+		&result.PacketLoss,
+		&result.P50MS,
+		&result.P95MS,
+		&result.P99MS,
+		&result.ThroughputMbps,
+		&result.PathMTU,
+		&result.Hops,
+		&result.SlavesUp,
+		&result.SlavesTotal,
+		&result.ClockOffsetMS,
+		&result.TLSVersion,
+		&result.CertNotAfter,
+		&result.TCPRetransmits,
+		&result.TCPRTTMS,
+		&result.TCPCwnd,
+		&result.ErrorMessage,
+		&result.TestedAt,
+	); err != nil {
+		return TestResult{}, fmt.Errorf("failed to scan test result: %w", err)
+	}
+	return result, nil
+}
+
+// LastOutcome reports whether the most recent earlier result of the same
+// test, from the same source to the same target address and port, passed.
+// found is false when the test has not run before.
+func (db *DB) LastOutcome(result TestResult) (success, found bool, err error) {
+	err = db.conn.QueryRow(`
+		SELECT success FROM test_results
+		WHERE source_hostname = ? AND target_hostname = ? AND target_ip = ? AND test_type = ? AND port = ?
+		ORDER BY tested_at DESC, id DESC
+		LIMIT 1
+	`, result.SourceHostname, result.TargetHostname, result.TargetIP, result.TestType, result.Port).Scan(&success)
+
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get last outcome: %w", err)
+	}
+	return success, true, nil
+}
+
+// PairFailures counts the failed tests from one host to another's link
+type PairFailures struct {
+	SourceHostname string
+	TargetHostname string
+	BondName       string
+	TestType       string
+	Failures       int
+}
+
+// CountPairFailures returns the failed tests of a run per source, target
+// link and test type, leaving out pairs without failures
+func (db *DB) CountPairFailures(runID string) ([]PairFailures, error) {
+	rows, err := db.conn.Query(`
+		SELECT source_hostname, target_hostname, bond_name, test_type, COUNT(*)
+		FROM test_results
+		WHERE run_id = ? AND success = 0
+		GROUP BY source_hostname, target_hostname, bond_name, test_type
+		ORDER BY source_hostname, target_hostname, bond_name, test_type
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count failures: %w", err)
+	}
+	defer rows.Close()
+
+	var pairs []PairFailures
+	for rows.Next() {
+		var pair PairFailures
+		if err := rows.Scan(&pair.SourceHostname, &pair.TargetHostname, &pair.BondName, &pair.TestType, &pair.Failures); err != nil {
+			return nil, fmt.Errorf("failed to scan failures: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+// PairCount counts the tests from one host to another
+type PairCount struct {
+	SourceHostname string
+	TargetHostname string
+	Total          int
+	Passed         int
+}
+
+// CountTestResultsByPair counts the test results matching the filter per
+// source and target host, ignoring its limit, offset and sort order
+func (db *DB) CountTestResultsByPair(filter TestResultFilter) ([]PairCount, error) {
+	where, args := filter.where()
+	rows, err := db.conn.Query(`
+		SELECT source_hostname, target_hostname, COUNT(*), SUM(success)
+		FROM test_results`+where+`
+		GROUP BY source_hostname, target_hostname
+		ORDER BY source_hostname, target_hostname
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count test results: %w", err)
+	}
+	defer rows.Close()
+
+	var pairs []PairCount
+	for rows.Next() {
+		var pair PairCount
+		if err := rows.Scan(&pair.SourceHostname, &pair.TargetHostname, &pair.Total, &pair.Passed); err != nil {
+			return nil, fmt.Errorf("failed to scan test result counts: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+// PairOutcome sums up the results of a test of a run from a source host to
+// a target address and port
+type PairOutcome struct {
+	SourceHostname string
+	TargetHostname string
+	TargetIP       string
+	BondName       string
+	TestType       string
+	Port           int
+	Total          int
+	Passed         int
+	AvgResponseMS  float64
+	AvgRTTMS       float64 // 0 unless the test measures round trip times
+	ErrorMessage   string  // of a failed result, if any
+}
+
+// PairOutcomes returns the outcome of each test of a run per source, target
+// address and port
+func (db *DB) PairOutcomes(runID string) ([]PairOutcome, error) {
+	rows, err := db.conn.Query(`
+		SELECT source_hostname, target_hostname, target_ip, MAX(bond_name), test_type, port,
+			COUNT(*), SUM(success), AVG(response_time_ms), COALESCE(AVG(NULLIF(rtt_avg_ms, 0)), 0),
+			COALESCE(MAX(CASE WHEN success = 0 THEN error_message END), '')
+		FROM test_results
+		WHERE run_id = ?
+		GROUP BY source_hostname, target_hostname, target_ip, test_type, port
+		ORDER BY source_hostname, target_hostname, target_ip, test_type, port
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outcomes: %w", err)
+	}
+	defer rows.Close()
+
+	var outcomes []PairOutcome
+	for rows.Next() {
+		var outcome PairOutcome
+		if err := rows.Scan(&outcome.SourceHostname, &outcome.TargetHostname, &outcome.TargetIP, &outcome.BondName,
+			&outcome.TestType, &outcome.Port, &outcome.Total, &outcome.Passed, &outcome.AvgResponseMS,
+			&outcome.AvgRTTMS, &outcome.ErrorMessage); err != nil {
+			return nil, fmt.Errorf("failed to scan outcome: %w", err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	return outcomes, nil
+}
+
+// TrendPoint aggregates the test results of a bucket of time, from a
+// source host to a target host's link, or of a link of every host
+type TrendPoint struct {
+	SourceHostname    string // empty when grouped by link
+	TargetHostname    string // empty when grouped by link
+	BondName          string
+	BucketStart       time.Time
+	Total             int
+	Passed            int
+	AvgResponseTimeMS float64
+	AvgRTTMS          float64 // of the tests measuring round trip times
+	MaxRTTMS          float64
+}
+
+// TestResultTrends aggregates the results matching the filter, tested
+// since its Since time, into buckets of the given size per pair, or per
+// link when byLink is set. Its limit, offset and sort order are ignored.
+func (db *DB) TestResultTrends(filter TestResultFilter, bucket time.Duration, byLink bool) ([]TrendPoint, error) {
+	// Times are stored as text starting with the UTC date and time
+	bucketExpr := "CAST(strftime('%s', substr(tested_at, 1, 19)) AS INTEGER) / ?"
+	keys := "source_hostname, target_hostname, bond_name"
+	if byLink {
+		keys = "'', '', bond_name"
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+		SELECT %s, %s AS bucket, COUNT(*), SUM(success),
+			AVG(response_time_ms), COALESCE(AVG(NULLIF(rtt_avg_ms, 0)), 0), MAX(rtt_max_ms)
+		FROM test_results%s
+		GROUP BY %s, bucket
+		ORDER BY %s, bucket
+	`, keys, bucketExpr, where, keys, keys)
+	seconds := int64(bucket / time.Second)
+	args = append([]interface{}{seconds}, args...)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trends: %w", err)
+	}
+	defer rows.Close()
+
+	var points []TrendPoint
+	for rows.Next() {
+		var (
+			point       TrendPoint
+			bucketIndex int64
+			avgResponse sql.NullFloat64
+		)
+		if err := rows.Scan(&point.SourceHostname, &point.TargetHostname, &point.BondName, &bucketIndex,
+			&point.Total, &point.Passed, &avgResponse, &point.AvgRTTMS, &point.MaxRTTMS); err != nil {
+			return nil, fmt.Errorf("failed to scan trend: %w", err)
+		}
+		point.BucketStart = time.Unix(bucketIndex*seconds, 0).UTC()
+		point.AvgResponseTimeMS = avgResponse.Float64
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// CountTestResults returns the number of test results matching the filter,
+// ignoring its limit, offset and sort order
+func (db *DB) CountTestResults(filter TestResultFilter) (int, error) {
+	where, args := filter.where()
+
+	var count int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM test_results"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count test results: %w", err)
+	}
+	return count, nil
+}
+
+// LatestRunID returns the run ID of the most recently saved test result that
+// has one, or "" if there is none
+func (db *DB) LatestRunID() (string, error) {
+	var runID string
+	err := db.conn.QueryRow(`
+		SELECT run_id FROM test_results
+		WHERE run_id != ''
+		ORDER BY tested_at DESC, id DESC
+		LIMIT 1
+	`).Scan(&runID)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get latest run ID: %w", err)
+	}
+
+	return runID, nil
+}
+
+// CreateRun records a test run that has just started
+func (db *DB) CreateRun(id string, startedAt time.Time) error {
+	// Times are stored as text, in UTC, so they compare in order
+	_, err := db.conn.Exec(`
+		INSERT INTO runs (id, status, started_at) VALUES (?, ?, ?)
+	`, id, RunStatusRunning, startedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create run: %w", err)
+	}
+	return nil
+}
+
+// SetRunAgents records the number of agents a run was triggered on
+func (db *DB) SetRunAgents(id string, agents int) error {
+	if _, err := db.conn.Exec("UPDATE runs SET agents = ? WHERE id = ?", agents, id); err != nil {
+		return fmt.Errorf("failed to update run: %w", err)
+	}
+	return nil
+}
+
+// FinishRun records that a running test run ended with the given status
+func (db *DB) FinishRun(id, status, errorMessage string) error {
+	_, err := db.conn.Exec(`
+		UPDATE runs SET status = ?, error_message = ?, finished_at = ?
+		WHERE id = ? AND status = ?
+	`, status, errorMessage, time.Now().UTC(), id, RunStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to finish run: %w", err)
+	}
+	return nil
+}
+
+// FailRunningRuns marks every run still running as failed, e.g. when the
+// aggregator restarted and stopped following them
+func (db *DB) FailRunningRuns(errorMessage string) error {
+	_, err := db.conn.Exec(`
+		UPDATE runs SET status = ?, error_message = ?, finished_at = ?
+		WHERE status = ?
+	`, RunStatusFailed, errorMessage, time.Now().UTC(), RunStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to update runs: %w", err)
+	}
+	return nil
+}
+
+// GetRuns returns the most recent test runs, or all of them for a zero limit
+func (db *DB) GetRuns(limit int) ([]Run, error) {
+	query := `
+		SELECT id, status, agents, error_message, started_at, finished_at
+		FROM runs
+		ORDER BY started_at DESC
+	`
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		if err := rows.Scan(&run.ID, &run.Status, &run.Agents, &run.ErrorMessage, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// GetRun returns a test run by ID, or nil if there is none
+func (db *DB) GetRun(id string) (*Run, error) {
+	var run Run
+	err := db.conn.QueryRow(`
+		SELECT id, status, agents, error_message, started_at, finished_at
+		FROM runs
+		WHERE id = ?
+	`, id).Scan(&run.ID, &run.Status, &run.Agents, &run.ErrorMessage, &run.StartedAt, &run.FinishedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get run: %w", err)
+	}
+
+	return &run, nil
+}
+
+// DeleteTestResultsBefore deletes the test results tested before cutoff,
+// and the runs started before it, returning the number of results deleted
+func (db *DB) DeleteTestResultsBefore(cutoff time.Time) (int64, error) {
+	// Times are stored as text, in UTC, so they compare in order
+	res, err := db.conn.Exec("DELETE FROM test_results WHERE tested_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete test results: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete test results: %w", err)
+	}
+
+	if _, err := db.conn.Exec("DELETE FROM runs WHERE started_at < ? AND status != ?", cutoff.UTC(), RunStatusRunning); err != nil {
+		return deleted, fmt.Errorf("failed to delete runs: %w", err)
+	}
+	return deleted, nil
+}
+
+// TrimTestResults deletes the oldest test results beyond the newest max,
+// returning the number deleted
+func (db *DB) TrimTestResults(max int) (int64, error) {
+	res, err := db.conn.Exec(`
+		DELETE FROM test_results WHERE id <= (
+			SELECT id FROM test_results ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, max)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim test results: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim test results: %w", err)
+	}
+	return deleted, nil
+}
+
 // ClearTestResults deletes all test results from the database
 func (db *DB) ClearTestResults() error {
 	_, err := db.conn.Exec("DELETE FROM test_results")
@@ -0,0 +1,321 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB opens an empty database that is closed with the test
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRunStatusTransitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		finish     []string // statuses FinishRun is called with, in order
+		failAll    bool     // FailRunningRuns is called afterwards
+		wantStatus string
+		wantError  string
+	}{
+		{"running", nil, false, RunStatusRunning, ""},
+		{"complete", []string{RunStatusComplete}, false, RunStatusComplete, ""},
+		{"failed", []string{RunStatusFailed}, false, RunStatusFailed, "boom"},
+		{"finished once", []string{RunStatusComplete, RunStatusFailed}, false, RunStatusComplete, ""},
+		{"restart fails running", nil, true, RunStatusFailed, "aggregator restarted"},
+		{"restart keeps finished", []string{RunStatusComplete}, true, RunStatusComplete, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.CreateRun("run-1", time.Now()); err != nil {
+				t.Fatalf("CreateRun() error = %v", err)
+			}
+			for _, status := range tt.finish {
+				errorMessage := ""
+				if status == RunStatusFailed {
+					errorMessage = "boom"
+				}
+				if err := db.FinishRun("run-1", status, errorMessage); err != nil {
+					t.Fatalf("FinishRun() error = %v", err)
+				}
+			}
+			if tt.failAll {
+				if err := db.FailRunningRuns("aggregator restarted"); err != nil {
+					t.Fatalf("FailRunningRuns() error = %v", err)
+				}
+			}
+
+			run, err := db.GetRun("run-1")
+			if err != nil || run == nil {
+				t.Fatalf("GetRun() = %v, %v", run, err)
+			}
+			if run.Status != tt.wantStatus || run.ErrorMessage != tt.wantError {
+				t.Errorf("run = %s %q, want %s %q", run.Status, run.ErrorMessage, tt.wantStatus, tt.wantError)
+			}
+			if (run.FinishedAt == nil) != (tt.wantStatus == RunStatusRunning) {
+				t.Errorf("finished_at = %v with status %s", run.FinishedAt, run.Status)
+			}
+		})
+	}
+}
+
+func TestRunTimesStoredInUTC(t *testing.T) {
+	db := newTestDB(t)
+	startedAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	if err := db.CreateRun("run-1", startedAt); err != nil {
+		t.Fatalf("CreateRun() error = %v", err)
+	}
+	if err := db.FinishRun("run-1", RunStatusComplete, ""); err != nil {
+		t.Fatalf("FinishRun() error = %v", err)
+	}
+
+	var started, finished string
+	if err := db.conn.QueryRow("SELECT started_at || '', finished_at || '' FROM runs").Scan(&started, &finished); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if !strings.HasPrefix(started, "2026-03-01 07:00:00") || !strings.HasSuffix(started, " UTC") {
+		t.Errorf("started_at = %q, want 2026-03-01 07:00:00 in UTC", started)
+	}
+	if !strings.HasSuffix(finished, " UTC") {
+		t.Errorf("finished_at = %q, want UTC", finished)
+	}
+}
+
+func TestNormalizeRunTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	// Recorded in the local time zone, as older versions did
+	startedAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("CET", 60*60))
+	if _, err := db.conn.Exec("INSERT INTO runs (id, status, started_at) VALUES (?, ?, ?)", "run-1", RunStatusRunning, startedAt); err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() error = %v", err)
+	}
+	defer db.Close()
+
+	var started string
+	if err := db.conn.QueryRow("SELECT started_at || '' FROM runs").Scan(&started); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if !strings.HasPrefix(started, "2026-03-01 08:00:00") || !strings.HasSuffix(started, " UTC") {
+		t.Errorf("started_at = %q, want 2026-03-01 08:00:00 in UTC", started)
+	}
+}
+
+func TestDeleteTestResultsBefore(t *testing.T) {
+	zones := []*time.Location{
+		time.UTC,
+		time.FixedZone("UTC+10", 10*60*60),
+		time.FixedZone("UTC-7", -7*60*60),
+	}
+
+	for _, zone := range zones {
+		t.Run(zone.String(), func(t *testing.T) {
+			// Times are created in the host's zone, like time.Now() on it
+			local := time.Local
+			time.Local = zone
+			t.Cleanup(func() { time.Local = local })
+
+			db := newTestDB(t)
+			now := time.Now()
+			for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute} {
+				result := TestResult{SourceHostname: "web1", TargetHostname: "web2", TestType: "icmp", TestedAt: now.Add(-age)}
+				if err := db.SaveTestResult(result); err != nil {
+					t.Fatalf("SaveTestResult() error = %v", err)
+				}
+			}
+			runs := []struct {
+				id  string
+				age time.Duration
+			}{
+				{"old", 2 * time.Hour},
+				{"old-running", 2 * time.Hour},
+				{"recent", 30 * time.Minute},
+			}
+			for _, run := range runs {
+				if err := db.CreateRun(run.id, now.Add(-run.age)); err != nil {
+					t.Fatalf("CreateRun() error = %v", err)
+				}
+				if run.id != "old-running" {
+					if err := db.FinishRun(run.id, RunStatusComplete, ""); err != nil {
+						t.Fatalf("FinishRun() error = %v", err)
+					}
+				}
+			}
+
+			deleted, err := db.DeleteTestResultsBefore(now.Add(-time.Hour))
+			if err != nil {
+				t.Fatalf("DeleteTestResultsBefore() error = %v", err)
+			}
+			if deleted != 2 {
+				t.Errorf("deleted = %d, want 2", deleted)
+			}
+
+			for _, want := range []struct {
+				id   string
+				kept bool
+			}{
+				{"old", false},
+				{"old-running", true},
+				{"recent", true},
+			} {
+				run, err := db.GetRun(want.id)
+				if err != nil {
+					t.Fatalf("GetRun() error = %v", err)
+				}
+				if (run != nil) != want.kept {
+					t.Errorf("run %s kept = %v, want %v", want.id, run != nil, want.kept)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimTestResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		saved       int
+		max         int
+		wantDeleted int64
+	}{
+		{"below max", 3, 5, 0},
+		{"at max", 5, 5, 0},
+		{"above max", 5, 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			for i := 0; i < tt.saved; i++ {
+				result := TestResult{SourceHostname: "web1", TargetHostname: "web2", TestType: "icmp", TestedAt: time.Now()}
+				if err := db.SaveTestResult(result); err != nil {
+					t.Fatalf("SaveTestResult() error = %v", err)
+				}
+			}
+
+			deleted, err := db.TrimTestResults(tt.max)
+			if err != nil {
+				t.Fatalf("TrimTestResults() error = %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+
+			// The newest results are the ones kept
+			results, err := db.FindTestResults(TestResultFilter{})
+			if err != nil {
+				t.Fatalf("FindTestResults() error = %v", err)
+			}
+			if want := tt.saved - int(tt.wantDeleted); len(results) != want || (want > 0 && results[0].ID != int64(tt.saved)) {
+				t.Errorf("kept %d results, newest %v, want %d up to id %d", len(results), results, want, tt.saved)
+			}
+		})
+	}
+}
+
+func TestEachTestResultPages(t *testing.T) {
+	db := newTestDB(t)
+	// More than two pages, with tied sort values across page boundaries
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	saved := 2*testResultPageSize + 10
+	for i := 0; i < saved; i++ {
+		result := TestResult{
+			SourceHostname: "web1",
+			TargetHostname: "web2",
+			TestType:       []string{"icmp", "udp"}[i%2],
+			Success:        i%3 != 0,
+			ResponseTime:   int64(i % 7),
+			TestedAt:       start.Add(time.Duration(i/4) * time.Second),
+		}
+		if err := db.SaveTestResult(result); err != nil {
+			t.Fatalf("SaveTestResult() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter TestResultFilter
+	}{
+		{"newest first", TestResultFilter{}},
+		{"oldest first", TestResultFilter{Ascending: true}},
+		{"by response time", TestResultFilter{Sort: "response_time_ms"}},
+		{"by test type ascending", TestResultFilter{Sort: "test_type", Ascending: true}},
+		{"filtered", TestResultFilter{TestType: "udp", Sort: "success"}},
+		{"limit across pages", TestResultFilter{Limit: testResultPageSize + 5}},
+		{"offset", TestResultFilter{Offset: testResultPageSize - 3, Sort: "response_time_ms", Ascending: true}},
+		{"limit and offset", TestResultFilter{Limit: 20, Offset: 1500}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := db.FindTestResults(tt.filter)
+			if err != nil {
+				t.Fatalf("FindTestResults() error = %v", err)
+			}
+
+			var got []int64
+			err = db.EachTestResult(tt.filter, func(result TestResult) error {
+				got = append(got, result.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("EachTestResult() error = %v", err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("got %d results, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i].ID {
+					t.Fatalf("result %d has id %d, want %d", i, got[i], want[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestEachTestResultReleasesDatabase(t *testing.T) {
+	db := newTestDB(t)
+	saved := testResultPageSize + 1
+	for i := 0; i < saved; i++ {
+		if err := db.SaveTestResult(TestResult{SourceHostname: "web1", TargetHostname: "web2", TestType: "icmp", TestedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveTestResult() error = %v", err)
+		}
+	}
+
+	// The database only has one connection, so using it would block forever
+	// if the results were still being read. The oldest result, on the second
+	// page, is deleted before that page is read.
+	var seen int
+	err := db.EachTestResult(TestResultFilter{}, func(result TestResult) error {
+		seen++
+		if seen > 1 {
+			return nil
+		}
+		_, err := db.conn.Exec("DELETE FROM test_results WHERE id = 1")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("EachTestResult() error = %v", err)
+	}
+	if seen != saved-1 {
+		t.Errorf("saw %d results, want %d", seen, saved-1)
+	}
+}
@@ -1,23 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"validate/agent"
 	"validate/aggregator"
+	"validate/auth"
 	"validate/config"
+	"validate/netplan"
 	"validate/sysinfo"
+	"validate/systemd"
 )
 
 func main() {
+	// Subcommands are dispatched before the server flags are parsed
+	if len(os.Args) > 1 && os.Args[1] == "netplan" {
+		os.Exit(runNetplanCommand(os.Args[2:]))
+	}
+
 	configFile := flag.String("config", "config.toml", "Path to configuration file")
 	generateConfig := flag.String("generate-config", "", "Generate a default config file (aggregator or agent)")
 	flag.Parse()
@@ -46,37 +58,195 @@ func main() {
 	}
 }
 
+// runNetplanCommand implements the netplan subcommands and returns the
+// process exit code
+func runNetplanCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: network-validator netplan lint [dir]")
+		return 2
+	}
+
+	switch args[0] {
+	case "lint":
+		return runNetplanLint(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown netplan command: %s\n", args[0])
+		return 2
+	}
+}
+
+// runNetplanLint validates every netplan file in a directory and prints a
+// JSON report. It exits with 1 when the configuration is invalid and 2 when
+// the directory could not be linted at all.
+func runNetplanLint(args []string) int {
+	flags := flag.NewFlagSet("netplan lint", flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "Treat warnings as errors")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	dir := "/etc/netplan"
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	report, err := netplan.LintNetplanDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to lint %s: %v\n", dir, err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode report: %v\n", err)
+		return 2
+	}
+
+	if !report.Valid || (*strict && len(report.Warnings) > 0) {
+		return 1
+	}
+	return 0
+}
+
 func runAggregator(cfg *config.Config) {
+	au, err := auth.New(cfg.Auth, cfg.TLS)
+	if err != nil {
+		log.Fatalf("Failed to load auth config: %v", err)
+	}
+
 	agg, err := aggregator.NewAggregator(cfg.Aggregator.Port, cfg.Aggregator.Database)
 	if err != nil {
 		log.Fatalf("Failed to create aggregator: %v", err)
 	}
 	defer agg.Close()
+	agg.SetAuth(au)
+	if err := agg.SetExternalEndpoints(cfg.Aggregator.ExternalEndpoints); err != nil {
+		log.Fatalf("Invalid aggregator config: %v", err)
+	}
+	agg.SetNTPServer(cfg.Aggregator.NTPServer)
+	if err := agg.SetExpectedPorts(cfg.Aggregator.ExpectedPorts); err != nil {
+		log.Fatalf("Invalid aggregator config: %v", err)
+	}
+	if err := agg.SetTLSPorts(cfg.Aggregator.TLSPorts); err != nil {
+		log.Fatalf("Invalid aggregator config: %v", err)
+	}
+	agg.SetRetention(time.Duration(cfg.Aggregator.RetentionDays)*24*time.Hour, cfg.Aggregator.MaxResults)
+	if err := agg.SetAlerts(cfg.Aggregator.AlertWebhooks, time.Duration(cfg.Aggregator.AlertCooldown)*time.Second, time.Duration(cfg.Aggregator.StaleAfter)*time.Second); err != nil {
+		log.Fatalf("Invalid aggregator config: %v", err)
+	}
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown, letting requests in flight finish
+	stopped := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 		log.Println("Shutting down aggregator...")
-		agg.Stop()
-		os.Exit(0)
+		systemd.Stopping()
+		if err := agg.Stop(); err != nil {
+			log.Printf("Failed to shut down gracefully: %v", err)
+		}
+		close(stopped)
 	}()
 
-	log.Fatal(agg.Start())
+	// Keep-alives stop with the process
+	go systemd.StartWatchdog(nil)
+
+	// Serving ends as soon as the shutdown starts, before requests drained
+	if err := agg.Start(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
 }
 
+// agentDrainTimeout is how long an agent shutting down waits for cancelled
+// test runs to submit their results and for requests in flight, such as tests
+// run while the caller waits, to finish
+const agentDrainTimeout = 15 * time.Second
+
 func runAgent(cfg *config.Config) {
+	logger, err := newAgentLogger(cfg.Agent.LogLevel, cfg.Agent.LogFormat)
+	if err != nil {
+		log.Fatalf("Invalid logging config: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	au, err := auth.New(cfg.Auth, cfg.TLS)
+	if err != nil {
+		log.Fatalf("Failed to load auth config: %v", err)
+	}
+
 	// Create agent
 	ag, err := agent.NewAgent(cfg.Agent.AggregatorURL)
 	if err != nil {
 		log.Fatalf("Failed to create agent: %v", err)
 	}
+	ag.SetAuth(au)
+	ag.SetListenAddr(cfg.Agent.ListenAddr, cfg.Agent.AdvertiseURL)
+	ag.SetParallelism(cfg.Agent.MaxParallelTests, cfg.Agent.MaxParallelTestsPerInterface)
+	ag.SetProbeRate(cfg.Agent.MaxProbesPerSecond, cfg.Agent.MaxProbesPerSecondPerInterface)
+	if err := ag.SetHTTPTest(agent.TestOptions{
+		Port:           cfg.Agent.HTTPTest.Port,
+		Path:           cfg.Agent.HTTPTest.Path,
+		Scheme:         cfg.Agent.HTTPTest.Scheme,
+		ExpectedStatus: cfg.Agent.HTTPTest.ExpectedStatus,
+		TimeoutMS:      cfg.Agent.HTTPTest.TimeoutMS,
+	}); err != nil {
+		log.Fatalf("Invalid http_test config: %v", err)
+	}
+	if err := ag.SetNamespaces(cfg.Agent.Namespaces); err != nil {
+		log.Fatalf("Invalid namespaces config: %v", err)
+	}
+	if err := ag.SetProfiles(testProfiles(cfg.Agent.Profiles)); err != nil {
+		log.Fatalf("Invalid profiles config: %v", err)
+	}
+	ag.SetResultBatching(cfg.Agent.ResultBatchSize, time.Duration(cfg.Agent.ResultBatchInterval)*time.Second)
+	ag.SetSubmitRetry(cfg.Agent.SubmitAttempts, time.Duration(cfg.Agent.SubmitRetryBackoffMS)*time.Millisecond)
+	ag.SetCircuitBreaker(cfg.Agent.CircuitBreakerThreshold, time.Duration(cfg.Agent.CircuitBreakerCooldown)*time.Second)
 
 	// Start periodic registration in background
 	stopChan := make(chan struct{})
+	// Test runs last as long as the server, and are cancelled when it shuts down
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
 	go ag.StartPeriodicRegistration(time.Duration(cfg.Agent.RegisterInterval)*time.Second, stopChan)
 
+	// Resubmit results the aggregator could not receive when they were produced
+	if err := ag.SetSpoolDir(cfg.Agent.SpoolDir); err != nil {
+		log.Printf("Warning: results will not be spooled: %v", err)
+	} else {
+		go ag.StartResultRetry(stopChan)
+	}
+
+	// Repeat the last test run on a schedule, independently of the aggregator
+	if schedule, err := selfTestSchedule(cfg.Agent); err != nil {
+		log.Fatalf("Invalid self-test schedule: %v", err)
+	} else if schedule != nil {
+		go ag.StartScheduledTests(runCtx, schedule)
+	}
+
+	// Learn the switch ports of the host's NICs for registrations
+	go func() {
+		if err := ag.StartLLDPCapture(stopChan); err != nil {
+			log.Printf("LLDP capture stopped: %v", err)
+		}
+	}()
+
+	// Answer UDP echo probes from other agents
+	go func() {
+		if err := agent.StartUDPEcho(fmt.Sprintf(":%d", agent.UDPEchoPort), stopChan); err != nil {
+			log.Printf("UDP echo responder stopped: %v", err)
+		}
+	}()
+	for _, namespace := range ag.Namespaces() {
+		go func() {
+			if err := agent.StartUDPEchoInNamespace(namespace, fmt.Sprintf(":%d", agent.UDPEchoPort), stopChan); err != nil {
+				log.Printf("UDP echo responder in network namespace %s stopped: %v", namespace, err)
+			}
+		}()
+	}
+
 	// Start HTTP server for receiving test requests
 	mux := http.NewServeMux()
 
@@ -86,31 +256,161 @@ func runAgent(cfg *config.Config) {
 	// Endpoint for health check
 	mux.HandleFunc("GET /api/health", handleHealth)
 
-	// Endpoint for running connectivity tests
-	mux.HandleFunc("POST /api/run-tests", func(w http.ResponseWriter, r *http.Request) {
-		handleRunTests(w, r, ag)
+	// Endpoint listing the local addresses targets are matched against
+	mux.HandleFunc("GET /api/interfaces", func(w http.ResponseWriter, r *http.Request) {
+		handleInterfaces(w, r, ag)
+	})
+
+	// Endpoint for aborting a running test run
+	mux.HandleFunc("POST /api/cancel-tests", au.Require(func(w http.ResponseWriter, r *http.Request) {
+		handleCancelTests(w, r, ag)
+	}))
+
+	// Endpoint answering clock skew tests from other agents
+	mux.HandleFunc("GET /api/time", agent.HandleTimeExchange)
+
+	// Endpoint receiving the bandwidth test stream from other agents
+	mux.HandleFunc("POST /api/throughput", agent.HandleThroughputSink)
+
+	// Endpoint reporting how far the current test run has got
+	mux.HandleFunc("GET /api/test-progress", func(w http.ResponseWriter, r *http.Request) {
+		handleTestProgress(w, r, ag)
 	})
 
+	// Endpoint for running a single test while the caller waits
+	mux.HandleFunc("POST /api/test-now", au.Require(func(w http.ResponseWriter, r *http.Request) {
+		handleTestNow(w, r, ag)
+	}))
+
+	// Endpoint for running connectivity tests
+	mux.HandleFunc("POST /api/run-tests", au.Require(func(w http.ResponseWriter, r *http.Request) {
+		handleRunTests(w, r, ag, runCtx)
+	}))
+
 	server := &http.Server{
 		Addr:         cfg.Agent.ListenAddr,
 		Handler:      loggingMiddleware(mux),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		TLSConfig:    au.ServerTLSConfig(),
 	}
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown, letting requests in flight finish
+	stopped := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 		log.Println("Shutting down agent...")
+		systemd.Stopping()
 		close(stopChan)
-		server.Close()
-		os.Exit(0)
+		ctx, cancel := context.WithTimeout(context.Background(), agentDrainTimeout)
+		defer cancel()
+		// Cancelled runs still submit, or spool, the results they have
+		cancelRuns()
+		if !ag.WaitForTests(ctx) {
+			log.Printf("Test runs did not finish submitting results within %v", agentDrainTimeout)
+		}
+		if err := ag.Deregister(); err != nil {
+			log.Printf("Failed to deregister from aggregator: %v", err)
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down gracefully: %v", err)
+			server.Close()
+		}
+		close(stopped)
 	}()
 
-	log.Printf("Agent HTTP server listening on %s", cfg.Agent.ListenAddr)
-	log.Fatal(server.ListenAndServe())
+	// Serve the API in every network namespace too, so agents testing the
+	// links there can reach it
+	for _, namespace := range ag.Namespaces() {
+		ln, err := agent.ListenInNamespace(namespace, "tcp", cfg.Agent.ListenAddr)
+		if err != nil {
+			log.Printf("Warning: API not served in network namespace %s: %v", namespace, err)
+			continue
+		}
+		go func() {
+			if err := au.Serve(server, ln); err != http.ErrServerClosed {
+				log.Printf("API server in network namespace %s stopped: %v", namespace, err)
+			}
+		}()
+		log.Printf("Agent %s server listening on %s in network namespace %s", strings.ToUpper(au.Scheme()), cfg.Agent.ListenAddr, namespace)
+	}
+
+	ln, err := net.Listen("tcp", cfg.Agent.ListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.Agent.ListenAddr, err)
+	}
+	if err := systemd.Ready(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	go systemd.StartWatchdog(stopChan)
+
+	log.Printf("Agent %s server listening on %s", strings.ToUpper(au.Scheme()), cfg.Agent.ListenAddr)
+	err = au.Serve(server, ln)
+	// Serving ends as soon as the shutdown starts, before requests drained
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+}
+
+// newAgentLogger returns the structured logger of an agent, writing text or
+// JSON records of at least the given level to stderr
+func newAgentLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log_level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log_format %q (must be 'text' or 'json')", format)
+}
+
+// testProfiles converts the test profiles of the agent config
+func testProfiles(profiles map[string]config.TestProfileConfig) map[string]agent.TestProfile {
+	result := make(map[string]agent.TestProfile, len(profiles))
+	for name, profile := range profiles {
+		options := make(map[string]agent.TestOptions, len(profile.Options))
+		for testType, opts := range profile.Options {
+			options[testType] = agent.TestOptions{
+				Count:           opts.Count,
+				TimeoutMS:       opts.TimeoutMS,
+				DurationSeconds: opts.DurationSeconds,
+				Streams:         opts.Streams,
+				MaxOffsetMS:     opts.MaxOffsetMS,
+				DSCP:            opts.DSCP,
+				Port:            opts.Port,
+				Path:            opts.Path,
+				Scheme:          opts.Scheme,
+				ExpectedStatus:  opts.ExpectedStatus,
+			}
+		}
+		result[name] = agent.TestProfile{
+			TestTypes: profile.TestTypes,
+			Options:   options,
+			Ports:     profile.Ports,
+		}
+	}
+	return result
+}
+
+// selfTestSchedule returns the schedule of the agent's self-tests, or nil
+// when they are disabled
+func selfTestSchedule(cfg config.AgentConfig) (agent.Schedule, error) {
+	if cfg.SelfTestCron != "" {
+		return agent.ParseCron(cfg.SelfTestCron)
+	}
+	if cfg.SelfTestInterval > 0 {
+		return agent.IntervalSchedule(time.Duration(cfg.SelfTestInterval) * time.Second), nil
+	}
+	return nil, nil
 }
 
 // Agent HTTP handlers
@@ -132,13 +432,14 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		"status":    "healthy",
 		"timestamp": time.Now(),
 		"mode":      "agent",
+		"version":   agent.Version(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(health)
 }
 
-func handleRunTests(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
+func handleRunTests(w http.ResponseWriter, r *http.Request, ag *agent.Agent, runCtx context.Context) {
 	var testReq agent.TestRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&testReq); err != nil {
@@ -146,13 +447,23 @@ func handleRunTests(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
 		return
 	}
 
+	if err := testReq.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid test request: %v", err), http.StatusBadRequest)
+		return
+	}
+	testReq, err := ag.ResolveProfile(testReq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid test request: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("Received request to run connectivity tests to %d targets", len(testReq.Targets))
 
 	// Run tests asynchronously in background
 	// Results are now submitted as each test completes
 	go func() {
 		log.Printf("Starting connectivity tests in background")
-		ag.RunConnectivityTests(testReq.Targets)
+		ag.RunConnectivityTests(runCtx, testReq)
 		log.Printf("Connectivity tests completed")
 	}()
 
@@ -166,6 +477,66 @@ func handleRunTests(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func handleInterfaces(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
+	addrs, err := ag.SourceAddresses()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting local addresses: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addrs)
+}
+
+func handleTestProgress(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ag.Progress())
+}
+
+func handleTestNow(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
+	var testReq agent.AdHocTestRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&testReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := testReq.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid test request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Bandwidth tests and traceroutes can outlast the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to lift write deadline: %v", err)
+	}
+
+	log.Printf("Running %s test to %s", testReq.TestType, testReq.TargetIP)
+	result, err := ag.RunAdHocTest(r.Context(), testReq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to run test: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleCancelTests(w http.ResponseWriter, r *http.Request, ag *agent.Agent) {
+	response := map[string]interface{}{
+		"status":  "idle",
+		"message": "No connectivity tests running",
+	}
+	if ag.CancelTests() {
+		log.Printf("Cancelled running connectivity tests")
+		response["status"] = "cancelled"
+		response["message"] = "Connectivity tests cancelled"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -0,0 +1,261 @@
+package netplan
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConflictType classifies problems found when analyzing multiple netplan files
+type ConflictType string
+
+const (
+	// ConflictContradictory means an interface is defined in several files
+	// with different values for the same setting
+	ConflictContradictory ConflictType = "contradictory-definition"
+	// ConflictDuplicateAddress means the same IP address is assigned to
+	// more than one interface
+	ConflictDuplicateAddress ConflictType = "duplicate-address"
+	// ConflictEnslavedAddressed means an interface is a bond member or
+	// bridge port but also has its own IP configuration
+	ConflictEnslavedAddressed ConflictType = "enslaved-and-addressed"
+)
+
+// Conflict describes a single problem found across netplan files
+type Conflict struct {
+	Type      ConflictType `json:"type"`
+	Interface string       `json:"interface"`
+	Files     []string     `json:"files"`
+	Message   string       `json:"message"`
+}
+
+// String returns a human readable description of the conflict
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s (%s)", c.Type, c.Message, strings.Join(c.Files, ", "))
+}
+
+// AnalyzeNetplanDir loads every netplan file in a directory and reports
+// conflicts between them
+func AnalyzeNetplanDir(dir string) ([]Conflict, error) {
+	files, err := LoadNetplanFilesFromDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load netplan configs: %w", err)
+	}
+
+	return AnalyzeConfigFiles(files), nil
+}
+
+// fileDefinition is an interface definition together with its source file
+type fileDefinition struct {
+	interfaceDefinition
+	Path string
+}
+
+// AnalyzeConfigFiles reports conflicts between a set of netplan files
+func AnalyzeConfigFiles(files []ConfigFile) []Conflict {
+	var conflicts []Conflict
+
+	byName := make(map[string][]fileDefinition)
+	var names []string
+	for _, file := range files {
+		for _, def := range file.Config.definitions() {
+			if _, seen := byName[def.Name]; !seen {
+				names = append(names, def.Name)
+			}
+			byName[def.Name] = append(byName[def.Name], fileDefinition{def, file.Path})
+		}
+	}
+	sort.Strings(names)
+
+	// 1. Interfaces defined in several files with contradictory settings
+	for _, name := range names {
+		defs := byName[name]
+		for i := 1; i < len(defs); i++ {
+			conflicts = append(conflicts, compareDefinitions(defs[0], defs[i])...)
+		}
+	}
+
+	// 2. The same address assigned to more than one interface
+	type addressOwner struct {
+		iface string
+		path  string
+	}
+	owners := make(map[string][]addressOwner)
+	var addresses []string
+	for _, name := range names {
+		for _, def := range byName[name] {
+			if def.Common == nil {
+				continue
+			}
+			for _, addr := range def.Common.Addresses {
+				ip := stripCIDR(addr)
+				if _, seen := owners[ip]; !seen {
+					addresses = append(addresses, ip)
+				}
+				owners[ip] = append(owners[ip], addressOwner{def.Name, def.Path})
+			}
+		}
+	}
+	sort.Strings(addresses)
+
+	for _, ip := range addresses {
+		var ifaces, paths []string
+		seenIface := make(map[string]bool)
+		for _, owner := range owners[ip] {
+			if !seenIface[owner.iface] {
+				seenIface[owner.iface] = true
+				ifaces = append(ifaces, owner.iface)
+			}
+			paths = appendUnique(paths, owner.path)
+		}
+		if len(ifaces) > 1 {
+			conflicts = append(conflicts, Conflict{
+				Type:      ConflictDuplicateAddress,
+				Interface: strings.Join(ifaces, ", "),
+				Files:     paths,
+				Message:   fmt.Sprintf("address %s is assigned to multiple interfaces: %s", ip, strings.Join(ifaces, ", ")),
+			})
+		}
+	}
+
+	// 3. Bond members and bridge ports that also carry IP configuration
+	type enslavement struct {
+		master string
+		kind   InterfaceKind
+		path   string
+	}
+	enslaved := make(map[string][]enslavement)
+	for _, file := range files {
+		for name, bond := range file.Config.Network.Bonds {
+			for _, member := range bond.Interfaces {
+				enslaved[member] = append(enslaved[member], enslavement{name, KindBond, file.Path})
+			}
+		}
+		for name, bridge := range file.Config.Network.Bridges {
+			for _, port := range bridge.Interfaces {
+				enslaved[port] = append(enslaved[port], enslavement{name, KindBridge, file.Path})
+			}
+		}
+	}
+
+	for _, name := range names {
+		masters, ok := enslaved[name]
+		if !ok {
+			continue
+		}
+		for _, def := range byName[name] {
+			if def.Common == nil || !isAddressed(def.Common) {
+				continue
+			}
+			for _, master := range masters {
+				conflicts = append(conflicts, Conflict{
+					Type:      ConflictEnslavedAddressed,
+					Interface: name,
+					Files:     appendUnique([]string{master.path}, def.Path),
+					Message:   fmt.Sprintf("%s is enslaved to %s %s but also has IP configuration", name, master.kind, master.master),
+				})
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// compareDefinitions reports settings that differ between two definitions
+// of the same interface
+func compareDefinitions(a, b fileDefinition) []Conflict {
+	files := appendUnique([]string{a.Path}, b.Path)
+
+	if a.Kind != b.Kind {
+		return []Conflict{{
+			Type:      ConflictContradictory,
+			Interface: a.Name,
+			Files:     files,
+			Message:   fmt.Sprintf("%s is defined as both %s and %s", a.Name, a.Kind, b.Kind),
+		}}
+	}
+
+	aFields, errA := definitionFields(a.Value)
+	bFields, errB := definitionFields(b.Value)
+	if errA != nil || errB != nil {
+		return nil
+	}
+
+	keys := contradictorySettings("", aFields, bFields)
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	return []Conflict{{
+		Type:      ConflictContradictory,
+		Interface: a.Name,
+		Files:     files,
+		Message:   fmt.Sprintf("%s %s has contradictory settings: %s", a.Kind, a.Name, strings.Join(keys, ", ")),
+	}}
+}
+
+// contradictorySettings returns the dotted paths of scalar settings that
+// differ between a and b. Netplan merges mappings key by key and
+// concatenates lists across files, so only settings one file overrides in
+// the other are reported.
+func contradictorySettings(prefix string, a, b map[string]interface{}) []string {
+	var keys []string
+	for key, aValue := range a {
+		bValue, exists := b[key]
+		if !exists {
+			continue
+		}
+		path := prefix + key
+
+		aMap, aIsMap := aValue.(map[string]interface{})
+		bMap, bIsMap := bValue.(map[string]interface{})
+		_, aIsList := aValue.([]interface{})
+		_, bIsList := bValue.([]interface{})
+		switch {
+		case aIsMap && bIsMap:
+			keys = append(keys, contradictorySettings(path+".", aMap, bMap)...)
+		case aIsList && bIsList:
+			// Concatenated
+		case !reflect.DeepEqual(aValue, bValue):
+			keys = append(keys, path)
+		}
+	}
+	return keys
+}
+
+// definitionFields converts an interface definition into a map keyed by
+// its YAML field names
+func definitionFields(value interface{}) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// isAddressed reports whether an interface has static addresses or DHCP enabled
+func isAddressed(iface *CommonInterface) bool {
+	return len(iface.Addresses) > 0 ||
+		(iface.DHCP4 != nil && *iface.DHCP4) ||
+		(iface.DHCP6 != nil && *iface.DHCP6)
+}
+
+// appendUnique appends value to list unless it is already present
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
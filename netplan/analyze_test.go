@@ -0,0 +1,144 @@
+package netplan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeNetplanDir(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"01-base.yaml": `network:
+  version: 2
+  ethernets:
+    eth0:
+      mtu: 1500
+      addresses: [10.0.0.1/24]
+    eth1:
+      dhcp4: false
+  bonds:
+    bond0:
+      interfaces: [eth1]
+      addresses: [10.0.1.1/24]`,
+		"50-override.yaml": `network:
+  version: 2
+  ethernets:
+    eth0:
+      mtu: 9000
+    eth1:
+      addresses: [192.168.0.1/24]
+    eth2:
+      addresses: [10.0.1.1/24]`,
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	conflicts, err := AnalyzeNetplanDir(dir)
+	if err != nil {
+		t.Fatalf("AnalyzeNetplanDir failed: %v", err)
+	}
+
+	found := make(map[ConflictType][]Conflict)
+	for _, conflict := range conflicts {
+		found[conflict.Type] = append(found[conflict.Type], conflict)
+	}
+
+	if len(found[ConflictContradictory]) != 1 || found[ConflictContradictory][0].Interface != "eth0" {
+		t.Errorf("Expected one contradictory definition for eth0, got %v", found[ConflictContradictory])
+	}
+	if len(found[ConflictDuplicateAddress]) != 1 || found[ConflictDuplicateAddress][0].Interface != "bond0, eth2" {
+		t.Errorf("Expected duplicate address on bond0 and eth2, got %v", found[ConflictDuplicateAddress])
+	}
+	if len(found[ConflictEnslavedAddressed]) != 1 || found[ConflictEnslavedAddressed][0].Interface != "eth1" {
+		t.Errorf("Expected eth1 to be reported as enslaved and addressed, got %v", found[ConflictEnslavedAddressed])
+	}
+	if len(conflicts) != 3 {
+		t.Errorf("Expected 3 conflicts, got %d: %v", len(conflicts), conflicts)
+	}
+}
+
+func TestAnalyzeNetplanDirMergedDefinitions(t *testing.T) {
+	tests := []struct {
+		name         string
+		base         string
+		override     string
+		wantSettings string
+	}{
+		{
+			name: "split addresses",
+			base: `ethernets:
+    eth0:
+      addresses: [10.0.0.1/24]`,
+			override: `ethernets:
+    eth0:
+      addresses: [10.0.0.2/24]`,
+		},
+		{
+			name: "split parameters",
+			base: `bonds:
+    bond0:
+      interfaces: [eth0]
+      parameters:
+        mode: active-backup`,
+			override: `bonds:
+    bond0:
+      interfaces: [eth1]
+      parameters:
+        mii-monitor-interval: 100`,
+		},
+		{
+			name: "contradictory parameter",
+			base: `bonds:
+    bond0:
+      parameters:
+        mode: active-backup
+        mii-monitor-interval: 100`,
+			override: `bonds:
+    bond0:
+      parameters:
+        mode: 802.3ad
+        mii-monitor-interval: 100`,
+			wantSettings: "parameters.mode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range map[string]string{"01-base.yaml": tt.base, "50-override.yaml": tt.override} {
+				content = "network:\n  version: 2\n  " + content
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatalf("Failed to write %s: %v", name, err)
+				}
+			}
+
+			conflicts, err := AnalyzeNetplanDir(dir)
+			if err != nil {
+				t.Fatalf("AnalyzeNetplanDir failed: %v", err)
+			}
+
+			var contradictory []Conflict
+			for _, conflict := range conflicts {
+				if conflict.Type == ConflictContradictory {
+					contradictory = append(contradictory, conflict)
+				}
+			}
+			if tt.wantSettings == "" {
+				if len(contradictory) != 0 {
+					t.Errorf("Expected no contradictory definitions, got %v", contradictory)
+				}
+				return
+			}
+			if len(contradictory) != 1 || !strings.HasSuffix(contradictory[0].Message, ": "+tt.wantSettings) {
+				t.Errorf("Expected %s to be contradictory, got %v", tt.wantSettings, contradictory)
+			}
+		})
+	}
+}
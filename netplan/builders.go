@@ -1,5 +1,7 @@
 package netplan
 
+import "fmt"
+
 // NewConfig creates a new netplan configuration with default values
 func NewConfig() *Config {
 	return &Config{
@@ -11,6 +13,9 @@ func NewConfig() *Config {
 
 // AddEthernet adds an ethernet interface configuration
 func (c *Config) AddEthernet(name string, config *Ethernet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.Ethernets == nil {
 		c.Network.Ethernets = make(map[string]*Ethernet)
 	}
@@ -19,6 +24,9 @@ func (c *Config) AddEthernet(name string, config *Ethernet) {
 
 // AddWifi adds a wifi interface configuration
 func (c *Config) AddWifi(name string, config *Wifi) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.Wifis == nil {
 		c.Network.Wifis = make(map[string]*Wifi)
 	}
@@ -27,6 +35,9 @@ func (c *Config) AddWifi(name string, config *Wifi) {
 
 // AddBridge adds a bridge interface configuration
 func (c *Config) AddBridge(name string, config *Bridge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.Bridges == nil {
 		c.Network.Bridges = make(map[string]*Bridge)
 	}
@@ -35,6 +46,9 @@ func (c *Config) AddBridge(name string, config *Bridge) {
 
 // AddBond adds a bond interface configuration
 func (c *Config) AddBond(name string, config *Bond) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.Bonds == nil {
 		c.Network.Bonds = make(map[string]*Bond)
 	}
@@ -43,6 +57,9 @@ func (c *Config) AddBond(name string, config *Bond) {
 
 // AddVLAN adds a VLAN interface configuration
 func (c *Config) AddVLAN(name string, config *VLAN) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.VLANs == nil {
 		c.Network.VLANs = make(map[string]*VLAN)
 	}
@@ -51,12 +68,26 @@ func (c *Config) AddVLAN(name string, config *VLAN) {
 
 // AddTunnel adds a tunnel interface configuration
 func (c *Config) AddTunnel(name string, config *Tunnel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.Network.Tunnels == nil {
 		c.Network.Tunnels = make(map[string]*Tunnel)
 	}
 	c.Network.Tunnels[name] = config
 }
 
+// AddVRF adds a VRF configuration
+func (c *Config) AddVRF(name string, config *VRF) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Network.VRFs == nil {
+		c.Network.VRFs = make(map[string]*VRF)
+	}
+	c.Network.VRFs[name] = config
+}
+
 // Helper functions for creating common configurations
 
 // NewEthernetDHCP creates an ethernet interface with DHCP configuration
@@ -95,6 +126,48 @@ func NewEthernetStatic(addresses []string, gateway4, gateway6 string, nameserver
 	return eth
 }
 
+// NewEthernetStaticV6 creates an IPv6-only ethernet interface with static
+// addresses. DHCPv4 is disabled and router advertisements are ignored so
+// only the configured addresses and gateway are used.
+func NewEthernetStaticV6(addresses []string, gateway6 string, nameservers []string) *Ethernet {
+	eth := NewEthernetStatic(addresses, "", gateway6, nameservers)
+	eth.DHCP4 = Bool(false)
+	eth.DHCP6 = Bool(false)
+	eth.SetAcceptRA(false)
+	eth.SetLinkLocal(LinkLocalIPv6)
+	return eth
+}
+
+// NewEthernetDHCP6Only creates an ethernet interface that is configured
+// through DHCPv6 and router advertisements only
+func NewEthernetDHCP6Only() *Ethernet {
+	eth := &Ethernet{
+		CommonInterface: CommonInterface{
+			DHCP4: Bool(false),
+			DHCP6: Bool(true),
+		},
+	}
+	eth.SetAcceptRA(true)
+	return eth
+}
+
+// SetAcceptRA controls whether router advertisements are accepted
+func (i *CommonInterface) SetAcceptRA(accept bool) {
+	i.AcceptRA = Bool(accept)
+}
+
+// SetIPv6Privacy enables or disables IPv6 privacy extensions (RFC 4941)
+func (i *CommonInterface) SetIPv6Privacy(enabled bool) {
+	i.IPv6Privacy = Bool(enabled)
+}
+
+// SetLinkLocal sets the address families that get link-local addresses.
+// Calling it without arguments disables link-local addressing.
+func (i *CommonInterface) SetLinkLocal(families ...string) {
+	i.LinkLocal = LinkLocal{}
+	i.LinkLocal = append(i.LinkLocal, families...)
+}
+
 // NewWifiWPA creates a WiFi interface with WPA/WPA2 configuration
 func NewWifiWPA(ssid, password string) *Wifi {
 	dhcp4 := true
@@ -147,6 +220,73 @@ func NewVLAN(id int, link string) *VLAN {
 	}
 }
 
+// NewVRF creates a VRF bound to the given routing table with the given member interfaces
+func NewVRF(table int, interfaces []string) *VRF {
+	return &VRF{
+		Table:      table,
+		Interfaces: interfaces,
+	}
+}
+
+// NewSRIOVEthernet creates an ethernet physical function with the given number of virtual functions
+func NewSRIOVEthernet(totalVFs int) *Ethernet {
+	return &Ethernet{
+		CommonInterface: CommonInterface{
+			SRIOV: &SRIOV{
+				TotalVFs: totalVFs,
+			},
+		},
+	}
+}
+
+// Update runs fn with exclusive access to the configuration, for changes
+// that modify Network directly while other goroutines use the Config
+func (c *Config) Update(fn func(network *Network)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn(&c.Network)
+}
+
+// AssignVF adds virtual function id of the physical function link to the
+// VF table, optionally pinning its MAC address. The returned VFConfig can
+// be used to set further properties such as the VLAN or trust mode.
+func (c *Config) AssignVF(link string, id int, mac string) (*VFConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pf, exists := c.Network.Ethernets[link]
+	if !exists {
+		return nil, fmt.Errorf("ethernet %s is not defined", link)
+	}
+	if pf.SRIOV == nil {
+		return nil, fmt.Errorf("ethernet %s has no sriov configuration", link)
+	}
+	if id < 0 || id >= pf.SRIOV.TotalVFs {
+		return nil, fmt.Errorf("invalid vf id %d for %s (must be 0-%d)", id, link, pf.SRIOV.TotalVFs-1)
+	}
+	if mac != "" && !isValidMAC(mac) {
+		return nil, fmt.Errorf("invalid macaddress %s", mac)
+	}
+
+	for name, vf := range pf.SRIOV.VFTable {
+		if vf != nil && vf.ID == id {
+			return nil, fmt.Errorf("vf id %d of %s is already assigned as %s", id, link, name)
+		}
+	}
+
+	if pf.SRIOV.VFTable == nil {
+		pf.SRIOV.VFTable = make(map[string]*VFConfig)
+	}
+	vf := &VFConfig{
+		ID:         id,
+		MacAddress: mac,
+	}
+	pf.SRIOV.VFTable[fmt.Sprintf("vf%d", id)] = vf
+
+	return vf, nil
+}
+
 // Bool is a helper function to create a pointer to a boolean value
 func Bool(b bool) *bool {
 	return &b
@@ -0,0 +1,260 @@
+package netplan
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Equal reports whether two configurations are semantically equivalent.
+// Unset boolean pointers are treated the same as their netplan default,
+// the ordering of member interfaces and routes is ignored, and empty
+// sections compare equal to missing ones. Addresses, nameservers and search
+// domains are compared in order: the first address is the interface's
+// primary one, and nameservers and search domains are tried in the order
+// given.
+func (c *Config) Equal(other *Config) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+
+	a, err := c.clone()
+	if err != nil {
+		return false
+	}
+	b, err := other.clone()
+	if err != nil {
+		return false
+	}
+
+	a.Normalize()
+	b.Normalize()
+
+	return reflect.DeepEqual(a, b)
+}
+
+// clone returns a deep copy of the configuration by round-tripping it
+// through YAML, holding c.mu while the configuration is read. Empty maps and
+// slices are dropped in the process.
+func (c *Config) clone() (*Config, error) {
+	c.mu.Lock()
+	data, err := c.toYAML()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return LoadConfigFromBytes(data)
+}
+
+// Normalize rewrites the configuration in place into a canonical form:
+// lists whose order netplan ignores, such as member interfaces and routes,
+// are sorted, while addresses and nameservers keep their order; fields
+// equal to their netplan default are removed
+// and MAC addresses are lower-cased. Two logically identical configurations
+// serialize to byte-identical YAML after being normalized.
+// Normalizing discards the formatting and comments of the source document.
+func (c *Config) Normalize() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.node = nil
+
+	if c.Network.Renderer == string(RendererNetworkd) {
+		c.Network.Renderer = ""
+	}
+
+	for _, eth := range c.Network.Ethernets {
+		normalizeCommon(&eth.CommonInterface)
+	}
+	for _, wifi := range c.Network.Wifis {
+		normalizeCommon(&wifi.CommonInterface)
+		for _, ap := range wifi.AccessPoints {
+			if ap != nil {
+				clearDefaultBool(&ap.Hidden, false)
+				ap.BSSID = strings.ToLower(ap.BSSID)
+			}
+		}
+	}
+	for _, bridge := range c.Network.Bridges {
+		normalizeCommon(&bridge.CommonInterface)
+		sort.Strings(bridge.Interfaces)
+		if bridge.Parameters != nil {
+			clearDefaultBool(&bridge.Parameters.STP, true)
+			if *bridge.Parameters == (BridgeParameters{}) {
+				bridge.Parameters = nil
+			}
+		}
+	}
+	for _, bond := range c.Network.Bonds {
+		normalizeCommon(&bond.CommonInterface)
+		sort.Strings(bond.Interfaces)
+		if bond.Parameters != nil {
+			clearDefaultBool(&bond.Parameters.AllSlavesActive, false)
+			sort.Strings(bond.Parameters.ARPIPTargets)
+		}
+	}
+	for _, vlan := range c.Network.VLANs {
+		normalizeCommon(&vlan.CommonInterface)
+	}
+	for _, tunnel := range c.Network.Tunnels {
+		normalizeCommon(&tunnel.CommonInterface)
+	}
+	for _, vrf := range c.Network.VRFs {
+		sort.Strings(vrf.Interfaces)
+		for i := range vrf.Routes {
+			clearDefaultBool(&vrf.Routes[i].OnLink, false)
+		}
+		sortRoutes(vrf.Routes)
+		sortRoutingPolicy(vrf.RoutingPolicy)
+	}
+	for _, modem := range c.Network.Modems {
+		normalizeCommon(&modem.CommonInterface)
+		clearDefaultBool(&modem.AutoConfig, false)
+	}
+}
+
+// normalizeCommon normalizes the properties shared by all interface types
+func normalizeCommon(iface *CommonInterface) {
+	clearDefaultBool(&iface.DHCP4, false)
+	clearDefaultBool(&iface.DHCP6, false)
+	clearDefaultBool(&iface.IPv6Privacy, false)
+	clearDefaultBool(&iface.Critical, false)
+	clearDefaultBool(&iface.Optional, false)
+	clearDefaultBool(&iface.WakeOnLan, false)
+
+	if len(iface.LinkLocal) == 1 && iface.LinkLocal[0] == "ipv6" {
+		iface.LinkLocal = nil
+	}
+
+	iface.MacAddress = strings.ToLower(iface.MacAddress)
+
+	if iface.Nameservers != nil {
+		if len(iface.Nameservers.Addresses) == 0 && len(iface.Nameservers.Search) == 0 {
+			iface.Nameservers = nil
+		}
+	}
+
+	if iface.DHCP4Overrides != nil {
+		o := iface.DHCP4Overrides
+		clearDefaultBool(&o.UseDNS, true)
+		clearDefaultBool(&o.UseHostname, true)
+		clearDefaultBool(&o.SendHostname, true)
+		clearDefaultBool(&o.UseMTU, true)
+		clearDefaultBool(&o.UseNTP, true)
+		clearDefaultBool(&o.UseRoutes, true)
+		if *o == (DHCP4Overrides{}) {
+			iface.DHCP4Overrides = nil
+		}
+	}
+
+	if iface.DHCP6Overrides != nil {
+		o := iface.DHCP6Overrides
+		clearDefaultBool(&o.UseDNS, true)
+		clearDefaultBool(&o.UseHostname, true)
+		clearDefaultBool(&o.SendHostname, true)
+		clearDefaultBool(&o.UseMTU, true)
+		clearDefaultBool(&o.UseNTP, true)
+		clearDefaultBool(&o.UseRoutes, true)
+		if *o == (DHCP6Overrides{}) {
+			iface.DHCP6Overrides = nil
+		}
+	}
+
+	if iface.RAOverrides != nil {
+		o := iface.RAOverrides
+		clearDefaultBool(&o.UseDNS, true)
+		clearDefaultBool(&o.UseMTU, true)
+		clearDefaultBool(&o.UseGateway, true)
+		if *o == (RAOverrides{}) {
+			iface.RAOverrides = nil
+		}
+	}
+
+	for i := range iface.Routes {
+		clearDefaultBool(&iface.Routes[i].OnLink, false)
+	}
+	sortRoutes(iface.Routes)
+	sortRoutingPolicy(iface.RoutingPolicy)
+
+	for i := range iface.Neigh {
+		iface.Neigh[i].MAC = strings.ToLower(iface.Neigh[i].MAC)
+	}
+	sort.SliceStable(iface.Neigh, func(i, j int) bool {
+		return iface.Neigh[i].To < iface.Neigh[j].To
+	})
+
+	if iface.Match != nil {
+		iface.Match.MacAddress = strings.ToLower(iface.Match.MacAddress)
+		if *iface.Match == (Match{}) {
+			iface.Match = nil
+		}
+	}
+
+	if iface.SRIOV != nil {
+		for _, vf := range iface.SRIOV.VFTable {
+			if vf != nil {
+				vf.MacAddress = strings.ToLower(vf.MacAddress)
+			}
+		}
+	}
+}
+
+// sortRoutes orders routes by destination, gateway, source, table and
+// metric, then by their remaining fields, so routes differing in any field
+// always end up in the same order
+func sortRoutes(routes []Route) {
+	slices.SortFunc(routes, func(a, b Route) int {
+		return cmp.Or(
+			cmp.Compare(a.To, b.To),
+			cmp.Compare(a.Via, b.Via),
+			cmp.Compare(a.From, b.From),
+			cmp.Compare(a.Table, b.Table),
+			cmp.Compare(a.Metric, b.Metric),
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.Scope, b.Scope),
+			cmp.Compare(a.MTU, b.MTU),
+			compareBool(a.OnLink, b.OnLink),
+			cmp.Compare(a.CongestionWindow, b.CongestionWindow),
+			cmp.Compare(a.AdvertisedMSS, b.AdvertisedMSS),
+			cmp.Compare(a.AdvertisedReceiveWindow, b.AdvertisedReceiveWindow),
+		)
+	})
+}
+
+// sortRoutingPolicy orders routing policy rules by priority, then selectors,
+// table, mark and type of service
+func sortRoutingPolicy(rules []RoutingPolicy) {
+	slices.SortFunc(rules, func(a, b RoutingPolicy) int {
+		return cmp.Or(
+			cmp.Compare(a.Priority, b.Priority),
+			cmp.Compare(a.From, b.From),
+			cmp.Compare(a.To, b.To),
+			cmp.Compare(a.Table, b.Table),
+			cmp.Compare(a.Mark, b.Mark),
+			cmp.Compare(a.TypeOfService, b.TypeOfService),
+		)
+	})
+}
+
+// compareBool orders unset boolean pointers before false, and false before true
+func compareBool(a, b *bool) int {
+	rank := func(v *bool) int {
+		switch {
+		case v == nil:
+			return 0
+		case !*v:
+			return 1
+		}
+		return 2
+	}
+	return cmp.Compare(rank(a), rank(b))
+}
+
+// clearDefaultBool sets a boolean pointer to nil when it holds the default value
+func clearDefaultBool(b **bool, def bool) {
+	if *b != nil && **b == def {
+		*b = nil
+	}
+}
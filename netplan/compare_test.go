@@ -0,0 +1,289 @@
+package netplan
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConfigEqual(t *testing.T) {
+	base := `network:
+  version: 2
+  ethernets:
+    eth0:
+      addresses:
+        - 10.0.0.10/24
+        - 192.168.1.10/24
+      nameservers:
+        addresses: [8.8.8.8, 1.1.1.1]
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      parameters:
+        mode: active-backup`
+
+	tests := []struct {
+		name     string
+		other    string
+		expected bool
+	}{
+		{
+			name:     "identical",
+			other:    base,
+			expected: true,
+		},
+		{
+			name: "reordered members and explicit defaults",
+			other: `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth0:
+      dhcp4: false
+      optional: false
+      addresses:
+        - 10.0.0.10/24
+        - 192.168.1.10/24
+      nameservers:
+        addresses: [8.8.8.8, 1.1.1.1]
+  bonds:
+    bond0:
+      interfaces: [eth2, eth1]
+      parameters:
+        mode: active-backup`,
+			expected: true,
+		},
+		{
+			name: "reordered addresses",
+			other: `network:
+  version: 2
+  ethernets:
+    eth0:
+      addresses:
+        - 192.168.1.10/24
+        - 10.0.0.10/24
+      nameservers:
+        addresses: [8.8.8.8, 1.1.1.1]
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      parameters:
+        mode: active-backup`,
+			expected: false,
+		},
+		{
+			name: "reordered nameservers",
+			other: `network:
+  version: 2
+  ethernets:
+    eth0:
+      addresses:
+        - 10.0.0.10/24
+        - 192.168.1.10/24
+      nameservers:
+        addresses: [1.1.1.1, 8.8.8.8]
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      parameters:
+        mode: active-backup`,
+			expected: false,
+		},
+		{
+			name: "different address",
+			other: `network:
+  version: 2
+  ethernets:
+    eth0:
+      addresses:
+        - 10.0.0.11/24
+        - 192.168.1.10/24
+      nameservers:
+        addresses: [8.8.8.8, 1.1.1.1]
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      parameters:
+        mode: active-backup`,
+			expected: false,
+		},
+		{
+			name: "dhcp enabled",
+			other: `network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+      addresses:
+        - 10.0.0.10/24
+        - 192.168.1.10/24
+      nameservers:
+        addresses: [8.8.8.8, 1.1.1.1]
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      parameters:
+        mode: active-backup`,
+			expected: false,
+		},
+	}
+
+	baseConfig, err := LoadConfigFromBytes([]byte(base))
+	if err != nil {
+		t.Fatalf("Failed to load base config: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := LoadConfigFromBytes([]byte(tt.other))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			members := append([]string(nil), other.Network.Bonds["bond0"].Interfaces...)
+
+			if result := baseConfig.Equal(other); result != tt.expected {
+				t.Errorf("Equal() = %v, want %v", result, tt.expected)
+			}
+			if result := other.Equal(baseConfig); result != tt.expected {
+				t.Errorf("Equal() is not symmetric: got %v, want %v", result, tt.expected)
+			}
+
+			// Equal must not modify the configurations being compared
+			if got := other.Network.Bonds["bond0"].Interfaces; strings.Join(got, ",") != strings.Join(members, ",") {
+				t.Errorf("Equal modified the receiver: interfaces %v, want %v", got, members)
+			}
+		})
+	}
+}
+
+func TestConfigEqualSearchDomains(t *testing.T) {
+	config := func(search string) *Config {
+		t.Helper()
+		c, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      nameservers:
+        addresses: [10.0.0.53]
+        search: ` + search))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		return c
+	}
+
+	// The resolver tries search domains in order, like nameservers
+	if !config("[lab.example.com, example.com]").Equal(config("[lab.example.com, example.com]")) {
+		t.Error("Equal() = false for identical search domains")
+	}
+	if config("[lab.example.com, example.com]").Equal(config("[example.com, lab.example.com]")) {
+		t.Error("Equal() = true for reordered search domains")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	a, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth0:
+      dhcp4: false
+      macaddress: "AA:BB:CC:DD:EE:FF"
+      addresses: [10.0.0.2/24, 10.0.0.1/24]
+      routes:
+        - to: 172.16.0.0/16
+          via: 10.0.0.254
+        - to: 10.10.0.0/16
+          via: 10.0.0.254`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	b, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      macaddress: "aa:bb:cc:dd:ee:ff"
+      addresses: [10.0.0.2/24, 10.0.0.1/24]
+      routes:
+        - to: 10.10.0.0/16
+          via: 10.0.0.254
+        - to: 172.16.0.0/16
+          via: 10.0.0.254`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	a.Normalize()
+	b.Normalize()
+
+	if a.Network.Renderer != "" {
+		t.Errorf("Expected default renderer to be stripped, got %q", a.Network.Renderer)
+	}
+	if a.Network.Ethernets["eth0"].DHCP4 != nil {
+		t.Error("Expected dhcp4: false to be stripped")
+	}
+	if mac := a.Network.Ethernets["eth0"].MacAddress; mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Expected lower-cased MAC, got %s", mac)
+	}
+	if addrs := a.Network.Ethernets["eth0"].Addresses; addrs[0] != "10.0.0.2/24" {
+		t.Errorf("Expected addresses to keep their order, got %v", addrs)
+	}
+
+	aYAML, err := a.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	bYAML, err := b.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+
+	if string(aYAML) != string(bYAML) {
+		t.Errorf("Normalized configs differ:\n%s\n---\n%s", aYAML, bYAML)
+	}
+}
+
+func TestNormalizeRouteOrder(t *testing.T) {
+	// Routes and rules only told apart by their later fields
+	routes := []string{
+		"{to: 10.10.0.0/16, via: 10.0.0.254, mtu: 9000}",
+		"{to: 10.10.0.0/16, via: 10.0.0.254, mtu: 1500}",
+		"{to: 10.10.0.0/16, via: 10.0.0.254, type: blackhole}",
+		"{to: 10.10.0.0/16, via: 10.0.0.254, on-link: true}",
+		"{to: 10.10.0.0/16, via: 10.0.0.254, advertised-mss: 1400}",
+	}
+	rules := []string{
+		"{from: 10.0.0.0/24, table: 100, mark: 2}",
+		"{from: 10.0.0.0/24, table: 100, mark: 1}",
+		"{from: 10.0.0.0/24, table: 100, type-of-service: 16}",
+	}
+	config := func(routes, rules []string) string {
+		t.Helper()
+		c, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      addresses: [10.0.0.1/24]
+      routes: [` + strings.Join(routes, ", ") + `]
+      routing-policy: [` + strings.Join(rules, ", ") + `]`))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		c.Normalize()
+		data, err := c.ToYAML()
+		if err != nil {
+			t.Fatalf("Failed to marshal config: %v", err)
+		}
+		return string(data)
+	}
+
+	want := config(routes, rules)
+	reversedRoutes := slices.Clone(routes)
+	slices.Reverse(reversedRoutes)
+	reversedRules := slices.Clone(rules)
+	slices.Reverse(reversedRules)
+	if got := config(reversedRoutes, reversedRules); got != want {
+		t.Errorf("Normalized configs differ:\n%s\n---\n%s", want, got)
+	}
+}
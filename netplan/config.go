@@ -2,9 +2,12 @@ package netplan
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -24,12 +27,15 @@ type IPWithMask struct {
 	CIDR     string // Full CIDR notation (e.g., "10.150.0.1/22")
 	IPNet    *net.IPNet
 	BondName string
+	MTU      int // Configured MTU of the interface carrying the address
 }
 
-// InSameSubnet checks if two IP addresses are in the same subnet
+// InSameSubnet checks if two IP addresses are in the same subnet. Addresses
+// of different families never are, and IPv6 link-local addresses are not
+// matched because every link shares the fe80::/64 prefix.
 func InSameSubnet(ip1CIDR, ip2 string) bool {
 	// Parse the first IP with its CIDR notation
-	_, ipNet1, err := net.ParseCIDR(ip1CIDR)
+	ip1Addr, ipNet1, err := net.ParseCIDR(ip1CIDR)
 	if err != nil {
 		return false
 	}
@@ -40,6 +46,13 @@ func InSameSubnet(ip1CIDR, ip2 string) bool {
 		return false
 	}
 
+	if (ip1Addr.To4() == nil) != (ip2Addr.To4() == nil) {
+		return false
+	}
+	if ip2Addr.To4() == nil && ip2Addr.IsLinkLocalUnicast() {
+		return false
+	}
+
 	// Check if ip2 is in the subnet of ip1
 	return ipNet1.Contains(ip2Addr)
 }
@@ -51,27 +64,34 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read file %s: %w", filename, err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
-	}
-
-	return &config, nil
+	return LoadConfigFromBytes(data)
 }
 
-// LoadConfigFromBytes loads a netplan configuration from byte data
+// LoadConfigFromBytes loads a netplan configuration from byte data.
+// The parsed YAML document is retained so that comments and key order
+// are preserved when the configuration is written back out.
 func LoadConfigFromBytes(data []byte) (*Config, error) {
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
 	}
 
+	var config Config
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		if err := doc.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		config.node = &doc
+	}
+
 	return &config, nil
 }
 
-// SaveConfig saves a netplan configuration to a file
+// SaveConfig saves a netplan configuration to a file. The file is written
+// atomically with ConfigFileMode permissions, so readers never observe a
+// partially written configuration.
 func SaveConfig(config *Config, filename string) error {
-	data, err := yaml.Marshal(config)
+	data, err := config.ToYAML()
 	if err != nil {
 		return fmt.Errorf("failed to marshal YAML: %w", err)
 	}
@@ -83,20 +103,75 @@ func SaveConfig(config *Config, filename string) error {
 		}
 	}
 
-	if err := os.WriteFile(filename, data, 0644); err != nil {
+	if err := writeFileAtomic(filename, data, ConfigFileMode); err != nil {
 		return fmt.Errorf("failed to write file %s: %w", filename, err)
 	}
 
 	return nil
 }
 
+// ConfigFileName returns a netplan file name in the conventional
+// NN-name.yaml form, where NN is the two digit priority that determines
+// the order in which netplan merges files
+func ConfigFileName(priority int, name string) string {
+	if priority < 0 {
+		priority = 0
+	}
+	if priority > 99 {
+		priority = 99
+	}
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".yaml"), ".yml")
+	return fmt.Sprintf("%02d-%s.yaml", priority, name)
+}
+
 // LoadAllNetplanConfigs loads all netplan configuration files from /etc/netplan
 func LoadAllNetplanConfigs() ([]*Config, error) {
 	return LoadNetplanConfigsFromDir("/etc/netplan")
 }
 
+// ConfigFile pairs a loaded netplan configuration with the file it came from
+type ConfigFile struct {
+	Path   string
+	Config *Config
+}
+
 // LoadNetplanConfigsFromDir loads all netplan configuration files from a directory
 func LoadNetplanConfigsFromDir(dir string) ([]*Config, error) {
+	files, err := LoadNetplanFilesFromDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*Config
+	for _, file := range files {
+		configs = append(configs, file.Config)
+	}
+
+	return configs, nil
+}
+
+// LoadNetplanFilesFromDir loads all netplan configuration files from a directory
+// in the order netplan applies them (lexical order of the file names)
+func LoadNetplanFilesFromDir(dir string) ([]ConfigFile, error) {
+	paths, err := netplanFilePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ConfigFile
+	for _, path := range paths {
+		config, err := LoadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config from %s: %w", path, err)
+		}
+		files = append(files, ConfigFile{Path: path, Config: config})
+	}
+
+	return files, nil
+}
+
+// netplanFilePaths returns the .yaml and .yml files in a directory sorted by file name
+func netplanFilePaths(dir string) ([]string, error) {
 	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to glob yaml files in %s: %w", dir, err)
@@ -109,21 +184,18 @@ func LoadNetplanConfigsFromDir(dir string) ([]*Config, error) {
 	}
 
 	files = append(files, ymlFiles...)
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
 
-	var configs []*Config
-	for _, file := range files {
-		config, err := LoadConfig(file)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load config from %s: %w", file, err)
-		}
-		configs = append(configs, config)
-	}
-
-	return configs, nil
+	return files, nil
 }
 
 // Validate performs basic validation of the netplan configuration
 func (c *Config) Validate() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	var errors []error
 
 	// Check version
@@ -142,7 +214,7 @@ func (c *Config) Validate() []error {
 			}
 		}
 		if !valid {
-			errors = append(errors, fmt.Errorf("invalid renderer: %s (must be one of: %s)", c.Network.Renderer, strings.Join(validRenderers, ", ")))
+			errors = append(errors, invalidValueError("renderer", c.Network.Renderer, validRenderers))
 		}
 	}
 
@@ -156,6 +228,26 @@ func (c *Config) Validate() []error {
 				errors = append(errors, fmt.Errorf("ethernet %s: %w", name, err))
 			}
 		}
+		for _, err := range validateOffloads(eth) {
+			errors = append(errors, fmt.Errorf("ethernet %s: %w", name, err))
+		}
+		if eth.LargeReceiveOffload != nil && *eth.LargeReceiveOffload {
+			for bridgeName, bridge := range c.Network.Bridges {
+				if containsString(bridge.Interfaces, name) {
+					errors = append(errors, fmt.Errorf("ethernet %s: large-receive-offload cannot be enabled on a port of bridge %s", name, bridgeName))
+				}
+			}
+		}
+		if eth.VirtualFunction != nil {
+			pf, exists := c.Network.Ethernets[eth.VirtualFunction.Link]
+			if eth.VirtualFunction.Link == "" {
+				errors = append(errors, fmt.Errorf("ethernet %s: virtual-function link is required", name))
+			} else if !exists {
+				errors = append(errors, fmt.Errorf("ethernet %s: virtual-function link %s is not a defined ethernet", name, eth.VirtualFunction.Link))
+			} else if pf.SRIOV == nil {
+				errors = append(errors, fmt.Errorf("ethernet %s: virtual-function link %s has no sriov configuration", name, eth.VirtualFunction.Link))
+			}
+		}
 	}
 
 	// Validate wifi interfaces
@@ -168,6 +260,11 @@ func (c *Config) Validate() []error {
 				errors = append(errors, fmt.Errorf("wifi %s: %w", name, err))
 			}
 		}
+		for ssid, ap := range wifi.AccessPoints {
+			for _, err := range validateAccessPoint(ap) {
+				errors = append(errors, fmt.Errorf("wifi %s: access point %q: %w", name, ssid, err))
+			}
+		}
 	}
 
 	// Validate bridges
@@ -192,6 +289,9 @@ func (c *Config) Validate() []error {
 				errors = append(errors, fmt.Errorf("bond %s: %w", name, err))
 			}
 		}
+		if bond.Parameters != nil && bond.Parameters.Mode != "" && !containsString(validBondModes, bond.Parameters.Mode) {
+			errors = append(errors, fmt.Errorf("bond %s: %w", name, invalidValueError("bond mode", bond.Parameters.Mode, validBondModes)))
+		}
 	}
 
 	// Validate VLANs
@@ -207,9 +307,439 @@ func (c *Config) Validate() []error {
 		}
 	}
 
+	// Validate tunnels
+	for name, tunnel := range c.Network.Tunnels {
+		if err := validateInterfaceName(name); err != nil {
+			errors = append(errors, fmt.Errorf("tunnel %s: %w", name, err))
+		}
+		for _, err := range validateCommonInterface(&tunnel.CommonInterface) {
+			errors = append(errors, fmt.Errorf("tunnel %s: %w", name, err))
+		}
+		for _, err := range validateTunnel(tunnel) {
+			errors = append(errors, fmt.Errorf("tunnel %s: %w", name, err))
+		}
+	}
+
+	// Validate match and set-name
+	for _, def := range c.definitions() {
+		if def.Common == nil {
+			continue
+		}
+		for _, err := range validateMatch(def.Kind, def.Common) {
+			errors = append(errors, fmt.Errorf("%s %s: %w", def.Kind, def.Name, err))
+		}
+	}
+
+	// Validate activation-mode
+	for _, def := range c.definitions() {
+		if def.Common == nil || def.Common.ActivationMode == "" {
+			continue
+		}
+		switch def.Common.ActivationMode {
+		case "manual", "off":
+		default:
+			errors = append(errors, fmt.Errorf("%s %s: invalid activation-mode %s (must be manual or off)", def.Kind, def.Name, def.Common.ActivationMode))
+			continue
+		}
+		// Only the networkd backend implements activation-mode
+		if c.Network.Renderer == string(RendererNetworkManager) {
+			errors = append(errors, fmt.Errorf("%s %s: activation-mode is not supported by the %s renderer", def.Kind, def.Name, RendererNetworkManager))
+		}
+	}
+
+	// Validate routing policy
+	for _, def := range c.definitions() {
+		var rules []RoutingPolicy
+		if def.Common != nil {
+			rules = def.Common.RoutingPolicy
+		} else if vrf, ok := def.Value.(*VRF); ok {
+			rules = vrf.RoutingPolicy
+		}
+		for i, rule := range rules {
+			for _, err := range validateRoutingPolicy(rule) {
+				errors = append(errors, fmt.Errorf("%s %s: routing-policy %d: %w", def.Kind, def.Name, i, err))
+			}
+		}
+	}
+
+	// LACP only applies to OpenVSwitch bonds
+	for _, def := range c.definitions() {
+		if def.Kind != KindBond && def.Common != nil && def.Common.OpenVSwitch != nil && def.Common.OpenVSwitch.Lacp != "" {
+			errors = append(errors, fmt.Errorf("%s %s: openvswitch lacp is only supported on bonds", def.Kind, def.Name))
+		}
+	}
+
+	// Validate VRFs
+	defined := make(map[string]bool)
+	for _, name := range c.interfaceNames() {
+		defined[name] = true
+	}
+	vrfTables := make(map[int]string)
+	vrfMembers := make(map[string]string)
+	for _, name := range sortedKeys(c.Network.VRFs) {
+		vrf := c.Network.VRFs[name]
+		if err := validateInterfaceName(name); err != nil {
+			errors = append(errors, fmt.Errorf("vrf %s: %w", name, err))
+		}
+		if err := validateVRFTable(vrf.Table); err != nil {
+			errors = append(errors, fmt.Errorf("vrf %s: %w", name, err))
+		} else if other, exists := vrfTables[vrf.Table]; exists {
+			errors = append(errors, fmt.Errorf("vrf %s: table %d is already used by vrf %s", name, vrf.Table, other))
+		} else {
+			vrfTables[vrf.Table] = name
+		}
+		for _, member := range vrf.Interfaces {
+			if !defined[member] {
+				errors = append(errors, fmt.Errorf("vrf %s: member interface %s is not defined", name, member))
+			} else if _, isVRF := c.Network.VRFs[member]; isVRF {
+				errors = append(errors, fmt.Errorf("vrf %s: member interface %s is itself a vrf", name, member))
+			}
+			if other, exists := vrfMembers[member]; exists {
+				errors = append(errors, fmt.Errorf("vrf %s: interface %s is already a member of vrf %s", name, member, other))
+			} else {
+				vrfMembers[member] = name
+			}
+		}
+	}
+
+	// Validate the global OpenVSwitch section
+	errors = append(errors, validateGlobalOpenVSwitch(c.Network.OpenVSwitch)...)
+
+	// SSL controller connections use the certificates of the global section
+	hasSSL := c.Network.OpenVSwitch != nil && c.Network.OpenVSwitch.SSL != nil
+	for _, def := range c.definitions() {
+		if def.Common == nil || def.Common.OpenVSwitch == nil || def.Common.OpenVSwitch.Controller == nil || hasSSL {
+			continue
+		}
+		for _, addr := range def.Common.OpenVSwitch.Controller.Addresses {
+			if strings.HasPrefix(addr, "ssl:") || strings.HasPrefix(addr, "pssl:") {
+				errors = append(errors, fmt.Errorf("%s %s: openvswitch controller address %s requires ssl settings in the global openvswitch section", def.Kind, def.Name, addr))
+			}
+		}
+	}
+
+	// Validate MTU consistency between stacked interfaces
+	errors = append(errors, c.validateMTUs()...)
+
+	return errors
+}
+
+// WiFi frequency bands supported by netplan
+const (
+	WiFiBand24GHz = "2.4GHz"
+	WiFiBand5GHz  = "5GHz"
+)
+
+// validWiFi5GHzChannels lists the 20MHz channels usable in the 5GHz band
+var validWiFi5GHzChannels = map[int]bool{
+	32: true, 36: true, 40: true, 44: true, 48: true, 52: true, 56: true, 60: true, 64: true,
+	68: true, 96: true, 100: true, 104: true, 108: true, 112: true, 116: true, 120: true,
+	124: true, 128: true, 132: true, 136: true, 140: true, 144: true, 149: true, 153: true,
+	157: true, 161: true, 165: true, 169: true, 173: true, 177: true,
+}
+
+// validEAPMethods lists the EAP methods supported by netplan
+var validEAPMethods = []string{"tls", "peap", "ttls", "leap", "pwd"}
+
+// validateAccessPoint validates a WiFi access point definition
+func validateAccessPoint(ap *AccessPoint) []error {
+	var errors []error
+
+	if ap == nil {
+		return errors
+	}
+
+	// Validate mode
+	if ap.Mode != "" {
+		switch WiFiMode(ap.Mode) {
+		case WiFiModeInfrastructure, WiFiModeAdhoc, WiFiModeAP:
+		default:
+			errors = append(errors, fmt.Errorf("invalid mode %s (must be one of: %s, %s, %s)",
+				ap.Mode, WiFiModeInfrastructure, WiFiModeAdhoc, WiFiModeAP))
+		}
+	}
+
+	// Validate band and channel
+	switch ap.Band {
+	case "":
+		if ap.Channel != 0 {
+			errors = append(errors, fmt.Errorf("channel %d requires band to be set", ap.Channel))
+		}
+	case WiFiBand24GHz:
+		if ap.Channel != 0 && (ap.Channel < 1 || ap.Channel > 14) {
+			errors = append(errors, fmt.Errorf("invalid channel %d for band %s (must be 1-14)", ap.Channel, ap.Band))
+		}
+	case WiFiBand5GHz:
+		if ap.Channel != 0 && !validWiFi5GHzChannels[ap.Channel] {
+			errors = append(errors, fmt.Errorf("invalid channel %d for band %s", ap.Channel, ap.Band))
+		}
+	default:
+		errors = append(errors, fmt.Errorf("invalid band %s (must be %s or %s)", ap.Band, WiFiBand24GHz, WiFiBand5GHz))
+	}
+
+	// Validate BSSID
+	if ap.BSSID != "" && !isValidMAC(ap.BSSID) {
+		errors = append(errors, fmt.Errorf("invalid bssid %s", ap.BSSID))
+	}
+
+	// Validate EAP authentication
+	if ap.Auth != nil {
+		if ap.Auth.KeyManagement != "" && !containsString(validKeyManagement, ap.Auth.KeyManagement) {
+			errors = append(errors, invalidValueError("key-management", ap.Auth.KeyManagement, validKeyManagement))
+		}
+		switch KeyManagement(ap.Auth.KeyManagement) {
+		case KeyManagementEAP, KeyManagement8021X:
+			if ap.Auth.Method == "" {
+				errors = append(errors, fmt.Errorf("auth method is required for key-management %s", ap.Auth.KeyManagement))
+			} else if !containsString(validEAPMethods, ap.Auth.Method) {
+				errors = append(errors, fmt.Errorf("invalid auth method %s (must be one of: %s)", ap.Auth.Method, strings.Join(validEAPMethods, ", ")))
+			}
+			if ap.Auth.Identity == "" {
+				errors = append(errors, fmt.Errorf("auth identity is required for key-management %s", ap.Auth.KeyManagement))
+			}
+		}
+	}
+
+	return errors
+}
+
+// isValidMAC reports whether s is a 48-bit Ethernet MAC address
+func isValidMAC(s string) bool {
+	hw, err := net.ParseMAC(s)
+	return err == nil && len(hw) == 6
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validBondModes lists the bonding modes supported by netplan
+var validBondModes = []string{
+	string(BondModeRoundRobin), string(BondModeActiveBackup), string(BondModeBalanceXOR),
+	string(BondModeBroadcast), string(BondMode8023AD), string(BondModeBalanceTLB), string(BondModeBalanceALB),
+}
+
+// validTunnelModes lists the tunnel modes supported by netplan
+var validTunnelModes = []string{
+	string(TunnelModeGRE), string(TunnelModeIPIP), string(TunnelModeIP6IP6),
+	string(TunnelModeIP6GRE), string(TunnelModeVTI), string(TunnelModeVTI6), string(TunnelModeWG),
+}
+
+// validKeyManagement lists the WiFi key-management values supported by netplan
+var validKeyManagement = []string{
+	string(KeyManagementNone), string(KeyManagementPSK), string(KeyManagementEAP), string(KeyManagement8021X),
+	string(KeyManagementSAE), string(KeyManagementEAPSHA256), string(KeyManagementEAPSuiteB192),
+}
+
+// tunnelModeFamilies maps each tunnel mode to the IP family of its endpoints
+// (4 or 6). Modes not listed here do not use local/remote endpoints.
+var tunnelModeFamilies = map[TunnelMode]int{
+	TunnelModeGRE:    4,
+	TunnelModeIPIP:   4,
+	TunnelModeVTI:    4,
+	TunnelModeIP6IP6: 6,
+	TunnelModeIP6GRE: 6,
+	TunnelModeVTI6:   6,
+}
+
+// tunnelModesWithKeys lists the tunnel modes that accept input/output keys
+var tunnelModesWithKeys = map[TunnelMode]bool{
+	TunnelModeGRE:    true,
+	TunnelModeIP6GRE: true,
+	TunnelModeVTI:    true,
+	TunnelModeVTI6:   true,
+}
+
+// validateTunnel validates tunnel-specific properties
+func validateTunnel(tunnel *Tunnel) []error {
+	var errors []error
+
+	mode := TunnelMode(tunnel.Mode)
+	family, hasEndpoints := tunnelModeFamilies[mode]
+
+	if tunnel.Mode == "" {
+		errors = append(errors, fmt.Errorf("mode is required"))
+	} else if !hasEndpoints && mode != TunnelModeWG {
+		errors = append(errors, invalidValueError("tunnel mode", tunnel.Mode, validTunnelModes))
+	}
+
+	// Validate endpoints
+	if hasEndpoints {
+		endpoints := []struct {
+			field string
+			value string
+		}{
+			{"local", tunnel.Local},
+			{"remote", tunnel.Remote},
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.value == "" {
+				errors = append(errors, fmt.Errorf("%s is required for %s tunnels", endpoint.field, mode))
+				continue
+			}
+			ip := net.ParseIP(endpoint.value)
+			if ip == nil {
+				errors = append(errors, fmt.Errorf("%s address %s is not a valid IP address", endpoint.field, endpoint.value))
+				continue
+			}
+			if isIPv4 := ip.To4() != nil; isIPv4 != (family == 4) {
+				errors = append(errors, fmt.Errorf("%s address %s must be an IPv%d address for %s tunnels", endpoint.field, endpoint.value, family, mode))
+			}
+		}
+	} else if mode == TunnelModeWG && tunnel.Remote != "" {
+		errors = append(errors, fmt.Errorf("remote is not supported for %s tunnels (configure peers instead)", mode))
+	}
+
+	// Validate keys
+	hasKeys := tunnel.Key != "" || (tunnel.Keys != nil && (tunnel.Keys.Input != "" || tunnel.Keys.Output != ""))
+	if hasKeys {
+		switch {
+		case tunnelModesWithKeys[mode]:
+			for _, key := range []string{tunnel.Key, tunnel.Keys.inputKey(), tunnel.Keys.outputKey()} {
+				if key != "" && !isValidTunnelKey(key) {
+					errors = append(errors, fmt.Errorf("invalid key %s (must be a 32-bit number or dotted quad)", key))
+				}
+			}
+		case mode == TunnelModeWG:
+			if tunnel.Keys != nil && (tunnel.Keys.Input != "" || tunnel.Keys.Output != "") {
+				errors = append(errors, fmt.Errorf("input/output keys are not supported for %s tunnels", mode))
+			}
+		case hasEndpoints:
+			errors = append(errors, fmt.Errorf("keys are not supported for %s tunnels", mode))
+		}
+	}
+
+	// Validate TTL and TOS
+	if tunnel.TTL < 0 || tunnel.TTL > 255 {
+		errors = append(errors, fmt.Errorf("invalid ttl %d (must be 1-255)", tunnel.TTL))
+	}
+	if tunnel.TOS < 0 || tunnel.TOS > 255 {
+		errors = append(errors, fmt.Errorf("invalid tos %d (must be 0-255)", tunnel.TOS))
+	}
+
+	return errors
+}
+
+// inputKey returns the input key, tolerating a nil receiver
+func (k *Keys) inputKey() string {
+	if k == nil {
+		return ""
+	}
+	return k.Input
+}
+
+// outputKey returns the output key, tolerating a nil receiver
+func (k *Keys) outputKey() string {
+	if k == nil {
+		return ""
+	}
+	return k.Output
+}
+
+// isValidTunnelKey reports whether a GRE/VTI key is a 32-bit number or an IPv4-style dotted quad
+func isValidTunnelKey(key string) bool {
+	if _, err := strconv.ParseUint(key, 10, 32); err == nil {
+		return true
+	}
+	ip := net.ParseIP(key)
+	return ip != nil && ip.To4() != nil && strings.Count(key, ".") == 3
+}
+
+// validateVRFTable validates the routing table number of a VRF
+func validateVRFTable(table int) error {
+	if table <= 0 || int64(table) > math.MaxUint32 {
+		return fmt.Errorf("invalid table %d (must be 1-%d)", table, uint32(math.MaxUint32))
+	}
+	// The kernel reserves these tables for its own use
+	switch table {
+	case RouteTableDefault, RouteTableMain, RouteTableLocal:
+		return fmt.Errorf("table %d is reserved (default, main and local tables cannot be used)", table)
+	}
+	return nil
+}
+
+// Routing tables reserved by the kernel
+const (
+	RouteTableDefault = 253
+	RouteTableMain    = 254
+	RouteTableLocal   = 255
+)
+
+// EffectiveTable returns the routing table a policy rule looks up. Rules
+// without an explicit table use the main table.
+func (r RoutingPolicy) EffectiveTable() RouteTable {
+	if r.Table == 0 {
+		return RouteTableMain
+	}
+	return r.Table
+}
+
+// validateRoutingPolicy validates a single routing policy rule
+func validateRoutingPolicy(rule RoutingPolicy) []error {
+	var errors []error
+
+	if rule.From == "" && rule.To == "" {
+		errors = append(errors, fmt.Errorf("from or to is required"))
+	}
+
+	families := make(map[string]bool)
+	for _, selector := range []struct {
+		field string
+		value string
+	}{
+		{"from", rule.From},
+		{"to", rule.To},
+	} {
+		if selector.value == "" {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(selector.value)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("invalid %s %s (must be a CIDR, e.g. 10.0.0.0/24)", selector.field, selector.value))
+			continue
+		}
+		if ip.To4() != nil {
+			families["IPv4"] = true
+		} else {
+			families["IPv6"] = true
+		}
+	}
+	if len(families) > 1 {
+		errors = append(errors, fmt.Errorf("from %s and to %s must be of the same address family", rule.From, rule.To))
+	}
+
+	if rule.Table < 0 || int64(rule.Table) > math.MaxUint32 {
+		errors = append(errors, fmt.Errorf("invalid table %d (must be 1-%d)", rule.Table, uint32(math.MaxUint32)))
+	}
+	if rule.Priority < 0 || int64(rule.Priority) > math.MaxUint32 {
+		errors = append(errors, fmt.Errorf("invalid priority %d (must be 0-%d)", rule.Priority, uint32(math.MaxUint32)))
+	}
+	if rule.Mark < 0 || int64(rule.Mark) > math.MaxUint32 {
+		errors = append(errors, fmt.Errorf("invalid mark %d (must be 0-%d)", rule.Mark, uint32(math.MaxUint32)))
+	}
+	if rule.TypeOfService < 0 || rule.TypeOfService > 255 {
+		errors = append(errors, fmt.Errorf("invalid type-of-service %d (must be 0-255)", rule.TypeOfService))
+	}
+
 	return errors
 }
 
+// sortedKeys returns the keys of a string-keyed map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // validateInterfaceName validates interface names
 func validateInterfaceName(name string) error {
 	if name == "" {
@@ -237,11 +767,330 @@ func validateCommonInterface(iface *CommonInterface) []error {
 		errors = append(errors, fmt.Errorf("invalid MTU %d (must be 68-65536)", iface.MTU))
 	}
 
+	// Validate link-local families
+	for _, family := range iface.LinkLocal {
+		if family != LinkLocalIPv4 && family != LinkLocalIPv6 {
+			errors = append(errors, fmt.Errorf("invalid link-local family %s (must be %s or %s)", family, LinkLocalIPv4, LinkLocalIPv6))
+		}
+	}
+
+	// Validate IPv6 address generation
+	switch iface.IPv6AddressGeneration {
+	case "", IPv6AddressGenerationEUI64, IPv6AddressGenerationStablePrivacy:
+	default:
+		errors = append(errors, fmt.Errorf("invalid ipv6-address-generation %s (must be %s or %s)",
+			iface.IPv6AddressGeneration, IPv6AddressGenerationEUI64, IPv6AddressGenerationStablePrivacy))
+	}
+	if iface.IPv6AddressToken != "" {
+		if iface.IPv6AddressGeneration != "" {
+			errors = append(errors, fmt.Errorf("ipv6-address-generation and ipv6-address-token are mutually exclusive"))
+		}
+		if ip := net.ParseIP(iface.IPv6AddressToken); ip == nil || ip.To4() != nil {
+			errors = append(errors, fmt.Errorf("invalid ipv6-address-token %s (must be an IPv6 address such as ::2)", iface.IPv6AddressToken))
+		}
+	}
+
+	// Validate SR-IOV
+	if iface.EmbeddedSwitch != "" && iface.EmbeddedSwitch != "switchdev" && iface.EmbeddedSwitch != "legacy" {
+		errors = append(errors, fmt.Errorf("invalid embedded-switch %s (must be switchdev or legacy)", iface.EmbeddedSwitch))
+	}
+	errors = append(errors, validateSRIOV(iface.SRIOV)...)
+
+	// Validate OpenVSwitch
+	errors = append(errors, validateOpenVSwitch(iface.OpenVSwitch)...)
+
+	// Validate DHCP and RA overrides
+	errors = append(errors, validateOverrides(iface)...)
+
+	return errors
+}
+
+// validUseDomains lists the accepted values of the use-domains override
+var validUseDomains = []string{"true", "false", "route"}
+
+// validateOverrides validates the dhcp4-overrides, dhcp6-overrides and
+// ra-overrides settings
+func validateOverrides(iface *CommonInterface) []error {
+	var errors []error
+
+	check := func(section, useDomains string, routeMetric int) {
+		if useDomains != "" && !containsString(validUseDomains, useDomains) {
+			errors = append(errors, fmt.Errorf("%s: %w", section, invalidValueError("use-domains", useDomains, validUseDomains)))
+		}
+		if routeMetric < 0 {
+			errors = append(errors, fmt.Errorf("%s: invalid route-metric %d (must not be negative)", section, routeMetric))
+		}
+	}
+
+	if o := iface.DHCP4Overrides; o != nil {
+		check("dhcp4-overrides", o.UseDomains, o.RouteMetric)
+	}
+	if o := iface.DHCP6Overrides; o != nil {
+		check("dhcp6-overrides", o.UseDomains, o.RouteMetric)
+	}
+	if o := iface.RAOverrides; o != nil {
+		check("ra-overrides", o.UseDomains, 0)
+		if o.Table < 0 || int64(o.Table) > math.MaxUint32 {
+			errors = append(errors, fmt.Errorf("ra-overrides: invalid table %d", o.Table))
+		}
+	}
+
+	return errors
+}
+
+// validateMatch validates the match stanza and set-name of an interface
+func validateMatch(kind InterfaceKind, iface *CommonInterface) []error {
+	var errors []error
+
+	physical := kind == KindEthernet || kind == KindWifi || kind == KindModem
+
+	if iface.Match != nil && !physical {
+		errors = append(errors, fmt.Errorf("match is only supported on physical devices"))
+	}
+
+	if iface.SetName != "" {
+		if iface.Match == nil {
+			errors = append(errors, fmt.Errorf("set-name %s requires a match stanza", iface.SetName))
+		}
+		if err := validateInterfaceName(iface.SetName); err != nil {
+			errors = append(errors, fmt.Errorf("set-name: %w", err))
+		}
+	}
+
+	if iface.Match != nil && iface.Match.MacAddress != "" && !isValidMAC(iface.Match.MacAddress) {
+		errors = append(errors, fmt.Errorf("invalid match macaddress %s", iface.Match.MacAddress))
+	}
+
+	return errors
+}
+
+// Warnings reports configuration that is accepted by Validate but is likely
+// to fail or behave unexpectedly when netplan applies it
+func (c *Config) Warnings() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var warnings []error
+
+	for _, key := range sortedKeys(c.Network.Extra) {
+		warnings = append(warnings, fmt.Errorf("unknown network key %q", key))
+	}
+
+	routedTables := c.routedTables()
+
+	// The kernel forces bond members to the bond MTU, so differing values
+	// are silently ignored
+	for _, name := range sortedKeys(c.Network.Bonds) {
+		bond := c.Network.Bonds[name]
+		if bond.MTU == 0 {
+			continue
+		}
+		for _, member := range bond.Interfaces {
+			if iface := c.getCommonInterface(member); iface != nil && iface.MTU != 0 && iface.MTU != bond.MTU {
+				warnings = append(warnings, fmt.Errorf("bond %s: member %s has mtu %d but the bond mtu is %d", name, member, iface.MTU, bond.MTU))
+			}
+		}
+	}
+
+	for _, def := range c.definitions() {
+		// Unknown keys are kept but most likely are typos or need a newer netplan
+		for _, key := range sortedKeys(def.Extra) {
+			warnings = append(warnings, fmt.Errorf("%s %s: unknown key %q", def.Kind, def.Name, key))
+		}
+
+		if def.Common == nil {
+			continue
+		}
+		iface := def.Common
+
+		// Overrides only apply when the corresponding protocol is in use
+		if iface.RAOverrides != nil && iface.AcceptRA != nil && !*iface.AcceptRA {
+			warnings = append(warnings, fmt.Errorf("%s %s: ra-overrides has no effect with accept-ra disabled", def.Kind, def.Name))
+		}
+
+		// Policies pointing at a table nothing populates send traffic nowhere
+		for _, rule := range iface.RoutingPolicy {
+			table := rule.EffectiveTable()
+			if table != RouteTableDefault && table != RouteTableMain && table != RouteTableLocal && !routedTables[table] {
+				warnings = append(warnings, fmt.Errorf("%s %s: routing-policy references table %d which has no routes", def.Kind, def.Name, table))
+			}
+		}
+
+		// netplan refuses to rename more than one device to the same name
+		if iface.SetName != "" && iface.Match != nil && hasGlob(iface.Match.Name) {
+			warnings = append(warnings, fmt.Errorf("%s %s: match name %q contains a glob together with set-name %s; netplan fails if more than one device matches",
+				def.Kind, def.Name, iface.Match.Name, iface.SetName))
+		}
+	}
+
+	return warnings
+}
+
+// DefaultMTU is the MTU the kernel gives an interface that does not set one
+const DefaultMTU = 1500
+
+// validateMTUs checks that no interface has a larger MTU than the device
+// it is stacked on: a VLAN cannot exceed its link and a bridge cannot
+// exceed any of its ports
+func (c *Config) validateMTUs() []error {
+	var errors []error
+
+	for _, name := range sortedKeys(c.Network.VLANs) {
+		vlan := c.Network.VLANs[name]
+		if vlan.MTU == 0 {
+			continue
+		}
+		if link := c.effectiveMTU(vlan.Link, nil); link != 0 && vlan.MTU > link {
+			errors = append(errors, fmt.Errorf("vlan %s: mtu %d exceeds mtu %d of link %s", name, vlan.MTU, link, vlan.Link))
+		}
+	}
+
+	for _, name := range sortedKeys(c.Network.Bridges) {
+		bridge := c.Network.Bridges[name]
+		if bridge.MTU == 0 {
+			continue
+		}
+		for _, port := range bridge.Interfaces {
+			if mtu := c.effectiveMTU(port, nil); mtu != 0 && bridge.MTU > mtu {
+				errors = append(errors, fmt.Errorf("bridge %s: mtu %d exceeds mtu %d of port %s", name, bridge.MTU, mtu, port))
+			}
+		}
+	}
+
+	return errors
+}
+
+// effectiveMTU returns the MTU an interface ends up with: its configured
+// MTU, the smallest port MTU for bridges that do not set one, or
+// DefaultMTU. It returns 0 for interfaces that are not defined.
+func (c *Config) effectiveMTU(name string, seen map[string]bool) int {
+	iface := c.getCommonInterface(name)
+	if iface == nil || seen[name] {
+		return 0
+	}
+	if iface.MTU != 0 {
+		return iface.MTU
+	}
+
+	bridge, isBridge := c.Network.Bridges[name]
+	if !isBridge || len(bridge.Interfaces) == 0 {
+		return DefaultMTU
+	}
+
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	seen[name] = true
+
+	lowest := 0
+	for _, port := range bridge.Interfaces {
+		if mtu := c.effectiveMTU(port, seen); mtu != 0 && (lowest == 0 || mtu < lowest) {
+			lowest = mtu
+		}
+	}
+	if lowest == 0 {
+		return DefaultMTU
+	}
+	return lowest
+}
+
+// routedTables returns the routing tables that receive routes from the
+// configuration, either through explicit route tables or as VRF tables
+func (c *Config) routedTables() map[RouteTable]bool {
+	tables := make(map[RouteTable]bool)
+	for _, def := range c.definitions() {
+		if def.Common == nil {
+			continue
+		}
+		for _, route := range def.Common.Routes {
+			if route.Table != 0 {
+				tables[route.Table] = true
+			}
+		}
+	}
+	for _, vrf := range c.Network.VRFs {
+		tables[RouteTable(vrf.Table)] = true
+	}
+	return tables
+}
+
+// validateOffloads validates the dependencies between hardware offload settings
+func validateOffloads(eth *Ethernet) []error {
+	var errors []error
+
+	enabled := func(b *bool) bool { return b != nil && *b }
+	disabled := func(b *bool) bool { return b != nil && !*b }
+
+	// The kernel cannot segment TCP in hardware without checksumming in hardware
+	if enabled(eth.TCPSegmentationOffload) && disabled(eth.TransmitChecksumOffload) {
+		errors = append(errors, fmt.Errorf("tcp-segmentation-offload requires transmit-checksum-offload"))
+	}
+	// LRO aggregates packets before the checksum has been verified
+	if enabled(eth.LargeReceiveOffload) && disabled(eth.ReceiveChecksumOffload) {
+		errors = append(errors, fmt.Errorf("large-receive-offload requires receive-checksum-offload"))
+	}
+
+	return errors
+}
+
+// validateSRIOV validates SR-IOV physical function configuration
+func validateSRIOV(sriov *SRIOV) []error {
+	var errors []error
+
+	if sriov == nil {
+		return errors
+	}
+
+	if sriov.TotalVFs < 0 {
+		errors = append(errors, fmt.Errorf("invalid sriov total-vfs %d (must not be negative)", sriov.TotalVFs))
+	}
+
+	usedIDs := make(map[int]string)
+	for _, name := range sortedKeys(sriov.VFTable) {
+		vf := sriov.VFTable[name]
+		if vf == nil {
+			continue
+		}
+
+		if vf.ID < 0 || vf.ID >= sriov.TotalVFs {
+			errors = append(errors, fmt.Errorf("sriov vf %s: invalid id %d (must be 0-%d)", name, vf.ID, sriov.TotalVFs-1))
+		} else if other, exists := usedIDs[vf.ID]; exists {
+			errors = append(errors, fmt.Errorf("sriov vf %s: id %d is already used by vf %s", name, vf.ID, other))
+		} else {
+			usedIDs[vf.ID] = name
+		}
+
+		if vf.MacAddress != "" && !isValidMAC(vf.MacAddress) {
+			errors = append(errors, fmt.Errorf("sriov vf %s: invalid macaddress %s", name, vf.MacAddress))
+		}
+		if vf.VLAN < 0 || vf.VLAN > 4094 {
+			errors = append(errors, fmt.Errorf("sriov vf %s: invalid vlan %d (must be 0-4094)", name, vf.VLAN))
+		}
+		if vf.QoS < 0 || vf.QoS > 7 {
+			errors = append(errors, fmt.Errorf("sriov vf %s: invalid qos %d (must be 0-7)", name, vf.QoS))
+		}
+		if vf.QoS != 0 && vf.VLAN == 0 {
+			errors = append(errors, fmt.Errorf("sriov vf %s: qos requires a vlan", name))
+		}
+		switch vf.LinkState {
+		case "", "auto", "enable", "disable":
+		default:
+			errors = append(errors, fmt.Errorf("sriov vf %s: invalid link-state %s (must be auto, enable or disable)", name, vf.LinkState))
+		}
+	}
+
 	return errors
 }
 
 // GetInterfaceNames returns all interface names defined in the configuration
 func (c *Config) GetInterfaceNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interfaceNames()
+}
+
+// interfaceNames is GetInterfaceNames without locking
+func (c *Config) interfaceNames() []string {
 	var names []string
 
 	for name := range c.Network.Ethernets {
@@ -272,8 +1121,112 @@ func (c *Config) GetInterfaceNames() []string {
 	return names
 }
 
+// GetInterface looks up an interface by name across every section of the
+// configuration. It returns the definition (e.g. *Bond or *VLAN), the kind
+// of section it was found in and whether it exists at all.
+func (c *Config) GetInterface(name string) (interface{}, InterfaceKind, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getInterface(name)
+}
+
+// getInterface is GetInterface without locking
+func (c *Config) getInterface(name string) (interface{}, InterfaceKind, bool) {
+	if eth, exists := c.Network.Ethernets[name]; exists {
+		return eth, KindEthernet, true
+	}
+	if wifi, exists := c.Network.Wifis[name]; exists {
+		return wifi, KindWifi, true
+	}
+	if bridge, exists := c.Network.Bridges[name]; exists {
+		return bridge, KindBridge, true
+	}
+	if bond, exists := c.Network.Bonds[name]; exists {
+		return bond, KindBond, true
+	}
+	if vlan, exists := c.Network.VLANs[name]; exists {
+		return vlan, KindVLAN, true
+	}
+	if tunnel, exists := c.Network.Tunnels[name]; exists {
+		return tunnel, KindTunnel, true
+	}
+	if vrf, exists := c.Network.VRFs[name]; exists {
+		return vrf, KindVRF, true
+	}
+	if modem, exists := c.Network.Modems[name]; exists {
+		return modem, KindModem, true
+	}
+	return nil, "", false
+}
+
+// GetInterfaceAs looks up an interface by name and returns it as type T,
+// e.g. GetInterfaceAs[*VLAN](config, "bond0.100"). The boolean is false if
+// the interface does not exist or is of a different kind.
+func GetInterfaceAs[T any](c *Config, name string) (T, bool) {
+	var zero T
+
+	iface, _, exists := c.GetInterface(name)
+	if !exists {
+		return zero, false
+	}
+
+	typed, ok := iface.(T)
+	if !ok {
+		return zero, false
+	}
+	return typed, true
+}
+
+// interfaceDefinition describes a single interface entry in a configuration
+type interfaceDefinition struct {
+	Name   string
+	Kind   InterfaceKind
+	Value  interface{}
+	Common *CommonInterface // nil for VRFs
+	Extra  map[string]interface{}
+}
+
+// definitions returns every interface defined in the configuration, sorted by name
+func (c *Config) definitions() []interfaceDefinition {
+	var defs []interfaceDefinition
+
+	for name, eth := range c.Network.Ethernets {
+		defs = append(defs, interfaceDefinition{name, KindEthernet, eth, &eth.CommonInterface, eth.Extra})
+	}
+	for name, wifi := range c.Network.Wifis {
+		defs = append(defs, interfaceDefinition{name, KindWifi, wifi, &wifi.CommonInterface, wifi.Extra})
+	}
+	for name, bridge := range c.Network.Bridges {
+		defs = append(defs, interfaceDefinition{name, KindBridge, bridge, &bridge.CommonInterface, bridge.Extra})
+	}
+	for name, bond := range c.Network.Bonds {
+		defs = append(defs, interfaceDefinition{name, KindBond, bond, &bond.CommonInterface, bond.Extra})
+	}
+	for name, vlan := range c.Network.VLANs {
+		defs = append(defs, interfaceDefinition{name, KindVLAN, vlan, &vlan.CommonInterface, vlan.Extra})
+	}
+	for name, tunnel := range c.Network.Tunnels {
+		defs = append(defs, interfaceDefinition{name, KindTunnel, tunnel, &tunnel.CommonInterface, tunnel.Extra})
+	}
+	for name, vrf := range c.Network.VRFs {
+		defs = append(defs, interfaceDefinition{name, KindVRF, vrf, nil, vrf.Extra})
+	}
+	for name, modem := range c.Network.Modems {
+		defs = append(defs, interfaceDefinition{name, KindModem, modem, &modem.CommonInterface, modem.Extra})
+	}
+
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+
+	return defs
+}
+
 // HasDHCP returns true if any interface is configured for DHCP
 func (c *Config) HasDHCP() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	checkDHCP := func(iface *CommonInterface) bool {
 		return (iface.DHCP4 != nil && *iface.DHCP4) || (iface.DHCP6 != nil && *iface.DHCP6)
 	}
@@ -302,9 +1255,32 @@ func (c *Config) HasDHCP() bool {
 	return false
 }
 
-// ToYAML converts the configuration to YAML format
+// ToYAML converts the configuration to YAML format. Configurations that
+// were loaded from YAML keep the comments, key order and formatting of
+// the original document for every field that has not been changed.
 func (c *Config) ToYAML() ([]byte, error) {
-	return yaml.Marshal(c)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.toYAML()
+}
+
+// toYAML is ToYAML without locking
+func (c *Config) toYAML() ([]byte, error) {
+	if c.node == nil {
+		return yaml.Marshal(c.document())
+	}
+	return c.marshalPreserving()
+}
+
+// configDocument is the YAML form of a Config. Marshaling goes through it
+// rather than Config itself so the encoder never touches the mutex.
+type configDocument struct {
+	Network *Network `yaml:"network"`
+}
+
+// document returns the configuration in its YAML form
+func (c *Config) document() *configDocument {
+	return &configDocument{Network: &c.Network}
 }
 
 // String returns a string representation of the configuration
@@ -316,268 +1292,243 @@ func (c *Config) String() string {
 	return string(data)
 }
 
-// GetBondIPAddresses returns a map of interface names to their IP addresses
-// for all interfaces that involve the specified bond (including VLANs and bridges)
-func (c *Config) GetBondIPAddresses(bondName string) map[string][]string {
-	result := make(map[string][]string)
+// GetInterfaceIPAddresses returns a map of interface names to their IP addresses
+// for the named interface and every interface stacked on top of it (VLANs,
+// bridges, VRFs, tunnels, ...)
+func (c *Config) GetInterfaceIPAddresses(name string) map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interfaceIPAddresses(name)
+}
 
-	// Check if the bond exists
-	bond, exists := c.Network.Bonds[bondName]
-	if !exists {
-		return result
-	}
+// interfaceIPAddresses is GetInterfaceIPAddresses without locking
+func (c *Config) interfaceIPAddresses(name string) map[string][]string {
+	result := make(map[string][]string)
 
-	// 1. Get IP addresses directly assigned to the bond
-	if len(bond.Addresses) > 0 {
-		for _, addr := range bond.Addresses {
-			result[bondName] = append(result[bondName], stripCIDR(addr))
+	for _, ifaceName := range c.stackedInterfaces(name) {
+		iface := c.getCommonInterface(ifaceName)
+		if iface == nil {
+			continue
 		}
-	}
-
-	// 2. Find VLANs that use this bond as their link
-	for vlanName, vlan := range c.Network.VLANs {
-		if vlan.Link == bondName && len(vlan.Addresses) > 0 {
-			for _, addr := range vlan.Addresses {
-				result[vlanName] = append(result[vlanName], stripCIDR(addr))
-			}
+		for _, addr := range iface.Addresses {
+			result[ifaceName] = append(result[ifaceName], stripCIDR(addr))
 		}
 	}
 
-	// 3. Find bridges that include this bond in their interfaces
-	for bridgeName, bridge := range c.Network.Bridges {
-		for _, iface := range bridge.Interfaces {
-			if iface == bondName && len(bridge.Addresses) > 0 {
-				for _, addr := range bridge.Addresses {
-					result[bridgeName] = append(result[bridgeName], stripCIDR(addr))
-				}
-				break
-			}
-		}
-	}
+	return result
+}
 
-	// 4. Find VLANs that are built on top of bridges that include this bond
-	for vlanName, vlan := range c.Network.VLANs {
-		if bridge, bridgeExists := c.Network.Bridges[vlan.Link]; bridgeExists {
-			// Check if this bridge includes our bond
-			for _, iface := range bridge.Interfaces {
-				if iface == bondName && len(vlan.Addresses) > 0 {
-					for _, addr := range vlan.Addresses {
-						result[vlanName] = append(result[vlanName], stripCIDR(addr))
-					}
-					break
-				}
-			}
-		}
-	}
+// GetInterfaceIPAddressesWithMask returns the IP addresses of the named interface
+// and every interface stacked on top of it with their CIDR notation intact.
+// This is used for subnet matching when testing connectivity.
+func (c *Config) GetInterfaceIPAddressesWithMask(name string) []IPWithMask {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interfaceIPAddressesWithMask(name)
+}
 
-	// 5. Find bridges that include VLANs built on top of this bond
-	for bridgeName, bridge := range c.Network.Bridges {
-		for _, iface := range bridge.Interfaces {
-			if vlan, vlanExists := c.Network.VLANs[iface]; vlanExists {
-				if vlan.Link == bondName && len(bridge.Addresses) > 0 {
-					for _, addr := range bridge.Addresses {
-						result[bridgeName] = append(result[bridgeName], stripCIDR(addr))
-					}
-					break
-				}
-			}
-		}
-	}
+// interfaceIPAddressesWithMask is GetInterfaceIPAddressesWithMask without
+// locking
+func (c *Config) interfaceIPAddressesWithMask(name string) []IPWithMask {
+	var result []IPWithMask
 
-	// 6. Handle nested scenarios: find tunnels that might use the bond or its derived interfaces
-	for tunnelName, tunnel := range c.Network.Tunnels {
-		if len(tunnel.Addresses) > 0 {
-			// Check if tunnel references the bond directly or any of its derived interfaces
-			if c.isBondRelated(bondName, tunnelName) {
-				for _, addr := range tunnel.Addresses {
-					result[tunnelName] = append(result[tunnelName], stripCIDR(addr))
-				}
+	for _, ifaceName := range c.stackedInterfaces(name) {
+		iface := c.getCommonInterface(ifaceName)
+		if iface == nil {
+			continue
+		}
+		for _, addr := range iface.Addresses {
+			_, ipNet, err := net.ParseCIDR(addr)
+			if err != nil {
+				continue
 			}
+			result = append(result, IPWithMask{
+				IP:       stripCIDR(addr),
+				CIDR:     addr,
+				IPNet:    ipNet,
+				BondName: ifaceName,
+				MTU:      c.effectiveMTU(ifaceName, nil),
+			})
 		}
 	}
 
 	return result
 }
 
-// GetBondIPAddressesWithMask returns bond IP addresses with their CIDR notation intact
-// This is used for subnet matching when testing connectivity
-// Includes IPs from: bond itself, VLANs on bond, bridges with bond, VLANs on bridges, tunnels
-func (c *Config) GetBondIPAddressesWithMask(bondName string) []IPWithMask {
-	var result []IPWithMask
+// stackedInterfaces returns the named interface followed by every interface
+// that depends on it, or nil if the interface is not defined
+func (c *Config) stackedInterfaces(name string) []string {
+	if _, _, exists := c.getInterface(name); !exists {
+		return nil
+	}
+	return append([]string{name}, c.topology().Descendants(name)...)
+}
 
-	// Check if the bond exists
-	bond, exists := c.Network.Bonds[bondName]
+// getCommonInterface returns the common properties of the named interface,
+// or nil if it does not exist or has none (VRFs)
+func (c *Config) getCommonInterface(name string) *CommonInterface {
+	iface, _, exists := c.getInterface(name)
 	if !exists {
-		return result
+		return nil
+	}
+
+	switch v := iface.(type) {
+	case *Ethernet:
+		return &v.CommonInterface
+	case *Wifi:
+		return &v.CommonInterface
+	case *Bridge:
+		return &v.CommonInterface
+	case *Bond:
+		return &v.CommonInterface
+	case *VLAN:
+		return &v.CommonInterface
+	case *Tunnel:
+		return &v.CommonInterface
+	case *Modem:
+		return &v.CommonInterface
 	}
+	return nil
+}
 
-	// Helper to create IPWithMask from address
-	addIPWithMask := func(addr, interfaceName string) {
-		_, ipNet, err := net.ParseCIDR(addr)
-		if err != nil {
-			return
-		}
-		result = append(result, IPWithMask{
-			IP:       stripCIDR(addr),
-			CIDR:     addr,
-			IPNet:    ipNet,
-			BondName: interfaceName,
-		})
-	}
+// GetBondIPAddresses returns a map of interface names to their IP addresses
+// for all interfaces that involve the specified bond (including VLANs and bridges)
+func (c *Config) GetBondIPAddresses(bondName string) map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// 1. Get IP addresses directly assigned to the bond
-	for _, addr := range bond.Addresses {
-		addIPWithMask(addr, bondName)
+	if _, exists := c.Network.Bonds[bondName]; !exists {
+		return make(map[string][]string)
 	}
+	return c.interfaceIPAddresses(bondName)
+}
 
-	// 2. Find VLANs that use this bond as their link
-	for vlanName, vlan := range c.Network.VLANs {
-		if vlan.Link == bondName {
-			for _, addr := range vlan.Addresses {
-				addIPWithMask(addr, vlanName)
-			}
-		}
-	}
+// GetBondIPAddressesWithMask returns bond IP addresses with their CIDR notation intact
+// This is used for subnet matching when testing connectivity
+// Includes IPs from: bond itself, VLANs on bond, bridges with bond, VLANs on bridges, tunnels
+func (c *Config) GetBondIPAddressesWithMask(bondName string) []IPWithMask {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// 3. Find bridges that include this bond in their interfaces
-	for bridgeName, bridge := range c.Network.Bridges {
-		for _, iface := range bridge.Interfaces {
-			if iface == bondName {
-				for _, addr := range bridge.Addresses {
-					addIPWithMask(addr, bridgeName)
-				}
-				break
-			}
-		}
+	if _, exists := c.Network.Bonds[bondName]; !exists {
+		return nil
 	}
+	return c.interfaceIPAddressesWithMask(bondName)
+}
 
-	// 4. Find VLANs that are built on top of bridges that include this bond
-	for vlanName, vlan := range c.Network.VLANs {
-		if bridge, bridgeExists := c.Network.Bridges[vlan.Link]; bridgeExists {
-			for _, iface := range bridge.Interfaces {
-				if iface == bondName {
-					for _, addr := range vlan.Addresses {
-						addIPWithMask(addr, vlanName)
-					}
-					break
-				}
-			}
-		}
-	}
+// GetAllBondRelatedInterfaces returns all interface names that are related to the specified bond
+func (c *Config) GetAllBondRelatedInterfaces(bondName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// 5. Find bridges that include VLANs built on top of this bond
-	for bridgeName, bridge := range c.Network.Bridges {
-		for _, iface := range bridge.Interfaces {
-			if vlan, vlanExists := c.Network.VLANs[iface]; vlanExists {
-				if vlan.Link == bondName {
-					for _, addr := range bridge.Addresses {
-						addIPWithMask(addr, bridgeName)
-					}
-					break
-				}
-			}
-		}
+	if _, exists := c.Network.Bonds[bondName]; !exists {
+		return nil
 	}
+	return c.stackedInterfaces(bondName)
+}
 
-	// 6. Handle nested scenarios: find tunnels that might use the bond or its derived interfaces
-	for tunnelName, tunnel := range c.Network.Tunnels {
-		if len(tunnel.Addresses) > 0 {
-			// Check if tunnel references the bond directly or any of its derived interfaces
-			if c.isBondRelated(bondName, tunnelName) {
-				for _, addr := range tunnel.Addresses {
-					addIPWithMask(addr, tunnelName)
-				}
-			}
+// GetLinkIPAddresses returns the IP addresses of every interface grouped by
+// link, as described by GetLinkIPAddressesWithMask
+func (c *Config) GetLinkIPAddresses() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make(map[string][]string)
+	for link, addrs := range c.linkIPAddressesWithMask() {
+		for _, addr := range addrs {
+			result[link] = append(result[link], addr.IP)
 		}
 	}
-
 	return result
 }
 
-// isBondRelated checks if an interface name is related to the specified bond
-// This includes the bond itself, VLANs on the bond, bridges containing the bond, etc.
-func (c *Config) isBondRelated(bondName, interfaceName string) bool {
-	// Direct match
-	if interfaceName == bondName {
-		return true
-	}
+// GetLinkIPAddressesWithMask returns the IP addresses of every interface that
+// has one, grouped by link. Interfaces stacked on a bond belong to the bond's
+// link, so each bond maps to the same addresses as GetBondIPAddressesWithMask.
+// Any other interface, such as a plain ethernet or a VLAN or bridge on a NIC,
+// is a link of its own.
+func (c *Config) GetLinkIPAddressesWithMask() map[string][]IPWithMask {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.linkIPAddressesWithMask()
+}
 
-	// Check if it's a VLAN on the bond
-	if vlan, exists := c.Network.VLANs[interfaceName]; exists {
-		if vlan.Link == bondName {
-			return true
-		}
-		// Check if it's a VLAN on a bridge that contains the bond
-		if bridge, bridgeExists := c.Network.Bridges[vlan.Link]; bridgeExists {
-			for _, iface := range bridge.Interfaces {
-				if iface == bondName {
-					return true
-				}
-			}
+// linkIPAddressesWithMask is GetLinkIPAddressesWithMask without locking
+func (c *Config) linkIPAddressesWithMask() map[string][]IPWithMask {
+	result := make(map[string][]IPWithMask)
+	topology := c.topology()
+
+	names := c.interfaceNames()
+	sort.Strings(names)
+	for _, name := range names {
+		iface := c.getCommonInterface(name)
+		if iface == nil {
+			continue
 		}
-	}
 
-	// Check if it's a bridge that contains the bond
-	if bridge, exists := c.Network.Bridges[interfaceName]; exists {
-		for _, iface := range bridge.Interfaces {
-			if iface == bondName {
-				return true
+		link := c.linkOf(name, topology)
+		for _, addr := range iface.Addresses {
+			_, ipNet, err := net.ParseCIDR(addr)
+			if err != nil {
+				continue
 			}
+			result[link] = append(result[link], IPWithMask{
+				IP:       stripCIDR(addr),
+				CIDR:     addr,
+				IPNet:    ipNet,
+				BondName: name,
+				MTU:      c.effectiveMTU(name, nil),
+			})
 		}
 	}
 
-	return false
+	return result
 }
 
-// GetAllBondRelatedInterfaces returns all interface names that are related to the specified bond
-func (c *Config) GetAllBondRelatedInterfaces(bondName string) []string {
-	var interfaces []string
+// GetGateways returns the gateways configured on every interface: its
+// gateway4 and gateway6, and the next hops of its routes. Each gateway is
+// listed once per interface, in the order it is configured.
+func (c *Config) GetGateways() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// Check if the bond exists
-	if _, exists := c.Network.Bonds[bondName]; !exists {
-		return interfaces
-	}
-
-	// Add the bond itself
-	interfaces = append(interfaces, bondName)
+	result := make(map[string][]string)
 
-	// Find VLANs that use this bond
-	for vlanName, vlan := range c.Network.VLANs {
-		if vlan.Link == bondName {
-			interfaces = append(interfaces, vlanName)
+	names := c.interfaceNames()
+	sort.Strings(names)
+	for _, name := range names {
+		iface := c.getCommonInterface(name)
+		if iface == nil {
+			continue
 		}
-	}
 
-	// Find bridges that include this bond
-	for bridgeName, bridge := range c.Network.Bridges {
-		for _, iface := range bridge.Interfaces {
-			if iface == bondName {
-				interfaces = append(interfaces, bridgeName)
-				break
-			}
+		candidates := []string{iface.Gateway4, iface.Gateway6}
+		for _, route := range iface.Routes {
+			candidates = append(candidates, route.Via)
 		}
-	}
-
-	// Find VLANs on bridges that include this bond
-	for vlanName, vlan := range c.Network.VLANs {
-		if bridge, bridgeExists := c.Network.Bridges[vlan.Link]; bridgeExists {
-			for _, iface := range bridge.Interfaces {
-				if iface == bondName {
-					interfaces = append(interfaces, vlanName)
-					break
-				}
+		for _, gateway := range candidates {
+			if gateway == "" || containsString(result[name], gateway) {
+				continue
 			}
+			result[name] = append(result[name], gateway)
 		}
 	}
 
-	// Find tunnels that might reference bond-related interfaces
-	for tunnelName := range c.Network.Tunnels {
-		if c.isBondRelated(bondName, tunnelName) {
-			interfaces = append(interfaces, tunnelName)
+	return result
+}
+
+// linkOf returns the link an interface belongs to: the bond it is, or is
+// stacked on, and otherwise the interface itself
+func (c *Config) linkOf(name string, topology *Topology) string {
+	if _, isBond := c.Network.Bonds[name]; isBond {
+		return name
+	}
+	for _, ancestor := range topology.Ancestors(name) {
+		if _, isBond := c.Network.Bonds[ancestor]; isBond {
+			return ancestor
 		}
 	}
-
-	return interfaces
+	return name
 }
 
 // GetBondIPAddresses loads netplan configs from a directory and returns
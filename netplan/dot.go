@@ -0,0 +1,75 @@
+package netplan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dotShapes maps interface kinds to graphviz node shapes
+var dotShapes = map[InterfaceKind]string{
+	KindEthernet: "box",
+	KindWifi:     "box",
+	KindModem:    "box",
+	KindBond:     "hexagon",
+	KindBridge:   "component",
+	KindVLAN:     "ellipse",
+	KindTunnel:   "cds",
+	KindVRF:      "folder",
+	KindUnknown:  "plaintext",
+}
+
+// ToDOT renders the interface topology as a graphviz digraph. Each node is
+// labelled with the interface name, its kind and its configured addresses;
+// edges point from lower layer devices to the devices stacked on them.
+func (c *Config) ToDOT() string {
+	// Topology locks the configuration
+	topo := c.Topology()
+
+	var sb strings.Builder
+	sb.WriteString("digraph netplan {\n")
+	sb.WriteString("\trankdir=BT;\n")
+	sb.WriteString("\tnode [fontname=\"monospace\"];\n")
+
+	names := make([]string, 0, len(topo.Nodes))
+	for name := range topo.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := topo.Nodes[name]
+
+		label := []string{node.Name, string(node.Kind)}
+		label = append(label, node.Addresses...)
+
+		shape, ok := dotShapes[node.Kind]
+		if !ok {
+			shape = "box"
+		}
+
+		style := ""
+		if node.Kind == KindUnknown {
+			style = ", style=dashed"
+		}
+
+		fmt.Fprintf(&sb, "\t%s [label=%s, shape=%s%s];\n",
+			dotQuote(name), dotQuote(strings.Join(label, "\n")), shape, style)
+	}
+
+	for _, edge := range topo.Edges {
+		fmt.Fprintf(&sb, "\t%s -> %s [label=%s];\n",
+			dotQuote(edge.Parent), dotQuote(edge.Child), dotQuote(string(edge.Relation)))
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// dotQuote returns s as a double-quoted DOT identifier
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,67 @@
+package netplan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileMode is the permission netplan expects on its configuration
+// files. netplan warns about files that are readable by other users.
+const ConfigFileMode os.FileMode = 0600
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// filename and renames it into place, syncing both the file and the
+// directory so the new contents survive a crash
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temporary file on any failure before the rename
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if err := tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	committed = true
+
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so that renames within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
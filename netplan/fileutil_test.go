@@ -0,0 +1,63 @@
+package netplan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveConfigAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFileName(1, "netcfg"))
+
+	config := NewConfig()
+	config.AddEthernet("eth0", NewEthernetDHCP())
+
+	if err := SaveConfig(config, path); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat saved config: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != ConfigFileMode {
+		t.Errorf("Expected mode %v, got %v", ConfigFileMode, perm)
+	}
+
+	// No temporary files may be left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the config file in %s, found %d entries", dir, len(entries))
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if !loaded.Equal(config) {
+		t.Error("Saved config does not match the original")
+	}
+}
+
+func TestConfigFileName(t *testing.T) {
+	tests := []struct {
+		priority int
+		name     string
+		expected string
+	}{
+		{1, "netcfg", "01-netcfg.yaml"},
+		{50, "cloud-init.yaml", "50-cloud-init.yaml"},
+		{150, "override", "99-override.yaml"},
+		{-1, "base.yml", "00-base.yaml"},
+	}
+
+	for _, tt := range tests {
+		if result := ConfigFileName(tt.priority, tt.name); result != tt.expected {
+			t.Errorf("ConfigFileName(%d, %q) = %s, want %s", tt.priority, tt.name, result, tt.expected)
+		}
+	}
+}
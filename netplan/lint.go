@@ -0,0 +1,79 @@
+package netplan
+
+import (
+	"fmt"
+	"os"
+)
+
+// LintIssue is a single error or warning reported by LintNetplanDir
+type LintIssue struct {
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintReport is the machine-readable result of linting a netplan directory
+type LintReport struct {
+	Dir       string      `json:"dir"`
+	Files     []string    `json:"files"`
+	Valid     bool        `json:"valid"`
+	Errors    []LintIssue `json:"errors"`
+	Warnings  []LintIssue `json:"warnings"`
+	Conflicts []Conflict  `json:"conflicts"`
+}
+
+// LintNetplanDir loads every netplan file in a directory and reports parse
+// errors, validation errors and warnings for the merged configuration, and
+// conflicts between files. The report is valid when it has no errors and no
+// conflicts; warnings do not affect validity. An error is only returned if
+// the directory itself cannot be read.
+func LintNetplanDir(dir string) (*LintReport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to read netplan directory: %w", err)
+	}
+
+	paths, err := netplanFilePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LintReport{
+		Dir:       dir,
+		Files:     paths,
+		Errors:    []LintIssue{},
+		Warnings:  []LintIssue{},
+		Conflicts: []Conflict{},
+	}
+
+	var files []ConfigFile
+	for _, path := range paths {
+		config, err := LoadConfig(path)
+		if err != nil {
+			report.Errors = append(report.Errors, LintIssue{File: path, Message: err.Error()})
+			continue
+		}
+		files = append(files, ConfigFile{Path: path, Config: config})
+	}
+
+	// Interfaces may reference devices defined in other files, so
+	// validation runs on the merged configuration netplan would apply
+	configs := make([]*Config, 0, len(files))
+	for _, file := range files {
+		configs = append(configs, file.Config)
+	}
+	merged, err := MergeConfigs(configs...)
+	if err != nil {
+		report.Errors = append(report.Errors, LintIssue{Message: fmt.Sprintf("failed to merge configs: %v", err)})
+	} else if len(configs) > 0 {
+		for _, err := range merged.Validate() {
+			report.Errors = append(report.Errors, LintIssue{Message: err.Error()})
+		}
+		for _, warning := range merged.Warnings() {
+			report.Warnings = append(report.Warnings, LintIssue{Message: warning.Error()})
+		}
+	}
+
+	report.Conflicts = append(report.Conflicts, AnalyzeConfigFiles(files)...)
+	report.Valid = len(report.Errors) == 0 && len(report.Conflicts) == 0
+
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package netplan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintNetplanDir(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		valid         bool
+		errors        []string
+		warnings      int
+		conflictTypes []ConflictType
+	}{
+		{
+			name: "cross-file-references",
+			files: map[string]string{
+				"00-ethernets.yaml": "network:\n  version: 2\n  ethernets:\n    eth0: {}\n    eth1: {}",
+				"10-bond.yaml":      "network:\n  version: 2\n  bonds:\n    bond0:\n      interfaces: [eth0, eth1]\n      dhcp4: true",
+			},
+			valid: true,
+		},
+		{
+			name: "parse-error",
+			files: map[string]string{
+				"00-good.yaml":   "network:\n  version: 2",
+				"50-broken.yaml": "network:\n  ethernets: [",
+			},
+			errors: []string{"50-broken.yaml"},
+		},
+		{
+			name: "validation-error",
+			files: map[string]string{
+				"00-vrf.yaml": "network:\n  version: 2\n  vrfs:\n    vrf-blue:\n      table: 100\n      interfaces: [eth9]",
+			},
+			errors: []string{"eth9"},
+		},
+		{
+			name: "conflicts",
+			files: map[string]string{
+				"00-a.yaml": "network:\n  version: 2\n  ethernets:\n    eth0:\n      mtu: 1500",
+				"10-b.yaml": "network:\n  version: 2\n  ethernets:\n    eth0:\n      mtu: 9000",
+			},
+			conflictTypes: []ConflictType{ConflictContradictory},
+		},
+		{
+			name: "warnings-only",
+			files: map[string]string{
+				"00-a.yaml": "network:\n  version: 2\n  ethernets:\n    eth0:\n      future-key: 1",
+			},
+			valid:    true,
+			warnings: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatalf("Failed to write %s: %v", name, err)
+				}
+			}
+
+			report, err := LintNetplanDir(dir)
+			if err != nil {
+				t.Fatalf("LintNetplanDir failed: %v", err)
+			}
+
+			if report.Valid != tt.valid {
+				t.Errorf("Expected valid=%v, got report %+v", tt.valid, report)
+			}
+			if len(report.Files) != len(tt.files) {
+				t.Errorf("Expected %d files, got %v", len(tt.files), report.Files)
+			}
+			if len(report.Errors) != len(tt.errors) {
+				t.Fatalf("Expected %d errors, got %+v", len(tt.errors), report.Errors)
+			}
+			for i, want := range tt.errors {
+				issue := report.Errors[i]
+				if !strings.Contains(issue.File+" "+issue.Message, want) {
+					t.Errorf("Expected error mentioning %q, got %+v", want, issue)
+				}
+			}
+			if len(report.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %+v", tt.warnings, report.Warnings)
+			}
+			if len(report.Conflicts) != len(tt.conflictTypes) {
+				t.Fatalf("Expected %d conflicts, got %+v", len(tt.conflictTypes), report.Conflicts)
+			}
+			for i, want := range tt.conflictTypes {
+				if report.Conflicts[i].Type != want {
+					t.Errorf("Expected conflict %s, got %s", want, report.Conflicts[i].Type)
+				}
+			}
+		})
+	}
+
+	if _, err := LintNetplanDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected error for missing directory")
+	}
+}
@@ -0,0 +1,105 @@
+package netplan
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// FindByMatch returns the names of the physical interfaces (ethernets,
+// wifis and modems) whose definition applies to a device with the given
+// properties. Definitions with a match stanza are evaluated the way
+// netplan does: every field that is set must match, name, driver and
+// path accept shell globs and MAC addresses compare case-insensitively.
+// Definitions without a match stanza apply to the device of the same name.
+func (c *Config) FindByMatch(device Match) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+
+	check := func(name string, iface *CommonInterface) {
+		if iface.Match == nil {
+			if device.Name != "" && device.Name == name {
+				names = append(names, name)
+			}
+			return
+		}
+		if iface.Match.Matches(device) {
+			names = append(names, name)
+		}
+	}
+
+	for name, eth := range c.Network.Ethernets {
+		check(name, &eth.CommonInterface)
+	}
+	for name, wifi := range c.Network.Wifis {
+		check(name, &wifi.CommonInterface)
+	}
+	for name, modem := range c.Network.Modems {
+		check(name, &modem.CommonInterface)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// FindByMAC returns the names of the interfaces that match the given MAC
+// address, either through their match stanza or their configured macaddress
+func (c *Config) FindByMAC(mac string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+
+	for _, def := range c.definitions() {
+		if def.Common == nil {
+			continue
+		}
+		if def.Common.Match != nil && def.Common.Match.MacAddress != "" && strings.EqualFold(def.Common.Match.MacAddress, mac) {
+			names = append(names, def.Name)
+			continue
+		}
+		if def.Common.MacAddress != "" && strings.EqualFold(def.Common.MacAddress, mac) {
+			names = append(names, def.Name)
+		}
+	}
+
+	return names
+}
+
+// Matches reports whether a device with the given properties satisfies
+// the match criteria. Fields left empty in the criteria are ignored.
+func (m *Match) Matches(device Match) bool {
+	if m.Name != "" && !globMatch(m.Name, device.Name) {
+		return false
+	}
+	if m.MacAddress != "" && !strings.EqualFold(m.MacAddress, device.MacAddress) {
+		return false
+	}
+	if m.Driver != "" && !globMatch(m.Driver, device.Driver) {
+		return false
+	}
+	if m.Path != "" && !globMatch(m.Path, device.Path) {
+		return false
+	}
+	return true
+}
+
+// globMatch matches value against a shell glob pattern. Malformed
+// patterns only match the identical string.
+func globMatch(pattern, value string) bool {
+	if value == "" {
+		return false
+	}
+	matched, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return matched
+}
+
+// hasGlob reports whether s contains shell glob metacharacters
+func hasGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
@@ -0,0 +1,143 @@
+package netplan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindByMatch(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    lan:
+      match:
+        macaddress: "AA:BB:CC:DD:EE:01"
+      set-name: lan0
+    mlx:
+      match:
+        driver: mlx5_*
+        name: enp*
+    eno1:
+      dhcp4: true
+  wifis:
+    wlan:
+      match:
+        path: pci-0000:03:00.0`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		device   Match
+		expected []string
+	}{
+		{
+			name:     "mac-match-case-insensitive",
+			device:   Match{Name: "enp1s0", MacAddress: "aa:bb:cc:dd:ee:01", Driver: "e1000e"},
+			expected: []string{"lan"},
+		},
+		{
+			name:     "driver-and-name-globs",
+			device:   Match{Name: "enp65s0f0", MacAddress: "aa:bb:cc:dd:ee:02", Driver: "mlx5_core"},
+			expected: []string{"mlx"},
+		},
+		{
+			name:     "glob-name-mismatch",
+			device:   Match{Name: "eth0", Driver: "mlx5_core"},
+			expected: nil,
+		},
+		{
+			name:     "no-match-stanza-uses-name",
+			device:   Match{Name: "eno1", Driver: "igb"},
+			expected: []string{"eno1"},
+		},
+		{
+			name:     "path-match",
+			device:   Match{Name: "wlp3s0", Path: "pci-0000:03:00.0"},
+			expected: []string{"wlan"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := config.FindByMatch(tt.device)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("FindByMatch(%+v) = %v, want %v", tt.device, result, tt.expected)
+			}
+		})
+	}
+
+	if result := config.FindByMAC("aa:bb:cc:dd:ee:01"); !reflect.DeepEqual(result, []string{"lan"}) {
+		t.Errorf("FindByMAC() = %v, want [lan]", result)
+	}
+	if result := config.FindByMAC("00:00:00:00:00:00"); len(result) != 0 {
+		t.Errorf("FindByMAC() = %v, want no matches", result)
+	}
+}
+
+func TestSetNameValidation(t *testing.T) {
+	tests := []struct {
+		name          string
+		iface         *Ethernet
+		expectError   bool
+		expectWarning bool
+	}{
+		{
+			name: "valid-rename",
+			iface: &Ethernet{CommonInterface: CommonInterface{
+				Match:   &Match{MacAddress: "aa:bb:cc:dd:ee:ff"},
+				SetName: "lan0",
+			}},
+		},
+		{
+			name:        "set-name-without-match",
+			iface:       &Ethernet{CommonInterface: CommonInterface{SetName: "lan0"}},
+			expectError: true,
+		},
+		{
+			name: "set-name-too-long",
+			iface: &Ethernet{CommonInterface: CommonInterface{
+				Match:   &Match{Name: "enp1s0"},
+				SetName: "a-very-long-interface-name",
+			}},
+			expectError: true,
+		},
+		{
+			name: "invalid-match-mac",
+			iface: &Ethernet{CommonInterface: CommonInterface{
+				Match: &Match{MacAddress: "zz:zz"},
+			}},
+			expectError: true,
+		},
+		{
+			name: "glob-with-set-name",
+			iface: &Ethernet{CommonInterface: CommonInterface{
+				Match:   &Match{Name: "enp*"},
+				SetName: "lan0",
+			}},
+			expectWarning: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddEthernet("eth0", tt.iface)
+
+			if errors := config.Validate(); (len(errors) > 0) != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+			if warnings := config.Warnings(); (len(warnings) > 0) != tt.expectWarning {
+				t.Errorf("Expected warning: %v, got warnings: %v", tt.expectWarning, warnings)
+			}
+		})
+	}
+
+	// match is not allowed on virtual devices
+	config := NewConfig()
+	config.AddBond("bond0", &Bond{CommonInterface: CommonInterface{Match: &Match{Name: "bond*"}}})
+	if errors := config.Validate(); len(errors) == 0 {
+		t.Error("Expected error for match on a bond")
+	}
+}
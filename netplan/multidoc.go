@@ -0,0 +1,135 @@
+package netplan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfigsFromBytes loads every document of a multi-document YAML
+// stream. Each returned Config retains its own YAML document, so comments
+// and key order are preserved when it is written back out. Empty documents
+// are skipped.
+func LoadConfigsFromBytes(data []byte) ([]*Config, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	var configs []*Config
+	for index := 0; ; index++ {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML document %d: %w", index, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].ShortTag() == "!!null" {
+			continue
+		}
+
+		var config Config
+		if err := doc.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML document %d: %w", index, err)
+		}
+		config.node = &doc
+		configs = append(configs, &config)
+	}
+
+	return configs, nil
+}
+
+// LoadMergedConfigFromBytes loads a multi-document YAML stream and merges
+// all documents into a single configuration using MergeConfigs
+func LoadMergedConfigFromBytes(data []byte) (*Config, error) {
+	configs, err := LoadConfigsFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return MergeConfigs(configs...)
+}
+
+// MergeConfigs merges configurations the way netplan merges files: later
+// configs override scalar values of earlier ones, mappings are merged
+// recursively and lists are concatenated, skipping repeated entries
+func MergeConfigs(configs ...*Config) (*Config, error) {
+	var merged *yaml.Node
+	for i, config := range configs {
+		var node yaml.Node
+		config.mu.Lock()
+		err := node.Encode(config.document())
+		config.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config %d: %w", i, err)
+		}
+
+		// An unset version must not override the version of earlier configs
+		if network := mappingValue(&node, "network"); network != nil {
+			if version := mappingValue(network, "version"); version != nil && version.Value == "0" {
+				removeMappingKey(network, "version")
+			}
+		}
+
+		if merged == nil {
+			merged = &node
+			continue
+		}
+		mergeNetplanNodes(merged, &node)
+	}
+
+	result := &Config{}
+	if merged == nil {
+		return result, nil
+	}
+	if err := merged.Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to decode merged config: %w", err)
+	}
+	return result, nil
+}
+
+// mergeNetplanNodes merges src into dst following netplan's rules for
+// combining several configuration files
+func mergeNetplanNodes(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		*dst = *src
+		return
+	}
+
+	switch dst.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			if existing := mappingValue(dst, key.Value); existing != nil {
+				mergeNetplanNodes(existing, value)
+			} else {
+				dst.Content = append(dst.Content, key, value)
+			}
+		}
+
+	case yaml.SequenceNode:
+		for _, item := range src.Content {
+			if !sequenceContains(dst, item) {
+				dst.Content = append(dst.Content, item)
+			}
+		}
+
+	default:
+		*dst = *src
+	}
+}
+
+// sequenceContains reports whether a sequence already holds a scalar
+// equal to item. Non-scalar items are never considered duplicates.
+func sequenceContains(seq, item *yaml.Node) bool {
+	if item.Kind != yaml.ScalarNode {
+		return false
+	}
+	for _, existing := range seq.Content {
+		if existing.Kind == yaml.ScalarNode && existing.Value == item.Value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package netplan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const multiDocConfig = `# base layout
+network:
+  version: 2
+  ethernets:
+    eth0:
+      mtu: 1500
+      addresses: [10.0.0.2/24]
+---
+---
+network:
+  ethernets:
+    eth0:
+      mtu: 9000
+      addresses: [10.0.0.2/24, 10.0.0.3/24]
+    eth1:
+      dhcp4: true
+`
+
+func TestLoadConfigsFromBytes(t *testing.T) {
+	configs, err := LoadConfigsFromBytes([]byte(multiDocConfig))
+	if err != nil {
+		t.Fatalf("Failed to load configs: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 documents (empty one skipped), got %d", len(configs))
+	}
+
+	if configs[0].Network.Ethernets["eth0"].MTU != 1500 {
+		t.Errorf("Unexpected first document: %+v", configs[0].Network.Ethernets["eth0"])
+	}
+	if _, ok := configs[1].Network.Ethernets["eth1"]; !ok {
+		t.Errorf("Expected eth1 in the second document")
+	}
+
+	data, err := configs[0].ToYAML()
+	if err != nil {
+		t.Fatalf("ToYAML failed: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# base layout\n") {
+		t.Errorf("Expected document comment to be preserved, got:\n%s", data)
+	}
+
+	if _, err := LoadConfigsFromBytes([]byte("network:\n  version: 2\n---\nnetwork: [")); err == nil {
+		t.Errorf("Expected error for malformed second document")
+	}
+}
+
+func TestLoadMergedConfigFromBytes(t *testing.T) {
+	config, err := LoadMergedConfigFromBytes([]byte(multiDocConfig))
+	if err != nil {
+		t.Fatalf("Failed to load merged config: %v", err)
+	}
+
+	if config.Network.Version != 2 {
+		t.Errorf("Expected version from the first document, got %d", config.Network.Version)
+	}
+
+	eth0 := config.Network.Ethernets["eth0"]
+	if eth0.MTU != 9000 {
+		t.Errorf("Expected later MTU to win, got %d", eth0.MTU)
+	}
+	expected := []string{"10.0.0.2/24", "10.0.0.3/24"}
+	if !reflect.DeepEqual(eth0.Addresses, expected) {
+		t.Errorf("Expected concatenated addresses %v, got %v", expected, eth0.Addresses)
+	}
+
+	eth1, ok := config.Network.Ethernets["eth1"]
+	if !ok || eth1.DHCP4 == nil || !*eth1.DHCP4 {
+		t.Errorf("Expected eth1 from the second document, got %+v", eth1)
+	}
+}
+
+func TestMergeConfigsEmpty(t *testing.T) {
+	config, err := MergeConfigs()
+	if err != nil {
+		t.Fatalf("MergeConfigs failed: %v", err)
+	}
+	if len(config.Network.Ethernets) != 0 {
+		t.Errorf("Expected empty config, got %+v", config.Network)
+	}
+}
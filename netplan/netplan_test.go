@@ -1,6 +1,9 @@
 package netplan
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -181,6 +184,235 @@ func TestValidation(t *testing.T) {
 	}
 }
 
+func TestVRFValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		build       func(c *Config)
+		expectError bool
+	}{
+		{
+			name: "valid-vrf",
+			build: func(c *Config) {
+				c.AddEthernet("eth0", NewEthernetDHCP())
+				c.AddVRF("vrf-blue", NewVRF(1000, []string{"eth0"}))
+			},
+			expectError: false,
+		},
+		{
+			name: "reserved-table",
+			build: func(c *Config) {
+				c.AddVRF("vrf-blue", NewVRF(254, nil))
+			},
+			expectError: true,
+		},
+		{
+			name: "missing-table",
+			build: func(c *Config) {
+				c.AddVRF("vrf-blue", NewVRF(0, nil))
+			},
+			expectError: true,
+		},
+		{
+			name: "undefined-member",
+			build: func(c *Config) {
+				c.AddVRF("vrf-blue", NewVRF(1000, []string{"eth9"}))
+			},
+			expectError: true,
+		},
+		{
+			name: "duplicate-table",
+			build: func(c *Config) {
+				c.AddVRF("vrf-blue", NewVRF(1000, nil))
+				c.AddVRF("vrf-red", NewVRF(1000, nil))
+			},
+			expectError: true,
+		},
+		{
+			name: "member-in-two-vrfs",
+			build: func(c *Config) {
+				c.AddEthernet("eth0", NewEthernetDHCP())
+				c.AddVRF("vrf-blue", NewVRF(1000, []string{"eth0"}))
+				c.AddVRF("vrf-red", NewVRF(1001, []string{"eth0"}))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			tt.build(config)
+
+			errors := config.Validate()
+			hasError := len(errors) > 0
+
+			if hasError != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
+
+func TestTunnelValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		tunnel      *Tunnel
+		expectError bool
+	}{
+		{
+			name:        "valid-gre",
+			tunnel:      &Tunnel{Mode: string(TunnelModeGRE), Local: "192.168.1.1", Remote: "192.168.2.1", Key: "1234"},
+			expectError: false,
+		},
+		{
+			name:        "valid-ip6gre",
+			tunnel:      &Tunnel{Mode: string(TunnelModeIP6GRE), Local: "fd00::1", Remote: "fd00::2", TTL: 64},
+			expectError: false,
+		},
+		{
+			name:        "unknown-mode",
+			tunnel:      &Tunnel{Mode: "pptp", Local: "192.168.1.1", Remote: "192.168.2.1"},
+			expectError: true,
+		},
+		{
+			name:        "family-mismatch",
+			tunnel:      &Tunnel{Mode: string(TunnelModeIPIP), Local: "192.168.1.1", Remote: "fd00::2"},
+			expectError: true,
+		},
+		{
+			name:        "missing-remote",
+			tunnel:      &Tunnel{Mode: string(TunnelModeGRE), Local: "192.168.1.1"},
+			expectError: true,
+		},
+		{
+			name:        "keys-on-ipip",
+			tunnel:      &Tunnel{Mode: string(TunnelModeIPIP), Local: "192.168.1.1", Remote: "192.168.2.1", Keys: &Keys{Input: "1"}},
+			expectError: true,
+		},
+		{
+			name:        "ttl-out-of-range",
+			tunnel:      &Tunnel{Mode: string(TunnelModeGRE), Local: "192.168.1.1", Remote: "192.168.2.1", TTL: 300},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddTunnel("tun0", tt.tunnel)
+
+			errors := config.Validate()
+			hasError := len(errors) > 0
+
+			if hasError != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
+
+func TestAccessPointValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		ap          *AccessPoint
+		expectError bool
+	}{
+		{
+			name:        "valid-psk",
+			ap:          &AccessPoint{Password: "secret", Band: WiFiBand5GHz, Channel: 36},
+			expectError: false,
+		},
+		{
+			name: "valid-eap",
+			ap: &AccessPoint{Auth: &Auth{
+				KeyManagement: string(KeyManagementEAP),
+				Method:        "peap",
+				Identity:      "user@example.com",
+			}},
+			expectError: false,
+		},
+		{
+			name:        "invalid-band",
+			ap:          &AccessPoint{Band: "6GHz"},
+			expectError: true,
+		},
+		{
+			name:        "channel-band-mismatch",
+			ap:          &AccessPoint{Band: WiFiBand24GHz, Channel: 36},
+			expectError: true,
+		},
+		{
+			name:        "channel-without-band",
+			ap:          &AccessPoint{Channel: 6},
+			expectError: true,
+		},
+		{
+			name:        "invalid-bssid",
+			ap:          &AccessPoint{BSSID: "not-a-mac"},
+			expectError: true,
+		},
+		{
+			name:        "invalid-mode",
+			ap:          &AccessPoint{Mode: "mesh"},
+			expectError: true,
+		},
+		{
+			name:        "eap-missing-identity",
+			ap:          &AccessPoint{Auth: &Auth{KeyManagement: string(KeyManagement8021X), Method: "tls"}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddWifi("wlan0", &Wifi{
+				AccessPoints: map[string]*AccessPoint{"TestSSID": tt.ap},
+			})
+
+			errors := config.Validate()
+			hasError := len(errors) > 0
+
+			if hasError != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
+
+func TestSRIOV(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("enp1s0f0", NewSRIOVEthernet(4))
+
+	vf, err := config.AssignVF("enp1s0f0", 0, "00:11:22:33:44:55")
+	if err != nil {
+		t.Fatalf("Failed to assign VF: %v", err)
+	}
+	vf.VLAN = 100
+
+	if _, err := config.AssignVF("enp1s0f0", 0, ""); err == nil {
+		t.Error("Expected error when assigning the same VF twice")
+	}
+	if _, err := config.AssignVF("enp1s0f0", 4, ""); err == nil {
+		t.Error("Expected error for VF id beyond total-vfs")
+	}
+	if _, err := config.AssignVF("enp2s0", 0, ""); err == nil {
+		t.Error("Expected error for undefined physical function")
+	}
+
+	if errors := config.Validate(); len(errors) > 0 {
+		t.Errorf("Expected valid config, got errors: %v", errors)
+	}
+
+	// Hand-built tables with bad values must fail validation
+	config.Network.Ethernets["enp1s0f0"].SRIOV.VFTable["bad"] = &VFConfig{ID: 7, VLAN: 5000, QoS: 9}
+	config.AddEthernet("enp2s0", &Ethernet{VirtualFunction: &VirtualFunction{Link: "missing"}})
+
+	if errors := config.Validate(); len(errors) != 4 {
+		t.Errorf("Expected 4 validation errors, got %d: %v", len(errors), errors)
+	}
+}
+
 func TestBuilders(t *testing.T) {
 	config := NewConfig()
 	config.Network.Renderer = "networkd"
@@ -228,6 +460,80 @@ func TestBuilders(t *testing.T) {
 	}
 }
 
+func TestIPv6Builders(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", NewEthernetStaticV6([]string{"fd00::10/64"}, "fd00::1", []string{"fd00::53"}))
+
+	dhcp6 := NewEthernetDHCP6Only()
+	dhcp6.SetIPv6Privacy(true)
+	dhcp6.SetLinkLocal()
+	config.AddEthernet("eth1", dhcp6)
+
+	if errors := config.Validate(); len(errors) > 0 {
+		t.Errorf("Expected valid config, got errors: %v", errors)
+	}
+
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+
+	loaded, err := LoadConfigFromBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	eth0 := loaded.Network.Ethernets["eth0"]
+	if eth0.Gateway6 != "fd00::1" || eth0.Gateway4 != "" {
+		t.Errorf("Unexpected gateways: gateway4=%q gateway6=%q", eth0.Gateway4, eth0.Gateway6)
+	}
+	if eth0.AcceptRA == nil || *eth0.AcceptRA {
+		t.Error("Expected accept-ra to be disabled for static IPv6")
+	}
+
+	eth1 := loaded.Network.Ethernets["eth1"]
+	if eth1.DHCP6 == nil || !*eth1.DHCP6 || eth1.DHCP4 == nil || *eth1.DHCP4 {
+		t.Error("Expected DHCPv6 only")
+	}
+	if eth1.IPv6Privacy == nil || !*eth1.IPv6Privacy {
+		t.Error("Expected ipv6-privacy to be enabled")
+	}
+	// An explicitly empty link-local list disables link-local addressing
+	// and must survive the round trip
+	if eth1.LinkLocal == nil || len(eth1.LinkLocal) != 0 {
+		t.Errorf("Expected empty link-local list, got %#v", eth1.LinkLocal)
+	}
+}
+
+func TestGetInterface(t *testing.T) {
+	config := NewConfig()
+	config.AddBond("bond0", NewBond([]string{"eth0", "eth1"}, BondModeActiveBackup))
+	config.AddVLAN("bond0.100", NewVLAN(100, "bond0"))
+
+	iface, kind, ok := config.GetInterface("bond0.100")
+	if !ok {
+		t.Fatal("Expected bond0.100 to be found")
+	}
+	if kind != KindVLAN {
+		t.Errorf("Expected kind %s, got %s", KindVLAN, kind)
+	}
+	if vlan, isVLAN := iface.(*VLAN); !isVLAN || vlan.ID != 100 {
+		t.Errorf("Expected *VLAN with ID 100, got %#v", iface)
+	}
+
+	if _, _, ok := config.GetInterface("eth9"); ok {
+		t.Error("Expected eth9 not to be found")
+	}
+
+	bond, ok := GetInterfaceAs[*Bond](config, "bond0")
+	if !ok || len(bond.Interfaces) != 2 {
+		t.Errorf("GetInterfaceAs[*Bond] failed: %#v", bond)
+	}
+	if _, ok := GetInterfaceAs[*Bridge](config, "bond0"); ok {
+		t.Error("Expected GetInterfaceAs[*Bridge] to fail for a bond")
+	}
+}
+
 func TestHelperFunctions(t *testing.T) {
 	// Test Bool helper
 	b := Bool(true)
@@ -502,3 +808,636 @@ func TestGetAllBondRelatedInterfaces(t *testing.T) {
 		t.Error("Expected empty result for non-existent bond")
 	}
 }
+
+func TestGetInterfaceIPAddresses(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", &Ethernet{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.0.0.10/24"},
+		},
+	})
+	config.AddVLAN("eth0.200", &VLAN{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.200.0.10/24"},
+		},
+		ID:   200,
+		Link: "eth0",
+	})
+	config.AddBridge("br200", &Bridge{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"172.16.200.1/24"},
+		},
+		Interfaces: []string{"eth0.200"},
+	})
+	config.AddTunnel("gre0", &Tunnel{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"192.168.255.1/30"},
+		},
+		Mode:   string(TunnelModeGRE),
+		Local:  "172.16.200.1",
+		Remote: "172.16.201.1",
+	})
+
+	result := config.GetInterfaceIPAddresses("eth0")
+	for _, name := range []string{"eth0", "eth0.200", "br200", "gre0"} {
+		if len(result[name]) != 1 {
+			t.Errorf("Expected 1 address for %s, got %v", name, result[name])
+		}
+	}
+	if result["eth0"][0] != "10.0.0.10" {
+		t.Errorf("Expected address without mask, got %s", result["eth0"][0])
+	}
+
+	withMask := config.GetInterfaceIPAddressesWithMask("eth0.200")
+	if len(withMask) != 3 {
+		t.Fatalf("Expected 3 addresses above eth0.200, got %d: %v", len(withMask), withMask)
+	}
+	if withMask[0].BondName != "eth0.200" || withMask[0].CIDR != "10.200.0.10/24" {
+		t.Errorf("Expected the interface's own address first, got %+v", withMask[0])
+	}
+	for _, ip := range withMask {
+		if ip.MTU != DefaultMTU {
+			t.Errorf("Expected default MTU for %s, got %d", ip.BondName, ip.MTU)
+		}
+	}
+
+	if result := config.GetInterfaceIPAddresses("nonexistent"); len(result) != 0 {
+		t.Error("Expected empty result for non-existent interface")
+	}
+}
+
+func TestGetLinkIPAddresses(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", &Ethernet{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.0.0.10/24"},
+		},
+	})
+	config.AddEthernet("eth1", &Ethernet{})
+	config.AddEthernet("eth2", &Ethernet{})
+	config.AddBond("bond0", &Bond{
+		Interfaces: []string{"eth1", "eth2"},
+	})
+	config.AddVLAN("bond0.100", &VLAN{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.100.0.10/24"},
+		},
+		ID:   100,
+		Link: "bond0",
+	})
+	config.AddVLAN("eth0.5", &VLAN{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.5.0.10/24", "fd00:5::10/64"},
+		},
+		ID:   5,
+		Link: "eth0",
+	})
+
+	links := config.GetLinkIPAddressesWithMask()
+	if len(links) != 3 {
+		t.Fatalf("Expected 3 links, got %d: %v", len(links), links)
+	}
+
+	bond := links["bond0"]
+	if len(bond) != 1 || bond[0].BondName != "bond0.100" || bond[0].CIDR != "10.100.0.10/24" {
+		t.Errorf("Expected the VLAN address under bond0, got %+v", bond)
+	}
+	if got := config.GetBondIPAddressesWithMask("bond0"); len(got) != len(bond) || got[0].CIDR != bond[0].CIDR {
+		t.Errorf("Expected bond link to match GetBondIPAddressesWithMask, got %+v", got)
+	}
+	if len(links["eth0"]) != 1 {
+		t.Errorf("Expected plain ethernet eth0 to be a link, got %+v", links["eth0"])
+	}
+	if len(links["eth0.5"]) != 2 {
+		t.Errorf("Expected VLAN on a NIC to be a link, got %+v", links["eth0.5"])
+	}
+
+	plain := config.GetLinkIPAddresses()
+	if plain["eth0"][0] != "10.0.0.10" {
+		t.Errorf("Expected address without mask, got %v", plain["eth0"])
+	}
+}
+
+func TestGetGateways(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", &Ethernet{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.0.0.10/24", "fd00::10/64"},
+			Gateway4:  "10.0.0.1",
+			Gateway6:  "fd00::1",
+			Routes: []Route{
+				{To: "10.1.0.0/16", Via: "10.0.0.254"},
+				{To: "10.2.0.0/16", Via: "10.0.0.254"},
+				{To: "default", Via: "10.0.0.1"},
+			},
+		},
+	})
+	config.AddEthernet("eth1", &Ethernet{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.9.0.10/24"},
+			Routes:    []Route{{To: "10.9.1.0/24", Scope: "link"}},
+		},
+	})
+
+	gateways := config.GetGateways()
+	want := []string{"10.0.0.1", "fd00::1", "10.0.0.254"}
+	if strings.Join(gateways["eth0"], ",") != strings.Join(want, ",") {
+		t.Errorf("Expected eth0 gateways %v, got %v", want, gateways["eth0"])
+	}
+	if _, ok := gateways["eth1"]; ok {
+		t.Errorf("Expected no gateways for eth1 with only on-link routes, got %v", gateways["eth1"])
+	}
+}
+
+func TestOffloadFields(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      receive-checksum-offload: true
+      transmit-checksum-offload: false
+      tcp-segmentation-offload: true
+      generic-segmentation-offload: false
+      generic-receive-offload: true
+      large-receive-offload: false`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	eth := config.Network.Ethernets["eth0"]
+	if eth.ReceiveChecksumOffload == nil || !*eth.ReceiveChecksumOffload {
+		t.Error("Expected receive-checksum-offload to be enabled")
+	}
+	if eth.LargeReceiveOffload == nil || *eth.LargeReceiveOffload {
+		t.Error("Expected large-receive-offload to be disabled")
+	}
+
+	// TSO without transmit checksum offload is rejected
+	if errors := config.Validate(); len(errors) != 1 {
+		t.Errorf("Expected 1 validation error, got %v", errors)
+	}
+
+	eth.TransmitChecksumOffload = Bool(true)
+	if errors := config.Validate(); len(errors) != 0 {
+		t.Errorf("Expected valid config, got errors: %v", errors)
+	}
+
+	// Explicitly disabled offloads must survive a round trip
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	loaded, err := LoadConfigFromBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if gso := loaded.Network.Ethernets["eth0"].GenericSegmentationOffload; gso == nil || *gso {
+		t.Error("Expected generic-segmentation-offload: false to survive a round trip")
+	}
+}
+
+func TestActivationModeValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		renderer    string
+		mode        string
+		expectError bool
+	}{
+		{"manual-networkd", string(RendererNetworkd), "manual", false},
+		{"off-default-renderer", "", "off", false},
+		{"invalid-mode", "", "always", true},
+		{"network-manager", string(RendererNetworkManager), "manual", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.Network.Renderer = tt.renderer
+			config.AddEthernet("eth0", &Ethernet{CommonInterface: CommonInterface{ActivationMode: tt.mode}})
+
+			errors := config.Validate()
+			if (len(errors) > 0) != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
+
+func TestDidYouMeanSuggestions(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		expected string
+	}{
+		{
+			name:     "renderer-case",
+			yaml:     "network:\n  version: 2\n  renderer: networkD",
+			expected: `invalid renderer "networkD" (did you mean "networkd"?)`,
+		},
+		{
+			name:     "renderer-unrelated",
+			yaml:     "network:\n  version: 2\n  renderer: systemd",
+			expected: `invalid renderer "systemd" (must be one of: networkd, NetworkManager)`,
+		},
+		{
+			name:     "bond-mode",
+			yaml:     "network:\n  version: 2\n  bonds:\n    bond0:\n      parameters:\n        mode: active-bakup",
+			expected: `bond bond0: invalid bond mode "active-bakup" (did you mean "active-backup"?)`,
+		},
+		{
+			name:     "tunnel-mode",
+			yaml:     "network:\n  version: 2\n  tunnels:\n    tun0:\n      mode: ip6gr\n      local: fd00::1\n      remote: fd00::2",
+			expected: `tunnel tun0: invalid tunnel mode "ip6gr" (did you mean "ip6gre"?)`,
+		},
+		{
+			name:     "key-management",
+			yaml:     "network:\n  version: 2\n  wifis:\n    wlan0:\n      access-points:\n        corp:\n          auth:\n            key-management: pks",
+			expected: `invalid key-management "pks" (did you mean "psk"?)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfigFromBytes([]byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			var messages []string
+			for _, err := range config.Validate() {
+				messages = append(messages, err.Error())
+			}
+			found := false
+			for _, msg := range messages {
+				if strings.Contains(msg, tt.expected) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, messages)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"psk", "", 3},
+		{"networkd", "networkd", 0},
+		{"pks", "psk", 1},
+		{"ab", "ba", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.expected {
+			t.Errorf("levenshtein(%q, %q) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestOverrideFields(t *testing.T) {
+	input := `network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+      dhcp6: true
+      dhcp4-overrides:
+        send-hostname: false
+        use-routes: false
+        route-metric: 200
+      dhcp6-overrides:
+        use-routes: false
+        route-metric: 300
+        use-domains: route
+      ra-overrides:
+        use-dns: false
+        use-domains: "true"
+        use-gateway: false
+        table: 1000`
+
+	config, err := LoadConfigFromBytes([]byte(input))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		t.Fatalf("Unexpected validation errors: %v", errs)
+	}
+
+	eth := config.Network.Ethernets["eth0"]
+	if eth.DHCP4Overrides.SendHostname == nil || *eth.DHCP4Overrides.SendHostname {
+		t.Errorf("Expected dhcp4 send-hostname false")
+	}
+	if eth.DHCP6Overrides.RouteMetric != 300 || eth.DHCP6Overrides.UseRoutes == nil {
+		t.Errorf("Unexpected dhcp6-overrides: %+v", eth.DHCP6Overrides)
+	}
+	if eth.RAOverrides == nil || eth.RAOverrides.Table != 1000 || eth.RAOverrides.UseDomains != "true" {
+		t.Fatalf("Unexpected ra-overrides: %+v", eth.RAOverrides)
+	}
+
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("ToYAML failed: %v", err)
+	}
+	reloaded, err := LoadConfigFromBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if !config.Equal(reloaded) {
+		t.Errorf("Overrides were lost on round trip:\n%s", data)
+	}
+
+	invalid := NewConfig()
+	invalid.AddEthernet("eth0", &Ethernet{CommonInterface: CommonInterface{
+		AcceptRA:       Bool(false),
+		DHCP4Overrides: &DHCP4Overrides{UseDomains: "yes", RouteMetric: -1},
+		RAOverrides:    &RAOverrides{Table: -5},
+	}})
+	if errs := invalid.Validate(); len(errs) != 3 {
+		t.Errorf("Expected 3 validation errors, got %v", errs)
+	}
+	if warnings := invalid.Warnings(); len(warnings) != 1 {
+		t.Errorf("Expected ra-overrides warning, got %v", warnings)
+	}
+}
+
+func TestRoutingPolicyValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        RoutingPolicy
+		expectError bool
+	}{
+		{"from-cidr", RoutingPolicy{From: "10.0.0.0/24", Table: 100}, false},
+		{"to-v6-default-table", RoutingPolicy{To: "2001:db8::/32", Priority: 1000}, false},
+		{"no-selector", RoutingPolicy{Table: 100}, true},
+		{"bare-ip", RoutingPolicy{From: "10.0.0.1", Table: 100}, true},
+		{"mixed-families", RoutingPolicy{From: "10.0.0.0/24", To: "2001:db8::/32", Table: 100}, true},
+		{"negative-priority", RoutingPolicy{From: "10.0.0.0/24", Priority: -1}, true},
+		{"negative-table", RoutingPolicy{From: "10.0.0.0/24", Table: -1}, true},
+		{"tos-out-of-range", RoutingPolicy{From: "10.0.0.0/24", TypeOfService: 256}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddEthernet("eth0", &Ethernet{CommonInterface: CommonInterface{
+				Routes:        []Route{{To: "default", Via: "10.0.0.1", Table: 100}},
+				RoutingPolicy: []RoutingPolicy{tt.rule},
+			}})
+
+			errors := config.Validate()
+			if (len(errors) > 0) != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
+
+func TestRoutingPolicyTableWarnings(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      routes:
+        - to: default
+          via: 10.0.0.1
+          table: 100
+      routing-policy:
+        - from: 10.0.0.0/24
+          table: 100
+        - from: 10.0.1.0/24
+          table: 200
+        - from: 10.0.2.0/24
+          table: 300
+        - from: 10.0.3.0/24
+  vrfs:
+    vrf-blue:
+      table: 300`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	warnings := config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "table 200") {
+		t.Errorf("Expected a single warning about table 200, got %v", warnings)
+	}
+}
+
+func TestMTUConsistency(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name: "consistent",
+			yaml: `network:
+  version: 2
+  ethernets:
+    eth0: {mtu: 9000}
+    eth1: {mtu: 9000}
+  bonds:
+    bond0:
+      interfaces: [eth0, eth1]
+      mtu: 9000
+  vlans:
+    bond0.100: {id: 100, link: bond0, mtu: 9000}
+  bridges:
+    br0:
+      interfaces: [bond0.100]
+      mtu: 9000`,
+		},
+		{
+			name: "vlan-exceeds-default-link",
+			yaml: `network:
+  version: 2
+  ethernets:
+    eth0: {}
+  vlans:
+    vlan10: {id: 10, link: eth0, mtu: 9000}`,
+			errors: []string{"vlan vlan10: mtu 9000 exceeds mtu 1500 of link eth0"},
+		},
+		{
+			name: "vlan-on-bridge-without-mtu",
+			yaml: `network:
+  version: 2
+  ethernets:
+    eth0: {mtu: 9000}
+    eth1: {mtu: 4000}
+  bridges:
+    br0:
+      interfaces: [eth0, eth1]
+  vlans:
+    vlan10: {id: 10, link: br0, mtu: 9000}`,
+			errors: []string{"vlan vlan10: mtu 9000 exceeds mtu 4000 of link br0"},
+		},
+		{
+			name: "bridge-exceeds-port",
+			yaml: `network:
+  version: 2
+  ethernets:
+    eth0: {mtu: 9000}
+    eth1: {mtu: 1500}
+  bridges:
+    br0:
+      interfaces: [eth0, eth1]
+      mtu: 9000`,
+			errors: []string{"bridge br0: mtu 9000 exceeds mtu 1500 of port eth1"},
+		},
+		{
+			name: "bond-member-mismatch",
+			yaml: `network:
+  version: 2
+  ethernets:
+    eth0: {mtu: 1500}
+    eth1: {}
+  bonds:
+    bond0:
+      interfaces: [eth0, eth1]
+      mtu: 9000`,
+			warnings: []string{"bond bond0: member eth0 has mtu 1500 but the bond mtu is 9000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfigFromBytes([]byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			var errors []string
+			for _, err := range config.Validate() {
+				errors = append(errors, err.Error())
+			}
+			var warnings []string
+			for _, warning := range config.Warnings() {
+				warnings = append(warnings, warning.Error())
+			}
+
+			if strings.Join(errors, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("Expected errors %v, got %v", tt.errors, errors)
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("Expected warnings %v, got %v", tt.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestIPv6AddressGeneration(t *testing.T) {
+	tests := []struct {
+		name        string
+		generation  string
+		token       string
+		expectError bool
+	}{
+		{"eui64", IPv6AddressGenerationEUI64, "", false},
+		{"stable-privacy", IPv6AddressGenerationStablePrivacy, "", false},
+		{"token", "", "::42", false},
+		{"invalid-mode", "random", "", true},
+		{"both", IPv6AddressGenerationEUI64, "::42", true},
+		{"ipv4-token", "", "10.0.0.1", true},
+		{"prefixed-token", "", "::42/64", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddEthernet("eth0", &Ethernet{CommonInterface: CommonInterface{
+				DHCP6:                 Bool(true),
+				IPv6AddressGeneration: tt.generation,
+				IPv6AddressToken:      tt.token,
+			}})
+
+			errors := config.Validate()
+			if (len(errors) > 0) != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+
+	config, err := LoadConfigFromBytes([]byte("network:\n  version: 2\n  ethernets:\n    eth0:\n      ipv6-address-token: \"::2\"\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Network.Ethernets["eth0"].IPv6AddressToken != "::2" {
+		t.Errorf("Expected ipv6-address-token to be loaded")
+	}
+	data, err := config.ToYAML()
+	if err != nil || !strings.Contains(string(data), "ipv6-address-token") {
+		t.Errorf("Expected ipv6-address-token in output, got %s (%v)", data, err)
+	}
+}
+
+func TestConcurrentMutation(t *testing.T) {
+	config := NewConfig()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("eth%d", i)
+			config.AddEthernet(name, NewEthernetDHCP())
+			config.AddVLAN(fmt.Sprintf("vlan%d", i+1), NewVLAN(i+1, name))
+			config.Update(func(network *Network) {
+				network.Ethernets[name].MTU = 9000
+			})
+			if _, err := config.ToYAML(); err != nil {
+				t.Errorf("ToYAML failed: %v", err)
+			}
+			config.Validate()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(config.Network.Ethernets) != 20 || len(config.Network.VLANs) != 20 {
+		t.Errorf("Expected 20 ethernets and VLANs, got %d and %d", len(config.Network.Ethernets), len(config.Network.VLANs))
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", NewEthernetDHCP())
+	config.AddEthernet("eth1", NewEthernetDHCP())
+	config.AddBond("bond0", NewBond([]string{"eth0", "eth1"}, BondModeActiveBackup))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			config.AddVLAN(fmt.Sprintf("bond0.%d", i+1), NewVLAN(i+1, "bond0"))
+			config.Update(func(network *Network) {
+				network.Bonds["bond0"].Addresses = append(network.Bonds["bond0"].Addresses, fmt.Sprintf("10.0.%d.1/24", i))
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			config.GetInterfaceNames()
+			config.GetInterface("bond0")
+			config.GetInterfaceIPAddresses("bond0")
+			config.GetInterfaceIPAddressesWithMask("bond0")
+			config.GetBondIPAddressesWithMask("bond0")
+			config.GetLinkIPAddresses()
+			config.GetGateways()
+			config.HasDHCP()
+			config.Topology()
+			config.ToDOT()
+			config.FindByMAC("00:11:22:33:44:55")
+			config.Equal(config)
+		}()
+	}
+	wg.Wait()
+
+	if got := len(config.GetBondIPAddressesWithMask("bond0")); got != 20 {
+		t.Errorf("Expected 20 addresses on bond0, got %d", got)
+	}
+}
@@ -0,0 +1,199 @@
+package netplan
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validOVSProtocols lists the OpenFlow versions OpenVSwitch accepts
+var validOVSProtocols = []string{"OpenFlow10", "OpenFlow11", "OpenFlow12", "OpenFlow13", "OpenFlow14", "OpenFlow15"}
+
+// MarshalYAML encodes the patch port pair as a two element list
+func (p OVSPatchPort) MarshalYAML() (interface{}, error) {
+	return []string{p.Local, p.Peer}, nil
+}
+
+// UnmarshalYAML decodes a two element list into a patch port pair
+func (p *OVSPatchPort) UnmarshalYAML(value *yaml.Node) error {
+	var names []string
+	if err := value.Decode(&names); err != nil {
+		return fmt.Errorf("openvswitch port must be a list of two port names: %w", err)
+	}
+	if len(names) != 2 {
+		return fmt.Errorf("openvswitch port must be a list of two port names, got %d", len(names))
+	}
+	p.Local, p.Peer = names[0], names[1]
+	return nil
+}
+
+// NewOVSBridge creates a bridge managed by OpenVSwitch instead of the kernel bridge driver
+func NewOVSBridge(interfaces []string, failMode OVSFailMode) *Bridge {
+	return &Bridge{
+		CommonInterface: CommonInterface{
+			OpenVSwitch: &OpenVSwitch{
+				FailMode: string(failMode),
+			},
+		},
+		Interfaces: interfaces,
+	}
+}
+
+// NewOVSBond creates an OpenVSwitch bond (trunk) with the given LACP mode
+func NewOVSBond(interfaces []string, lacp OVSLacp) *Bond {
+	return &Bond{
+		CommonInterface: CommonInterface{
+			OpenVSwitch: &OpenVSwitch{
+				Lacp: string(lacp),
+			},
+		},
+		Interfaces: interfaces,
+	}
+}
+
+// AddPatchPort connects two OpenVSwitch patch ports
+func (o *OpenVSwitch) AddPatchPort(local, peer string) {
+	o.Ports = append(o.Ports, OVSPatchPort{Local: local, Peer: peer})
+}
+
+// validateOpenVSwitch validates OpenVSwitch settings
+func validateOpenVSwitch(ovs *OpenVSwitch) []error {
+	var errors []error
+
+	if ovs == nil {
+		return errors
+	}
+
+	switch OVSFailMode(ovs.FailMode) {
+	case "", OVSFailModeSecure, OVSFailModeStandalone:
+	default:
+		errors = append(errors, fmt.Errorf("openvswitch: invalid fail-mode %s (must be %s or %s)",
+			ovs.FailMode, OVSFailModeSecure, OVSFailModeStandalone))
+	}
+
+	switch OVSLacp(ovs.Lacp) {
+	case "", OVSLacpActive, OVSLacpPassive, OVSLacpOff:
+	default:
+		errors = append(errors, fmt.Errorf("openvswitch: invalid lacp %s (must be %s, %s or %s)",
+			ovs.Lacp, OVSLacpActive, OVSLacpPassive, OVSLacpOff))
+	}
+
+	for _, protocol := range ovs.Protocols {
+		if !containsString(validOVSProtocols, protocol) {
+			errors = append(errors, fmt.Errorf("openvswitch: invalid protocol %s (must be one of: %s)",
+				protocol, strings.Join(validOVSProtocols, ", ")))
+		}
+	}
+
+	if ovs.Controller != nil {
+		switch ovs.Controller.ConnectionMode {
+		case "", "in-band", "out-of-band":
+		default:
+			errors = append(errors, fmt.Errorf("openvswitch: invalid controller connection-mode %s (must be in-band or out-of-band)",
+				ovs.Controller.ConnectionMode))
+		}
+		for _, addr := range ovs.Controller.Addresses {
+			if err := validateOVSTarget(addr); err != nil {
+				errors = append(errors, fmt.Errorf("openvswitch: controller address %s: %w", addr, err))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, port := range ovs.Ports {
+		if port.Local == "" || port.Peer == "" {
+			errors = append(errors, fmt.Errorf("openvswitch: patch port names cannot be empty"))
+			continue
+		}
+		if port.Local == port.Peer {
+			errors = append(errors, fmt.Errorf("openvswitch: patch port %s cannot be connected to itself", port.Local))
+		}
+		for _, name := range []string{port.Local, port.Peer} {
+			if err := validateInterfaceName(name); err != nil {
+				errors = append(errors, fmt.Errorf("openvswitch: patch port: %w", err))
+			}
+			if seen[name] {
+				errors = append(errors, fmt.Errorf("openvswitch: patch port %s is used more than once", name))
+			}
+			seen[name] = true
+		}
+	}
+
+	return errors
+}
+
+// validateGlobalOpenVSwitch validates the network level openvswitch
+// section, which only accepts a subset of the per-interface settings
+func validateGlobalOpenVSwitch(ovs *OpenVSwitch) []error {
+	if ovs == nil {
+		return nil
+	}
+
+	errors := validateOpenVSwitch(ovs)
+
+	interfaceOnly := []struct {
+		key string
+		set bool
+	}{
+		{"lacp", ovs.Lacp != ""},
+		{"fail-mode", ovs.FailMode != ""},
+		{"mcast-snooping-enable", ovs.McastSnoopingEnable != nil},
+		{"rstp-enable", ovs.RSTPEnable != nil},
+		{"controller", ovs.Controller != nil},
+	}
+	for _, setting := range interfaceOnly {
+		if setting.set {
+			errors = append(errors, fmt.Errorf("openvswitch: %s is only supported on bridges and bonds, not in the global section", setting.key))
+		}
+	}
+
+	if ssl := ovs.SSL; ssl != nil && (ssl.CAFile == "" || ssl.CertFile == "" || ssl.KeyFile == "") {
+		errors = append(errors, fmt.Errorf("openvswitch: ssl requires ca-file, cert-file and key-file"))
+	}
+
+	return errors
+}
+
+// validateOVSTarget validates an OpenVSwitch connection target such as
+// tcp:10.0.0.1:6653, ssl:[fd00::1]:6653 or unix:/var/run/openvswitch/db.sock
+func validateOVSTarget(target string) error {
+	method, rest, found := strings.Cut(target, ":")
+	if !found {
+		return fmt.Errorf("missing connection method")
+	}
+
+	switch method {
+	case "unix", "punix":
+		if rest == "" {
+			return fmt.Errorf("missing socket path")
+		}
+		return nil
+	case "tcp", "ssl":
+		host, port, err := net.SplitHostPort(rest)
+		if err != nil {
+			return fmt.Errorf("expected %s:IP:PORT", method)
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid IP address %s", host)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid port %s", port)
+		}
+		return nil
+	case "ptcp", "pssl":
+		// Passive targets take an optional port and bind address
+		if rest == "" {
+			return nil
+		}
+		port, _, _ := strings.Cut(rest, ":")
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid port %s", port)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown connection method %s", method)
+	}
+}
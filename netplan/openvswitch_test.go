@@ -0,0 +1,201 @@
+package netplan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOVSPatchPortRoundTrip(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  bridges:
+    ovs0:
+      interfaces: [eth0]
+      openvswitch:
+        fail-mode: secure
+        ports:
+          - [patch0-1, patch1-0]`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	ports := config.Network.Bridges["ovs0"].OpenVSwitch.Ports
+	if len(ports) != 1 || ports[0].Local != "patch0-1" || ports[0].Peer != "patch1-0" {
+		t.Fatalf("Unexpected patch ports: %v", ports)
+	}
+
+	config.Network.Bridges["ovs0"].OpenVSwitch.AddPatchPort("patch0-2", "patch2-0")
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if !strings.Contains(string(data), "[patch0-1, patch1-0]") || !strings.Contains(string(data), "patch2-0") {
+		t.Errorf("Patch ports not preserved in output:\n%s", data)
+	}
+
+	if _, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  bridges:
+    ovs0:
+      openvswitch:
+        ports:
+          - [patch0-1]`)); err == nil {
+		t.Error("Expected error for patch port with a single name")
+	}
+}
+
+func TestGlobalOVSRoundTrip(t *testing.T) {
+	input := `network:
+  version: 2
+  openvswitch:
+    external-ids:
+      system-id: host1
+    other-config:
+      disable-in-band: "true"
+    protocols: [OpenFlow13, OpenFlow14]
+    ports:
+      - [patch0-1, patch1-0]
+`
+	config, err := LoadConfigFromBytes([]byte(input))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	ovs := config.Network.OpenVSwitch
+	if ovs == nil || ovs.ExternalIDs["system-id"] != "host1" || len(ovs.Ports) != 1 {
+		t.Fatalf("Unexpected global openvswitch section: %+v", ovs)
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		t.Errorf("Unexpected validation errors: %v", errs)
+	}
+
+	config.node = nil
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	reloaded, err := LoadConfigFromBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if !config.Equal(reloaded) {
+		t.Errorf("Global openvswitch section not preserved:\n%s", data)
+	}
+}
+
+func TestOVSValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		build       func(c *Config)
+		expectError bool
+	}{
+		{
+			name: "valid-bridge-and-bond",
+			build: func(c *Config) {
+				bridge := NewOVSBridge([]string{"bond0"}, OVSFailModeStandalone)
+				bridge.OpenVSwitch.Protocols = []string{"OpenFlow13"}
+				bridge.OpenVSwitch.Controller = &Controller{
+					Addresses:      []string{"tcp:10.0.0.1:6653", "unix:/var/run/openvswitch/ovs0.sock"},
+					ConnectionMode: "out-of-band",
+				}
+				c.AddBridge("ovs0", bridge)
+				c.AddBond("bond0", NewOVSBond([]string{"eth0", "eth1"}, OVSLacpActive))
+			},
+			expectError: false,
+		},
+		{
+			name: "invalid-fail-mode",
+			build: func(c *Config) {
+				c.AddBridge("ovs0", NewOVSBridge(nil, "open"))
+			},
+			expectError: true,
+		},
+		{
+			name: "lacp-on-bridge",
+			build: func(c *Config) {
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.Lacp = string(OVSLacpActive)
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid-protocol",
+			build: func(c *Config) {
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.Protocols = []string{"OpenFlow20"}
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid-controller",
+			build: func(c *Config) {
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.Controller = &Controller{Addresses: []string{"tcp:controller"}}
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: true,
+		},
+		{
+			name: "global-section",
+			build: func(c *Config) {
+				c.Network.OpenVSwitch = &OpenVSwitch{
+					ExternalIDs: map[string]string{"system-id": "host1"},
+					Protocols:   []string{"OpenFlow13"},
+					SSL:         &SSL{CAFile: "/etc/ovs/ca.pem", CertFile: "/etc/ovs/cert.pem", KeyFile: "/etc/ovs/key.pem"},
+				}
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.Controller = &Controller{Addresses: []string{"ssl:10.0.0.1:6653"}}
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: false,
+		},
+		{
+			name: "global-interface-only-setting",
+			build: func(c *Config) {
+				c.Network.OpenVSwitch = &OpenVSwitch{FailMode: string(OVSFailModeSecure)}
+			},
+			expectError: true,
+		},
+		{
+			name: "global-incomplete-ssl",
+			build: func(c *Config) {
+				c.Network.OpenVSwitch = &OpenVSwitch{SSL: &SSL{CAFile: "/etc/ovs/ca.pem"}}
+			},
+			expectError: true,
+		},
+		{
+			name: "ssl-controller-without-global-ssl",
+			build: func(c *Config) {
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.Controller = &Controller{Addresses: []string{"ssl:10.0.0.1:6653"}}
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: true,
+		},
+		{
+			name: "self-patched-port",
+			build: func(c *Config) {
+				bridge := NewOVSBridge(nil, OVSFailModeSecure)
+				bridge.OpenVSwitch.AddPatchPort("patch0", "patch0")
+				c.AddBridge("ovs0", bridge)
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			tt.build(config)
+
+			errors := config.Validate()
+			hasError := len(errors) > 0
+
+			if hasError != tt.expectError {
+				t.Errorf("Expected error: %v, got errors: %v", tt.expectError, errors)
+			}
+		})
+	}
+}
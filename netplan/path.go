@@ -0,0 +1,212 @@
+package netplan
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GetPath returns the value at a dotted path below the network key, using
+// the same syntax as `netplan get`, e.g. "ethernets.eth0.mtu". Maps and
+// lists are returned as map[string]interface{} and []interface{}. An empty
+// path returns the whole network block. Keys containing dots, such as VLAN
+// names, are resolved against the existing keys or can be escaped ("\.").
+func (c *Config) GetPath(path string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	root, err := c.pathRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	node := root
+	segments := splitPath(path)
+	for len(segments) > 0 {
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("path %s: %s is not a mapping", path, strings.Join(segments, "."))
+		}
+		key, rest := matchPathKey(node, segments)
+		value := mappingValue(node, key)
+		if value == nil {
+			return nil, fmt.Errorf("path %s: key %s not found", path, key)
+		}
+		node, segments = value, rest
+	}
+
+	var result interface{}
+	if err := node.Decode(&result); err != nil {
+		return nil, fmt.Errorf("path %s: failed to decode value: %w", path, err)
+	}
+	return result, nil
+}
+
+// SetPath sets the value at a dotted path below the network key, using the
+// same semantics as `netplan set`: the value is parsed as YAML, so "true",
+// "1500" and "[10.0.0.1/24]" set a boolean, a number and a list, and "null"
+// removes the key (along with any parent mappings left empty). Missing
+// intermediate mappings are created. The result must still decode into the
+// netplan types, so type mismatches are rejected. Unknown keys are only
+// accepted where the types keep them in an Extra map.
+func (c *Config) SetPath(path, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return fmt.Errorf("path cannot be empty")
+	}
+
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return fmt.Errorf("failed to parse value %q: %w", value, err)
+	}
+	var valueNode *yaml.Node
+	if len(parsed.Content) > 0 && parsed.Content[0].ShortTag() != "!!null" {
+		valueNode = parsed.Content[0]
+	}
+
+	root, err := c.pathRoot()
+	if err != nil {
+		return err
+	}
+
+	if err := setPathNode(root, segments, valueNode); err != nil {
+		return fmt.Errorf("path %s: %w", path, err)
+	}
+
+	// Re-decode strictly so the change is checked against the netplan types
+	doc := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "network"},
+			root,
+		},
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("path %s: failed to encode config: %w", path, err)
+	}
+
+	var updated Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&updated); err != nil {
+		return fmt.Errorf("path %s: invalid value %q: %w", path, value, err)
+	}
+
+	c.Network = updated.Network
+	return nil
+}
+
+// pathRoot encodes the network block of the configuration into a YAML node
+func (c *Config) pathRoot() (*yaml.Node, error) {
+	var root yaml.Node
+	if err := root.Encode(&c.Network); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return &root, nil
+}
+
+// setPathNode sets or removes the value at segments below node
+func setPathNode(node *yaml.Node, segments []string, value *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", strings.Join(segments, "."))
+	}
+
+	key, rest := matchPathKey(node, segments)
+	existing := mappingValue(node, key)
+
+	if len(rest) == 0 {
+		if value == nil {
+			removeMappingKey(node, key)
+		} else if existing != nil {
+			*existing = *value
+		} else {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+		}
+		return nil
+	}
+
+	if existing == nil {
+		if value == nil {
+			// Removing something that does not exist is a no-op
+			return nil
+		}
+		existing = &yaml.Node{Kind: yaml.MappingNode}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, existing)
+	}
+
+	if err := setPathNode(existing, rest, value); err != nil {
+		return err
+	}
+
+	// Drop mappings that became empty through a removal
+	if value == nil && existing.Kind == yaml.MappingNode && len(existing.Content) == 0 {
+		removeMappingKey(node, key)
+	}
+	return nil
+}
+
+// splitPath splits a dotted path into segments, honouring "\." escapes
+// and ignoring a leading "network" segment
+func splitPath(path string) []string {
+	var segments []string
+	var current strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			current.WriteByte('.')
+			i++
+		case path[i] == '.':
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+	if path != "" {
+		segments = append(segments, current.String())
+	}
+
+	if len(segments) > 0 && segments[0] == "network" {
+		segments = segments[1:]
+	}
+	return segments
+}
+
+// matchPathKey picks the key in a mapping that the leading path segments
+// refer to. The longest run of segments that names an existing key wins,
+// so "vlans.bond0.100.id" resolves to the "bond0.100" VLAN when it exists.
+func matchPathKey(node *yaml.Node, segments []string) (string, []string) {
+	for n := len(segments); n > 1; n-- {
+		key := strings.Join(segments[:n], ".")
+		if mappingValue(node, key) != nil {
+			return key, segments[n:]
+		}
+	}
+	return segments[0], segments[1:]
+}
+
+// mappingValue returns the value node for key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeMappingKey deletes key and its value from a mapping node
+func removeMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
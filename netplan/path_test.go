@@ -0,0 +1,160 @@
+package netplan
+
+import (
+	"reflect"
+	"testing"
+)
+
+const pathTestConfig = `network:
+  version: 2
+  ethernets:
+    eth0:
+      mtu: 9000
+      addresses: [10.0.0.2/24]
+      nameservers:
+        addresses: [1.1.1.1]
+  vlans:
+    eth0.100:
+      id: 100
+      link: eth0`
+
+func TestGetPath(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(pathTestConfig))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "scalar", path: "ethernets.eth0.mtu", expected: 9000},
+		{name: "network-prefix", path: "network.ethernets.eth0.mtu", expected: 9000},
+		{name: "list", path: "ethernets.eth0.addresses", expected: []interface{}{"10.0.0.2/24"}},
+		{name: "nested", path: "ethernets.eth0.nameservers.addresses", expected: []interface{}{"1.1.1.1"}},
+		{name: "dotted-key", path: "vlans.eth0.100.id", expected: 100},
+		{name: "escaped-key", path: `vlans.eth0\.100.link`, expected: "eth0"},
+		{name: "missing", path: "ethernets.eth1", wantErr: true},
+		{name: "through-scalar", path: "ethernets.eth0.mtu.value", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.GetPath(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetPath(%q) expected error, got %v", tt.path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetPath(%q) failed: %v", tt.path, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("GetPath(%q) = %#v, expected %#v", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	t.Run("set-scalar", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+		if err := config.SetPath("ethernets.eth0.mtu", "1500"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		if config.Network.Ethernets["eth0"].MTU != 1500 {
+			t.Errorf("Expected MTU 1500, got %d", config.Network.Ethernets["eth0"].MTU)
+		}
+	})
+
+	t.Run("create-interface", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+		if err := config.SetPath("network.ethernets.eth1.dhcp4", "true"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		eth1, ok := config.Network.Ethernets["eth1"]
+		if !ok || eth1.DHCP4 == nil || !*eth1.DHCP4 {
+			t.Errorf("Expected eth1 with dhcp4 enabled, got %+v", eth1)
+		}
+	})
+
+	t.Run("set-list", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+		if err := config.SetPath("ethernets.eth0.addresses", "[10.0.0.3/24, 10.0.0.4/24]"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		expected := []string{"10.0.0.3/24", "10.0.0.4/24"}
+		if !reflect.DeepEqual(config.Network.Ethernets["eth0"].Addresses, expected) {
+			t.Errorf("Expected %v, got %v", expected, config.Network.Ethernets["eth0"].Addresses)
+		}
+	})
+
+	t.Run("dotted-key", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+		if err := config.SetPath("vlans.eth0.100.id", "200"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		if config.Network.VLANs["eth0.100"].ID != 200 {
+			t.Errorf("Expected VLAN id 200, got %d", config.Network.VLANs["eth0.100"].ID)
+		}
+	})
+
+	t.Run("null-removes", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+		if err := config.SetPath("ethernets.eth0.nameservers.addresses", "null"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		if config.Network.Ethernets["eth0"].Nameservers != nil {
+			t.Errorf("Expected empty nameservers to be removed, got %+v", config.Network.Ethernets["eth0"].Nameservers)
+		}
+
+		if err := config.SetPath("vlans.eth0.100", "null"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		if len(config.Network.VLANs) != 0 {
+			t.Errorf("Expected VLAN to be removed, got %v", config.Network.VLANs)
+		}
+	})
+
+	t.Run("preserves-comments", func(t *testing.T) {
+		config, _ := LoadConfigFromBytes([]byte("network:\n  version: 2\n  ethernets:\n    eth0:\n      # jumbo frames\n      mtu: 9000\n"))
+		if err := config.SetPath("ethernets.eth0.mtu", "1500"); err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		data, err := config.ToYAML()
+		if err != nil {
+			t.Fatalf("ToYAML failed: %v", err)
+		}
+		expected := "network:\n  version: 2\n  ethernets:\n    eth0:\n      # jumbo frames\n      mtu: 1500\n"
+		if string(data) != expected {
+			t.Errorf("Unexpected YAML:\n%s\nexpected:\n%s", data, expected)
+		}
+	})
+
+	errorTests := []struct {
+		name  string
+		path  string
+		value string
+	}{
+		{name: "empty-path", path: "", value: "1"},
+		{name: "unknown-nested-field", path: "ethernets.eth0.nameservers.bogus", value: "1"},
+		{name: "type-mismatch", path: "ethernets.eth0.mtu", value: "large"},
+		{name: "through-scalar", path: "ethernets.eth0.mtu.value", value: "1"},
+		{name: "invalid-yaml", path: "ethernets.eth0.mtu", value: "[1"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _ := LoadConfigFromBytes([]byte(pathTestConfig))
+			if err := config.SetPath(tt.path, tt.value); err == nil {
+				t.Errorf("SetPath(%q, %q) expected error", tt.path, tt.value)
+			}
+			if config.Network.Ethernets["eth0"].MTU != 9000 {
+				t.Errorf("Config modified by failed SetPath")
+			}
+		})
+	}
+}
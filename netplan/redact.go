@@ -0,0 +1,50 @@
+package netplan
+
+// RedactedValue replaces secrets in configs returned by Redacted
+const RedactedValue = "***"
+
+// Redacted returns a copy of the configuration with WiFi passwords, 802.1x
+// credentials, modem PINs and passwords, and tunnel keys replaced by
+// RedactedValue, so it can be logged or sent to the aggregator. The copy is
+// meant for display only; redacted tunnel keys no longer pass validation.
+func (c *Config) Redacted() (*Config, error) {
+	redacted, err := c.clone()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wifi := range redacted.Network.Wifis {
+		for _, ap := range wifi.AccessPoints {
+			if ap == nil {
+				continue
+			}
+			redactString(&ap.Password)
+			if ap.Auth != nil {
+				redactString(&ap.Auth.Password)
+				redactString(&ap.Auth.ClientKeyPassword)
+			}
+		}
+	}
+
+	for _, modem := range redacted.Network.Modems {
+		redactString(&modem.Password)
+		redactString(&modem.PIN)
+	}
+
+	for _, tunnel := range redacted.Network.Tunnels {
+		redactString(&tunnel.Key)
+		if tunnel.Keys != nil {
+			redactString(&tunnel.Keys.Input)
+			redactString(&tunnel.Keys.Output)
+		}
+	}
+
+	return redacted, nil
+}
+
+// redactString replaces a non-empty secret with RedactedValue
+func redactString(s *string) {
+	if *s != "" {
+		*s = RedactedValue
+	}
+}
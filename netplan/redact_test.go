@@ -0,0 +1,82 @@
+package netplan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  wifis:
+    wlan0:
+      access-points:
+        home:
+          password: "hunter22"
+        corp:
+          auth:
+            key-management: eap
+            method: peap
+            identity: alice
+            password: "s3cret-eap"
+            client-key-password: "s3cret-key"
+        open: {}
+  modems:
+    wwan0:
+      apn: internet
+      password: "modem-pass"
+      pin: "1234"
+  tunnels:
+    gre1:
+      mode: gre
+      local: 10.0.0.1
+      remote: 10.0.0.2
+      keys:
+        input: 1111
+        output: 2222
+    gre2:
+      mode: gre
+      local: 10.0.0.1
+      remote: 10.0.0.3
+      key: 3333`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	redacted, err := config.Redacted()
+	if err != nil {
+		t.Fatalf("Redacted failed: %v", err)
+	}
+
+	data, err := redacted.ToYAML()
+	if err != nil {
+		t.Fatalf("ToYAML failed: %v", err)
+	}
+	for _, secret := range []string{"hunter22", "s3cret-eap", "s3cret-key", "modem-pass", "1234", "1111", "2222", "3333"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Redacted YAML still contains %q:\n%s", secret, data)
+		}
+	}
+
+	corp := redacted.Network.Wifis["wlan0"].AccessPoints["corp"]
+	if corp.Auth.Identity != "alice" || corp.Auth.Method != "peap" {
+		t.Errorf("Non-secret auth fields should be kept, got %+v", corp.Auth)
+	}
+	if corp.Auth.Password != RedactedValue {
+		t.Errorf("Expected EAP password to be %q, got %q", RedactedValue, corp.Auth.Password)
+	}
+	if redacted.Network.Wifis["wlan0"].AccessPoints["open"].Password != "" {
+		t.Errorf("Empty passwords should stay empty")
+	}
+	if redacted.Network.Modems["wwan0"].APN != "internet" {
+		t.Errorf("Modem APN should be kept")
+	}
+
+	// The original config must be left untouched
+	if config.Network.Wifis["wlan0"].AccessPoints["home"].Password != "hunter22" {
+		t.Errorf("Redacted modified the original config")
+	}
+	if config.Network.Tunnels["gre2"].Key != "3333" {
+		t.Errorf("Redacted modified the original tunnel key")
+	}
+}
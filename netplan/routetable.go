@@ -0,0 +1,112 @@
+package netplan
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RTTablesPath is the iproute2 file that maps routing table names to
+// numbers. Files in the matching rt_tables.d directory are read as well.
+var RTTablesPath = "/etc/iproute2/rt_tables"
+
+// RouteTable is a routing table number. In YAML it accepts either a number
+// or a table name, which is resolved through RTTablesPath when the config is
+// loaded. It is always written out as a number, the only form netplan
+// itself accepts.
+type RouteTable int
+
+// builtinRouteTables are the names iproute2 knows without an rt_tables file
+var builtinRouteTables = map[string]int{
+	"unspec":  0,
+	"default": RouteTableDefault,
+	"main":    RouteTableMain,
+	"local":   RouteTableLocal,
+}
+
+// UnmarshalYAML decodes a numeric table or resolves a table name
+func (t *RouteTable) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("routing table must be a number or a name")
+	}
+
+	if number, err := strconv.Atoi(value.Value); err == nil {
+		*t = RouteTable(number)
+		return nil
+	}
+
+	number, err := LookupRouteTable(value.Value)
+	if err != nil {
+		return err
+	}
+	*t = RouteTable(number)
+	return nil
+}
+
+// LookupRouteTable resolves a routing table name to its number using the
+// built-in iproute2 names and the rt_tables files
+func LookupRouteTable(name string) (int, error) {
+	if number, ok := builtinRouteTables[name]; ok {
+		return number, nil
+	}
+
+	tables, err := readRTTables()
+	if err != nil {
+		return 0, err
+	}
+	if number, ok := tables[name]; ok {
+		return number, nil
+	}
+
+	return 0, fmt.Errorf("unknown routing table %q (not defined in %s)", name, RTTablesPath)
+}
+
+// readRTTables parses RTTablesPath and the *.conf files in the rt_tables.d
+// directory next to it. Missing files are not an error.
+func readRTTables() (map[string]int, error) {
+	paths := []string{RTTablesPath}
+	extra, err := filepath.Glob(filepath.Join(RTTablesPath+".d", "*.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob routing table files: %w", err)
+	}
+	sort.Strings(extra)
+	paths = append(paths, extra...)
+
+	tables := make(map[string]int)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read routing tables: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			number, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			tables[fields[1]] = number
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read routing tables from %s: %w", path, err)
+		}
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,78 @@
+package netplan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRouteTableNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rt_tables")
+	if err := os.WriteFile(path, []byte("# reserved values\n255\tlocal\n254\tmain\n100 uplink # ISP A\n"), 0600); err != nil {
+		t.Fatalf("Failed to write rt_tables: %v", err)
+	}
+	if err := os.MkdirAll(path+".d", 0700); err != nil {
+		t.Fatalf("Failed to create rt_tables.d: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(path+".d", "storage.conf"), []byte("200 storage\n"), 0600); err != nil {
+		t.Fatalf("Failed to write rt_tables.d file: %v", err)
+	}
+
+	oldPath := RTTablesPath
+	RTTablesPath = path
+	defer func() { RTTablesPath = oldPath }()
+
+	config, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      routes:
+        - to: default
+          via: 10.0.0.1
+          table: uplink
+          advertised-receive-window: 64
+        - to: 10.10.0.0/16
+          via: 10.0.0.2
+          table: 300
+      routing-policy:
+        - from: 10.0.0.0/24
+          table: storage
+        - from: 10.0.1.0/24
+          table: main`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	eth := config.Network.Ethernets["eth0"]
+	if eth.Routes[0].Table != 100 || eth.Routes[1].Table != 300 {
+		t.Errorf("Unexpected route tables: %d, %d", eth.Routes[0].Table, eth.Routes[1].Table)
+	}
+	if eth.Routes[0].AdvertisedReceiveWindow != 64 {
+		t.Errorf("Expected advertised-receive-window 64, got %d", eth.Routes[0].AdvertisedReceiveWindow)
+	}
+	if eth.RoutingPolicy[0].Table != 200 || eth.RoutingPolicy[1].Table != RouteTableMain {
+		t.Errorf("Unexpected policy tables: %d, %d", eth.RoutingPolicy[0].Table, eth.RoutingPolicy[1].Table)
+	}
+
+	// Names are written back as the numbers netplan expects
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("ToYAML failed: %v", err)
+	}
+	if strings.Contains(string(data), "uplink") || !strings.Contains(string(data), "table: 100") {
+		t.Errorf("Expected numeric tables in output:\n%s", data)
+	}
+
+	if _, err := LoadConfigFromBytes([]byte(`network:
+  version: 2
+  ethernets:
+    eth0:
+      routes:
+        - to: default
+          via: 10.0.0.1
+          table: nonexistent`)); err == nil {
+		t.Error("Expected error for unknown table name")
+	}
+}
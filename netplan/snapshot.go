@@ -0,0 +1,235 @@
+package netplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultSnapshotDir is where snapshots of /etc/netplan are stored
+	DefaultSnapshotDir = "/var/lib/network-validator/netplan-snapshots"
+	// DefaultSnapshotKeep is the number of historical snapshots retained
+	DefaultSnapshotKeep = 10
+
+	snapshotMetadataFile = "snapshot.json"
+	snapshotIDFormat     = "20060102T150405.000000000Z"
+)
+
+// SnapshotFile records a single file captured in a snapshot
+type SnapshotFile struct {
+	Name string      `json:"name"`
+	Mode os.FileMode `json:"mode"`
+}
+
+// SnapshotInfo describes an archived copy of a netplan directory
+type SnapshotInfo struct {
+	ID        string         `json:"id"`
+	SourceDir string         `json:"source_dir"`
+	Path      string         `json:"path"`
+	Reason    string         `json:"reason,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []SnapshotFile `json:"files"`
+}
+
+// SnapshotManager archives a netplan directory before modifications and
+// restores it when they need to be rolled back
+type SnapshotManager struct {
+	sourceDir string
+	backupDir string
+	keep      int
+}
+
+// NewSnapshotManager creates a snapshot manager for sourceDir that stores
+// its snapshots in backupDir and retains at most keep of them
+func NewSnapshotManager(sourceDir, backupDir string, keep int) *SnapshotManager {
+	if keep <= 0 {
+		keep = DefaultSnapshotKeep
+	}
+	return &SnapshotManager{
+		sourceDir: sourceDir,
+		backupDir: backupDir,
+		keep:      keep,
+	}
+}
+
+// Snapshot archives the netplan files in dir using the default snapshot
+// location and retention
+func Snapshot(dir string) (*SnapshotInfo, error) {
+	return NewSnapshotManager(dir, DefaultSnapshotDir, DefaultSnapshotKeep).Snapshot("")
+}
+
+// Snapshot archives the current contents of the source directory and
+// prunes snapshots beyond the retention limit
+func (m *SnapshotManager) Snapshot(reason string) (*SnapshotInfo, error) {
+	paths, err := netplanFilePaths(m.sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	info := &SnapshotInfo{
+		ID:        now.Format(snapshotIDFormat),
+		SourceDir: m.sourceDir,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	info.Path = filepath.Join(m.backupDir, info.ID)
+
+	if err := os.MkdirAll(info.Path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory %s: %w", info.Path, err)
+	}
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		name := filepath.Base(path)
+		if err := writeFileAtomic(filepath.Join(info.Path, name), data, ConfigFileMode); err != nil {
+			return nil, fmt.Errorf("failed to archive %s: %w", path, err)
+		}
+		info.Files = append(info.Files, SnapshotFile{Name: name, Mode: stat.Mode().Perm()})
+	}
+
+	metadata, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot metadata: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(info.Path, snapshotMetadataFile), metadata, ConfigFileMode); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot metadata: %w", err)
+	}
+
+	if err := m.Prune(); err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// Restore replaces the netplan files in the snapshot's source directory
+// with the archived copies. Netplan files that did not exist when the
+// snapshot was taken are removed.
+func Restore(snapshot *SnapshotInfo) error {
+	current, err := netplanFilePaths(snapshot.SourceDir)
+	if err != nil {
+		return err
+	}
+
+	archived := make(map[string]bool)
+	for _, file := range snapshot.Files {
+		archived[file.Name] = true
+
+		data, err := os.ReadFile(filepath.Join(snapshot.Path, file.Name))
+		if err != nil {
+			return fmt.Errorf("failed to read archived %s: %w", file.Name, err)
+		}
+		if err := writeFileAtomic(filepath.Join(snapshot.SourceDir, file.Name), data, file.Mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Name, err)
+		}
+	}
+
+	for _, path := range current {
+		if archived[filepath.Base(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	return syncDir(snapshot.SourceDir)
+}
+
+// Restore rolls the source directory back to the given snapshot
+func (m *SnapshotManager) Restore(snapshot *SnapshotInfo) error {
+	return Restore(snapshot)
+}
+
+// Apply takes a snapshot, runs fn and rolls back to the snapshot if fn
+// returns an error
+func (m *SnapshotManager) Apply(reason string, fn func() error) error {
+	snapshot, err := m.Snapshot(reason)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", m.sourceDir, err)
+	}
+
+	if err := fn(); err != nil {
+		if restoreErr := Restore(snapshot); restoreErr != nil {
+			return fmt.Errorf("%w (rollback to snapshot %s failed: %v)", err, snapshot.ID, restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// List returns the available snapshots, newest first
+func (m *SnapshotManager) List() ([]*SnapshotInfo, error) {
+	entries, err := os.ReadDir(m.backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory %s: %w", m.backupDir, err)
+	}
+
+	var snapshots []*SnapshotInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(m.backupDir, entry.Name(), snapshotMetadataFile))
+		if err != nil {
+			// Incomplete snapshot, most likely interrupted while being taken
+			continue
+		}
+
+		var info SnapshotInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			continue
+		}
+		info.Path = filepath.Join(m.backupDir, entry.Name())
+		snapshots = append(snapshots, &info)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// Latest returns the most recent snapshot, or nil if there are none
+func (m *SnapshotManager) Latest() (*SnapshotInfo, error) {
+	snapshots, err := m.List()
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return snapshots[0], nil
+}
+
+// Prune removes the oldest snapshots beyond the retention limit
+func (m *SnapshotManager) Prune() error {
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	for i := m.keep; i < len(snapshots); i++ {
+		if err := os.RemoveAll(snapshots[i].Path); err != nil {
+			return fmt.Errorf("failed to remove snapshot %s: %w", snapshots[i].ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package netplan
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	sourceDir := t.TempDir()
+	manager := NewSnapshotManager(sourceDir, t.TempDir(), 2)
+
+	original := []byte("network:\n  version: 2\n")
+	configPath := filepath.Join(sourceDir, "01-netcfg.yaml")
+	if err := os.WriteFile(configPath, original, 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	snapshot, err := manager.Snapshot("before change")
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	if len(snapshot.Files) != 1 || snapshot.Files[0].Name != "01-netcfg.yaml" {
+		t.Fatalf("Unexpected snapshot files: %v", snapshot.Files)
+	}
+
+	// Modify the directory, then roll back
+	if err := os.WriteFile(configPath, []byte("network:\n  version: 3\n"), 0600); err != nil {
+		t.Fatalf("Failed to modify config: %v", err)
+	}
+	extraPath := filepath.Join(sourceDir, "99-extra.yaml")
+	if err := os.WriteFile(extraPath, original, 0600); err != nil {
+		t.Fatalf("Failed to write extra config: %v", err)
+	}
+
+	if err := manager.Restore(snapshot); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read restored config: %v", err)
+	}
+	if string(data) != string(original) {
+		t.Errorf("Restored config = %q, want %q", data, original)
+	}
+	if _, err := os.Stat(extraPath); !os.IsNotExist(err) {
+		t.Error("Expected file added after the snapshot to be removed")
+	}
+}
+
+func TestSnapshotApplyRollsBack(t *testing.T) {
+	sourceDir := t.TempDir()
+	manager := NewSnapshotManager(sourceDir, t.TempDir(), 2)
+
+	configPath := filepath.Join(sourceDir, "01-netcfg.yaml")
+	if err := os.WriteFile(configPath, []byte("network:\n  version: 2\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	applyErr := errors.New("netplan apply failed")
+	err := manager.Apply("test", func() error {
+		if err := os.Remove(configPath); err != nil {
+			t.Fatalf("Failed to remove config: %v", err)
+		}
+		return applyErr
+	})
+	if !errors.Is(err, applyErr) {
+		t.Fatalf("Expected apply error, got %v", err)
+	}
+
+	if _, err := os.Stat(configPath); err != nil {
+		t.Errorf("Expected config to be restored after failure: %v", err)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	manager := NewSnapshotManager(t.TempDir(), t.TempDir(), 2)
+
+	for i := 0; i < 4; i++ {
+		if _, err := manager.Snapshot(""); err != nil {
+			t.Fatalf("Failed to take snapshot: %v", err)
+		}
+	}
+
+	snapshots, err := manager.List()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("Expected 2 retained snapshots, got %d", len(snapshots))
+	}
+
+	latest, err := manager.Latest()
+	if err != nil {
+		t.Fatalf("Failed to get latest snapshot: %v", err)
+	}
+	if latest.ID != snapshots[0].ID {
+		t.Errorf("Latest() = %s, want %s", latest.ID, snapshots[0].ID)
+	}
+}
@@ -57,6 +57,42 @@ func TestInSameSubnet(t *testing.T) {
 			ip2:      "172.17.0.1",
 			expected: false,
 		},
+		{
+			name:     "Same IPv6 /64 subnet",
+			ip1CIDR:  "2001:db8:10::1/64",
+			ip2:      "2001:db8:10::2",
+			expected: true,
+		},
+		{
+			name:     "Different IPv6 /64 subnet",
+			ip1CIDR:  "2001:db8:10::1/64",
+			ip2:      "2001:db8:11::1",
+			expected: false,
+		},
+		{
+			name:     "IPv6 with CIDR notation",
+			ip1CIDR:  "fd00:150::1/48",
+			ip2:      "fd00:150:0:3::5/64",
+			expected: true,
+		},
+		{
+			name:     "IPv6 link-local is never matched",
+			ip1CIDR:  "fe80::1/64",
+			ip2:      "fe80::2",
+			expected: false,
+		},
+		{
+			name:     "IPv4 target against IPv6 default prefix",
+			ip1CIDR:  "::/0",
+			ip2:      "10.150.0.2",
+			expected: false,
+		},
+		{
+			name:     "IPv6 target against IPv4 default prefix",
+			ip1CIDR:  "0.0.0.0/0",
+			ip2:      "2001:db8::1",
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
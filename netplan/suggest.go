@@ -0,0 +1,72 @@
+package netplan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// invalidValueError builds the error for a value that is not one of the
+// allowed choices, suggesting the closest choice when there is a plausible
+// one, e.g. `invalid renderer "networkD" (did you mean "networkd"?)`
+func invalidValueError(field, value string, choices []string) error {
+	if suggestion := closestMatch(value, choices); suggestion != "" {
+		return fmt.Errorf("invalid %s %q (did you mean %q?)", field, value, suggestion)
+	}
+	return fmt.Errorf("invalid %s %q (must be one of: %s)", field, value, strings.Join(choices, ", "))
+}
+
+// closestMatch returns the choice with the smallest edit distance to value,
+// or "" if none is close enough to be a likely typo. Case differences are
+// not counted against a choice.
+func closestMatch(value string, choices []string) string {
+	best := ""
+	bestDistance := -1
+	for _, choice := range choices {
+		distance := levenshtein(strings.ToLower(value), strings.ToLower(choice))
+		if bestDistance == -1 || distance < bestDistance {
+			best, bestDistance = choice, distance
+		}
+	}
+
+	// Allow roughly one edit per three characters, but at least one
+	maxDistance := len(value) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	if bestDistance == -1 || bestDistance > maxDistance {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between two strings. Swapping two
+// adjacent characters counts as a single edit, since that is the most
+// common typo ("pks" for "psk").
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Three rolling rows are enough to look back for transpositions
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(rb)]
+}
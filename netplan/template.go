@@ -0,0 +1,107 @@
+package netplan
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"os"
+	"text/template"
+)
+
+// ConfigTemplate is a netplan config skeleton with text/template
+// placeholders that is rendered into concrete per-host configs
+type ConfigTemplate struct {
+	tmpl *template.Template
+}
+
+// templateFuncs are the helpers available to config templates
+var templateFuncs = template.FuncMap{
+	"add":      func(a, b int) int { return a + b },
+	"ipAdd":    ipAdd,
+	"cidrHost": cidrHost,
+}
+
+// NewConfigTemplate parses a config template. Placeholders use the
+// text/template syntax, e.g. "addresses: [{{ cidrHost .RackSubnet .Index }}]".
+// Referencing a variable that is not supplied when rendering is an error.
+func NewConfigTemplate(name, text string) (*ConfigTemplate, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return &ConfigTemplate{tmpl: tmpl}, nil
+}
+
+// LoadConfigTemplate reads and parses a config template from a file
+func LoadConfigTemplate(filename string) (*ConfigTemplate, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	return NewConfigTemplate(filename, string(data))
+}
+
+// Render executes the template with vars and returns the raw YAML
+func (t *ConfigTemplate) Render(vars map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", t.tmpl.Name(), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTemplate executes the template with vars and parses the result
+// into a Config
+func (t *ConfigTemplate) RenderTemplate(vars map[string]interface{}) (*Config, error) {
+	data, err := t.Render(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfigFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("template %s produced invalid config: %w", t.tmpl.Name(), err)
+	}
+	return config, nil
+}
+
+// ipAdd returns the address n positions after ip
+func ipAdd(ip string, n int) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+
+	value := new(big.Int).SetBytes(addr.AsSlice())
+	value.Add(value, big.NewInt(int64(n)))
+	if value.Sign() < 0 || value.BitLen() > addr.BitLen() {
+		return "", fmt.Errorf("%s + %d is out of range", ip, n)
+	}
+
+	raw := make([]byte, addr.BitLen()/8)
+	value.FillBytes(raw)
+	result, _ := netip.AddrFromSlice(raw)
+	return result.String(), nil
+}
+
+// cidrHost returns the n-th host address of a subnet in CIDR notation,
+// keeping the subnet's prefix length, e.g. cidrHost "10.1.0.0/24" 10
+// returns "10.1.0.10/24"
+func cidrHost(subnet string, n int) (string, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet %q: %w", subnet, err)
+	}
+	prefix = prefix.Masked()
+
+	ip, err := ipAdd(prefix.Addr().String(), n)
+	if err != nil {
+		return "", err
+	}
+	addr := netip.MustParseAddr(ip)
+	if !prefix.Contains(addr) {
+		return "", fmt.Errorf("host %d is outside subnet %s", n, subnet)
+	}
+	return netip.PrefixFrom(addr, prefix.Bits()).String(), nil
+}
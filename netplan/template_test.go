@@ -0,0 +1,142 @@
+package netplan
+
+import (
+	"reflect"
+	"testing"
+)
+
+const bondVLANTemplate = `network:
+  version: 2
+  ethernets:
+    eno1: {}
+    eno2: {}
+  bonds:
+    bond0:
+      interfaces: [eno1, eno2]
+      parameters:
+        mode: 802.3ad
+  vlans:
+{{- range .VLANs }}
+    bond0.{{ .ID }}:
+      id: {{ .ID }}
+      link: bond0
+      addresses: [{{ cidrHost .Subnet $.Index }}]
+{{- end }}
+  bridges:
+    br-mgmt:
+      interfaces: []
+      addresses: [{{ ipAdd .MgmtBase $.Index }}/32]`
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl, err := NewConfigTemplate("bond-vlan", bondVLANTemplate)
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	vars := map[string]interface{}{
+		"Index":    12,
+		"MgmtBase": "10.255.0.0",
+		"VLANs": []map[string]interface{}{
+			{"ID": 100, "Subnet": "10.1.4.0/24"},
+			{"ID": 200, "Subnet": "fd00:200::/64"},
+		},
+	}
+
+	config, err := tmpl.RenderTemplate(vars)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if errs := config.Validate(); len(errs) > 0 {
+		t.Fatalf("Rendered config is invalid: %v", errs)
+	}
+
+	expected := map[string][]string{
+		"bond0.100": {"10.1.4.12/24"},
+		"bond0.200": {"fd00:200::c/64"},
+	}
+	for name, addresses := range expected {
+		vlan, ok := config.Network.VLANs[name]
+		if !ok {
+			t.Fatalf("Expected VLAN %s to be rendered", name)
+		}
+		if !reflect.DeepEqual(vlan.Addresses, addresses) {
+			t.Errorf("VLAN %s addresses = %v, expected %v", name, vlan.Addresses, addresses)
+		}
+	}
+
+	if got := config.Network.Bridges["br-mgmt"].Addresses; !reflect.DeepEqual(got, []string{"10.255.0.12/32"}) {
+		t.Errorf("Unexpected bridge addresses: %v", got)
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]interface{}
+	}{
+		{
+			name:     "missing-variable",
+			template: "network:\n  version: 2\n  vlans:\n    v:\n      id: {{ .VLAN }}\n      link: eth0",
+			vars:     map[string]interface{}{},
+		},
+		{
+			name:     "host-outside-subnet",
+			template: "network:\n  addresses: [{{ cidrHost \"10.0.0.0/30\" .Index }}]",
+			vars:     map[string]interface{}{"Index": 4},
+		},
+		{
+			name:     "invalid-yaml",
+			template: "network:\n  ethernets: [{{ .Name }}",
+			vars:     map[string]interface{}{"Name": "eth0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := NewConfigTemplate(tt.name, tt.template)
+			if err != nil {
+				t.Fatalf("Failed to parse template: %v", err)
+			}
+			if _, err := tmpl.RenderTemplate(tt.vars); err == nil {
+				t.Errorf("Expected render error")
+			}
+		})
+	}
+
+	if _, err := NewConfigTemplate("bad", "{{ .Unclosed"); err == nil {
+		t.Errorf("Expected parse error for malformed template")
+	}
+}
+
+func TestTemplateAddressHelpers(t *testing.T) {
+	tests := []struct {
+		fn       func() (string, error)
+		expected string
+		wantErr  bool
+	}{
+		{fn: func() (string, error) { return ipAdd("10.0.0.250", 10) }, expected: "10.0.1.4"},
+		{fn: func() (string, error) { return ipAdd("10.0.0.1", -2) }, expected: "9.255.255.255"},
+		{fn: func() (string, error) { return ipAdd("255.255.255.255", 1) }, wantErr: true},
+		{fn: func() (string, error) { return ipAdd("not-an-ip", 1) }, wantErr: true},
+		{fn: func() (string, error) { return cidrHost("192.168.7.77/24", 5) }, expected: "192.168.7.5/24"},
+		{fn: func() (string, error) { return cidrHost("2001:db8::/64", 255) }, expected: "2001:db8::ff/64"},
+		{fn: func() (string, error) { return cidrHost("192.168.7.0/24", 256) }, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		got, err := tt.fn()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("case %d: expected error, got %s", i, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		} else if got != tt.expected {
+			t.Errorf("case %d: got %s, expected %s", i, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package netplan
+
+import (
+	"sort"
+)
+
+// InterfaceKind identifies the netplan section an interface is defined in
+type InterfaceKind string
+
+const (
+	KindEthernet InterfaceKind = "ethernet"
+	KindWifi     InterfaceKind = "wifi"
+	KindBridge   InterfaceKind = "bridge"
+	KindBond     InterfaceKind = "bond"
+	KindVLAN     InterfaceKind = "vlan"
+	KindTunnel   InterfaceKind = "tunnel"
+	KindVRF      InterfaceKind = "vrf"
+	KindModem    InterfaceKind = "modem"
+	// KindUnknown is used for interfaces that are referenced but not defined
+	KindUnknown InterfaceKind = "unknown"
+)
+
+// EdgeRelation describes how a child interface depends on its parent
+type EdgeRelation string
+
+const (
+	RelationBondMember      EdgeRelation = "bond-member"
+	RelationBridgePort      EdgeRelation = "bridge-port"
+	RelationVLANLink        EdgeRelation = "vlan-link"
+	RelationVRFMember       EdgeRelation = "vrf-member"
+	RelationTunnelUnderlay  EdgeRelation = "tunnel-underlay"
+	RelationVirtualFunction EdgeRelation = "virtual-function"
+)
+
+// TopologyNode represents a single interface in the topology graph
+type TopologyNode struct {
+	Name      string
+	Kind      InterfaceKind
+	Addresses []string
+}
+
+// TopologyEdge connects a parent (lower layer) interface to a child
+// (upper layer) interface, e.g. a bond member to its bond
+type TopologyEdge struct {
+	Parent   string
+	Child    string
+	Relation EdgeRelation
+}
+
+// Topology is a directed graph of the interfaces defined in a configuration.
+// Edges point from lower layer devices to the devices stacked on top of them.
+type Topology struct {
+	Nodes map[string]*TopologyNode
+	Edges []TopologyEdge
+
+	parents  map[string][]string
+	children map[string][]string
+}
+
+// Topology builds the interface dependency graph for the configuration
+func (c *Config) Topology() *Topology {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topology()
+}
+
+// topology is Topology without locking
+func (c *Config) topology() *Topology {
+	t := &Topology{
+		Nodes:    make(map[string]*TopologyNode),
+		parents:  make(map[string][]string),
+		children: make(map[string][]string),
+	}
+
+	for name, eth := range c.Network.Ethernets {
+		t.addNode(name, KindEthernet, eth.Addresses)
+	}
+	for name, wifi := range c.Network.Wifis {
+		t.addNode(name, KindWifi, wifi.Addresses)
+	}
+	for name, bridge := range c.Network.Bridges {
+		t.addNode(name, KindBridge, bridge.Addresses)
+	}
+	for name, bond := range c.Network.Bonds {
+		t.addNode(name, KindBond, bond.Addresses)
+	}
+	for name, vlan := range c.Network.VLANs {
+		t.addNode(name, KindVLAN, vlan.Addresses)
+	}
+	for name, tunnel := range c.Network.Tunnels {
+		t.addNode(name, KindTunnel, tunnel.Addresses)
+	}
+	for name := range c.Network.VRFs {
+		t.addNode(name, KindVRF, nil)
+	}
+	for name, modem := range c.Network.Modems {
+		t.addNode(name, KindModem, modem.Addresses)
+	}
+
+	for name, eth := range c.Network.Ethernets {
+		if eth.Link != "" {
+			t.addEdge(eth.Link, name, RelationVirtualFunction)
+		}
+	}
+	for name, bond := range c.Network.Bonds {
+		for _, member := range bond.Interfaces {
+			t.addEdge(member, name, RelationBondMember)
+		}
+	}
+	for name, bridge := range c.Network.Bridges {
+		for _, port := range bridge.Interfaces {
+			t.addEdge(port, name, RelationBridgePort)
+		}
+	}
+	for name, vlan := range c.Network.VLANs {
+		if vlan.Link != "" {
+			t.addEdge(vlan.Link, name, RelationVLANLink)
+		}
+	}
+	for name, vrf := range c.Network.VRFs {
+		for _, member := range vrf.Interfaces {
+			t.addEdge(member, name, RelationVRFMember)
+		}
+	}
+	for name, tunnel := range c.Network.Tunnels {
+		if tunnel.Local == "" {
+			continue
+		}
+		// A tunnel rides on whichever interface owns its local endpoint address
+		for _, node := range t.Nodes {
+			if node.Name == name {
+				continue
+			}
+			for _, addr := range node.Addresses {
+				if stripCIDR(addr) == tunnel.Local {
+					t.addEdge(node.Name, name, RelationTunnelUnderlay)
+				}
+			}
+		}
+	}
+
+	sort.Slice(t.Edges, func(i, j int) bool {
+		if t.Edges[i].Parent != t.Edges[j].Parent {
+			return t.Edges[i].Parent < t.Edges[j].Parent
+		}
+		return t.Edges[i].Child < t.Edges[j].Child
+	})
+	for name := range t.parents {
+		sort.Strings(t.parents[name])
+	}
+	for name := range t.children {
+		sort.Strings(t.children[name])
+	}
+
+	return t
+}
+
+// addNode adds an interface to the graph
+func (t *Topology) addNode(name string, kind InterfaceKind, addresses []string) {
+	t.Nodes[name] = &TopologyNode{
+		Name:      name,
+		Kind:      kind,
+		Addresses: addresses,
+	}
+}
+
+// addEdge links a parent interface to a child interface, creating a
+// placeholder node for parents that are referenced but not defined
+func (t *Topology) addEdge(parent, child string, relation EdgeRelation) {
+	if _, exists := t.Nodes[parent]; !exists {
+		t.addNode(parent, KindUnknown, nil)
+	}
+	t.Edges = append(t.Edges, TopologyEdge{
+		Parent:   parent,
+		Child:    child,
+		Relation: relation,
+	})
+	t.parents[child] = append(t.parents[child], parent)
+	t.children[parent] = append(t.children[parent], child)
+}
+
+// Parents returns the interfaces directly underneath the named interface
+func (t *Topology) Parents(name string) []string {
+	return append([]string(nil), t.parents[name]...)
+}
+
+// Children returns the interfaces stacked directly on top of the named interface
+func (t *Topology) Children(name string) []string {
+	return append([]string(nil), t.children[name]...)
+}
+
+// Ancestors returns every interface the named interface depends on,
+// e.g. the bond and physical ports underneath a VLAN
+func (t *Topology) Ancestors(name string) []string {
+	return t.walk(name, t.parents)
+}
+
+// Descendants returns every interface that depends on the named interface,
+// e.g. the VLANs and bridges built on top of a bond
+func (t *Topology) Descendants(name string) []string {
+	return t.walk(name, t.children)
+}
+
+// walk performs a breadth-first traversal over the given adjacency map
+// and returns the sorted set of reachable interfaces, excluding the start
+func (t *Topology) walk(start string, adjacency map[string][]string) []string {
+	visited := map[string]bool{start: true}
+	queue := []string{start}
+	var result []string
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, next := range adjacency[current] {
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			result = append(result, next)
+			queue = append(queue, next)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
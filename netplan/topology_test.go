@@ -0,0 +1,101 @@
+package netplan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopology(t *testing.T) {
+	config := NewConfig()
+	config.AddEthernet("eth0", &Ethernet{})
+	config.AddEthernet("eth1", &Ethernet{})
+	config.AddBond("bond0", &Bond{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.0.1.100/24"},
+		},
+		Interfaces: []string{"eth0", "eth1"},
+	})
+	config.AddVLAN("bond0.100", &VLAN{ID: 100, Link: "bond0"})
+	config.AddBridge("br0", &Bridge{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"172.16.1.1/24"},
+		},
+		Interfaces: []string{"bond0.100"},
+	})
+	config.AddTunnel("gre0", &Tunnel{
+		Mode:   string(TunnelModeGRE),
+		Local:  "172.16.1.1",
+		Remote: "172.16.2.1",
+	})
+	config.Network.VRFs = map[string]*VRF{
+		"vrf0": {Table: 100, Interfaces: []string{"br0"}},
+	}
+
+	topo := config.Topology()
+
+	if kind := topo.Nodes["bond0"].Kind; kind != KindBond {
+		t.Errorf("Expected bond0 to be a bond, got %s", kind)
+	}
+
+	tests := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{"parents of bond0", topo.Parents("bond0"), []string{"eth0", "eth1"}},
+		{"children of br0", topo.Children("br0"), []string{"gre0", "vrf0"}},
+		{"ancestors of bond0.100", topo.Ancestors("bond0.100"), []string{"bond0", "eth0", "eth1"}},
+		{"descendants of bond0", topo.Descendants("bond0"), []string{"bond0.100", "br0", "gre0", "vrf0"}},
+		{"ancestors of gre0", topo.Ancestors("gre0"), []string{"bond0", "bond0.100", "br0", "eth0", "eth1"}},
+		{"descendants of unknown", topo.Descendants("nonexistent"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.expected) {
+				t.Errorf("Got %v, want %v", tt.got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTopologyUndefinedMember(t *testing.T) {
+	config := NewConfig()
+	config.AddBond("bond0", &Bond{Interfaces: []string{"eth9"}})
+
+	topo := config.Topology()
+
+	node, exists := topo.Nodes["eth9"]
+	if !exists {
+		t.Fatal("Expected undefined bond member to be added as a node")
+	}
+	if node.Kind != KindUnknown {
+		t.Errorf("Expected kind %s, got %s", KindUnknown, node.Kind)
+	}
+}
+
+func TestToDOT(t *testing.T) {
+	config := NewConfig()
+	config.AddBond("bond0", &Bond{
+		CommonInterface: CommonInterface{
+			Addresses: []string{"10.0.1.100/24"},
+		},
+		Interfaces: []string{"eth0"},
+	})
+	config.AddVLAN("bond0.100", &VLAN{ID: 100, Link: "bond0"})
+
+	dot := config.ToDOT()
+
+	expected := []string{
+		"digraph netplan {",
+		`"bond0" [label="bond0\nbond\n10.0.1.100/24", shape=hexagon];`,
+		`"eth0" -> "bond0" [label="bond-member"];`,
+		`"bond0" -> "bond0.100" [label="vlan-link"];`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected DOT output to contain %q, got:\n%s", want, dot)
+		}
+	}
+}
@@ -1,8 +1,26 @@
 package netplan
 
-// Config represents the root netplan configuration
+import (
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the root netplan configuration.
+//
+// Every method of Config is safe for concurrent use. Modifying Network or
+// the interface structs directly, including those returned by GetInterface,
+// is not synchronized; callers that do so from several goroutines must use
+// Update.
 type Config struct {
 	Network Network `yaml:"network"`
+
+	// mu serializes access to Network and node
+	mu sync.Mutex
+
+	// node is the YAML document the configuration was loaded from. It is
+	// used to preserve comments and key order when the config is saved.
+	node *yaml.Node
 }
 
 // Network represents the main network configuration block
@@ -17,6 +35,14 @@ type Network struct {
 	Tunnels   map[string]*Tunnel   `yaml:"tunnels,omitempty"`
 	VRFs      map[string]*VRF      `yaml:"vrfs,omitempty"`
 	Modems    map[string]*Modem    `yaml:"modems,omitempty"`
+
+	// Global OpenVSwitch settings (external-ids, other-config, protocols,
+	// ssl and patch ports) applied to the Open vSwitch database itself
+	OpenVSwitch *OpenVSwitch `yaml:"openvswitch,omitempty"`
+
+	// Unknown top-level keys, e.g. device types added in newer netplan
+	// releases, preserved so they survive a load and save
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // CommonInterface contains common network interface properties
@@ -25,12 +51,17 @@ type CommonInterface struct {
 	DHCP4          *bool           `yaml:"dhcp4,omitempty"`
 	DHCP6          *bool           `yaml:"dhcp6,omitempty"`
 	IPv6Privacy    *bool           `yaml:"ipv6-privacy,omitempty"`
-	LinkLocal      []string        `yaml:"link-local,omitempty"`
+	LinkLocal      LinkLocal       `yaml:"link-local,omitempty"`
 	Critical       *bool           `yaml:"critical,omitempty"`
 	DHCPIdentifier string          `yaml:"dhcp-identifier,omitempty"`
 	DHCP4Overrides *DHCP4Overrides `yaml:"dhcp4-overrides,omitempty"`
 	DHCP6Overrides *DHCP6Overrides `yaml:"dhcp6-overrides,omitempty"`
 	AcceptRA       *bool           `yaml:"accept-ra,omitempty"`
+	RAOverrides    *RAOverrides    `yaml:"ra-overrides,omitempty"`
+
+	// IPv6 SLAAC interface identifier generation
+	IPv6AddressGeneration string `yaml:"ipv6-address-generation,omitempty"`
+	IPv6AddressToken      string `yaml:"ipv6-address-token,omitempty"`
 
 	// Address configuration
 	Addresses   []string     `yaml:"addresses,omitempty"`
@@ -67,6 +98,35 @@ type Ethernet struct {
 	// Ethernet-specific configuration
 	Link            string           `yaml:"link,omitempty"`
 	VirtualFunction *VirtualFunction `yaml:"virtual-function,omitempty"`
+
+	// Hardware offload configuration (unset leaves the driver default)
+	ReceiveChecksumOffload     *bool `yaml:"receive-checksum-offload,omitempty"`
+	TransmitChecksumOffload    *bool `yaml:"transmit-checksum-offload,omitempty"`
+	TCPSegmentationOffload     *bool `yaml:"tcp-segmentation-offload,omitempty"`
+	GenericSegmentationOffload *bool `yaml:"generic-segmentation-offload,omitempty"`
+	GenericReceiveOffload      *bool `yaml:"generic-receive-offload,omitempty"`
+	LargeReceiveOffload        *bool `yaml:"large-receive-offload,omitempty"`
+
+	// Keys this package does not know about, such as settings added in
+	// newer netplan releases. They are written back out unchanged.
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// IPv6 address generation modes
+const (
+	IPv6AddressGenerationEUI64         = "eui64"
+	IPv6AddressGenerationStablePrivacy = "stable-privacy"
+)
+
+// LinkLocal lists the address families for which link-local addressing is
+// enabled. A nil list leaves the netplan default (IPv6 only) in place while
+// an empty, non-nil list disables link-local addressing entirely.
+type LinkLocal []string
+
+// IsZero reports whether the field should be omitted when marshaling, so
+// that an explicitly empty list is still written out
+func (l LinkLocal) IsZero() bool {
+	return l == nil
 }
 
 // Wifi represents wireless interface configuration
@@ -76,6 +136,9 @@ type Wifi struct {
 	// WiFi-specific configuration
 	AccessPoints map[string]*AccessPoint `yaml:"access-points,omitempty"`
 	Regulatory   string                  `yaml:"regulatory-domain,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // AccessPoint represents a WiFi access point configuration
@@ -111,6 +174,9 @@ type Bridge struct {
 	// Bridge-specific configuration
 	Interfaces []string          `yaml:"interfaces,omitempty"`
 	Parameters *BridgeParameters `yaml:"parameters,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // BridgeParameters represents bridge-specific parameters
@@ -132,6 +198,9 @@ type Bond struct {
 	// Bond-specific configuration
 	Interfaces []string        `yaml:"interfaces,omitempty"`
 	Parameters *BondParameters `yaml:"parameters,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // BondParameters represents bond-specific parameters
@@ -165,6 +234,9 @@ type VLAN struct {
 	// VLAN-specific configuration
 	ID   int    `yaml:"id"`
 	Link string `yaml:"link"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // Tunnel represents tunnel interface configuration
@@ -180,6 +252,9 @@ type Tunnel struct {
 	TTL    int    `yaml:"ttl,omitempty"`
 	TOS    int    `yaml:"tos,omitempty"`
 	PMTU   int    `yaml:"pmtu-discovery,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // Keys represents tunnel key configuration
@@ -195,6 +270,9 @@ type VRF struct {
 	Interfaces    []string        `yaml:"interfaces,omitempty"`
 	Routes        []Route         `yaml:"routes,omitempty"`
 	RoutingPolicy []RoutingPolicy `yaml:"routing-policy,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // Modem represents modem interface configuration
@@ -212,28 +290,44 @@ type Modem struct {
 	SimID         string `yaml:"sim-id,omitempty"`
 	SimOperatorID string `yaml:"sim-operator-id,omitempty"`
 	Username      string `yaml:"username,omitempty"`
+
+	// Unknown keys, preserved on round-trip
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // DHCP4Overrides represents DHCP4 override configuration
 type DHCP4Overrides struct {
-	UseDNS      *bool  `yaml:"use-dns,omitempty"`
-	UseDomains  string `yaml:"use-domains,omitempty"`
-	UseHostname *bool  `yaml:"use-hostname,omitempty"`
-	UseMTU      *bool  `yaml:"use-mtu,omitempty"`
-	UseNTP      *bool  `yaml:"use-ntp,omitempty"`
-	UseRoutes   *bool  `yaml:"use-routes,omitempty"`
-	Hostname    string `yaml:"hostname,omitempty"`
-	RouteMetric int    `yaml:"route-metric,omitempty"`
+	UseDNS       *bool  `yaml:"use-dns,omitempty"`
+	UseDomains   string `yaml:"use-domains,omitempty"`
+	UseHostname  *bool  `yaml:"use-hostname,omitempty"`
+	SendHostname *bool  `yaml:"send-hostname,omitempty"`
+	UseMTU       *bool  `yaml:"use-mtu,omitempty"`
+	UseNTP       *bool  `yaml:"use-ntp,omitempty"`
+	UseRoutes    *bool  `yaml:"use-routes,omitempty"`
+	Hostname     string `yaml:"hostname,omitempty"`
+	RouteMetric  int    `yaml:"route-metric,omitempty"`
 }
 
 // DHCP6Overrides represents DHCP6 override configuration
 type DHCP6Overrides struct {
-	UseDNS      *bool  `yaml:"use-dns,omitempty"`
-	UseDomains  string `yaml:"use-domains,omitempty"`
-	UseHostname *bool  `yaml:"use-hostname,omitempty"`
-	UseMTU      *bool  `yaml:"use-mtu,omitempty"`
-	UseNTP      *bool  `yaml:"use-ntp,omitempty"`
-	Hostname    string `yaml:"hostname,omitempty"`
+	UseDNS       *bool  `yaml:"use-dns,omitempty"`
+	UseDomains   string `yaml:"use-domains,omitempty"`
+	UseHostname  *bool  `yaml:"use-hostname,omitempty"`
+	SendHostname *bool  `yaml:"send-hostname,omitempty"`
+	UseMTU       *bool  `yaml:"use-mtu,omitempty"`
+	UseNTP       *bool  `yaml:"use-ntp,omitempty"`
+	UseRoutes    *bool  `yaml:"use-routes,omitempty"`
+	Hostname     string `yaml:"hostname,omitempty"`
+	RouteMetric  int    `yaml:"route-metric,omitempty"`
+}
+
+// RAOverrides represents IPv6 Router Advertisement override configuration
+type RAOverrides struct {
+	UseDNS     *bool      `yaml:"use-dns,omitempty"`
+	UseDomains string     `yaml:"use-domains,omitempty"`
+	UseMTU     *bool      `yaml:"use-mtu,omitempty"`
+	UseGateway *bool      `yaml:"use-gateway,omitempty"`
+	Table      RouteTable `yaml:"table,omitempty"`
 }
 
 // Nameservers represents DNS nameserver configuration
@@ -244,27 +338,28 @@ type Nameservers struct {
 
 // Route represents a network route
 type Route struct {
-	To               string `yaml:"to,omitempty"`
-	Via              string `yaml:"via,omitempty"`
-	From             string `yaml:"from,omitempty"`
-	OnLink           *bool  `yaml:"on-link,omitempty"`
-	Metric           int    `yaml:"metric,omitempty"`
-	Type             string `yaml:"type,omitempty"`
-	Scope            string `yaml:"scope,omitempty"`
-	Table            int    `yaml:"table,omitempty"`
-	MTU              int    `yaml:"mtu,omitempty"`
-	CongestionWindow int    `yaml:"congestion-window,omitempty"`
-	AdvertisedMSS    int    `yaml:"advertised-mss,omitempty"`
+	To                      string     `yaml:"to,omitempty"`
+	Via                     string     `yaml:"via,omitempty"`
+	From                    string     `yaml:"from,omitempty"`
+	OnLink                  *bool      `yaml:"on-link,omitempty"`
+	Metric                  int        `yaml:"metric,omitempty"`
+	Type                    string     `yaml:"type,omitempty"`
+	Scope                   string     `yaml:"scope,omitempty"`
+	Table                   RouteTable `yaml:"table,omitempty"`
+	MTU                     int        `yaml:"mtu,omitempty"`
+	CongestionWindow        int        `yaml:"congestion-window,omitempty"`
+	AdvertisedMSS           int        `yaml:"advertised-mss,omitempty"`
+	AdvertisedReceiveWindow int        `yaml:"advertised-receive-window,omitempty"`
 }
 
 // RoutingPolicy represents routing policy configuration
 type RoutingPolicy struct {
-	From          string `yaml:"from,omitempty"`
-	To            string `yaml:"to,omitempty"`
-	Table         int    `yaml:"table,omitempty"`
-	Priority      int    `yaml:"priority,omitempty"`
-	Mark          int    `yaml:"mark,omitempty"`
-	TypeOfService int    `yaml:"type-of-service,omitempty"`
+	From          string     `yaml:"from,omitempty"`
+	To            string     `yaml:"to,omitempty"`
+	Table         RouteTable `yaml:"table,omitempty"`
+	Priority      int        `yaml:"priority,omitempty"`
+	Mark          int        `yaml:"mark,omitempty"`
+	TypeOfService int        `yaml:"type-of-service,omitempty"`
 }
 
 // Neighbor represents neighbor/ARP configuration
@@ -313,10 +408,17 @@ type OpenVSwitch struct {
 	Protocols           []string          `yaml:"protocols,omitempty"`
 	RSTPEnable          *bool             `yaml:"rstp-enable,omitempty"`
 	Controller          *Controller       `yaml:"controller,omitempty"`
-	Ports               [][]interface{}   `yaml:"ports,omitempty"`
+	Ports               []OVSPatchPort    `yaml:"ports,omitempty"`
 	SSL                 *SSL              `yaml:"ssl,omitempty"`
 }
 
+// OVSPatchPort is a pair of OpenVSwitch patch ports connected to each other.
+// It is represented in YAML as a two element list: [local, peer].
+type OVSPatchPort struct {
+	Local string
+	Peer  string
+}
+
 // Controller represents OpenVSwitch controller configuration
 type Controller struct {
 	Addresses      []string `yaml:"addresses,omitempty"`
@@ -338,6 +440,12 @@ const (
 	RendererNetworkManager RendererType = "NetworkManager"
 )
 
+// Link-local address families
+const (
+	LinkLocalIPv4 = "ipv4"
+	LinkLocalIPv6 = "ipv6"
+)
+
 // TunnelMode represents tunnel mode types
 type TunnelMode string
 
@@ -373,12 +481,32 @@ const (
 	WiFiModeAP             WiFiMode = "ap"
 )
 
+// OVSFailMode represents the OpenVSwitch bridge fail mode
+type OVSFailMode string
+
+const (
+	OVSFailModeSecure     OVSFailMode = "secure"
+	OVSFailModeStandalone OVSFailMode = "standalone"
+)
+
+// OVSLacp represents the LACP mode of an OpenVSwitch bond
+type OVSLacp string
+
+const (
+	OVSLacpActive  OVSLacp = "active"
+	OVSLacpPassive OVSLacp = "passive"
+	OVSLacpOff     OVSLacp = "off"
+)
+
 // KeyManagement represents WiFi key management types
 type KeyManagement string
 
 const (
-	KeyManagementNone  KeyManagement = "none"
-	KeyManagementPSK   KeyManagement = "psk"
-	KeyManagementEAP   KeyManagement = "eap"
-	KeyManagement8021X KeyManagement = "802.1x"
+	KeyManagementNone         KeyManagement = "none"
+	KeyManagementPSK          KeyManagement = "psk"
+	KeyManagementEAP          KeyManagement = "eap"
+	KeyManagement8021X        KeyManagement = "802.1x"
+	KeyManagementSAE          KeyManagement = "sae"
+	KeyManagementEAPSHA256    KeyManagement = "eap-sha256"
+	KeyManagementEAPSuiteB192 KeyManagement = "eap-suite-b-192"
 )
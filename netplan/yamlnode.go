@@ -0,0 +1,110 @@
+package netplan
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlIndent is the indentation used when re-emitting a loaded document
+const yamlIndent = 2
+
+// marshalPreserving encodes the configuration on top of the YAML document
+// it was loaded from, keeping comments, key order and scalar styles for
+// every node whose value is unchanged
+func (c *Config) marshalPreserving() ([]byte, error) {
+	var updated yaml.Node
+	if err := updated.Encode(c.document()); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	mergeYAMLNode(c.node.Content[0], &updated)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent)
+	if err := encoder.Encode(c.node); err != nil {
+		return nil, fmt.Errorf("failed to encode YAML document: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode YAML document: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mergeYAMLNode rewrites dst so that it holds the same data as src while
+// retaining the comments and formatting of dst wherever possible
+func mergeYAMLNode(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		replaceYAMLNode(dst, src)
+		return
+	}
+
+	switch dst.Kind {
+	case yaml.DocumentNode:
+		if len(dst.Content) > 0 && len(src.Content) > 0 {
+			mergeYAMLNode(dst.Content[0], src.Content[0])
+		} else {
+			dst.Content = src.Content
+		}
+
+	case yaml.MappingNode:
+		srcValues := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			srcValues[src.Content[i].Value] = src.Content[i+1]
+		}
+
+		var content []*yaml.Node
+		seen := make(map[string]bool)
+
+		// Keep existing keys in their original order
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			key, value := dst.Content[i], dst.Content[i+1]
+			srcValue, exists := srcValues[key.Value]
+			if !exists {
+				continue
+			}
+			mergeYAMLNode(value, srcValue)
+			content = append(content, key, value)
+			seen[key.Value] = true
+		}
+
+		// Append keys that were added since the document was loaded
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if !seen[src.Content[i].Value] {
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+
+		dst.Content = content
+
+	case yaml.SequenceNode:
+		for i := 0; i < len(dst.Content) && i < len(src.Content); i++ {
+			mergeYAMLNode(dst.Content[i], src.Content[i])
+		}
+		if len(src.Content) > len(dst.Content) {
+			dst.Content = append(dst.Content, src.Content[len(dst.Content):]...)
+		} else {
+			dst.Content = dst.Content[:len(src.Content)]
+		}
+
+	case yaml.ScalarNode:
+		if dst.Value != src.Value || dst.ShortTag() != src.ShortTag() {
+			dst.Value = src.Value
+			dst.Tag = src.Tag
+			dst.Style = src.Style
+		}
+
+	default:
+		replaceYAMLNode(dst, src)
+	}
+}
+
+// replaceYAMLNode overwrites dst with src but keeps the comments of dst
+func replaceYAMLNode(dst, src *yaml.Node) {
+	head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+	*dst = *src
+	dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+}
@@ -0,0 +1,120 @@
+package netplan
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const commentedConfig = `# Managed by hand - do not regenerate
+network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    # Uplink to the core switch
+    enp3s0:
+      mtu: 1500 # jumbo frames disabled
+      addresses:
+        - 10.0.1.100/24 # primary
+      nameservers:
+        addresses: [1.1.1.1, 8.8.8.8]
+`
+
+func TestCommentPreservingRoundTrip(t *testing.T) {
+	config, err := LoadConfigFromBytes([]byte(commentedConfig))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// An unmodified config must be written back verbatim
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if string(data) != commentedConfig {
+		t.Errorf("Unmodified round trip changed the document:\n%s", data)
+	}
+
+	// Changing a single field must keep all comments and the key order
+	config.Network.Ethernets["enp3s0"].MTU = 9000
+	config.Network.Ethernets["enp3s0"].DHCP6 = Bool(false)
+
+	path := filepath.Join(t.TempDir(), "01-netcfg.yaml")
+	if err := SaveConfig(config, path); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	data, err = reloaded.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		"# Managed by hand - do not regenerate",
+		"# Uplink to the core switch",
+		"mtu: 9000 # jumbo frames disabled",
+		"- 10.0.1.100/24 # primary",
+		"addresses: [1.1.1.1, 8.8.8.8]",
+		"dhcp6: false",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	if strings.Index(out, "renderer") > strings.Index(out, "ethernets") {
+		t.Errorf("Key order was not preserved:\n%s", out)
+	}
+
+	if reloaded.Network.Ethernets["enp3s0"].MTU != 9000 {
+		t.Errorf("Expected MTU 9000 after reload, got %d", reloaded.Network.Ethernets["enp3s0"].MTU)
+	}
+}
+
+func TestUnknownFieldsRoundTrip(t *testing.T) {
+	input := `network:
+  version: 2
+  future-devices:
+    fd0:
+      enabled: true
+  ethernets:
+    eth0:
+      dhcp4: true
+      hypothetical-offload: true
+  vrfs:
+    vrf-blue:
+      table: 100
+      future-vrf-option: [a, b]
+`
+	config, err := LoadConfigFromBytes([]byte(input))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.Network.Ethernets["eth0"].Extra["hypothetical-offload"] != true {
+		t.Errorf("Expected unknown ethernet key in Extra, got %v", config.Network.Ethernets["eth0"].Extra)
+	}
+
+	// Encode without the preserved document so the struct alone carries the keys
+	config.node = nil
+	data, err := config.ToYAML()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{"future-devices:", "hypothetical-offload: true", "future-vrf-option:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	warnings := config.Warnings()
+	if len(warnings) != 3 {
+		t.Errorf("Expected 3 unknown key warnings, got %v", warnings)
+	}
+}
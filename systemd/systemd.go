@@ -0,0 +1,81 @@
+// Package systemd reports the service state to systemd through the
+// sd_notify protocol, so units of Type=notify are only considered started
+// once the server listens, and WatchdogSec can restart a hung service.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state, such as "READY=1", to the service manager. It does
+// nothing when the process was not started by systemd with a notify socket.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Sockets in the abstract namespace are announced with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// Ready tells the service manager that startup finished
+func Ready() error {
+	return Notify("READY=1")
+}
+
+// Stopping tells the service manager that the service is shutting down
+func Stopping() error {
+	return Notify("STOPPING=1")
+}
+
+// WatchdogInterval returns the interval the service manager expects
+// keep-alives at, from WatchdogSec, or 0 when the watchdog is disabled or
+// meant for another process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog sends keep-alives at half the watchdog interval until
+// stopChan is closed, so a service that stops being scheduled is restarted.
+// It returns immediately when the watchdog is disabled.
+func StartWatchdog(stopChan <-chan struct{}) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			Notify("WATCHDOG=1")
+		case <-stopChan:
+			return
+		}
+	}
+}